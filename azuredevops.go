@@ -4,28 +4,64 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/request"
 	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
 	"github.com/iver-wharf/wharf-core/pkg/ginutil"
-	"github.com/iver-wharf/wharf-core/pkg/problem"
 	_ "github.com/iver-wharf/wharf-provider-azuredevops/docs"
 	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/idempotency"
 	"github.com/iver-wharf/wharf-provider-azuredevops/internal/importer"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/metrics"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/requestid"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/semaphore"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/wharfapiext"
 )
 
 const (
-	providerName = "azuredevops"
+	providerName = request.ProviderAzureDevOps
+	// maxBuildDefinitionPaths is the maximum number of build definition
+	// paths per import, as each path may cost one request to Azure DevOps
+	// per imported repository.
+	maxBuildDefinitionPaths = 10
 )
 
 type importModule struct {
-	config *Config
+	config      *Config
+	metrics     *metrics.ImportMetrics
+	idempotency *idempotency.Store
+	// azureHTTPClient sends all requests to Azure DevOps. Uses
+	// http.DefaultClient when nil.
+	azureHTTPClient *http.Client
+	// wharfWriteSemaphore limits the number of concurrent writes to the
+	// Wharf API across all requests. No limit when nil.
+	wharfWriteSemaphore *semaphore.Semaphore
+	// wharfHTTPClient sends the requests to the Wharf API that the Wharf API
+	// client does not support, such as starting builds with a timeout, and
+	// the requests used to diagnose errors from the Wharf API. Uses
+	// http.DefaultClient when nil.
+	wharfHTTPClient *http.Client
 }
 
 func (m importModule) register(r gin.IRouter) {
 	r.POST("/import/azuredevops", m.runAzureDevOpsHandler)
-	r.POST("/import/azuredevops/triggers/:projectid/pr/created", m.prCreatedTriggerHandler)
+	r.GET("/import/azuredevops/organizations/:org/ping", m.pingOrganizationHandler)
+	r.GET("/import/azuredevops/organizations/:org/projects", m.getProjectsHandler)
+	r.DELETE("/import/azuredevops/organizations/:org/projects/:project", m.deleteProjectsHandler)
+	r.GET("/import/azuredevops/organizations/:org/projects/:project/repositories", m.getRepositoriesHandler)
+	r.GET("/import/azuredevops/token/validate", m.validateTokenHandler)
+
+	triggers := r.Group("/import/azuredevops/triggers", m.triggerBasicAuthHandler)
+	triggers.POST("/:projectid/pr/created", m.prCreatedTriggerHandler)
+	triggers.POST("/:projectid/pr/updated", m.prUpdatedTriggerHandler)
+	triggers.POST("/:projectid/pr/merged", m.prMergedTriggerHandler)
+	triggers.POST("/:projectid/push", m.pushTriggerHandler)
 }
 
 type importBody struct {
@@ -33,178 +69,815 @@ type importBody struct {
 	TokenID  uint   `json:"tokenId" example:"0"`
 	Token    string `json:"token" example:"sample token"`
 	UserName string `json:"user" example:"sample user name"`
-	URL      string `json:"url" example:"https://gitlab.local"`
+	// URL is the Azure DevOps base URL. For Azure DevOps Services this is the
+	// host, such as "https://dev.azure.com", while for Azure DevOps Server
+	// (when config import.mode is "server") it is the collection URL, such as
+	// "https://server/tfs/DefaultCollection".
+	URL string `json:"url" example:"https://dev.azure.com"`
 	// used in refresh only
 	ProviderID uint `json:"providerId" example:"0"`
-	// used in refresh only
+	// ProjectID is the ID of the Wharf project to refresh. The Azure DevOps
+	// repository is then found using the git URL of the Wharf project, and
+	// the Wharf project is kept in its current group unless TargetGroup is
+	// set, as the group and project names do not map back to Azure DevOps
+	// when imported using the "org-only" grouping strategy, a group name
+	// template, or a target group.
 	ProjectID   uint   `json:"projectId" example:"0"`
 	ProjectName string `json:"project" example:"sample project name"`
 	GroupName   string `json:"group" example:"default"`
+	// TargetGroup is used verbatim as the Wharf group of all imported
+	// projects when set, instead of the group derived from the Azure DevOps
+	// organization and project, such as to fit an existing Wharf group
+	// taxonomy. Previously imported Wharf projects are moved into the target
+	// group. When importing multiple Azure DevOps projects into the same
+	// target group, use the config import.groupingStrategy "org-only" or a
+	// project name template to keep the Wharf project names unique.
+	TargetGroup string `json:"targetGroup" example:"platform/backend"`
+	// RegisterWebhooks enables registering an Azure DevOps service hook for
+	// each imported repository, that triggers the pr/created trigger
+	// endpoint. Requires the config triggers.publicUrl to be set.
+	RegisterWebhooks bool `json:"registerWebhooks" example:"false"`
+	// WebhookEnvironment is the Wharf build environment used by the
+	// registered service hooks. Required if RegisterWebhooks is true.
+	WebhookEnvironment string `json:"webhookEnvironment" example:"dev"`
+	// BuildDefinitionPath is the repository-relative path of the build
+	// definition file to import. Defaults to ".wharf-ci.yml".
+	BuildDefinitionPath string `json:"buildDefinitionPath" example:".wharf-ci.yml"`
+	// BuildDefinitionPaths are the repository-relative paths of the build
+	// definition files to try in order, where the first file found in each
+	// repository is imported, such as ".wharf-ci.yml" followed by
+	// environment-specific variants. The imported paths are listed in the
+	// import result. Cannot be combined with BuildDefinitionPath.
+	BuildDefinitionPaths []string `json:"buildDefinitionPaths" example:".wharf-ci.yml,.wharf-ci.dev.yml"`
+	// BuildDefinitionBranch is the name of the branch to import the build
+	// definition file from, such as "feature/foo" or "refs/heads/feature/foo".
+	// Defaults to each repository's default branch.
+	BuildDefinitionBranch string `json:"buildDefinitionBranch" example:"feature/foo"`
+	// BranchRefFilter only imports the branches whose refs start with the
+	// filter, such as "heads/release/" or "refs/heads/release/". The default
+	// branch is only flagged as default if it matches the filter. Defaults to
+	// importing all branches.
+	//
+	// The branch list of a previously imported Wharf project is replaced, so
+	// its branches not matching the filter are removed from it, with a
+	// warning added to the import result.
+	BranchRefFilter string `json:"branchRefFilter" example:"heads/release/"`
+	// SkipReposWithoutBuildDef skips importing repositories that lack a build
+	// definition file. The skipped repositories are counted in the import
+	// result's reposSkipped field.
+	SkipReposWithoutBuildDef bool `json:"skipReposWithoutBuildDef" example:"false"`
+	// UseReadmeDescription uses the first paragraph of the README.md file in
+	// the root of each imported repository as the Wharf project's
+	// description, when the Azure DevOps project has no description.
+	UseReadmeDescription bool `json:"useReadmeDescription" example:"false"`
+	// ImportTags lists the Git tags of each imported repository in the
+	// import result. Tags are not stored in Wharf.
+	ImportTags bool `json:"importTags" example:"false"`
+	// ImportBranchProtection lists the branch policies of the default branch
+	// of each imported repository in the import result, including whether
+	// the branch requires pull requests. Branch protection is not stored in
+	// Wharf.
+	ImportBranchProtection bool `json:"importBranchProtection" example:"false"`
+	// ImportProcessTemplates lists the process template of each imported
+	// Azure DevOps project in the import result, such as "Agile" or "Scrum".
+	// Costs one extra request to Azure DevOps per project. Process templates
+	// are not stored in Wharf.
+	ImportProcessTemplates bool `json:"importProcessTemplates" example:"false"`
+	// IncludeDisabledRepos imports Azure DevOps repositories that are
+	// disabled, which Wharf cannot clone. By default disabled repositories
+	// are skipped, and counted in the import result's reposSkipped field.
+	IncludeDisabledRepos bool `json:"includeDisabledRepos" example:"false"`
+	// IncludeHidden also imports the Azure DevOps repositories that are
+	// hidden, which Azure DevOps only lists when explicitly requested, when
+	// importing an organization or project. The hidden repositories are
+	// listed in the import result's hiddenRepos field. Soft-deleted
+	// repositories in the recycle bin are never imported. By default hidden
+	// repositories are excluded.
+	IncludeHidden bool `json:"includeHidden" example:"false"`
+	// IncludeAllProjectStates includes Azure DevOps projects in all states
+	// when importing an organization. By default only projects in the state
+	// "wellFormed" are imported.
+	IncludeAllProjectStates bool `json:"includeAllProjectStates" example:"false"`
+	// VisibilityFilter decides which Azure DevOps projects to import based on
+	// their visibility when importing an organization or project.
+	// Defaults to "all".
+	VisibilityFilter importer.VisibilityFilter `json:"visibilityFilter" enums:"all,private,public" example:"all"`
+	// ProjectPattern is a regular expression, such as "^team-", that only
+	// imports the Azure DevOps projects whose names match it when importing
+	// an organization. The other projects are listed in the import result's
+	// skippedProjects field, without fetching their repositories.
+	ProjectPattern string `json:"projectPattern" example:"^team-"`
+	// ProjectMetadataChangedSince skips the Azure DevOps projects, and their
+	// repositories, whose project metadata, such as the name or description,
+	// was last updated before this time. Pushes to the repositories do not
+	// update the project metadata, so repositories with new commits are
+	// still skipped. Skipped projects are listed in the import result's
+	// skippedProjects field when importing an organization, and skipped
+	// repositories are counted in the reposSkipped field otherwise. Projects
+	// whose last update time Azure DevOps does not report are always
+	// imported. Defaults to importing all projects.
+	ProjectMetadataChangedSince time.Time `json:"projectMetadataChangedSince" example:"2022-05-20T00:00:00Z"`
+	// MaxRepoSizeBytes skips the Azure DevOps repositories larger than this
+	// number of bytes, such as very large monorepos that would overwhelm the
+	// build runners. Skipped repositories are counted in the import result's
+	// reposSkipped field. The size excludes Git LFS objects, as Azure DevOps
+	// does not report their size. Defaults to 0, meaning no limit.
+	MaxRepoSizeBytes int64 `json:"maxRepoSizeBytes" example:"1073741824"`
+	// ContinueOnError continues importing the remaining repositories when a
+	// single repository fails to import, listing the failures in the import
+	// result's failedRepos field and responding with 207 Multi-Status,
+	// instead of aborting the whole import.
+	ContinueOnError bool `json:"continueOnError" example:"false"`
+}
+
+// providerAuth holds the token and provider to import into, as given in the
+// import request body.
+type providerAuth struct {
+	TokenID    uint
+	Token      string
+	UserName   string
+	URL        string
+	ProviderID uint
 }
 
 // runAzureDevOpsHandler godoc
 // @Summary Import projects from Azure DevOps or refresh existing one
+// @Description When requested with the header "Accept: text/event-stream",
+// @Description then the progress is streamed as server-sent events, with one
+// @Description "repo" event per imported repository, followed by a "result"
+// @Description event with the import result, or an "error" event with a problem.
+// @Description When the "Idempotency-Key" header is set, then the result is
+// @Description cached, and later requests with the same key get the cached
+// @Description result instead of importing again. Reusing a key with a
+// @Description different request body is rejected.
 // @Accept json
 // @Produce json
+// @Produce text/event-stream
 // @Param import body importBody _ "import object"
-// @Success 201 "Successfully imported"
+// @Param Idempotency-Key header string false "Key to deduplicate retried imports"
+// @Success 201 {object} importer.ImportResult "Successfully imported"
+// @Success 207 {object} importer.ImportResult "Partially imported, when continueOnError is set"
 // @Failure 400 {object} problem.Response "Bad request"
-// @Failure 401 {object} problem.Response "Unauthorized or missing jwt token"
+// @Failure 401 {object} problem.Response "Unauthorized or missing jwt token, or unauthorized by Azure DevOps"
+// @Failure 404 {object} problem.Response "Organization, project, or repository not found in Azure DevOps"
+// @Failure 409 {object} problem.Response "Import with same idempotency key in progress"
+// @Failure 413 {object} problem.Response "Request body too large"
+// @Failure 422 {object} problem.Response "Idempotency key reused with a different request body"
+// @Failure 429 {object} problem.Response "Rate limited by Azure DevOps"
 // @Failure 502 {object} problem.Response "Bad gateway"
 // @Router /azuredevops [post]
 func (m importModule) runAzureDevOpsHandler(c *gin.Context) {
+	reqLog := requestid.Logger(log, c)
+	idempotencyKey, ok := m.beginIdempotentImportWritesProblem(c)
+	if !ok {
+		return
+	}
+	var completed bool
+	defer func() {
+		if !completed {
+			m.idempotency.Release(idempotencyKey)
+		}
+	}()
+	start := time.Now()
+	defer func() {
+		m.metrics.ImportFinished(time.Since(start), importFailedReason(c.Writer.Status()))
+	}()
 	client := wharfapi.Client{
 		APIURL:     m.config.API.URL,
 		AuthHeader: c.GetHeader("Authorization"),
 	}
 
 	i := importBody{}
-	err := c.ShouldBindJSON(&i)
-	if err != nil {
-		ginutil.WriteInvalidBindError(c, err,
-			"One or more parameters failed to parse when reading the request body for import details.")
+	if !bindStrictJSONWritesProblem(c, &i, maxImportBodyBytes) {
 		return
 	}
 
 	if i.GroupName == "" {
-		log.Error().Message("Unable to get due to empty group.")
+		reqLog.Error().Message("Unable to get due to empty group.")
 		err := errors.New("missing required property: group")
 		ginutil.WriteInvalidParamError(c, err, "group",
 			"Unable to import due to empty group.")
 		return
 	}
+	azureOrg, azureProj, azureRepo, err := parseRepoRefParams(i.GroupName, i.ProjectName)
+	if err != nil {
+		ginutil.WriteInvalidParamError(c, err, "group",
+			fmt.Sprintf("Unable to import due to invalid group %q. "+
+				"Expected either an Azure DevOps organization name, such as %q, "+
+				"or an organization and project name separated by a single slash, such as %q.",
+				i.GroupName, "MyOrg", "MyOrg/MyProject"))
+		return
+	}
 
-	tokenData := importer.TokenData{
-		ReqToken: importer.ReqToken{
-			Token:    i.Token,
-			UserName: i.UserName,
-		},
-		ID: i.TokenID,
+	opts := m.importerOptions()
+	if i.RegisterWebhooks {
+		webhooks, ok := m.webhookOptionsWritesProblem(c, i.WebhookEnvironment)
+		if !ok {
+			return
+		}
+		opts.Webhooks = &webhooks
 	}
-	providerData := importer.ProviderData{
-		ReqProvider: importer.ReqProvider{
-			Name:    providerName,
-			URL:     i.URL,
-			TokenID: i.TokenID,
-		},
-		ID: i.ProviderID,
+	if i.BuildDefinitionPath != "" {
+		buildDefPath, err := importer.ValidateBuildDefinitionPath(i.BuildDefinitionPath)
+		if err != nil {
+			ginutil.WriteInvalidParamError(c, err, "buildDefinitionPath",
+				"Unable to import due to invalid build definition path. "+
+					"The path must be relative to the repository root and must not contain \"..\".")
+			return
+		}
+		opts.BuildDefinitionPath = buildDefPath
+	}
+	if len(i.BuildDefinitionPaths) > 0 {
+		buildDefPaths, ok := validateBuildDefinitionPathsWritesProblem(c, i.BuildDefinitionPath, i.BuildDefinitionPaths)
+		if !ok {
+			return
+		}
+		opts.BuildDefinitionPaths = buildDefPaths
+	}
+	if i.BuildDefinitionBranch != "" {
+		branch, err := importer.ValidateBuildDefinitionBranch(i.BuildDefinitionBranch)
+		if err != nil {
+			ginutil.WriteInvalidParamError(c, err, "buildDefinitionBranch",
+				fmt.Sprintf("Unable to import due to invalid build definition branch %q, expected a branch name.",
+					i.BuildDefinitionBranch))
+			return
+		}
+		opts.BuildDefinitionBranch = branch
+	}
+	if i.TargetGroup != "" {
+		targetGroup, err := importer.ValidateTargetGroup(i.TargetGroup)
+		if err != nil {
+			ginutil.WriteInvalidParamError(c, err, "targetGroup",
+				fmt.Sprintf("Unable to import due to invalid target group %q, "+
+					"expected a Wharf group name without empty segments, such as %q.",
+					i.TargetGroup, "platform/backend"))
+			return
+		}
+		opts.TargetGroup = targetGroup
+	}
+	if i.BranchRefFilter != "" {
+		filter, err := importer.ValidateBranchRefFilter(i.BranchRefFilter)
+		if err != nil {
+			ginutil.WriteInvalidParamError(c, err, "branchRefFilter",
+				fmt.Sprintf("Unable to import due to invalid branch ref filter %q, expected it to start with \"heads/\".",
+					i.BranchRefFilter))
+			return
+		}
+		opts.BranchRefFilter = filter
+	}
+	opts.SkipReposWithoutBuildDef = i.SkipReposWithoutBuildDef
+	opts.IncludeAllProjectStates = i.IncludeAllProjectStates
+	opts.IncludeDisabledRepos = i.IncludeDisabledRepos
+	opts.IncludeHidden = i.IncludeHidden
+	opts.UseReadmeDescription = i.UseReadmeDescription
+	opts.ImportTags = i.ImportTags
+	opts.ImportBranchProtection = i.ImportBranchProtection
+	opts.ImportProcessTemplates = i.ImportProcessTemplates
+	opts.ContinueOnError = i.ContinueOnError
+	opts.ProjectMetadataChangedSince = i.ProjectMetadataChangedSince
+	if i.MaxRepoSizeBytes < 0 {
+		err := fmt.Errorf("negative max repo size: %d", i.MaxRepoSizeBytes)
+		ginutil.WriteInvalidParamError(c, err, "maxRepoSizeBytes",
+			fmt.Sprintf("Unable to import due to invalid max repo size %d, expected zero or a positive number of bytes.",
+				i.MaxRepoSizeBytes))
+		return
+	}
+	opts.MaxRepoSizeBytes = i.MaxRepoSizeBytes
+	if i.ProjectPattern != "" {
+		pattern, err := regexp.Compile(i.ProjectPattern)
+		if err != nil {
+			ginutil.WriteInvalidParamError(c, err, "projectPattern",
+				fmt.Sprintf("Unable to import due to invalid project pattern %q, expected a regular expression.",
+					i.ProjectPattern))
+			return
+		}
+		opts.ProjectPattern = pattern
+	}
+	switch i.VisibilityFilter {
+	case "", importer.VisibilityFilterAll, importer.VisibilityFilterPrivate, importer.VisibilityFilterPublic:
+		opts.VisibilityFilter = i.VisibilityFilter
+	default:
+		err := fmt.Errorf("invalid visibility filter: %q", i.VisibilityFilter)
+		ginutil.WriteInvalidParamError(c, err, "visibilityFilter",
+			fmt.Sprintf("Unable to import due to invalid visibility filter %q, expected %q, %q, or %q.",
+				i.VisibilityFilter, importer.VisibilityFilterAll,
+				importer.VisibilityFilterPrivate, importer.VisibilityFilterPublic))
+		return
+	}
+
+	var stream *eventStream
+	if acceptsEventStream(c) {
+		stream = newEventStream(c)
+		opts.OnRepoImported = func(progress importer.RepoProgress) {
+			stream.writeEvent("repo", progress)
+		}
 	}
 
-	importer := importer.NewAzureImporter(c, &client)
-	ok := importer.InitWritesProblem(tokenData, providerData, c, client)
+	azureImporter, ok := m.initImporterWritesProblem(c, client, providerAuth{
+		TokenID:    i.TokenID,
+		Token:      i.Token,
+		UserName:   i.UserName,
+		URL:        i.URL,
+		ProviderID: i.ProviderID,
+	}, opts)
 	if !ok {
+		if stream != nil {
+			stream.finish(nil, false)
+		}
 		return
 	}
 
-	azureOrg, azureProj, azureRepo := parseRepoRefParams(i.GroupName, i.ProjectName)
+	var result importer.ImportResult
 	switch {
+	case i.ProjectID != 0:
+		// The repository is resolved from the Wharf project, as the group
+		// and project names do not map back to Azure DevOps when imported
+		// using the "org-only" grouping strategy or a target group.
+		reqLog.Debug().
+			WithString("org", azureOrg).
+			WithString("project", azureProj).
+			WithString("repo", azureRepo).
+			WithUint("projectId", i.ProjectID).
+			Message("Refreshing specific repo from project")
+		result, ok = azureImporter.RefreshRepositoryWritesProblem(azureOrg, azureProj, azureRepo, i.ProjectID)
 	case azureProj == "":
-		log.Debug().
+		reqLog.Debug().
 			WithString("org", azureOrg).
 			Message("Importing all repos from org")
-		ok = importer.ImportOrganizationWritesProblem(azureOrg)
+		result, ok = azureImporter.ImportOrganizationWritesProblem(azureOrg)
 	case azureRepo == "":
-		log.Debug().
+		reqLog.Debug().
 			WithString("org", azureOrg).
 			WithString("project", azureProj).
 			Message("Importing all repos from project")
-		ok = importer.ImportProjectWritesProblem(azureOrg, azureProj)
+		result, ok = azureImporter.ImportProjectWritesProblem(azureOrg, azureProj)
 	default:
-		log.Debug().
+		reqLog.Debug().
 			WithString("org", azureOrg).
 			WithString("project", azureProj).
 			WithString("repo", azureRepo).
 			Message("Importing specific repo from project")
-		ok = importer.ImportRepositoryWritesProblem(azureOrg, azureProj, azureRepo)
+		result, ok = azureImporter.ImportRepositoryWritesProblem(azureOrg, azureProj, azureRepo)
 	}
 
+	result.CloneProtocol = m.config.Import.CloneProtocol
+	if ok {
+		completed = m.completeIdempotentImport(idempotencyKey, result)
+	}
+	if stream != nil {
+		stream.finish(result, ok)
+		return
+	}
 	if !ok {
 		return
 	}
+	c.JSON(importResultStatus(result), result)
+}
 
-	c.Status(http.StatusCreated)
+// importResultStatus returns 207 Multi-Status for partial imports, where some
+// repositories failed to import, and 201 Created otherwise.
+func importResultStatus(result importer.ImportResult) int {
+	if len(result.FailedRepos) > 0 {
+		return http.StatusMultiStatus
+	}
+	return http.StatusCreated
 }
 
-func parseRepoRefParams(wharfGroupName, wharfProjectName string) (azureOrgName, azureProjectName, azureRepoName string) {
-	azureOrgName, azureProjectName = splitStringOnceRune(wharfGroupName, '/')
-	if azureProjectName == "" {
-		azureProjectName = wharfProjectName
-		azureRepoName = ""
-	} else {
-		azureRepoName = wharfProjectName
+// getProjectsHandler godoc
+// @Summary List projects from an Azure DevOps organization without importing
+// @Description The token is read from the Authorization header, using either
+// @Description basic auth with the personal access token as password, or a
+// @Description bearer token. Nothing is read from nor written to Wharf.
+// @Produce json
+// @Param Authorization header string true "Azure DevOps credentials"
+// @Param org path string true "Azure DevOps organization name"
+// @Param url query string false "Azure DevOps URL, defaults to https://dev.azure.com"
+// @Success 200 {object} []azureapi.Project "OK"
+// @Failure 400 {object} problem.Response "Bad request"
+// @Failure 401 {object} problem.Response "Missing or invalid Authorization header, or unauthorized by Azure DevOps"
+// @Failure 404 {object} problem.Response "Organization, project, or repository not found in Azure DevOps"
+// @Failure 429 {object} problem.Response "Rate limited by Azure DevOps"
+// @Failure 502 {object} problem.Response "Bad gateway"
+// @Router /azuredevops/organizations/{org}/projects [get]
+func (m importModule) getProjectsHandler(c *gin.Context) {
+	orgName, ok := ginutil.RequireParamString(c, "org")
+	if !ok {
+		return
 	}
-	return
+	azureClient, ok := m.newAzureClientWritesProblem(c)
+	if !ok {
+		return
+	}
+
+	projects, ok := azureClient.GetProjectsWritesProblem(orgName)
+	if !ok {
+		return
+	}
+	importer.SortProjectsByName(projects)
+
+	c.JSON(http.StatusOK, projects)
 }
 
-// prCreatedTriggerHandler godoc
-// @Summary Triggers prcreated action on wharf-client
-// @Accept json
+// repositoriesPageQuery holds the optional paging query parameters of the
+// list repositories endpoint.
+type repositoriesPageQuery struct {
+	Top               int    `form:"top"`
+	ContinuationToken string `form:"continuationToken"`
+}
+
+// defaultRepositoriesPageSize is the page size used when only the
+// continuation token is given.
+const defaultRepositoriesPageSize = 100
+
+// continuationTokenHeader is the response header holding the continuation
+// token of the next page when listing repositories in pages.
+const continuationTokenHeader = "X-Continuation-Token"
+
+// getRepositoriesHandler godoc
+// @Summary List repositories from an Azure DevOps project without importing
+// @Description The repositories are paged when the "top" or
+// @Description "continuationToken" query parameters are set, where the
+// @Description continuation token of the next page is returned in the
+// @Description "X-Continuation-Token" response header. The header is omitted
+// @Description on the last page.
+// @Description The token is read from the Authorization header, using either
+// @Description basic auth with the personal access token as password, or a
+// @Description bearer token. Nothing is read from nor written to Wharf.
 // @Produce json
-// @Param projectid path int true "wharf project ID"
-// @Param azureDevOpsPR body azureapi.PullRequestEvent _ "AzureDevOps PR"
-// @Param environment query string true "wharf build environment"
-// @Success 200 {object} response.BuildReferenceWrapper "OK"
+// @Param Authorization header string true "Azure DevOps credentials"
+// @Param org path string true "Azure DevOps organization name"
+// @Param project path string true "Azure DevOps project name or ID"
+// @Param top query int false "Max number of repositories per page"
+// @Param continuationToken query string false "Continuation token from the previous page"
+// @Param url query string false "Azure DevOps URL, defaults to https://dev.azure.com"
+// @Success 200 {object} []azureapi.Repository "OK"
+// @Header 200 {string} X-Continuation-Token "Continuation token of the next page"
 // @Failure 400 {object} problem.Response "Bad request"
-// @Failure 401 {object} problem.Response "Unauthorized or missing jwt token"
+// @Failure 401 {object} problem.Response "Missing or invalid Authorization header, or unauthorized by Azure DevOps"
+// @Failure 404 {object} problem.Response "Organization, project, or repository not found in Azure DevOps"
+// @Failure 429 {object} problem.Response "Rate limited by Azure DevOps"
 // @Failure 502 {object} problem.Response "Bad gateway"
-// @Router /azuredevops/triggers/{projectid}/pr/created [post]
-func (m importModule) prCreatedTriggerHandler(c *gin.Context) {
-	const eventTypePullRequest string = "git.pullrequest.created"
+// @Router /azuredevops/organizations/{org}/projects/{project}/repositories [get]
+func (m importModule) getRepositoriesHandler(c *gin.Context) {
+	orgName, ok := ginutil.RequireParamString(c, "org")
+	if !ok {
+		return
+	}
+	projectNameOrID, ok := ginutil.RequireParamString(c, "project")
+	if !ok {
+		return
+	}
 
-	t := azureapi.PullRequestEvent{}
-	if err := c.ShouldBindJSON(&t); err != nil {
+	var pageQuery repositoriesPageQuery
+	if err := c.ShouldBindQuery(&pageQuery); err != nil {
 		ginutil.WriteInvalidBindError(c, err,
-			"One or more parameters failed to parse when reading the request body for pull request.")
+			"One or more parameters failed to parse when reading query parameters.")
+		return
+	}
+	if pageQuery.Top < 0 {
+		ginutil.WriteInvalidParamError(c, errors.New("negative page size"), "top",
+			fmt.Sprintf("Invalid page size %d. Must be a positive number.", pageQuery.Top))
+		return
+	}
+
+	azureClient, ok := m.newAzureClientWritesProblem(c)
+	if !ok {
 		return
 	}
 
-	if t.EventType != eventTypePullRequest {
-		err := fmt.Errorf("expected event type %q for trigger, got: %q", eventTypePullRequest, t.EventType)
-		ginutil.WriteProblemError(c, err, problem.Response{
-			Type:   "/prob/provider/azuredevops/unsupported-event-type",
-			Title:  "Invalid event type.",
-			Status: http.StatusBadRequest,
-			Detail: fmt.Sprintf("Received event type %q, while only %q is supported.",
-				t.EventType, eventTypePullRequest),
-		})
+	if pageQuery.Top == 0 && pageQuery.ContinuationToken == "" {
+		repos, ok := azureClient.GetRepositoriesWritesProblem(orgName, projectNameOrID)
+		if !ok {
+			return
+		}
+		importer.SortRepositoriesByName(repos)
+		c.JSON(http.StatusOK, repos)
+		return
+	}
+
+	top := pageQuery.Top
+	if top == 0 {
+		top = defaultRepositoriesPageSize
+	}
+	// Pages are not sorted by name, as sorting a single page would give an
+	// inconsistent order over all pages.
+	page, ok := azureClient.GetRepositoriesPageWritesProblem(orgName, projectNameOrID, top, pageQuery.ContinuationToken)
+	if !ok {
 		return
 	}
+	if page.ContinuationToken != "" {
+		c.Header(continuationTokenHeader, page.ContinuationToken)
+	}
+	c.JSON(http.StatusOK, page.Repositories)
+}
 
-	projectID, ok := ginutil.ParseParamUint(c, "projectid")
+// validateTokenHandler godoc
+// @Summary Validate a token's access to an Azure DevOps organization
+// @Description Tries a set of minimal read operations in the organization,
+// @Description such as listing projects and repositories, and reports which
+// @Description of them succeeded. Operations that Azure DevOps responded to
+// @Description with 403 Forbidden are reported as missing scope, while
+// @Description "valid" is false if any of the operations failed.
+// @Description The token is read from the Authorization header, using either
+// @Description basic auth with the personal access token as password, or a
+// @Description bearer token. Nothing is read from nor written to Wharf.
+// @Produce json
+// @Param Authorization header string true "Azure DevOps credentials"
+// @Param org query string true "Azure DevOps organization name"
+// @Param url query string false "Azure DevOps URL, defaults to https://dev.azure.com"
+// @Success 200 {object} azureapi.TokenValidation "OK"
+// @Failure 400 {object} problem.Response "Bad request"
+// @Failure 401 {object} problem.Response "Missing or invalid Authorization header"
+// @Router /azuredevops/token/validate [get]
+func (m importModule) validateTokenHandler(c *gin.Context) {
+	orgName, ok := ginutil.RequireQueryString(c, "org")
 	if !ok {
 		return
 	}
+	azureClient, ok := m.newAzureClientWritesProblem(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, azureClient.ValidateToken(orgName))
+}
 
-	environment, ok := ginutil.RequireQueryString(c, "environment")
+// pingOrganizationHandler godoc
+// @Summary Test the connectivity to an Azure DevOps organization
+// @Description Lists the first project of the organization and reports the
+// @Description HTTP status and round-trip time, such as to catch typos in the
+// @Description organization name or network issues before importing.
+// @Description Responds with 200 "OK" even if Azure DevOps could not be
+// @Description reached, where "ok" is false in the response.
+// @Description The token is read from the Authorization header, using either
+// @Description basic auth with the personal access token as password, or a
+// @Description bearer token. Nothing is read from nor written to Wharf.
+// @Produce json
+// @Param Authorization header string true "Azure DevOps credentials"
+// @Param org path string true "Azure DevOps organization name"
+// @Param url query string false "Azure DevOps URL, defaults to https://dev.azure.com"
+// @Success 200 {object} azureapi.OrganizationPing "OK"
+// @Failure 400 {object} problem.Response "Bad request"
+// @Failure 401 {object} problem.Response "Missing or invalid Authorization header"
+// @Router /azuredevops/organizations/{org}/ping [get]
+func (m importModule) pingOrganizationHandler(c *gin.Context) {
+	orgName, ok := ginutil.RequireParamString(c, "org")
 	if !ok {
 		return
 	}
+	azureClient, ok := m.newAzureClientWritesProblem(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, azureClient.PingOrganization(orgName))
+}
 
-	client := wharfapi.Client{
+// newWharfClientWritesProblem creates a Wharf API client using the
+// Authorization header from the request, or writes a 401 problem if the
+// header is missing.
+func (m importModule) newWharfClientWritesProblem(c *gin.Context) (wharfapi.Client, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		ginutil.WriteUnauthorized(c,
+			"Missing Authorization header, which is required to talk to the Wharf API.")
+		return wharfapi.Client{}, false
+	}
+	return wharfapi.Client{
 		APIURL:     m.config.API.URL,
-		AuthHeader: c.GetHeader("Authorization"),
+		AuthHeader: authHeader,
+	}, true
+}
+
+// defaultAzureDevOpsURL is the Azure DevOps URL used by the endpoints that
+// only talk to Azure DevOps when no URL is given, unless in server mode.
+const defaultAzureDevOpsURL = "https://dev.azure.com"
+
+// azureClientQuery holds the query parameters of the endpoints that only talk
+// to Azure DevOps, without importing into Wharf.
+type azureClientQuery struct {
+	URL string `form:"url"`
+}
+
+// newAzureClientWritesProblem creates an Azure DevOps API client using the
+// credentials from the Authorization header, without reading from or writing
+// to the Wharf API. The credentials are not read from the query parameters,
+// as URLs end up in access logs.
+//
+// Writes a 401 problem if the header is missing, or a 400 problem if the
+// "url" query parameter is invalid.
+func (m importModule) newAzureClientWritesProblem(c *gin.Context) (*azureapi.Client, bool) {
+	var q azureClientQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		ginutil.WriteInvalidBindError(c, err,
+			"One or more parameters failed to parse when reading query parameters.")
+		return nil, false
+	}
+	rawURL := q.URL
+	if rawURL == "" && m.config.Import.Mode != azureapi.ModeServer {
+		rawURL = defaultAzureDevOpsURL
+	}
+	urlParsed, err := importer.NormalizeProviderURL(rawURL)
+	if err != nil {
+		ginutil.WriteInvalidParamError(c, redact.Error(err), "url",
+			fmt.Sprintf("Invalid Azure DevOps URL %q. Expected an absolute HTTP or HTTPS URL, such as %q.",
+				redact.URLString(rawURL), defaultAzureDevOpsURL))
+		return nil, false
+	}
+	userName, token, authMode, ok := parseAzureAuthHeader(c.Request)
+	if !ok {
+		ginutil.WriteUnauthorized(c,
+			"Missing or invalid Authorization header, which is required to talk to Azure DevOps. "+
+				"Expected basic auth with the personal access token as password, or a bearer token.")
+		return nil, false
 	}
+	return &azureapi.Client{
+		Context:       c,
+		BaseURL:       urlParsed.String(),
+		BaseURLParsed: urlParsed,
+		UserName:      userName,
+		Token:         token,
+		Mode:          m.config.Import.Mode,
+		AuthMode:      authMode,
+
+		OmitBasicAuthUser: m.config.Azure.OmitBasicAuthUser,
+		HTTPClient:        m.azureHTTPClient,
+	}, true
+}
 
-	params := wharfapi.ProjectStartBuild{
-		Stage:       "prcreated",
-		Branch:      strings.TrimPrefix(t.Resource.SourceRefName, "refs/heads/"),
-		Environment: environment,
+// parseAzureAuthHeader returns the Azure DevOps credentials from the
+// Authorization header, using either basic auth, where the password is the
+// personal access token, or a bearer token, such as an OAuth access token.
+func parseAzureAuthHeader(req *http.Request) (userName, token string, authMode azureapi.AuthMode, ok bool) {
+	if userName, token, ok := req.BasicAuth(); ok && token != "" {
+		return userName, token, azureapi.AuthModeBasic, true
 	}
-	resp, err := client.StartProjectBuild(projectID, params, nil)
+	scheme, token, found := strings.Cut(req.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", "", "", false
+	}
+	return "", token, azureapi.AuthModeBearer, true
+}
 
-	if authErr, ok := err.(*wharfapi.AuthError); ok {
-		ginutil.WriteUnauthorizedError(c, authErr,
-			"Failed to authenticate to the Wharf API. The Authorization header was "+
-				"missing or is invalid.")
-		return
+// importFailedReason returns the reason label of the failed imports metric,
+// based on the HTTP response status, or an empty string if the import
+// succeeded.
+func importFailedReason(status int) string {
+	switch {
+	case status < 400:
+		return ""
+	case status == http.StatusBadRequest:
+		return "invalid-request"
+	case status == http.StatusUnauthorized:
+		return "unauthorized"
+	case status == http.StatusNotFound:
+		return "not-found"
+	case status == http.StatusTooManyRequests:
+		return "rate-limited"
+	case status == http.StatusInternalServerError:
+		return "internal-error"
+	case status == http.StatusBadGateway:
+		return "bad-gateway"
+	case status == http.StatusServiceUnavailable, status == http.StatusGatewayTimeout:
+		return "aborted"
+	default:
+		return "other"
 	}
+}
 
-	if err != nil {
-		log.Error().WithError(err).Message("Failed to send trigger to wharf-api.")
-		err = fmt.Errorf("unable to send trigger to wharf-api: %w", err)
-		ginutil.WriteTriggerError(c, err, "Unable to send trigger to Wharf API.")
-		return
+// validateBuildDefinitionPathsWritesProblem returns the cleaned build
+// definition paths, or writes an invalid param problem if any path is
+// invalid, if there are too many paths, or if combined with the single
+// build definition path.
+func validateBuildDefinitionPathsWritesProblem(c *gin.Context, buildDefPath string, buildDefPaths []string) ([]string, bool) {
+	if buildDefPath != "" {
+		err := errors.New("both buildDefinitionPath and buildDefinitionPaths were set")
+		ginutil.WriteInvalidParamError(c, err, "buildDefinitionPaths",
+			"Unable to import due to both buildDefinitionPath and buildDefinitionPaths being set. "+
+				"Use only one of them.")
+		return nil, false
+	}
+	if len(buildDefPaths) > maxBuildDefinitionPaths {
+		err := fmt.Errorf("too many build definition paths: %d", len(buildDefPaths))
+		ginutil.WriteInvalidParamError(c, err, "buildDefinitionPaths",
+			fmt.Sprintf("Unable to import due to %d build definition paths, expected at most %d.",
+				len(buildDefPaths), maxBuildDefinitionPaths))
+		return nil, false
+	}
+	cleaned := make([]string, 0, len(buildDefPaths))
+	for _, p := range buildDefPaths {
+		cleanedPath, err := importer.ValidateBuildDefinitionPath(p)
+		if err != nil {
+			ginutil.WriteInvalidParamError(c, err, "buildDefinitionPaths",
+				fmt.Sprintf("Unable to import due to invalid build definition path %q. "+
+					"The path must be relative to the repository root and must not contain \"..\".", p))
+			return nil, false
+		}
+		cleaned = append(cleaned, cleanedPath)
 	}
+	return cleaned, true
+}
+
+func (m importModule) importerOptions() importer.Options {
+	return importer.Options{
+		SSHPort:       m.config.Import.SSHPort,
+		CloneProtocol: m.config.Import.CloneProtocol,
+		Mode:          m.config.Import.Mode,
+		AuthMode:      m.config.Azure.AuthMode,
+		Metrics:       m.metrics,
+
+		OmitBasicAuthUser:   m.config.Azure.OmitBasicAuthUser,
+		HTTPClient:          m.azureHTTPClient,
+		GroupingStrategy:    m.config.Import.GroupingStrategy,
+		RemoteID:            m.config.Import.RemoteID,
+		ProjectNameTemplate: m.config.Import.projectNameTemplate,
+		GroupNameTemplate:   m.config.Import.groupNameTemplate,
+
+		WharfConflictRetries: m.config.API.ConflictRetries,
+		WharfWriteSemaphore:  m.wharfWriteSemaphore,
+		WharfAPIDiagnoser:    m.wharfAPIDiagnoser(),
+	}
+}
+
+// wharfAPIDiagnoser returns the diagnoser of errors from the Wharf API, used
+// to report a misconfigured Wharf API URL.
+func (m importModule) wharfAPIDiagnoser() wharfapiext.Diagnoser {
+	return wharfapiext.Diagnoser{
+		APIURL:     m.config.API.URL,
+		HTTPClient: m.wharfHTTPClient,
+	}
+}
+
+func (m importModule) webhookOptionsWritesProblem(c *gin.Context, environment string) (importer.WebhookOptions, bool) {
+	publicURL := m.config.Triggers.PublicURL
+	if publicURL == "" {
+		err := errors.New("missing config: triggers.publicUrl")
+		ginutil.WriteInvalidParamError(c, err, "registerWebhooks",
+			"Unable to register webhooks as this provider has not been configured with a public URL.")
+		return importer.WebhookOptions{}, false
+	}
+	if environment == "" {
+		err := errors.New("missing required property: webhookEnvironment")
+		ginutil.WriteInvalidParamError(c, err, "webhookEnvironment",
+			"Unable to register webhooks due to empty webhook environment.")
+		return importer.WebhookOptions{}, false
+	}
+	return importer.WebhookOptions{
+		TriggerURL: func(wharfProjectID uint) string {
+			return newPRCreatedTriggerURL(publicURL, m.config.HTTP.BasePath, wharfProjectID, environment)
+		},
+		BasicAuthUser:     m.config.Triggers.BasicAuthUser,
+		BasicAuthPassword: m.config.Triggers.BasicAuthPassword,
+	}, true
+}
+
+func newPRCreatedTriggerURL(publicURL, basePath string, wharfProjectID uint, environment string) string {
+	q := url.Values{}
+	q.Set("environment", environment)
+	return fmt.Sprintf("%s%s/import/azuredevops/triggers/%d/pr/created?%s",
+		strings.TrimSuffix(publicURL, "/"), basePath, wharfProjectID, q.Encode())
+}
+
+func (m importModule) initImporterWritesProblem(c *gin.Context, client wharfapi.Client, auth providerAuth, opts importer.Options) (importer.Importer, bool) {
+	tokenData := importer.TokenData{
+		ReqToken: importer.ReqToken{
+			Token:    auth.Token,
+			UserName: auth.UserName,
+		},
+		ID: auth.TokenID,
+	}
+	providerData := importer.ProviderData{
+		ReqProvider: importer.ReqProvider{
+			Name:    providerName,
+			URL:     auth.URL,
+			TokenID: auth.TokenID,
+		},
+		ID: auth.ProviderID,
+	}
+
+	azureImporter := importer.NewAzureImporter(c, &client, opts)
+	if !azureImporter.InitWritesProblem(tokenData, providerData, c, client) {
+		return nil, false
+	}
+	return azureImporter, true
+}
 
-	c.JSON(http.StatusOK, resp)
+// parseRepoRefParams maps the Wharf group and project names to the Azure
+// DevOps organization, project, and repository names. Group names with more
+// than one slash are rejected, as Azure DevOps project names cannot contain
+// slashes.
+func parseRepoRefParams(wharfGroupName, wharfProjectName string) (azureOrgName, azureProjectName, azureRepoName string, err error) {
+	if strings.Count(wharfGroupName, "/") > 1 {
+		return "", "", "", fmt.Errorf("group contains more than one slash: %q", wharfGroupName)
+	}
+	azureOrgName, azureProjectName = splitStringOnceRune(wharfGroupName, '/')
+	if azureOrgName == "" {
+		return "", "", "", fmt.Errorf("group is missing organization name: %q", wharfGroupName)
+	}
+	if azureProjectName == "" {
+		azureProjectName = wharfProjectName
+		azureRepoName = ""
+	} else {
+		azureRepoName = wharfProjectName
+	}
+	return
 }
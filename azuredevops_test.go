@@ -1,9 +1,21 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/request"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/wharfapitest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseRepoRefParams(t *testing.T) {
@@ -14,6 +26,7 @@ func TestParseRepoRefParams(t *testing.T) {
 		wantAzureOrg     string
 		wantAzureProject string
 		wantAzureRepo    string
+		wantErr          bool
 	}{
 		{
 			name:             "old v1 format",
@@ -31,14 +44,516 @@ func TestParseRepoRefParams(t *testing.T) {
 			wantAzureProject: "Proj",
 			wantAzureRepo:    "Repo",
 		},
+		{
+			name:             "only org",
+			wharfGroup:       "Org",
+			wharfProject:     "",
+			wantAzureOrg:     "Org",
+			wantAzureProject: "",
+			wantAzureRepo:    "",
+		},
+		{
+			name:             "org and project without repo",
+			wharfGroup:       "Org/Proj",
+			wharfProject:     "",
+			wantAzureOrg:     "Org",
+			wantAzureProject: "Proj",
+			wantAzureRepo:    "",
+		},
+		{
+			name:         "more than one slash",
+			wharfGroup:   "Org/Proj/Extra",
+			wharfProject: "Repo",
+			wantErr:      true,
+		},
+		{
+			name:         "trailing slash after project",
+			wharfGroup:   "Org/Proj/",
+			wharfProject: "Repo",
+			wantErr:      true,
+		},
+		{
+			name:         "missing org",
+			wharfGroup:   "/Proj",
+			wharfProject: "Repo",
+			wantErr:      true,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			gotAzureOrg, gotAzureProject, gotAzureRepo := parseRepoRefParams(tc.wharfGroup, tc.wharfProject)
+			gotAzureOrg, gotAzureProject, gotAzureRepo, err := parseRepoRefParams(tc.wharfGroup, tc.wharfProject)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
 			assert.Equal(t, tc.wantAzureOrg, gotAzureOrg)
 			assert.Equal(t, tc.wantAzureProject, gotAzureProject)
 			assert.Equal(t, tc.wantAzureRepo, gotAzureRepo)
 		})
 	}
 }
+
+func TestNewPRCreatedTriggerURL(t *testing.T) {
+	got := newPRCreatedTriggerURL("https://wharf.example.com/", "", 12, "my env")
+	want := "https://wharf.example.com/import/azuredevops/triggers/12/pr/created?environment=my+env"
+	assert.Equal(t, want, got)
+}
+
+func TestNewPRCreatedTriggerURLWithBasePath(t *testing.T) {
+	got := newPRCreatedTriggerURL("https://wharf.example.com/", "/azure", 12, "prod")
+	want := "https://wharf.example.com/azure/import/azuredevops/triggers/12/pr/created?environment=prod"
+	assert.Equal(t, want, got)
+}
+
+func TestValidateBuildDefinitionPathsWritesProblem(t *testing.T) {
+	var testCases = []struct {
+		name  string
+		path  string
+		paths []string
+		want  []string
+	}{
+		{
+			name:  "valid",
+			paths: []string{"./.wharf-ci.yml", "ci/.wharf-ci.dev.yml"},
+			want:  []string{".wharf-ci.yml", "ci/.wharf-ci.dev.yml"},
+		},
+		{
+			name:  "invalid path",
+			paths: []string{".wharf-ci.yml", "../.wharf-ci.yml"},
+		},
+		{
+			name:  "combined with single path",
+			path:  ".wharf-ci.yml",
+			paths: []string{".wharf-ci.dev.yml"},
+		},
+		{
+			name:  "too many",
+			paths: make([]string, maxBuildDefinitionPaths+1),
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			got, ok := validateBuildDefinitionPathsWritesProblem(c, tc.path, tc.paths)
+
+			if tc.want == nil {
+				assert.False(t, ok)
+				assert.Equal(t, http.StatusBadRequest, rec.Code)
+				return
+			}
+			assert.True(t, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseAzureAuthHeader(t *testing.T) {
+	testCases := []struct {
+		name         string
+		header       string
+		wantUserName string
+		wantToken    string
+		wantAuthMode azureapi.AuthMode
+		wantOK       bool
+	}{
+		{
+			name:         "basic",
+			header:       "Basic dXNlcjpwYXQ=",
+			wantUserName: "user",
+			wantToken:    "pat",
+			wantAuthMode: azureapi.AuthModeBasic,
+			wantOK:       true,
+		},
+		{
+			name:         "basic without user",
+			header:       "Basic OnBhdA==",
+			wantToken:    "pat",
+			wantAuthMode: azureapi.AuthModeBasic,
+			wantOK:       true,
+		},
+		{
+			name:         "bearer",
+			header:       "Bearer eyJ0.eyJ1.c2ln",
+			wantToken:    "eyJ0.eyJ1.c2ln",
+			wantAuthMode: azureapi.AuthModeBearer,
+			wantOK:       true,
+		},
+		{
+			name:   "basic without token",
+			header: "Basic dXNlcjo=",
+		},
+		{
+			name:   "empty bearer",
+			header: "Bearer ",
+		},
+		{
+			name:   "unknown scheme",
+			header: "Digest foo",
+		},
+		{
+			name: "missing",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			userName, token, authMode, ok := parseAzureAuthHeader(req)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantUserName, userName)
+			assert.Equal(t, tc.wantToken, token)
+			assert.Equal(t, tc.wantAuthMode, authMode)
+		})
+	}
+}
+
+func TestValidateTokenHandler(t *testing.T) {
+	var gotAuthHeaders []string
+	azureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuthHeaders = append(gotAuthHeaders, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/Org/_apis/projects":
+			w.Write([]byte(`{"count":0,"value":[]}`))
+		case "/Org/_apis/git/repositories":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"TF401027: You need the Git 'GenericRead' permission."}`))
+		default:
+			t.Errorf("unexpected Azure DevOps request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer azureServer.Close()
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Wharf API request: %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{API: WharfAPIConfig{URL: wharfServer.URL}}
+	importModule{config: &cfg}.register(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/import/azuredevops/token/validate?org=Org&url="+azureServer.URL, nil)
+	req.SetBasicAuth("user", "pat")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var validation azureapi.TokenValidation
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &validation))
+	assert.False(t, validation.Valid)
+	require.Len(t, validation.Operations, 2)
+	assert.True(t, validation.Operations[0].Succeeded)
+	assert.True(t, validation.Operations[1].MissingScope)
+	require.NotEmpty(t, gotAuthHeaders)
+	for _, h := range gotAuthHeaders {
+		assert.Equal(t, "Basic dXNlcjpwYXQ=", h)
+	}
+}
+
+func TestValidateTokenHandlerMissingAuthorization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	importModule{config: &Config{}}.register(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/import/azuredevops/token/validate?org=Org&token=pat", nil)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+}
+
+func TestPingOrganizationHandler(t *testing.T) {
+	var gotAuthHeader string
+	azureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuthHeader = r.Header.Get("Authorization")
+		if r.URL.Path != "/Org/_apis/projects" {
+			t.Errorf("unexpected Azure DevOps request: %s %s", r.Method, r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count":0,"value":[]}`))
+	}))
+	defer azureServer.Close()
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Wharf API request: %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{API: WharfAPIConfig{URL: wharfServer.URL}}
+	importModule{config: &cfg}.register(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/import/azuredevops/organizations/Org/ping?url="+azureServer.URL, nil)
+	req.Header.Set("Authorization", "Bearer azure-token")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var ping azureapi.OrganizationPing
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ping))
+	assert.True(t, ping.OK)
+	assert.Equal(t, "Bearer azure-token", gotAuthHeader)
+}
+
+func TestGetRepositoriesHandler(t *testing.T) {
+	var gotAuthHeader string
+	azureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuthHeader = r.Header.Get("Authorization")
+		if r.URL.Path != "/Org/Proj/_apis/git/repositories" {
+			t.Errorf("unexpected Azure DevOps request: %s %s", r.Method, r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"count":2,"value":[{"name":"Bravo"},{"name":"Alpha"}]}`))
+	}))
+	defer azureServer.Close()
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Wharf API request: %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{API: WharfAPIConfig{URL: wharfServer.URL}}
+	importModule{config: &cfg}.register(r)
+
+	req := httptest.NewRequest(http.MethodGet,
+		"/import/azuredevops/organizations/Org/projects/Proj/repositories?url="+azureServer.URL, nil)
+	req.SetBasicAuth("", "pat")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var repos []azureapi.Repository
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &repos))
+	require.Len(t, repos, 2)
+	assert.Equal(t, "Alpha", repos[0].Name)
+	assert.Equal(t, "Bravo", repos[1].Name)
+	assert.Equal(t, "Basic OnBhdA==", gotAuthHeader)
+}
+
+func TestImportWithTargetGroupThenPostPRStatus(t *testing.T) {
+	var gotStatusPath string
+	var azureServer *httptest.Server
+	azureServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/Org/Proj/_apis/git/repositories/Repo":
+			json.NewEncoder(w).Encode(azureapi.Repository{
+				ID:               "repo-id",
+				Name:             "Repo",
+				URL:              azureServer.URL + "/Org/_apis/git/repositories/repo-id",
+				Project:          azureapi.Project{ID: "proj-id", Name: "Proj"},
+				DefaultBranchRef: "refs/heads/main",
+			})
+		case "/Org/Proj/_apis/git/repositories/repo-id/refs":
+			w.Write([]byte(`{"count":1,"value":[{"name":"refs/heads/main"}]}`))
+		case "/Org/Proj/_apis/git/repositories/repo-id/items":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("build: {}\n"))
+		case "/Org/proj-id/_apis/git/repositories/repo-id/pullRequests/7/statuses":
+			gotStatusPath = r.URL.Path
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":1}`))
+		default:
+			t.Errorf("unexpected Azure DevOps request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer azureServer.Close()
+
+	wharf := &wharfapitest.Fake{}
+	wharfServer := httptest.NewServer(newFakeWharfAPIHandler(t, wharf))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{
+		API:      WharfAPIConfig{URL: wharfServer.URL},
+		Triggers: TriggersConfig{PostPRStatus: true},
+	}
+	importModule{config: &cfg}.register(r)
+
+	importBody := strings.NewReader(`{"token":"azure-token","user":"user","url":"` + azureServer.URL + `",` +
+		`"group":"Org/Proj","project":"Repo","targetGroup":"platform/backend"}`)
+	req := httptest.NewRequest(http.MethodPost, "/import/azuredevops", importBody)
+	req.Header.Set("Authorization", "Bearer wharf-token")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.Len(t, wharf.Projects, 1)
+	assert.Equal(t, "platform/backend", wharf.Projects[0].GroupName)
+
+	triggerBody := strings.NewReader(`{"eventType":"git.pullrequest.created","resource":{"pullRequestId":7,` +
+		`"sourceRefName":"refs/heads/feature/foo","targetRefName":"refs/heads/main",` +
+		`"url":"` + azureServer.URL + `/Org/_apis/git/repositories/repo-id/pullRequests/7",` +
+		`"repository":{"id":"repo-id","project":{"id":"proj-id"}}}}`)
+	req = httptest.NewRequest(http.MethodPost, "/import/azuredevops/triggers/"+
+		strconv.FormatUint(uint64(wharf.Projects[0].ProjectID), 10)+"/pr/created?environment=dev", triggerBody)
+	req.Header.Set("Authorization", "Bearer wharf-token")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "/Org/proj-id/_apis/git/repositories/repo-id/pullRequests/7/statuses", gotStatusPath,
+		"status posted to the Azure DevOps organization of the event, not of the Wharf group")
+}
+
+func TestImportWithTargetGroupThenRefresh(t *testing.T) {
+	var azureServer *httptest.Server
+	azureServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/Org/Proj/_apis/git/repositories/Repo":
+			json.NewEncoder(w).Encode(azureapi.Repository{
+				ID:               "repo-id",
+				Name:             "Repo",
+				URL:              azureServer.URL + "/Org/_apis/git/repositories/repo-id",
+				Project:          azureapi.Project{ID: "proj-id", Name: "Proj"},
+				DefaultBranchRef: "refs/heads/main",
+			})
+		case "/Org/Proj/_apis/git/repositories/repo-id/refs":
+			w.Write([]byte(`{"count":1,"value":[{"name":"refs/heads/main"}]}`))
+		case "/Org/Proj/_apis/git/repositories/repo-id/items":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("build: {}\n"))
+		default:
+			t.Errorf("unexpected Azure DevOps request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer azureServer.Close()
+
+	wharf := &wharfapitest.Fake{}
+	wharfServer := httptest.NewServer(newFakeWharfAPIHandler(t, wharf))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{API: WharfAPIConfig{URL: wharfServer.URL}}
+	importModule{config: &cfg}.register(r)
+
+	importBody := strings.NewReader(`{"token":"azure-token","user":"user","url":"` + azureServer.URL + `",` +
+		`"group":"Org/Proj","project":"Repo","targetGroup":"platform/backend"}`)
+	req := httptest.NewRequest(http.MethodPost, "/import/azuredevops", importBody)
+	req.Header.Set("Authorization", "Bearer wharf-token")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.Len(t, wharf.Projects, 1)
+
+	// As sent by the Wharf web when refreshing, from the Wharf names.
+	refreshBody := strings.NewReader(`{"token":"azure-token","user":"user","url":"` + azureServer.URL + `",` +
+		`"group":"platform/backend","project":"Repo","projectId":` +
+		strconv.FormatUint(uint64(wharf.Projects[0].ProjectID), 10) + `}`)
+	req = httptest.NewRequest(http.MethodPost, "/import/azuredevops", refreshBody)
+	req.Header.Set("Authorization", "Bearer wharf-token")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	require.Len(t, wharf.Projects, 1, "refreshed in place")
+	assert.Equal(t, "platform/backend", wharf.Projects[0].GroupName)
+	assert.Contains(t, rec.Body.String(), `"projectsUpdated":1`)
+}
+
+// newFakeWharfAPIHandler serves the Wharf API endpoints used when importing
+// and triggering builds, backed by the fake. Builds are always started with
+// the build reference "123".
+func newFakeWharfAPIHandler(t *testing.T, fake *wharfapitest.Fake) http.Handler {
+	decode := func(r *http.Request, v any) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(v))
+	}
+	optional := func(r *http.Request, key string) *string {
+		if !r.URL.Query().Has(key) {
+			return nil
+		}
+		v := r.URL.Query().Get(key)
+		return &v
+	}
+	optionalUint := func(r *http.Request, key string) *uint {
+		v := optional(r, key)
+		if v == nil {
+			return nil
+		}
+		n, err := strconv.ParseUint(*v, 10, 0)
+		require.NoError(t, err)
+		u := uint(n)
+		return &u
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result any
+		var err error
+		segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+		var id uint
+		if len(segments) > 1 {
+			n, parseErr := strconv.ParseUint(segments[1], 10, 0)
+			require.NoError(t, parseErr)
+			id = uint(n)
+		}
+		switch route := r.Method + " " + segments[0]; {
+		case route == "GET token" && len(segments) == 1:
+			result, err = fake.GetTokenList(wharfapi.TokenSearch{UserName: optional(r, "userName")})
+		case route == "GET token":
+			result, err = fake.GetToken(id)
+		case route == "POST token":
+			var token request.Token
+			decode(r, &token)
+			result, err = fake.CreateToken(token)
+		case route == "PUT token":
+			var token request.TokenUpdate
+			decode(r, &token)
+			result, err = fake.UpdateToken(id, token)
+		case route == "GET provider" && len(segments) == 1:
+			result, err = fake.GetProviderList(wharfapi.ProviderSearch{
+				Name: optional(r, "name"),
+				URL:  optional(r, "url"),
+			})
+		case route == "GET provider":
+			result, err = fake.GetProvider(id)
+		case route == "POST provider":
+			var provider request.Provider
+			decode(r, &provider)
+			result, err = fake.CreateProvider(provider)
+		case route == "GET project" && len(segments) == 1:
+			result, err = fake.GetProjectList(wharfapi.ProjectSearch{
+				Name:       optional(r, "name"),
+				GroupName:  optional(r, "groupName"),
+				ProviderID: optionalUint(r, "providerId"),
+			})
+		case route == "GET project":
+			result, err = fake.GetProject(id)
+		case route == "POST project" && len(segments) == 1:
+			var project request.Project
+			decode(r, &project)
+			result, err = fake.CreateProject(project)
+		case route == "PUT project" && len(segments) == 2:
+			var project request.ProjectUpdate
+			decode(r, &project)
+			result, err = fake.UpdateProject(id, project)
+		case route == "PUT project" && segments[2] == "branch":
+			var branches []request.Branch
+			decode(r, &branches)
+			result, err = fake.UpdateProjectBranchList(id, branches)
+		case route == "POST project" && segments[2] == "build":
+			result = response.BuildReferenceWrapper{BuildReference: "123"}
+		default:
+			t.Errorf("unexpected Wharf API request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
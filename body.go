@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/problemtype"
+)
+
+// maxImportBodyBytes is the maximum size, in bytes, of the request body of
+// import requests. The import details are only a handful of short fields, so
+// anything larger is most likely not an import request.
+const maxImportBodyBytes = 1 << 20
+
+// bindStrictJSONWritesProblem decodes the request body as JSON into obj,
+// rejecting bodies larger than maxBytes and bodies containing fields that
+// obj does not have, such as a misspelled "groupName" instead of "group",
+// which would otherwise silently be ignored.
+func bindStrictJSONWritesProblem(c *gin.Context, obj any, maxBytes int64) bool {
+	body, ok := readBodyWritesProblem(c, maxBytes)
+	if !ok {
+		return false
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(obj); err != nil {
+		if field, ok := unknownJSONField(err); ok {
+			ginutil.WriteInvalidParamError(c, err, field,
+				fmt.Sprintf("Unexpected field %q in the request body. "+
+					"Check the field name for typos, as field names are case sensitive.", field))
+			return false
+		}
+		ginutil.WriteInvalidBindError(c, err,
+			"One or more parameters failed to parse when reading the request body.")
+		return false
+	}
+	return true
+}
+
+// bindJSONWritesProblem decodes the request body as JSON into obj, ignoring
+// fields that obj does not have, such as for the Azure DevOps service hook
+// events that contain many more fields than used. Bodies larger than maxBytes
+// are rejected, where zero or less disables the limit. The detail is used in
+// the problem written when the body fails to parse.
+func bindJSONWritesProblem(c *gin.Context, obj any, maxBytes int64, detail string) bool {
+	body, ok := readBodyWritesProblem(c, maxBytes)
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(body, obj); err != nil {
+		ginutil.WriteInvalidBindError(c, err, detail)
+		return false
+	}
+	return true
+}
+
+// readBodyWritesProblem reads the request body, or writes a problem if it is
+// larger than maxBytes, where zero or less disables the limit.
+func readBodyWritesProblem(c *gin.Context, maxBytes int64) ([]byte, bool) {
+	var reader io.Reader = c.Request.Body
+	if maxBytes > 0 {
+		reader = io.LimitReader(c.Request.Body, maxBytes+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		ginutil.WriteBodyReadError(c, err, "Unable to read the request body.")
+		return nil, false
+	}
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		ginutil.WriteProblemError(c, errors.New("request body too large"), problem.Response{
+			Type:   problemtype.RequestBodyTooLarge,
+			Title:  "Request body too large.",
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("The request body must not be larger than %d bytes.", maxBytes),
+		})
+		return nil, false
+	}
+	return body, true
+}
+
+// unknownJSONField returns the field name from the error returned by a
+// json.Decoder that disallows unknown fields. The encoding/json package does
+// not have a dedicated error type for this, so the error message is parsed.
+func unknownJSONField(err error) (string, bool) {
+	const prefix = "json: unknown field "
+	msg := err.Error()
+	if !strings.HasPrefix(msg, prefix) {
+		return "", false
+	}
+	return strings.Trim(strings.TrimPrefix(msg, prefix), `"`), true
+}
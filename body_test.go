@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/problemtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindStrictJSONWritesProblem(t *testing.T) {
+	var testCases = []struct {
+		name       string
+		body       string
+		wantOK     bool
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:   "valid",
+			body:   `{"group":"Org","project":"Proj"}`,
+			wantOK: true,
+		},
+		{
+			name:       "unknown field",
+			body:       `{"groupName":"Org"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `Unexpected field \"groupName\"`,
+		},
+		{
+			name:       "invalid JSON",
+			body:       `{"group":`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   problemtype.InvalidParam,
+		},
+		{
+			name:       "too large",
+			body:       `{"group":"` + strings.Repeat("a", 100) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody:   problemtype.RequestBodyTooLarge,
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", strings.NewReader(tc.body))
+
+			var body importBody
+			ok := bindStrictJSONWritesProblem(c, &body, 64)
+
+			assert.Equal(t, tc.wantOK, ok)
+			if tc.wantOK {
+				assert.Equal(t, "Org", body.GroupName)
+				assert.Equal(t, "Proj", body.ProjectName)
+				return
+			}
+			assert.Equal(t, tc.wantStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), tc.wantBody)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type capabilitiesModule struct {
+	config *Config
+}
+
+func (m capabilitiesModule) register(r gin.IRouter) {
+	r.GET("/import/azuredevops/capabilities", m.getCapabilitiesHandler)
+}
+
+// Import scopes supported by the POST /import/azuredevops endpoint, decided by
+// which of the organization, project, and repository are set in its body.
+const (
+	importScopeOrganization = "organization"
+	importScopeProject      = "project"
+	importScopeRepository   = "repository"
+)
+
+// Capabilities describes what this provider version supports, so that the
+// Wharf API and frontend can adapt to the deployed provider.
+type Capabilities struct {
+	// ImportScopes are the scopes that can be imported in one request.
+	ImportScopes []string `json:"importScopes" enums:"organization,project,repository" example:"organization,project,repository"`
+	// TriggerEventTypes are the Azure DevOps service hook event types that
+	// can trigger Wharf builds.
+	TriggerEventTypes []string `json:"triggerEventTypes" example:"git.pullrequest.created,git.push"`
+	// Features are the optional features, where some depend on the
+	// configuration of this provider.
+	Features CapabilityFeatures `json:"features"`
+}
+
+// CapabilityFeatures holds which optional features are available.
+type CapabilityFeatures struct {
+	// ListOrganizationProjects is true if the Azure DevOps projects of an
+	// organization can be listed.
+	ListOrganizationProjects bool `json:"listOrganizationProjects" example:"true"`
+	// ListProjectRepositories is true if the Azure DevOps repositories of a
+	// project can be listed.
+	ListProjectRepositories bool `json:"listProjectRepositories" example:"true"`
+	// ProgressStream is true if import progress can be streamed as
+	// server-sent events.
+	ProgressStream bool `json:"progressStream" example:"true"`
+	// ContinueOnError is true if imports can continue past repositories
+	// that fail to import.
+	ContinueOnError bool `json:"continueOnError" example:"true"`
+	// DryRun is true if imports can be previewed without writing to the
+	// Wharf API. Not yet supported.
+	DryRun bool `json:"dryRun" example:"false"`
+	// Idempotency is true if retried imports are deduplicated using the
+	// Idempotency-Key header, which requires the config
+	// import.idempotencyTtl.
+	Idempotency bool `json:"idempotency" example:"false"`
+	// RegisterWebhooks is true if service hooks can be registered on import,
+	// which requires the config triggers.publicUrl.
+	RegisterWebhooks bool `json:"registerWebhooks" example:"false"`
+	// TriggerBasicAuth is true if the trigger endpoints require HTTP basic
+	// authentication.
+	TriggerBasicAuth bool `json:"triggerBasicAuth" example:"false"`
+	// PostPRStatus is true if the pull request triggers post a pending
+	// status to the Azure DevOps pull request.
+	PostPRStatus bool `json:"postPrStatus" example:"false"`
+}
+
+func newCapabilities(config *Config) Capabilities {
+	return Capabilities{
+		ImportScopes: []string{
+			importScopeOrganization,
+			importScopeProject,
+			importScopeRepository,
+		},
+		TriggerEventTypes: []string{
+			prCreatedTrigger.eventType,
+			prUpdatedTrigger.eventType,
+			prMergedTrigger.eventType,
+			eventTypePush,
+		},
+		Features: CapabilityFeatures{
+			ListOrganizationProjects: true,
+			ListProjectRepositories:  true,
+			ProgressStream:           true,
+			ContinueOnError:          true,
+			Idempotency:              config.Import.IdempotencyTTL > 0,
+			RegisterWebhooks:         config.Triggers.PublicURL != "",
+			TriggerBasicAuth:         config.Triggers.BasicAuthEnabled(),
+			PostPRStatus:             config.Triggers.PostPRStatus,
+		},
+	}
+}
+
+// getCapabilitiesHandler godoc
+// @summary Returns the import scopes, trigger event types, and features supported by this provider
+// @tags meta
+// @produce json
+// @success 200 {object} Capabilities
+// @router /azuredevops/capabilities [get]
+func (m capabilitiesModule) getCapabilitiesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, newCapabilities(m.config))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCapabilitiesHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	capabilitiesModule{config: &Config{
+		Import:   ImportConfig{IdempotencyTTL: time.Hour},
+		Triggers: TriggersConfig{BasicAuthUser: "azure", PostPRStatus: true},
+	}}.register(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/import/azuredevops/capabilities", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got Capabilities
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, []string{"organization", "project", "repository"}, got.ImportScopes)
+	assert.Equal(t, []string{
+		"git.pullrequest.created",
+		"git.pullrequest.updated",
+		"git.pullrequest.merged",
+		"git.push",
+	}, got.TriggerEventTypes)
+	assert.Equal(t, CapabilityFeatures{
+		ListOrganizationProjects: true,
+		ListProjectRepositories:  true,
+		ProgressStream:           true,
+		ContinueOnError:          true,
+		Idempotency:              true,
+		TriggerBasicAuth:         true,
+		PostPRStatus:             true,
+	}, got.Features)
+}
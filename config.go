@@ -1,10 +1,20 @@
 package main
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/iver-wharf/wharf-core/pkg/config"
 	"github.com/iver-wharf/wharf-core/pkg/env"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/importer"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/requests"
 )
 
 // Config holds all configurable settings for wharf-provider-azuredevops.
@@ -27,9 +37,12 @@ import (
 // case-insensitive. Keeping camelCasing in YAML config files is recommended
 // for consistency.
 type Config struct {
-	API  WharfAPIConfig
-	HTTP HTTPConfig
-	CA   CertConfig
+	API      WharfAPIConfig
+	HTTP     HTTPConfig
+	CA       CertConfig
+	Triggers TriggersConfig
+	Import   ImportConfig
+	Azure    AzureConfig
 }
 
 // WharfAPIConfig holds settings for the connection to the Wharf API.
@@ -40,6 +53,35 @@ type WharfAPIConfig struct {
 	//
 	// Added in v1.3.0.
 	URL string
+
+	// ConflictRetries is how many times an import retries writing a Wharf
+	// project when the Wharf API responds with 409 Conflict, such as when two
+	// concurrent imports create the same project. Each retry searches for the
+	// project again and updates it, so that concurrent imports converge
+	// instead of failing. Zero disables the retries.
+	//
+	// Added in v3.1.0.
+	ConflictRetries int
+
+	// MaxConcurrentWrites is the maximum number of concurrent requests that
+	// create, update, or delete data in the Wharf API, such as projects and
+	// their branches, shared by all imports. Requests that read from the
+	// Wharf API are not limited. Meant to be kept lower than
+	// azure.maxConcurrentRequests, to not trip the rate limits of the Wharf
+	// API when importing many repositories at once. A value of zero or less
+	// disables the limit.
+	//
+	// Added in v3.1.0.
+	MaxConcurrentWrites int
+
+	// AuthHeader is the value of the Authorization header sent to the Wharf
+	// API from the trigger endpoints when they use HTTP basic authentication,
+	// such as "Bearer eyJhbGciOi...", as the Authorization header of the
+	// trigger request then holds the service hook's credentials instead. No
+	// Authorization header is sent to the Wharf API when empty.
+	//
+	// Added in v3.1.0.
+	AuthHeader string
 }
 
 // HTTPConfig holds settings for the HTTP server.
@@ -56,6 +98,31 @@ type HTTPConfig struct {
 	//
 	// Added in v1.3.0
 	BindAddress string
+
+	// BasePath is a path prefix, such as "/azuredevops-provider", added to all
+	// endpoints, including the base path of the Swagger documentation and the
+	// trigger URLs registered as Azure DevOps service hooks. Meant for when
+	// deployed behind a reverse proxy or ingress that does not strip the path
+	// prefix. Any trailing slash is removed. Empty means no prefix.
+	//
+	// Added in v3.1.0.
+	BasePath string
+
+	// ShutdownTimeout is the maximum duration to wait for in-flight requests,
+	// such as organization imports, to finish when shutting down the HTTP
+	// server after receiving an interrupt or SIGTERM signal.
+	//
+	// Added in v3.1.0.
+	ShutdownTimeout time.Duration
+
+	// MetricsBindAddress is the IP-address and port, separated by a colon, to
+	// bind a separate HTTP server to that serves the Prometheus metrics from
+	// the endpoint GET /metrics. The metrics are unauthenticated, and are
+	// therefore not served from the BindAddress. Empty means the metrics are
+	// not served.
+	//
+	// Added in v3.1.0.
+	MetricsBindAddress string
 }
 
 // CORSConfig holds settings for the HTTP server's CORS settings.
@@ -70,6 +137,13 @@ type CORSConfig struct {
 	//
 	// Added in v1.3.0
 	AllowAllOrigins bool
+
+	// AllowedOrigins enables CORS and allows only the listed origins, such as
+	// "https://wharf.example.com", in the HTTP request origins. Ignored if
+	// AllowAllOrigins is set to true.
+	//
+	// Added in v3.1.0.
+	AllowedOrigins []string
 }
 
 // CertConfig holds settings for certificates verification used when talking
@@ -91,11 +165,326 @@ type CertConfig struct {
 	InsecureSkipVerify bool
 }
 
+// TriggersConfig holds settings for the trigger endpoints, that are called by
+// Azure DevOps service hooks.
+type TriggersConfig struct {
+	// BasicAuthUser is the username that Azure DevOps service hooks must
+	// provide using HTTP basic authentication when calling the trigger
+	// endpoints. Trigger endpoints are unauthenticated when both
+	// BasicAuthUser and BasicAuthPassword are empty.
+	//
+	// When set, the Authorization header is no longer forwarded to the Wharf
+	// API when starting builds, and the api.authHeader is sent instead.
+	//
+	// Added in v3.1.0.
+	BasicAuthUser string
+
+	// BasicAuthPassword is the password that Azure DevOps service hooks must
+	// provide using HTTP basic authentication when calling the trigger
+	// endpoints.
+	//
+	// Added in v3.1.0.
+	BasicAuthPassword string
+
+	// PublicURL is the URL that Azure DevOps can reach this provider on, such
+	// as "https://wharf.example.com". Used when registering service hooks on
+	// import, which is disabled when this is empty.
+	//
+	// Added in v3.1.0.
+	PublicURL string
+
+	// PREnvironments selects the Wharf environment of builds started by the
+	// pull request triggers based on the pull request's target branch. The
+	// first matching rule is used, and the "environment" query parameter is
+	// used when no rule matches.
+	//
+	// Added in v3.1.0.
+	PREnvironments []PREnvironmentRule
+
+	// PostPRStatus enables posting a pending status to the Azure DevOps pull
+	// request when the pull request triggers have started a Wharf build, so
+	// that reviewers can see that the build is running. The status is posted
+	// using the token of the Wharf project's provider, which then needs the
+	// "Code (status)" scope.
+	//
+	// Failing to post the status is logged, but does not fail the trigger, as
+	// the build has already been started.
+	//
+	// Added in v3.1.0.
+	PostPRStatus bool
+
+	// MaxBodyBytes is the maximum size, in bytes, of the request body of the
+	// trigger endpoints. Larger requests are responded to with 413 "Request
+	// Entity Too Large". A value of zero or less disables the limit.
+	//
+	// Added in v3.1.0.
+	MaxBodyBytes int64
+
+	// StartBuildTimeout is the maximum duration to wait for the Wharf API to
+	// start each build. When exceeded, the request towards the Wharf API is
+	// canceled and the trigger is responded to with 504 "Gateway Timeout",
+	// instead of piling up requests towards a slow Wharf API. The Wharf API
+	// may still have started the build if it received the request before it
+	// was canceled, so a retried trigger may start a duplicate build. A value
+	// of zero or less disables the timeout.
+	//
+	// Added in v3.1.0.
+	StartBuildTimeout time.Duration
+}
+
+// PREnvironmentRule maps pull requests into matching target branches to a
+// Wharf environment.
+type PREnvironmentRule struct {
+	// TargetBranch is a pattern matched against the name of the pull
+	// request's target branch, without the "refs/heads/" prefix, such as
+	// "release/*". The pattern syntax is the same as for Go's path.Match,
+	// where "*" does not match slashes.
+	//
+	// Added in v3.1.0.
+	TargetBranch string
+
+	// Environment is the Wharf environment used for builds of pull requests
+	// into branches matching TargetBranch.
+	//
+	// Added in v3.1.0.
+	Environment string
+}
+
+// Matches returns true if the rule applies to the given target branch.
+func (rule PREnvironmentRule) Matches(targetBranch string) bool {
+	ok, _ := path.Match(rule.TargetBranch, targetBranch)
+	return ok
+}
+
+// BasicAuthEnabled returns true if the trigger endpoints requires HTTP basic
+// authentication.
+func (cfg TriggersConfig) BasicAuthEnabled() bool {
+	return cfg.BasicAuthUser != "" || cfg.BasicAuthPassword != ""
+}
+
+// ImportConfig holds settings for how Azure DevOps repositories are imported
+// into Wharf.
+type ImportConfig struct {
+	// SSHPort is the port used in the Wharf project's git URL when it has to be
+	// constructed from the provider URL, which is done when Azure DevOps does
+	// not provide an SSH URL for the repository.
+	//
+	// Added in v3.1.0.
+	SSHPort int
+
+	// CloneProtocol decides which git URL is stored in the imported Wharf
+	// projects. Valid values are:
+	//
+	// - "ssh": The repository's SSH URL, such as
+	// "git@ssh.dev.azure.com:v3/org/project/repo"
+	//
+	// - "https": The repository's HTTPS URL, such as
+	// "https://dev.azure.com/org/project/_git/repo". The URL never contains
+	// any credentials, so Wharf needs to be configured separately to
+	// authenticate when cloning.
+	//
+	// Added in v3.1.0.
+	CloneProtocol importer.CloneProtocol
+
+	// Mode decides how the provider URL given when importing maps to the
+	// Azure DevOps REST API paths. Valid values are:
+	//
+	// - "services": For Azure DevOps Services. The provider URL is the host,
+	// such as "https://dev.azure.com", and the organization from the Wharf
+	// group name is appended to it, such as
+	// "https://dev.azure.com/{org}/_apis/projects".
+	//
+	// - "server": For on-premises Azure DevOps Server. The provider URL is the
+	// collection URL, such as "https://server/tfs/DefaultCollection", and the
+	// REST API paths are appended directly to it, such as
+	// "https://server/tfs/DefaultCollection/_apis/projects". The organization
+	// from the Wharf group name is then only used for naming.
+	//
+	// Added in v3.1.0.
+	Mode azureapi.Mode
+
+	// IdempotencyTTL is how long the results of imports requested with the
+	// Idempotency-Key HTTP header are cached, during which retried imports
+	// with the same key get the cached result instead of importing again.
+	// Idempotency keys are ignored when set to zero.
+	//
+	// Added in v3.1.0.
+	IdempotencyTTL time.Duration
+
+	// GroupingStrategy decides how imported repositories are grouped into
+	// Wharf groups. Valid values are:
+	//
+	// - "org-project": The group is "{org}/{project}" and the project name is
+	// the repository name.
+	//
+	// - "org-only": The group is "{org}" and the Azure DevOps project is
+	// folded into the project name, as "{project}-{repo}".
+	//
+	// Projects previously imported using the "org-project" names are found
+	// and renamed when imported again using other names.
+	//
+	// Added in v3.1.0.
+	GroupingStrategy importer.GroupingStrategy
+
+	// RemoteID decides which Azure DevOps IDs are stored as the remote
+	// project ID of created Wharf projects. Valid values are:
+	//
+	// - "project": The ID of the Azure DevOps project, which is shared by all
+	// repositories in the project.
+	//
+	// - "repository": The ID of the Azure DevOps repository.
+	//
+	// - "project-repository": Both IDs, as "{projectId}/{repoId}".
+	//
+	// When the remote project ID includes the repository ID, Wharf projects
+	// of repositories that have been renamed in Azure DevOps are found by
+	// their remote project ID and renamed, instead of imported a second time.
+	// The Wharf API does not support changing the remote project ID, so
+	// already imported projects keep their previous remote project ID.
+	//
+	// Added in v3.1.0.
+	RemoteID importer.RemoteID
+
+	// ProjectNameTemplate is a Go text/template of the names of imported
+	// Wharf projects, such as "{{.Project}}-{{.Repo}}". The template is given
+	// the fields Org, Project, and Repo, which are the names of the
+	// Azure DevOps organization, project, and repository. Overrides the name
+	// given by GroupingStrategy when set.
+	//
+	// Refreshing imported projects relies on the default names, and may not
+	// work for projects imported with a template.
+	//
+	// Added in v3.1.0.
+	ProjectNameTemplate string
+
+	// GroupNameTemplate is a Go text/template of the group names of imported
+	// Wharf projects, given the same fields as ProjectNameTemplate. Overrides
+	// the group name given by GroupingStrategy when set.
+	//
+	// Added in v3.1.0.
+	GroupNameTemplate string
+
+	projectNameTemplate *template.Template
+	groupNameTemplate   *template.Template
+}
+
+// AzureConfig holds settings for the requests sent to the Azure DevOps REST API.
+type AzureConfig struct {
+	// MaxResponseBytes is the maximum size, in bytes, of a response body read
+	// from Azure DevOps. Requests whose responses are larger than this fail
+	// instead of being read into memory. A value of zero or less disables the
+	// limit.
+	//
+	// Added in v3.1.0.
+	MaxResponseBytes int64
+
+	// AuthMode decides how the token of the imported provider is sent to
+	// Azure DevOps. Valid values are:
+	//
+	// - "basic": The token is a personal access token (PAT), sent using HTTP
+	// basic authentication together with the token's username.
+	//
+	// - "bearer": The token is an OAuth access token, such as one issued by
+	// Azure AD for a service principal, sent using the
+	// "Authorization: Bearer" HTTP header.
+	//
+	// - "auto": Uses "bearer" for tokens that are JSON Web Tokens (JWT),
+	// which all Azure AD access tokens are, and "basic" otherwise.
+	//
+	// Added in v3.1.0.
+	AuthMode azureapi.AuthMode
+
+	// OmitBasicAuthUser sends an empty username instead of the username of
+	// the imported token when authenticating using basic auth. Azure DevOps
+	// authenticates personal access tokens (PAT) by the token alone, so this
+	// avoids issues with usernames that cannot be used in basic auth, such
+	// as usernames containing colons.
+	//
+	// Added in v3.1.0.
+	OmitBasicAuthUser bool
+
+	// UserAgent is the User-Agent HTTP header sent in all requests to
+	// Azure DevOps. Defaults to "wharf-provider-azuredevops/{version}" when
+	// empty, where {version} is the version of this provider.
+	//
+	// Added in v3.1.0.
+	UserAgent string
+
+	// Timeout is the maximum duration of each request sent to Azure DevOps,
+	// including reading the response body. A value of zero disables the
+	// timeout.
+	//
+	// Added in v3.1.0.
+	Timeout time.Duration
+
+	// Proxy is the URL of the HTTP proxy used for all requests to
+	// Azure DevOps, such as "http://proxy.example.com:3128". When empty, the
+	// proxy is instead taken from the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY
+	// environment variables, if set.
+	//
+	// Added in v3.1.0.
+	Proxy string
+
+	// MaxIdleConns is the maximum number of idle (keep-alive) connections to
+	// Azure DevOps kept open for reuse, across all hosts. A value of zero or
+	// less uses the Go default of 100.
+	//
+	// Added in v3.1.0.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost is the maximum number of idle (keep-alive)
+	// connections to Azure DevOps kept open for reuse, per host. Raising this
+	// lets parallel imports reuse connections instead of opening new ones.
+	// A value of zero or less uses the Go default of 2.
+	//
+	// Added in v3.1.0.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle (keep-alive) connection to
+	// Azure DevOps is kept open before being closed. A value of zero or less
+	// uses the Go default of 90 seconds.
+	//
+	// Added in v3.1.0.
+	IdleConnTimeout time.Duration
+
+	// MaxConcurrentRequests is the maximum number of concurrent requests sent
+	// to Azure DevOps, shared by all imports and triggers. Requests beyond
+	// the limit wait for a previous request to finish, and the waiting counts
+	// towards the Timeout. A value of zero or less disables the limit.
+	//
+	// Added in v3.1.0.
+	MaxConcurrentRequests int
+}
+
 // DefaultConfig is the hard-coded default values for wharf-provider-azuredevops's
 // configs.
 var DefaultConfig = Config{
+	API: WharfAPIConfig{
+		ConflictRetries: 3,
+	},
 	HTTP: HTTPConfig{
-		BindAddress: "0.0.0.0:8080",
+		BindAddress:     "0.0.0.0:8080",
+		ShutdownTimeout: 30 * time.Second,
+	},
+	Triggers: TriggersConfig{
+		MaxBodyBytes:      4 << 20,
+		StartBuildTimeout: 15 * time.Second,
+	},
+	Import: ImportConfig{
+		SSHPort:          22,
+		CloneProtocol:    importer.CloneProtocolSSH,
+		Mode:             azureapi.ModeServices,
+		IdempotencyTTL:   time.Hour,
+		GroupingStrategy: importer.GroupingOrgProject,
+		RemoteID:         importer.RemoteIDProject,
+	},
+	Azure: AzureConfig{
+		MaxResponseBytes:    requests.DefaultMaxResponseBytes,
+		AuthMode:            azureapi.AuthModeAuto,
+		Timeout:             time.Minute,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
 	},
 }
 
@@ -116,9 +505,78 @@ func loadConfig() (Config, error) {
 	if err == nil {
 		err = cfg.addBackwardCompatibleConfigs()
 	}
+	if err == nil {
+		err = cfg.validate()
+	}
 	return cfg, err
 }
 
+func (cfg *Config) validate() error {
+	cfg.HTTP.BasePath = strings.TrimRight(cfg.HTTP.BasePath, "/")
+	if cfg.HTTP.BasePath != "" && !strings.HasPrefix(cfg.HTTP.BasePath, "/") {
+		return fmt.Errorf("invalid http.basePath %q, must start with a slash", cfg.HTTP.BasePath)
+	}
+	switch cfg.Import.CloneProtocol {
+	case importer.CloneProtocolSSH, importer.CloneProtocolHTTPS:
+	default:
+		return fmt.Errorf("invalid import.cloneProtocol %q, expected %q or %q",
+			cfg.Import.CloneProtocol, importer.CloneProtocolSSH, importer.CloneProtocolHTTPS)
+	}
+	switch cfg.Import.Mode {
+	case azureapi.ModeServices, azureapi.ModeServer:
+	default:
+		return fmt.Errorf("invalid import.mode %q, expected %q or %q",
+			cfg.Import.Mode, azureapi.ModeServices, azureapi.ModeServer)
+	}
+	switch cfg.Import.GroupingStrategy {
+	case importer.GroupingOrgProject, importer.GroupingOrgOnly:
+	default:
+		return fmt.Errorf("invalid import.groupingStrategy %q, expected %q or %q",
+			cfg.Import.GroupingStrategy, importer.GroupingOrgProject, importer.GroupingOrgOnly)
+	}
+	switch cfg.Import.RemoteID {
+	case importer.RemoteIDProject, importer.RemoteIDRepository, importer.RemoteIDProjectRepository:
+	default:
+		return fmt.Errorf("invalid import.remoteId %q, expected %q, %q, or %q",
+			cfg.Import.RemoteID, importer.RemoteIDProject, importer.RemoteIDRepository, importer.RemoteIDProjectRepository)
+	}
+	if cfg.Import.ProjectNameTemplate != "" {
+		tmpl, err := importer.ParseNameTemplate("projectName", cfg.Import.ProjectNameTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid import.projectNameTemplate: %w", err)
+		}
+		cfg.Import.projectNameTemplate = tmpl
+	}
+	if cfg.Import.GroupNameTemplate != "" {
+		tmpl, err := importer.ParseNameTemplate("groupName", cfg.Import.GroupNameTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid import.groupNameTemplate: %w", err)
+		}
+		cfg.Import.groupNameTemplate = tmpl
+	}
+	for idx, rule := range cfg.Triggers.PREnvironments {
+		if _, err := path.Match(rule.TargetBranch, ""); err != nil || rule.TargetBranch == "" {
+			return fmt.Errorf("invalid triggers.prEnvironments[%d].targetBranch %q, expected a branch name pattern", idx, rule.TargetBranch)
+		}
+		if rule.Environment == "" {
+			return fmt.Errorf("missing triggers.prEnvironments[%d].environment", idx)
+		}
+	}
+	if cfg.Azure.Proxy != "" {
+		u, err := url.Parse(cfg.Azure.Proxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid azure.proxy %q, expected an absolute URL", redact.URLString(cfg.Azure.Proxy))
+		}
+	}
+	switch cfg.Azure.AuthMode {
+	case azureapi.AuthModeBasic, azureapi.AuthModeBearer, azureapi.AuthModeAuto:
+	default:
+		return fmt.Errorf("invalid azure.authMode %q, expected %q, %q, or %q",
+			cfg.Azure.AuthMode, azureapi.AuthModeBasic, azureapi.AuthModeBearer, azureapi.AuthModeAuto)
+	}
+	return nil
+}
+
 func (cfg *Config) addBackwardCompatibleConfigs() error {
 	if value, ok := os.LookupEnv("ALLOW_CORS"); ok && value == "YES" {
 		cfg.HTTP.CORS.AllowAllOrigins = true
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/importer"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/requestid"
+)
+
+// deleteProjectsPageSize is the number of Wharf projects fetched per request
+// when searching for the projects to delete.
+const deleteProjectsPageSize = 100
+
+// deleteProjectsQuery holds the query parameters of the delete projects
+// endpoint, used to find the Wharf provider of the imported projects.
+type deleteProjectsQuery struct {
+	URL        string `form:"url"`
+	ProviderID uint   `form:"providerId"`
+}
+
+// DeleteResult holds the Wharf projects removed by the delete projects
+// endpoint.
+type DeleteResult struct {
+	// ProjectsDeleted is the list of deleted Wharf projects.
+	ProjectsDeleted []DeletedProject `json:"projectsDeleted"`
+}
+
+// DeletedProject is a Wharf project that has been deleted.
+type DeletedProject struct {
+	ProjectID uint   `json:"projectId" example:"123"`
+	Name      string `json:"name" example:"my-repo"`
+	GroupName string `json:"groupName" example:"my-org/my-project"`
+}
+
+// deleteProjectsHandler godoc
+// @Summary Delete the Wharf projects imported from an Azure DevOps project
+// @Description Deletes all Wharf projects in the group "{org}/{project}" that
+// @Description belong to the Azure DevOps provider, as found using either the
+// @Description "providerId" or "url" query parameters. Nothing is deleted in
+// @Description Azure DevOps. Projects imported using other group names, such
+// @Description as with the config import.groupingStrategy set to "org-only",
+// @Description are not found.
+// @Produce json
+// @Param org path string true "Azure DevOps organization name"
+// @Param project path string true "Azure DevOps project name"
+// @Param url query string false "Azure DevOps URL"
+// @Param providerId query int false "Wharf provider ID"
+// @Success 200 {object} DeleteResult "OK"
+// @Failure 400 {object} problem.Response "Bad request"
+// @Failure 401 {object} problem.Response "Unauthorized or missing jwt token"
+// @Failure 502 {object} problem.Response "Bad gateway"
+// @Router /azuredevops/organizations/{org}/projects/{project} [delete]
+func (m importModule) deleteProjectsHandler(c *gin.Context) {
+	orgName, ok := ginutil.RequireParamString(c, "org")
+	if !ok {
+		return
+	}
+	projectName, ok := ginutil.RequireParamString(c, "project")
+	if !ok {
+		return
+	}
+
+	var q deleteProjectsQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		ginutil.WriteInvalidBindError(c, err,
+			"One or more parameters failed to parse when reading query parameters.")
+		return
+	}
+	if q.ProviderID == 0 && q.URL == "" {
+		ginutil.WriteInvalidParamError(c, errors.New("missing url and providerId"), "url",
+			"Unable to find the Wharf provider without either the url or providerId query parameter.")
+		return
+	}
+
+	client, ok := m.newWharfClientWritesProblem(c)
+	if !ok {
+		return
+	}
+
+	result := DeleteResult{ProjectsDeleted: []DeletedProject{}}
+	providerID, found, ok := m.findWharfProviderIDWritesProblem(c, client, q)
+	if !ok {
+		return
+	}
+	if !found {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	groupName := fmt.Sprintf("%s/%s", orgName, projectName)
+	projects, ok := m.findWharfProjectsInGroupWritesProblem(c, client, groupName, providerID)
+	if !ok {
+		return
+	}
+	for _, project := range projects {
+		if err := m.deleteWharfProjectLimited(c, client, project.ProjectID); err != nil {
+			m.wharfAPIDiagnoser().WriteWriteError(c, err,
+				fmt.Sprintf("Unable to delete Wharf project with ID %d. Deleted %d other projects before failing.",
+					project.ProjectID, len(result.ProjectsDeleted)))
+			return
+		}
+		requestid.Logger(log, c).Info().
+			WithUint("projectId", project.ProjectID).
+			WithString("name", project.Name).
+			WithString("groupName", project.GroupName).
+			Message("Deleted Wharf project.")
+		result.ProjectsDeleted = append(result.ProjectsDeleted, DeletedProject{
+			ProjectID: project.ProjectID,
+			Name:      project.Name,
+			GroupName: project.GroupName,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// findWharfProviderIDWritesProblem returns the ID of the Wharf provider given
+// by ID, or found by the provider name and Azure DevOps URL, where the URLs
+// are compared after being normalized. The provider is never created.
+func (m importModule) findWharfProviderIDWritesProblem(c *gin.Context, client wharfapi.Client, q deleteProjectsQuery) (providerID uint, found bool, ok bool) {
+	if q.ProviderID != 0 {
+		return q.ProviderID, true, true
+	}
+	name := string(providerName)
+	providers, err := client.GetProviderList(wharfapi.ProviderSearch{
+		Name: &name,
+	})
+	if err != nil {
+		m.wharfAPIDiagnoser().WriteReadError(c, err,
+			fmt.Sprintf("Unable to search for Wharf provider with URL %q.", q.URL))
+		return 0, false, false
+	}
+	for _, provider := range providers.List {
+		if string(provider.Name) == name && importer.ProviderURLsEqual(provider.URL, q.URL) {
+			return provider.ProviderID, true, true
+		}
+	}
+	return 0, false, true
+}
+
+// findWharfProjectsInGroupWritesProblem returns all Wharf projects of the
+// provider in the group. The Wharf API may match the group name on
+// substrings, so only exact matches are returned.
+func (m importModule) findWharfProjectsInGroupWritesProblem(c *gin.Context, client wharfapi.Client, groupName string, providerID uint) ([]response.Project, bool) {
+	var projects []response.Project
+	limit := deleteProjectsPageSize
+	for offset := 0; ; offset += limit {
+		page, err := client.GetProjectList(wharfapi.ProjectSearch{
+			GroupName:  &groupName,
+			ProviderID: &providerID,
+			Limit:      &limit,
+			Offset:     &offset,
+		})
+		if err != nil {
+			m.wharfAPIDiagnoser().WriteReadError(c, err,
+				fmt.Sprintf("Unable to search for Wharf projects in group %q.", groupName))
+			return nil, false
+		}
+		for _, project := range page.List {
+			if project.GroupName == groupName && project.ProviderID == providerID {
+				projects = append(projects, project)
+			}
+		}
+		if len(page.List) < limit || int64(offset+len(page.List)) >= page.TotalCount {
+			return projects, true
+		}
+	}
+}
+
+// deleteWharfProjectLimited deletes a Wharf project while holding a slot of
+// the semaphore limiting concurrent writes to the Wharf API.
+func (m importModule) deleteWharfProjectLimited(c *gin.Context, client wharfapi.Client, projectID uint) error {
+	if err := m.wharfWriteSemaphore.Acquire(c.Request.Context()); err != nil {
+		return fmt.Errorf("wait for concurrent Wharf API writes: %w", err)
+	}
+	defer m.wharfWriteSemaphore.Release()
+	return deleteWharfProject(client, projectID)
+}
+
+// deleteWharfProject deletes a Wharf project by invoking the HTTP request:
+//
+//	DELETE /api/project/{projectId}
+//
+// The Wharf API client does not support deleting projects, so the request is
+// sent using http.DefaultClient, which uses the same transport as the Wharf
+// API client.
+func deleteWharfProject(client wharfapi.Client, projectID uint) error {
+	deleteURL, err := url.Parse(client.APIURL)
+	if err != nil {
+		return fmt.Errorf("parse Wharf API URL: %w", err)
+	}
+	deleteURL.Path = path.Join("/", deleteURL.Path, "project", strconv.FormatUint(uint64(projectID), 10))
+	req, err := http.NewRequest(http.MethodDelete, deleteURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", client.AuthHeader)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if problem.IsHTTPResponse(resp) {
+		prob, err := problem.ParseHTTPResponse(resp)
+		if err != nil {
+			return fmt.Errorf("unexpected status code returned: %s: %w", resp.Status, err)
+		}
+		return prob
+	}
+	return fmt.Errorf("unexpected status code returned: %s", resp.Status)
+}
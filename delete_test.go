@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteProjectsHandler(t *testing.T) {
+	var deleted []string
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/provider":
+			w.Write([]byte(`{"list":[{"providerId":2,"name":"azuredevops","url":"https://dev.azure.com"}],"totalCount":1}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/project":
+			assert.Equal(t, "Org/Proj", r.URL.Query().Get("groupName"))
+			assert.Equal(t, "2", r.URL.Query().Get("providerId"))
+			// Includes a substring match of the group name, that must not
+			// be deleted.
+			w.Write([]byte(`{"list":[
+				{"projectId":5,"name":"RepoA","groupName":"Org/Proj","providerId":2},
+				{"projectId":6,"name":"RepoB","groupName":"Org/Proj","providerId":2},
+				{"projectId":7,"name":"RepoC","groupName":"Org/Proj2","providerId":2}
+			],"totalCount":3}`))
+		case r.Method == http.MethodDelete:
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	m := importModule{config: &Config{API: WharfAPIConfig{URL: wharfServer.URL + "/api"}}}
+	m.register(r)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete,
+		"/import/azuredevops/organizations/Org/projects/Proj?url=https://dev.azure.com", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	r.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var result DeleteResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, []DeletedProject{
+		{ProjectID: 5, Name: "RepoA", GroupName: "Org/Proj"},
+		{ProjectID: 6, Name: "RepoB", GroupName: "Org/Proj"},
+	}, result.ProjectsDeleted)
+	assert.Equal(t, []string{"/api/project/5", "/api/project/6"}, deleted)
+}
+
+func TestDeleteProjectsHandlerMisconfiguredWharfAPIURL(t *testing.T) {
+	// Such as an ingress responding with its own HTML error page.
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<html><body>404 Not Found</body></html>"))
+	}))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	m := importModule{config: &Config{API: WharfAPIConfig{URL: wharfServer.URL + "/api"}}}
+	m.register(r)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete,
+		"/import/azuredevops/organizations/Org/projects/Proj?url=https://dev.azure.com", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadGateway, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "wharf-api-misconfigured")
+}
+
+func TestDeleteProjectsHandlerRequiresProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	m := importModule{config: &Config{}}
+	m.register(r)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/import/azuredevops/organizations/Org/projects/Proj", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
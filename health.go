@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/requestid"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/wharfapiext"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/problemtype"
+)
+
+const wharfAPIHealthTimeout = 5 * time.Second
+
+type healthModule struct {
+	config *Config
+	// httpClient sends the health checks to the Wharf API. Uses
+	// http.DefaultClient when nil.
+	httpClient *http.Client
+}
+
+func (m healthModule) register(r gin.IRouter) {
+	r.GET("/import/azuredevops/healthz", m.getHealthHandler)
+}
+
+// HealthStatus holds the health status of this provider.
+type HealthStatus struct {
+	Message string `json:"message" example:"Healthy."`
+}
+
+// getHealthHandler godoc
+// @summary Returns the health of this API and its connectivity to the Wharf API
+// @description Meant to be used as a readiness probe, as it responds with
+// @description 503 "Service Unavailable" when the Wharf API is unreachable,
+// @description or 502 "Bad Gateway" when the Wharf API URL appears to point at
+// @description something other than the Wharf API.
+// @tags meta
+// @produce json
+// @success 200 {object} HealthStatus "Healthy"
+// @failure 502 {object} problem.Response "Wharf API URL appears misconfigured"
+// @failure 503 {object} problem.Response "Wharf API is unreachable"
+// @router /azuredevops/healthz [get]
+func (m healthModule) getHealthHandler(c *gin.Context) {
+	if err := m.checkWharfAPIHealth(c.Request.Context()); err != nil {
+		requestid.Logger(log, c).Warn().WithError(err).Message("Wharf API health check failed.")
+		var misconfiguredErr *wharfapiext.MisconfiguredURLError
+		if errors.As(err, &misconfiguredErr) {
+			wharfapiext.WriteMisconfiguredURLProblem(c, misconfiguredErr)
+			return
+		}
+		ginutil.WriteProblemError(c, err, problem.Response{
+			Type:   problemtype.WharfAPIUnreachable,
+			Title:  "Wharf API unreachable.",
+			Status: http.StatusServiceUnavailable,
+			Detail: fmt.Sprintf("Unable to reach the Wharf API at %q.", m.config.API.URL),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, HealthStatus{Message: "Healthy."})
+}
+
+func (m healthModule) checkWharfAPIHealth(ctx context.Context) error {
+	healthURL, err := wharfapiext.HealthURL(m.config.API.URL)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wharfAPIHealthTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return err
+	}
+	client := m.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := wharfapiext.CheckContentType(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("non-2xx HTTP status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetHealthHandler(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		wharfStatus int
+		wantStatus  int
+	}{
+		{
+			name:        "wharf-api healthy",
+			wharfStatus: http.StatusOK,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "wharf-api unhealthy",
+			wharfStatus: http.StatusInternalServerError,
+			wantStatus:  http.StatusServiceUnavailable,
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/health", r.URL.Path)
+				w.WriteHeader(tc.wharfStatus)
+			}))
+			defer wharfServer.Close()
+
+			r := gin.New()
+			healthModule{config: &Config{API: WharfAPIConfig{URL: wharfServer.URL + "/api"}}}.register(r)
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/import/azuredevops/healthz", nil))
+			assert.Equal(t, tc.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestGetHealthHandlerUnreachable(t *testing.T) {
+	wharfServer := httptest.NewServer(http.NotFoundHandler())
+	wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	healthModule{config: &Config{API: WharfAPIConfig{URL: wharfServer.URL + "/api"}}}.register(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/import/azuredevops/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestGetHealthHandlerMisconfigured(t *testing.T) {
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>Welcome</body></html>"))
+	}))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	healthModule{config: &Config{API: WharfAPIConfig{URL: wharfServer.URL + "/api"}}}.register(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/import/azuredevops/healthz", nil))
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+}
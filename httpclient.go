@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/iver-wharf/wharf-core/pkg/cacertutil"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/semaphore"
+)
+
+// newAzureHTTPClient creates the HTTP client used for all requests to
+// Azure DevOps, instead of changing http.DefaultClient.
+func newAzureHTTPClient(config Config) (*http.Client, error) {
+	transport, err := newHTTPTransport(config.CA)
+	if err != nil {
+		return nil, err
+	}
+	// The transport is cloned from http.DefaultTransport, and so already
+	// uses the proxy from the environment variables if not overridden.
+	if config.Azure.Proxy != "" {
+		proxyURL, err := url.Parse(config.Azure.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy URL: %w", redact.Error(err))
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if config.Azure.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.Azure.MaxIdleConns
+	}
+	if config.Azure.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.Azure.MaxIdleConnsPerHost
+	}
+	if config.Azure.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.Azure.IdleConnTimeout
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   config.Azure.Timeout,
+	}
+	if sem := semaphore.New(config.Azure.MaxConcurrentRequests); sem != nil {
+		client.Transport = limitedTransport{base: transport, semaphore: sem}
+	}
+	return client, nil
+}
+
+// limitedTransport is an http.RoundTripper that holds a slot of the semaphore
+// from sending each request until its response body is closed, to limit the
+// number of concurrent requests.
+type limitedTransport struct {
+	base      http.RoundTripper
+	semaphore *semaphore.Semaphore
+}
+
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.semaphore.Acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.semaphore.Release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: t.semaphore.Release}
+	return resp, nil
+}
+
+// releasingBody calls release once when the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// newHTTPTransport creates a copy of http.DefaultTransport that trusts the
+// certificates from the CA config.
+func newHTTPTransport(config CertConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	if config.CertsFile != "" {
+		certsClient, err := cacertutil.NewHTTPClientWithCerts(config.CertsFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certs file: %w", err)
+		}
+		certsTransport, ok := certsClient.Transport.(*http.Transport)
+		if !ok || certsTransport.TLSClientConfig == nil {
+			return nil, fmt.Errorf("unexpected HTTP transport type %T with certs", certsClient.Transport)
+		}
+		transport.TLSClientConfig.RootCAs = certsTransport.TLSClientConfig.RootCAs
+	}
+	if config.InsecureSkipVerify {
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+	return transport, nil
+}
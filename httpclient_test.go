@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAzureHTTPClientWithCertsFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	certsFile := filepath.Join(t.TempDir(), "certs.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(certsFile, certPEM, 0600))
+
+	defaultClient := http.DefaultClient
+	var config Config
+	config.CA.CertsFile = certsFile
+	config.Azure.Timeout = time.Minute
+	client, err := newAzureHTTPClient(config)
+	require.NoError(t, err)
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, time.Minute, client.Timeout)
+	assert.Same(t, defaultClient, http.DefaultClient, "http.DefaultClient changed")
+
+	_, err = http.DefaultClient.Get(server.URL)
+	assert.Error(t, err, "http.DefaultClient trusting the certs file")
+}
+
+func TestNewAzureHTTPClientWithProxy(t *testing.T) {
+	var gotHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.URL.Host
+	}))
+	defer proxy.Close()
+
+	var config Config
+	config.Azure.Proxy = proxy.URL
+	client, err := newAzureHTTPClient(config)
+	require.NoError(t, err)
+
+	resp, err := client.Get("http://azure.example.com/fabrikam/_apis/projects")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "azure.example.com", gotHost)
+}
+
+func TestNewAzureHTTPClientConnectionPool(t *testing.T) {
+	var config Config
+	config.Azure.MaxIdleConns = 50
+	config.Azure.MaxIdleConnsPerHost = 10
+	config.Azure.IdleConnTimeout = 30 * time.Second
+	client, err := newAzureHTTPClient(config)
+	require.NoError(t, err)
+
+	transport, ok := client.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.Equal(t, 50, transport.MaxIdleConns)
+	assert.Equal(t, 10, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 30*time.Second, transport.IdleConnTimeout)
+}
+
+func TestNewAzureHTTPClientMaxConcurrentRequests(t *testing.T) {
+	var active, maxActive int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			max := atomic.LoadInt32(&maxActive)
+			if n <= max || atomic.CompareAndSwapInt32(&maxActive, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	var config Config
+	config.Azure.MaxConcurrentRequests = 2
+	config.Azure.Timeout = 10 * time.Second
+	client, err := newAzureHTTPClient(config)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 6; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxActive), int32(2))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/idempotency"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/importer"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/requestid"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/problemtype"
+)
+
+// beginIdempotentImportWritesProblem reserves the Idempotency-Key of the
+// request, if any, and returns the key to later complete or release it with.
+// Returns false if the import should not continue, either because a problem
+// was written, or because the cached result of a previous import with the
+// same key was replayed.
+//
+// The key is reserved along with a fingerprint of the request body, which is
+// read and then restored for the import to bind, so that reusing a key for a
+// different import is rejected instead of replaying the wrong result.
+func (m importModule) beginIdempotentImportWritesProblem(c *gin.Context) (string, bool) {
+	idempotencyKey := c.GetHeader(idempotency.HeaderName)
+	if idempotencyKey == "" || m.idempotency == nil {
+		return "", true
+	}
+	if len(idempotencyKey) > idempotency.MaxKeyLength {
+		err := fmt.Errorf("idempotency key too long: %d characters", len(idempotencyKey))
+		ginutil.WriteInvalidParamError(c, err, idempotency.HeaderName,
+			fmt.Sprintf("The %s header must not be longer than %d characters.",
+				idempotency.HeaderName, idempotency.MaxKeyLength))
+		return "", false
+	}
+
+	body, ok := readBodyWritesProblem(c, maxImportBodyBytes)
+	if !ok {
+		return "", false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	key := idempotency.Key(c.GetHeader("Authorization"), idempotencyKey)
+	cached, state := m.idempotency.Begin(key, idempotency.Fingerprint(body))
+	switch state {
+	case idempotency.StateMismatch:
+		ginutil.WriteProblemError(c, errors.New("idempotency key reused"), problem.Response{
+			Type:   problemtype.IdempotencyKeyReused,
+			Title:  "Idempotency key reused.",
+			Status: http.StatusUnprocessableEntity,
+			Detail: fmt.Sprintf("The %s header was already used for an import with a different request body. "+
+				"Use a new key for each different import.", idempotency.HeaderName),
+		})
+		return "", false
+	case idempotency.StateInFlight:
+		ginutil.WriteProblemError(c, errors.New("import in progress"), problem.Response{
+			Type:   problemtype.ImportInProgress,
+			Title:  "Import already in progress.",
+			Status: http.StatusConflict,
+			Detail: fmt.Sprintf("An import with the same %s header is still in progress. "+
+				"Retry the request after it has completed to get its result.", idempotency.HeaderName),
+		})
+		return "", false
+	case idempotency.StateCompleted:
+		requestid.Logger(log, c).Debug().Message("Replaying result of completed import.")
+		c.Header(idempotency.ReplayedHeaderName, "true")
+		if acceptsEventStream(c) {
+			newEventStream(c).finish(json.RawMessage(cached.Body), true)
+		} else {
+			c.Data(cached.StatusCode, "application/json; charset=utf-8", cached.Body)
+		}
+		return "", false
+	}
+	return key, true
+}
+
+// completeIdempotentImport caches the import result for replays. Returns
+// false if the result could not be cached, in which case the key must be
+// released instead.
+func (m importModule) completeIdempotentImport(key string, result importer.ImportResult) bool {
+	if key == "" {
+		return false
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		log.Error().WithError(err).Message("Failed to marshal import result for idempotency cache.")
+		return false
+	}
+	m.idempotency.Complete(key, idempotency.Response{
+		StatusCode: importResultStatus(result),
+		Body:       body,
+	})
+	return true
+}
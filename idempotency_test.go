@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/idempotency"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/importer"
+	"github.com/stretchr/testify/assert"
+)
+
+func newIdempotencyTestContext(key, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", strings.NewReader(body))
+	c.Request.Header.Set("Authorization", "Bearer abc")
+	c.Request.Header.Set(idempotency.HeaderName, key)
+	return c, rec
+}
+
+func TestIdempotentImportReplaysResult(t *testing.T) {
+	m := importModule{idempotency: idempotency.NewStore(time.Hour)}
+
+	c, _ := newIdempotencyTestContext("my-key", `{"group":"Org"}`)
+	key, ok := m.beginIdempotentImportWritesProblem(c)
+	assert.True(t, ok)
+	assert.True(t, m.completeIdempotentImport(key, importer.ImportResult{ProjectsCreated: 3}))
+
+	c, rec := newIdempotencyTestContext("my-key", `{"group":"Org"}`)
+	_, ok = m.beginIdempotentImportWritesProblem(c)
+
+	assert.False(t, ok, "should not import again")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get(idempotency.ReplayedHeaderName))
+	assert.Contains(t, rec.Body.String(), `"projectsCreated":3`)
+}
+
+func TestIdempotentImportConflictWhileInFlight(t *testing.T) {
+	m := importModule{idempotency: idempotency.NewStore(time.Hour)}
+
+	c, _ := newIdempotencyTestContext("my-key", `{"group":"Org"}`)
+	_, ok := m.beginIdempotentImportWritesProblem(c)
+	assert.True(t, ok)
+
+	c, rec := newIdempotencyTestContext("my-key", `{"group":"Org"}`)
+	_, ok = m.beginIdempotentImportWritesProblem(c)
+
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/prob/provider/azuredevops/import-in-progress")
+}
+
+func TestIdempotentImportWithoutKey(t *testing.T) {
+	m := importModule{idempotency: idempotency.NewStore(time.Hour)}
+
+	c, _ := newIdempotencyTestContext("", `{"group":"Org"}`)
+	key, ok := m.beginIdempotentImportWritesProblem(c)
+
+	assert.True(t, ok)
+	assert.Empty(t, key)
+}
+
+func TestIdempotentImportRejectsReusedKey(t *testing.T) {
+	m := importModule{idempotency: idempotency.NewStore(time.Hour)}
+
+	c, _ := newIdempotencyTestContext("my-key", `{"group":"Org"}`)
+	key, ok := m.beginIdempotentImportWritesProblem(c)
+	assert.True(t, ok)
+	body, err := io.ReadAll(c.Request.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"group":"Org"}`, string(body), "body restored for binding")
+	assert.True(t, m.completeIdempotentImport(key, importer.ImportResult{ProjectsCreated: 3}))
+
+	c, rec := newIdempotencyTestContext("my-key", `{"group":"Other"}`)
+	_, ok = m.beginIdempotentImportWritesProblem(c)
+
+	assert.False(t, ok, "should not import nor replay")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/prob/provider/azuredevops/idempotency-key-reused")
+}
@@ -0,0 +1,270 @@
+// Package azureapitest provides a fake azureapi.RepositoryFetcher, meant to
+// be used in tests.
+package azureapitest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+)
+
+// Fake is an in-memory implementation of azureapi.RepositoryFetcher.
+//
+// The organization name is ignored in all lookups. Projects and repositories
+// are matched on either name or ID.
+//
+// Contrary to azureapi.Client, the Fake does not write any problems. It only
+// returns false when a project or repository is not found, or when Err is
+// set.
+type Fake struct {
+	Projects     []azureapi.Project
+	Repositories []Repository
+	// Subscriptions holds all service hook subscriptions, including created
+	// ones.
+	Subscriptions []azureapi.ServiceHookSubscription
+	// Err makes all functions fail when set to true.
+	Err bool
+}
+
+// Repository is an Azure DevOps repository and its contents, as served by
+// the Fake.
+type Repository struct {
+	azureapi.Repository
+	Branches []azureapi.Branch
+	Tags     []azureapi.Tag
+	// BranchPolicies is a map of branch refs to the policies that apply to
+	// them.
+	BranchPolicies map[string][]azureapi.PolicyConfiguration
+	// Files is a map of file paths to file contents on the default branch.
+	Files map[string]string
+	// BranchFiles is a map of branch names, such as "feature/foo", to maps
+	// of file paths to file contents on that branch. Branches missing from
+	// the map are not found.
+	BranchFiles map[string]map[string]string
+	// Hidden makes the repository only be listed when including hidden
+	// repositories.
+	Hidden bool
+}
+
+var _ azureapi.RepositoryFetcher = &Fake{}
+var _ azureapi.ServiceHookSubscriber = &Fake{}
+
+// GetProjectsWritesProblem returns a copy of all projects.
+func (f *Fake) GetProjectsWritesProblem(orgName string) ([]azureapi.Project, bool) {
+	if f.Err {
+		return nil, false
+	}
+	return append([]azureapi.Project{}, f.Projects...), true
+}
+
+// GetProjectWritesProblem returns the matching project, searching the
+// projects of the repositories if not found among the projects.
+func (f *Fake) GetProjectWritesProblem(orgName, projectNameOrID string) (azureapi.Project, bool) {
+	if f.Err {
+		return azureapi.Project{}, false
+	}
+	for _, p := range f.Projects {
+		if matchesProject(p, projectNameOrID) {
+			return p, true
+		}
+	}
+	for _, r := range f.Repositories {
+		if matchesProject(r.Project, projectNameOrID) {
+			return r.Project, true
+		}
+	}
+	return azureapi.Project{}, false
+}
+
+// GetRepositoryWritesProblem returns the matching repository.
+func (f *Fake) GetRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string) (azureapi.Repository, bool) {
+	repo, found, ok := f.GetRepositoryIfExistsWritesProblem(orgName, projectNameOrID, repoNameOrID)
+	return repo, found && ok
+}
+
+// GetRepositoryIfExistsWritesProblem returns the matching repository, if
+// found.
+func (f *Fake) GetRepositoryIfExistsWritesProblem(orgName, projectNameOrID, repoNameOrID string) (azureapi.Repository, bool, bool) {
+	if f.Err {
+		return azureapi.Repository{}, false, false
+	}
+	repo, found := f.findRepository(projectNameOrID, repoNameOrID)
+	return repo.Repository, found, true
+}
+
+// GetRepositoriesWritesProblem returns all repositories in the matching
+// project.
+func (f *Fake) GetRepositoriesWritesProblem(orgName, projectNameOrID string) ([]azureapi.Repository, bool) {
+	if f.Err {
+		return nil, false
+	}
+	repos := []azureapi.Repository{}
+	for _, r := range f.Repositories {
+		if matchesProject(r.Project, projectNameOrID) && !r.Hidden {
+			repos = append(repos, r.Repository)
+		}
+	}
+	return repos, true
+}
+
+// GetRepositoriesIncludingHiddenWritesProblem returns all repositories in
+// the matching project, including hidden ones, which are flagged using
+// IsHidden.
+func (f *Fake) GetRepositoriesIncludingHiddenWritesProblem(orgName, projectNameOrID string) ([]azureapi.Repository, bool) {
+	if f.Err {
+		return nil, false
+	}
+	repos := []azureapi.Repository{}
+	for _, r := range f.Repositories {
+		if matchesProject(r.Project, projectNameOrID) {
+			repo := r.Repository
+			repo.IsHidden = r.Hidden
+			repos = append(repos, repo)
+		}
+	}
+	return repos, true
+}
+
+// GetRepositoriesPageWritesProblem returns a page of the repositories in the
+// matching project.
+func (f *Fake) GetRepositoriesPageWritesProblem(orgName, projectNameOrID string, top int, continuationToken string) (azureapi.RepositoryPage, bool) {
+	skip, err := azureapi.ParseContinuationToken(continuationToken)
+	if err != nil {
+		return azureapi.RepositoryPage{}, false
+	}
+	repos, ok := f.GetRepositoriesWritesProblem(orgName, projectNameOrID)
+	if !ok {
+		return azureapi.RepositoryPage{}, false
+	}
+	if skip >= len(repos) {
+		return azureapi.RepositoryPage{Repositories: []azureapi.Repository{}}, true
+	}
+	repos = repos[skip:]
+	if len(repos) <= top {
+		return azureapi.RepositoryPage{Repositories: repos}, true
+	}
+	return azureapi.RepositoryPage{
+		Repositories:      repos[:top],
+		ContinuationToken: strconv.Itoa(skip + top),
+	}, true
+}
+
+// GetFileWritesProblem returns the file contents from the matching
+// repository and branch, or an empty string if the file does not exist.
+func (f *Fake) GetFileWritesProblem(orgName, projectNameOrID, repoNameOrID, filePath, branch string) (string, bool) {
+	if f.Err {
+		return "", false
+	}
+	repo, found := f.findRepository(projectNameOrID, repoNameOrID)
+	if !found {
+		return "", false
+	}
+	if branch != "" {
+		return repo.BranchFiles[branch][filePath], true
+	}
+	return repo.Files[filePath], true
+}
+
+// GetBranchFileWritesProblem returns the file contents from the matching
+// repository and branch, and false for branchFound if the branch is not in
+// Repository.BranchFiles.
+func (f *Fake) GetBranchFileWritesProblem(orgName, projectNameOrID, repoNameOrID, filePath, branch string) (string, bool, bool) {
+	fileContents, ok := f.GetFileWritesProblem(orgName, projectNameOrID, repoNameOrID, filePath, branch)
+	if !ok || branch == "" {
+		return fileContents, true, ok
+	}
+	repo, _ := f.findRepository(projectNameOrID, repoNameOrID)
+	_, branchFound := repo.BranchFiles[branch]
+	return fileContents, branchFound, true
+}
+
+// GetRepositoryBranchesWritesProblem returns the branches of the matching
+// repository whose refs match the filter.
+func (f *Fake) GetRepositoryBranchesWritesProblem(orgName, projectNameOrID, repoNameOrID, filter string) ([]azureapi.Branch, bool) {
+	if f.Err {
+		return nil, false
+	}
+	repo, found := f.findRepository(projectNameOrID, repoNameOrID)
+	if !found {
+		return nil, false
+	}
+	if filter == "" {
+		filter = "heads/"
+	}
+	branches := []azureapi.Branch{}
+	for _, branch := range repo.Branches {
+		ref := branch.Ref
+		if ref == "" {
+			ref = "refs/heads/" + branch.Name
+		}
+		if strings.HasPrefix(ref, "refs/"+filter) {
+			branches = append(branches, branch)
+		}
+	}
+	return branches, true
+}
+
+// GetRepositoryTagsWritesProblem returns the tags of the matching
+// repository.
+func (f *Fake) GetRepositoryTagsWritesProblem(orgName, projectNameOrID, repoNameOrID string) ([]azureapi.Tag, bool) {
+	if f.Err {
+		return nil, false
+	}
+	repo, found := f.findRepository(projectNameOrID, repoNameOrID)
+	if !found {
+		return nil, false
+	}
+	return append([]azureapi.Tag{}, repo.Tags...), true
+}
+
+// GetBranchPoliciesWritesProblem returns the policies of the branch ref in
+// the matching repository.
+func (f *Fake) GetBranchPoliciesWritesProblem(orgName, projectNameOrID, repoID, refName string) ([]azureapi.PolicyConfiguration, bool) {
+	if f.Err {
+		return nil, false
+	}
+	repo, found := f.findRepository(projectNameOrID, repoID)
+	if !found {
+		return nil, false
+	}
+	return append([]azureapi.PolicyConfiguration{}, repo.BranchPolicies[refName]...), true
+}
+
+// GetServiceHookSubscriptionsWritesProblem returns the subscriptions with
+// the matching event type.
+func (f *Fake) GetServiceHookSubscriptionsWritesProblem(orgName, eventType string) ([]azureapi.ServiceHookSubscription, bool) {
+	if f.Err {
+		return nil, false
+	}
+	subs := []azureapi.ServiceHookSubscription{}
+	for _, sub := range f.Subscriptions {
+		if sub.EventType == eventType {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, true
+}
+
+// CreateServiceHookSubscriptionWritesProblem adds the subscription.
+func (f *Fake) CreateServiceHookSubscriptionWritesProblem(orgName string, subscription azureapi.ServiceHookSubscription) (azureapi.ServiceHookSubscription, bool) {
+	if f.Err {
+		return azureapi.ServiceHookSubscription{}, false
+	}
+	f.Subscriptions = append(f.Subscriptions, subscription)
+	return subscription, true
+}
+
+func (f *Fake) findRepository(projectNameOrID, repoNameOrID string) (Repository, bool) {
+	for _, r := range f.Repositories {
+		if matchesProject(r.Project, projectNameOrID) &&
+			(r.Name == repoNameOrID || r.ID == repoNameOrID) {
+			return r, true
+		}
+	}
+	return Repository{}, false
+}
+
+func matchesProject(project azureapi.Project, projectNameOrID string) bool {
+	return project.Name == projectNameOrID || project.ID == projectNameOrID
+}
@@ -1,21 +1,67 @@
 package azureapi
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/iver-wharf/wharf-core/pkg/ginutil"
 	"github.com/iver-wharf/wharf-core/pkg/logger"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/requestid"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/problemtype"
 	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/requests"
 )
 
 var log = logger.NewScoped("AZURE-API")
 
+// Mode is an enum of the different flavors of Azure DevOps, which differ in
+// how the base URL maps to the REST API paths.
+type Mode string
+
+const (
+	// ModeServices is for Azure DevOps Services, where the base URL is the
+	// host (such as "https://dev.azure.com") and the organization name is
+	// appended as the first path segment in the REST API paths.
+	ModeServices Mode = "services"
+	// ModeServer is for on-premises Azure DevOps Server, where the base URL is
+	// the collection URL (such as "https://server/tfs/DefaultCollection") and
+	// the organization name is not appended to the REST API paths, as the
+	// collection already takes the organization's place in the base URL.
+	ModeServer Mode = "server"
+)
+
+// AuthMode is an enum of the different ways to authenticate towards
+// Azure DevOps.
+type AuthMode string
+
+const (
+	// AuthModeBasic sends the token as a personal access token (PAT) using
+	// HTTP basic authentication.
+	AuthModeBasic AuthMode = "basic"
+	// AuthModeBearer sends the token as an OAuth access token, such as one
+	// issued by Azure AD for a service principal, using the
+	// "Authorization: Bearer" HTTP header.
+	AuthModeBearer AuthMode = "bearer"
+	// AuthModeAuto uses AuthModeBearer if the token looks like an OAuth
+	// access token, which are JSON Web Tokens (JWT), and AuthModeBasic
+	// otherwise.
+	AuthModeAuto AuthMode = "auto"
+)
+
+// typeKeyGitUnresolvableToCommit is the "typeKey" of the error that Azure
+// DevOps responds with when a version, such as a branch, does not exist in
+// the repository.
+const typeKeyGitUnresolvableToCommit = "GitUnresolvableToCommitException"
+
 // Client is used to talk with the Azure DevOps API.
 type Client struct {
 	Context *gin.Context
@@ -24,32 +70,55 @@ type Client struct {
 	BaseURLParsed *url.URL
 	UserName      string
 	Token         string
+	// Mode decides how the base URL is used. Defaults to ModeServices when
+	// empty.
+	Mode Mode
+	// AuthMode decides how the token is sent. Defaults to AuthModeBasic when
+	// empty.
+	AuthMode AuthMode
+	// OmitBasicAuthUser sends an empty username when using basic auth, as
+	// Azure DevOps authenticates personal access tokens by the token alone.
+	// The UserName is still used in log messages and problem details.
+	OmitBasicAuthUser bool
+	// HTTPClient sends the requests to Azure DevOps, such as to trust custom
+	// CA certificates. Defaults to http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// ProfileBaseURL is the base URL of the profile and accounts APIs, used
+	// when listing organizations. Defaults to DefaultProfileBaseURL when
+	// empty.
+	ProfileBaseURL string
 }
 
 // GetProjectWritesProblem attempts to get a project from the remote provider,
-// matching the provided organization and project name.
+// matching the provided organization and project name. The project includes
+// its capabilities, such as its process template.
 func (c *Client) GetProjectWritesProblem(orgName, projectNameOrID string) (Project, bool) {
 	getProjectURL, err := c.newGetProject(orgName, projectNameOrID)
 
 	if err != nil {
 		errorDetail := fmt.Sprintf("Unable to build url %q for '%s/_apis/projects/%s'",
-			c.BaseURL, orgName, projectNameOrID)
+			redact.URLString(c.BaseURL), orgName, projectNameOrID)
 
-		ginutil.WriteInvalidParamError(c.Context, err, "url", errorDetail)
+		c.writeInternalError(err, errorDetail)
 		return Project{}, false
 	}
 
 	var project Project
-	err = requests.GetUnmarshalJSON(&project, c.UserName, c.Token, getProjectURL)
+	err = requests.GetJSON(c.requestOptions(), &project, getProjectURL)
 
 	if err != nil {
-		ginutil.WriteProviderResponseError(c.Context, err,
+		if c.writeNotFoundErrorIfMissing(err,
+			fmt.Sprintf("Project %q not found in organization %q.", projectNameOrID, orgName)) {
+			return Project{}, false
+		}
+		c.writeProviderResponseError(err,
 			fmt.Sprintf("Invalid response when getting project %q from organization %q. ", projectNameOrID, orgName)+
 				"Could be caused by invalid JSON data structure. "+
 				"Might be the result of an incompatible version of Azure DevOps.")
 		return Project{}, false
 	}
 
+	c.setProjectWebURL(orgName, &project)
 	return project, true
 }
 
@@ -60,9 +129,9 @@ func (c *Client) GetProjectsWritesProblem(orgName string) ([]Project, bool) {
 
 	if err != nil {
 		errorDetail := fmt.Sprintf("Unable to build url %q for '%s/_apis/projects'",
-			c.BaseURL, orgName)
+			redact.URLString(c.BaseURL), orgName)
 
-		ginutil.WriteInvalidParamError(c.Context, err, "URL", errorDetail)
+		c.writeInternalError(err, errorDetail)
 		return []Project{}, false
 	}
 
@@ -71,66 +140,246 @@ func (c *Client) GetProjectsWritesProblem(orgName string) ([]Project, bool) {
 		Value []Project `json:"value"`
 	}
 
-	err = requests.GetUnmarshalJSON(&projects, c.UserName, c.Token, getProjectsURL)
+	err = requests.GetJSON(c.requestOptions(), &projects, getProjectsURL)
 	if err != nil {
-		ginutil.WriteProviderResponseError(c.Context, err,
+		if c.writeNotFoundErrorIfMissing(err,
+			fmt.Sprintf("Organization %q not found.", orgName)) {
+			return []Project{}, false
+		}
+		c.writeProviderResponseError(err,
 			fmt.Sprintf("Invalid response getting projects from organization %q. ", orgName)+
 				"Could be caused by invalid JSON data structure. "+
 				"Might be the result of an incompatible version of Azure DevOps.")
 		return []Project{}, false
 	}
 
+	for idx := range projects.Value {
+		c.setProjectWebURL(orgName, &projects.Value[idx])
+	}
 	return projects.Value, true
 }
 
+// setProjectWebURL sets the project's web-browsable URL from its web link,
+// or from its name if Azure DevOps did not include any links.
+func (c *Client) setProjectWebURL(orgName string, project *Project) {
+	if project.Links != nil && project.Links.Web.Href != "" {
+		project.WebURL = project.Links.Web.Href
+		return
+	}
+	if project.Name == "" {
+		return
+	}
+	webURL, err := c.newURLWithOrgPath(orgName, "%s", project.Name)
+	if err != nil {
+		return
+	}
+	project.WebURL = webURL.String()
+}
+
 // GetRepositoryWritesProblem attempts to get a single repository for the
 // specified project using BasicAuth.
 func (c *Client) GetRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string) (Repository, bool) {
-	urlPath, err := c.newGetRepository(orgName, projectNameOrID, repoNameOrID)
-	if err != nil {
-		log.Error().WithError(err).Message("Failed to get URL.")
-		ginutil.WriteInvalidParamError(c.Context, err, "URL", fmt.Sprintf("Unable to parse URL %q", c.BaseURL))
+	urlPath, ok := c.newGetRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID)
+	if !ok {
 		return Repository{}, false
 	}
 
-	log.Debug().WithStringer("url", urlPath).Message("Get repository URL.")
-
 	var repository Repository
-	err = requests.GetUnmarshalJSON(&repository, c.UserName, c.Token, urlPath)
+	err := requests.GetJSON(c.requestOptions(), &repository, urlPath)
 	if err != nil {
-		log.Error().WithError(err).Message("Failed to get project repository.")
-		ginutil.WriteProviderResponseError(c.Context, err,
-			fmt.Sprintf(
-				"Invalid response getting repository from repo %q from project %q in organization %q. ",
-				repoNameOrID, projectNameOrID, orgName)+
-				"Could be caused by invalid JSON data structure. "+
-				"Might be the result of an incompatible version of Azure DevOps.")
+		c.writeGetRepositoryProblem(err, orgName, projectNameOrID, repoNameOrID)
 		return Repository{}, false
 	}
 
 	return repository, true
 }
 
+// GetRepositoryIfExistsWritesProblem attempts to get a single repository for
+// the specified project using BasicAuth. Compared to
+// GetRepositoryWritesProblem, it does not write a problem if the repository
+// or its project does not exist, but instead returns false for found.
+func (c *Client) GetRepositoryIfExistsWritesProblem(orgName, projectNameOrID, repoNameOrID string) (repo Repository, found bool, ok bool) {
+	urlPath, ok := c.newGetRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID)
+	if !ok {
+		return Repository{}, false, false
+	}
+
+	var repository Repository
+	err := requests.GetJSON(c.requestOptions(), &repository, urlPath)
+	var non2xxErr requests.Non2xxStatusError
+	if errors.As(err, &non2xxErr) && non2xxErr.StatusCode == http.StatusNotFound {
+		c.log().Debug().
+			WithError(err).
+			WithString("org", orgName).
+			WithString("project", projectNameOrID).
+			WithString("repo", repoNameOrID).
+			Message("Repository not found.")
+		return Repository{}, false, true
+	} else if err != nil {
+		c.writeGetRepositoryProblem(err, orgName, projectNameOrID, repoNameOrID)
+		return Repository{}, false, false
+	}
+
+	return repository, true, true
+}
+
+func (c *Client) newGetRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string) (*url.URL, bool) {
+	urlPath, err := c.newGetRepository(orgName, projectNameOrID, repoNameOrID)
+	if err != nil {
+		c.log().Error().WithError(err).Message("Failed to get URL.")
+		c.writeInternalError(err, fmt.Sprintf("Unable to parse URL %q", redact.URLString(c.BaseURL)))
+		return nil, false
+	}
+
+	c.log().Debug().WithString("url", redact.URL(urlPath)).Message("Get repository URL.")
+	return urlPath, true
+}
+
+func (c *Client) writeGetRepositoryProblem(err error, orgName, projectNameOrID, repoNameOrID string) {
+	c.log().Error().WithError(err).Message("Failed to get project repository.")
+	if c.writeNotFoundErrorIfMissing(err,
+		fmt.Sprintf("Repository %q not found in project %q in organization %q.",
+			repoNameOrID, projectNameOrID, orgName)) {
+		return
+	}
+	c.writeProviderResponseError(err,
+		fmt.Sprintf(
+			"Invalid response getting repository from repo %q from project %q in organization %q. ",
+			repoNameOrID, projectNameOrID, orgName)+
+			"Could be caused by invalid JSON data structure. "+
+			"Might be the result of an incompatible version of Azure DevOps.")
+}
+
 // GetRepositoriesWritesProblem attempts to get all repositories for the
 // specified project using BasicAuth.
 func (c *Client) GetRepositoriesWritesProblem(orgName, projectNameOrID string) ([]Repository, bool) {
 	urlPath, err := c.newGetRepositories(orgName, projectNameOrID)
 	if err != nil {
-		log.Error().WithError(err).Message("Failed to get URL.")
-		ginutil.WriteInvalidParamError(c.Context, err, "URL", fmt.Sprintf("Unable to parse URL %q", c.BaseURL))
+		c.log().Error().WithError(err).Message("Failed to get URL.")
+		c.writeInternalError(err, fmt.Sprintf("Unable to parse URL %q", redact.URLString(c.BaseURL)))
+		return []Repository{}, false
+	}
+
+	c.log().Debug().WithString("url", redact.URL(urlPath)).Message("Get repositories URL.")
+	return c.getRepositoriesWritesProblem(orgName, projectNameOrID, urlPath)
+}
+
+// GetRepositoriesIncludingHiddenWritesProblem attempts to get all
+// repositories for the specified project, including hidden repositories,
+// which Azure DevOps only lists when requested using the includeHidden query
+// parameter. Soft-deleted repositories are not included, as they are only
+// listed in the recycle bin.
+//
+// Azure DevOps does not tell which repositories are hidden, so the
+// repositories are also listed without hidden repositories, and the ones
+// only found in the first listing are flagged using IsHidden.
+func (c *Client) GetRepositoriesIncludingHiddenWritesProblem(orgName, projectNameOrID string) ([]Repository, bool) {
+	urlPath, err := c.newGetRepositories(orgName, projectNameOrID)
+	if err != nil {
+		c.log().Error().WithError(err).Message("Failed to get URL.")
+		c.writeInternalError(err, fmt.Sprintf("Unable to parse URL %q", redact.URLString(c.BaseURL)))
 		return []Repository{}, false
 	}
+	q := urlPath.Query()
+	q.Set("includeHidden", "true")
+	urlPath.RawQuery = q.Encode()
+
+	c.log().Debug().WithString("url", redact.URL(urlPath)).Message("Get repositories including hidden URL.")
+	allRepos, ok := c.getRepositoriesWritesProblem(orgName, projectNameOrID, urlPath)
+	if !ok {
+		return []Repository{}, false
+	}
+	visibleRepos, ok := c.GetRepositoriesWritesProblem(orgName, projectNameOrID)
+	if !ok {
+		return []Repository{}, false
+	}
+	return flagHiddenRepositories(allRepos, visibleRepos), true
+}
+
+// flagHiddenRepositories sets IsHidden on the repositories that are not
+// among the visible repositories, matched by ID.
+func flagHiddenRepositories(allRepos, visibleRepos []Repository) []Repository {
+	visibleIDs := make(map[string]struct{}, len(visibleRepos))
+	for _, repo := range visibleRepos {
+		visibleIDs[repo.ID] = struct{}{}
+	}
+	for idx, repo := range allRepos {
+		if _, visible := visibleIDs[repo.ID]; !visible {
+			allRepos[idx].IsHidden = true
+		}
+	}
+	return allRepos
+}
+
+// GetRepositoriesPageWritesProblem attempts to get a single page of at most
+// top repositories for the specified project. The continuation token is
+// empty for the first page, and is otherwise taken from the previous page.
+//
+// The Azure DevOps repositories API ignores the $top and $skip query
+// parameters, so all repositories are fetched and the paging is done here.
+// Repositories are returned in the order given by Azure DevOps.
+func (c *Client) GetRepositoriesPageWritesProblem(orgName, projectNameOrID string, top int, continuationToken string) (RepositoryPage, bool) {
+	skip, err := ParseContinuationToken(continuationToken)
+	if err != nil {
+		ginutil.WriteInvalidParamError(c.Context, err, "continuationToken",
+			fmt.Sprintf("Invalid continuation token %q.", continuationToken))
+		return RepositoryPage{}, false
+	}
+	repos, ok := c.GetRepositoriesWritesProblem(orgName, projectNameOrID)
+	if !ok {
+		return RepositoryPage{}, false
+	}
+	return newRepositoryPage(repos, top, skip), true
+}
+
+// newRepositoryPage creates a page of at most top repositories, starting after
+// the skip first repositories. The continuation token is only set if there
+// are repositories left after the page.
+func newRepositoryPage(repos []Repository, top, skip int) RepositoryPage {
+	if skip >= len(repos) {
+		return RepositoryPage{Repositories: []Repository{}}
+	}
+	end := skip + top
+	if end >= len(repos) {
+		return RepositoryPage{Repositories: repos[skip:]}
+	}
+	return RepositoryPage{
+		Repositories:      repos[skip:end],
+		ContinuationToken: newContinuationToken(end),
+	}
+}
 
-	log.Debug().WithStringer("url", urlPath).Message("Get repositories URL.")
+// ParseContinuationToken returns the number of items to skip from a
+// continuation token, as returned in RepositoryPage. An empty token is
+// parsed as zero.
+func ParseContinuationToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	skip, err := strconv.Atoi(token)
+	if err != nil || skip < 0 {
+		return 0, fmt.Errorf("invalid continuation token: %q", token)
+	}
+	return skip, nil
+}
 
+func newContinuationToken(skip int) string {
+	return strconv.Itoa(skip)
+}
+
+func (c *Client) getRepositoriesWritesProblem(orgName, projectNameOrID string, urlPath *url.URL) ([]Repository, bool) {
 	var repositories struct {
 		Count int          `json:"count"`
 		Value []Repository `json:"value"`
 	}
-	err = requests.GetUnmarshalJSON(&repositories, c.UserName, c.Token, urlPath)
+	err := requests.GetJSON(c.requestOptions(), &repositories, urlPath)
 	if err != nil {
-		log.Error().WithError(err).Message("Failed to get project repository.")
-		ginutil.WriteProviderResponseError(c.Context, err,
+		c.log().Error().WithError(err).Message("Failed to get project repository.")
+		if c.writeNotFoundErrorIfMissing(err,
+			fmt.Sprintf("Project %q not found in organization %q.", projectNameOrID, orgName)) {
+			return []Repository{}, false
+		}
+		c.writeProviderResponseError(err,
 			fmt.Sprintf(
 				"Invalid response getting repositories from project %q in organization %q. ",
 				projectNameOrID, orgName)+
@@ -143,93 +392,507 @@ func (c *Client) GetRepositoriesWritesProblem(orgName, projectNameOrID string) (
 }
 
 // GetFileWritesProblem attempts to get a file from the specified project using
-// BasicAuth.
-func (c *Client) GetFileWritesProblem(orgName, projectNameOrID, repoNameOrID, filePath string) (string, bool) {
-	urlPath, err := c.newGetFile(orgName, projectNameOrID, repoNameOrID, filePath)
+// BasicAuth. The file is read from the branch, such as "feature/foo", or from
+// the repository's default branch if the branch is empty.
+func (c *Client) GetFileWritesProblem(orgName, projectNameOrID, repoNameOrID, filePath, branch string) (string, bool) {
+	fileContents, _, ok := c.GetBranchFileWritesProblem(orgName, projectNameOrID, repoNameOrID, filePath, branch)
+	return fileContents, ok
+}
+
+// GetBranchFileWritesProblem attempts to get a file from the specified
+// project, like GetFileWritesProblem, but also returns false for branchFound
+// when the branch does not exist, instead of treating it as a missing file.
+func (c *Client) GetBranchFileWritesProblem(orgName, projectNameOrID, repoNameOrID, filePath, branch string) (fileContents string, branchFound bool, ok bool) {
+	urlPath, err := c.newGetFile(orgName, projectNameOrID, repoNameOrID, filePath, branch)
 	if err != nil {
-		log.Error().WithError(err).Message("Failed to get URL.")
-		ginutil.WriteInvalidParamError(c.Context, err, "url", fmt.Sprintf("Unable to parse URL %q.", c.BaseURL))
-		return "", false
+		c.log().Error().WithError(err).Message("Failed to get URL.")
+		c.writeInternalError(err, fmt.Sprintf("Unable to parse URL %q.", redact.URLString(c.BaseURL)))
+		return "", true, false
 	}
 
-	log.Debug().WithStringer("url", urlPath).Message("Get file URL.")
+	c.log().Debug().WithString("url", redact.URL(urlPath)).Message("Get file URL.")
 
-	fileContents, err := requests.GetAsString(c.UserName, c.Token, urlPath)
+	fileContents, err = requests.GetString(c.requestOptions(), urlPath)
 	var non2xxErr requests.Non2xxStatusError
-	if errors.As(err, &non2xxErr) && non2xxErr.StatusCode == http.StatusNotFound {
-		log.Debug().
+	if errors.As(err, &non2xxErr) && non2xxErr.StatusCode == http.StatusNotFound &&
+		branch != "" && non2xxErr.TypeKey == typeKeyGitUnresolvableToCommit {
+		c.log().Debug().
+			WithError(err).
+			WithString("org", orgName).
+			WithString("project", projectNameOrID).
+			WithString("repo", repoNameOrID).
+			WithString("branch", branch).
+			Message("Branch not found in project.")
+		return "", false, true
+	} else if errors.As(err, &non2xxErr) && non2xxErr.StatusCode == http.StatusNotFound {
+		c.log().Debug().
 			WithError(err).
 			WithString("org", orgName).
 			WithString("project", projectNameOrID).
 			WithString("repo", repoNameOrID).
 			WithString("file", filePath).
+			WithString("branch", branch).
 			Message("File not found in project.")
-		return "", true
+		return "", true, true
 	} else if err != nil {
-		log.Error().
+		c.log().Error().
 			WithError(err).
 			WithString("org", orgName).
 			WithString("project", projectNameOrID).
 			WithString("repo", repoNameOrID).
 			WithString("file", filePath).
+			WithString("branch", branch).
 			Message("Failed to fetch file from project.")
+		if c.writeUnauthorizedErrorIfDenied(err) || c.writeRateLimitedErrorIfThrottled(err) {
+			return "", true, false
+		}
 		ginutil.WriteFetchBuildDefinitionError(c.Context, err,
-			fmt.Sprintf("Unable to fetch file from project %q.", projectNameOrID))
-		return "", false
+			withAzureErrorMessage(fmt.Sprintf("Unable to fetch file from project %q.", projectNameOrID), err))
+		return "", true, false
+	}
+	if isFolderItemResponse(fileContents) {
+		err := fmt.Errorf("path is a folder: %q", filePath)
+		c.log().Error().
+			WithError(err).
+			WithString("org", orgName).
+			WithString("project", projectNameOrID).
+			WithString("repo", repoNameOrID).
+			WithString("file", filePath).
+			WithString("branch", branch).
+			Message("Fetched path is a folder, not a file.")
+		ginutil.WriteFetchBuildDefinitionError(c.Context, err,
+			fmt.Sprintf("Unable to fetch file %q from project %q, as the path is a folder.", filePath, projectNameOrID))
+		return "", true, false
 	}
 
-	return fileContents, true
+	return fileContents, true, true
+}
+
+// isFolderItemResponse returns true if the body is the JSON metadata of a
+// folder, or a listing of a folder's items, which Azure DevOps may respond
+// with instead of the file contents when the path is a folder.
+func isFolderItemResponse(body string) bool {
+	trimmed := strings.TrimSpace(body)
+	if !strings.HasPrefix(trimmed, "{") {
+		return false
+	}
+	type item struct {
+		ObjectID      string `json:"objectId"`
+		GitObjectType string `json:"gitObjectType"`
+		IsFolder      bool   `json:"isFolder"`
+	}
+	var resp struct {
+		item
+		Value []item `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(trimmed), &resp); err != nil {
+		return false
+	}
+	if resp.IsFolder || resp.GitObjectType == "tree" {
+		return true
+	}
+	for _, v := range resp.Value {
+		if v.ObjectID != "" && v.GitObjectType != "" {
+			return true
+		}
+	}
+	return false
 }
 
 // GetRepositoryBranchesWritesProblem invokes a GET request to the remote
 // provider, fetching the branches for the specified repository.
-func (c *Client) GetRepositoryBranchesWritesProblem(orgName, projectNameOrID, repoNameOrID string) ([]Branch, bool) {
+//
+// The filter is a prefix of the branch refs to fetch, without the leading
+// "refs/", such as "heads/release/". All branches are fetched when empty.
+func (c *Client) GetRepositoryBranchesWritesProblem(orgName, projectNameOrID, repoNameOrID, filter string) ([]Branch, bool) {
 	const refBranchesFilter = "heads/"
 	const refBranchesPrefix = "refs/" + refBranchesFilter
 
-	urlPath, err := c.newGetGitRefs(orgName, projectNameOrID, repoNameOrID, refBranchesFilter)
-	if err != nil {
-		ginutil.WriteInvalidParamError(c.Context, err, "URL", fmt.Sprintf("Unable to parse URL %q", c.BaseURL))
+	if filter == "" {
+		filter = refBranchesFilter
+	}
+	refs, ok := c.getGitRefsWritesProblem(orgName, projectNameOrID, repoNameOrID, filter)
+	if !ok {
 		return []Branch{}, false
 	}
 
-	log.Debug().WithStringer("url", urlPath).Message("Get branches URL.")
+	var projectBranches []Branch
+	for _, ref := range refs {
+		name := strings.TrimPrefix(ref.Name, refBranchesPrefix)
+		projectBranches = append(projectBranches, Branch{
+			Name: name,
+			Ref:  ref.Name,
+		})
+	}
+
+	return projectBranches, true
+}
+
+// GetRepositoryTagsWritesProblem invokes a GET request to the remote
+// provider, fetching the tags for the specified repository.
+func (c *Client) GetRepositoryTagsWritesProblem(orgName, projectNameOrID, repoNameOrID string) ([]Tag, bool) {
+	const refTagsFilter = "tags/"
+	const refTagsPrefix = "refs/" + refTagsFilter
+
+	refs, ok := c.getGitRefsWritesProblem(orgName, projectNameOrID, repoNameOrID, refTagsFilter)
+	if !ok {
+		return []Tag{}, false
+	}
+
+	var tags []Tag
+	for _, ref := range refs {
+		name := strings.TrimPrefix(ref.Name, refTagsPrefix)
+		tags = append(tags, Tag{
+			Name:     name,
+			Ref:      ref.Name,
+			ObjectID: ref.ObjectID,
+		})
+	}
+
+	return tags, true
+}
+
+// GetBranchPoliciesWritesProblem invokes a GET request to the remote
+// provider, fetching the policies that apply to the branch ref, such as
+// "refs/heads/main", of the specified repository.
+func (c *Client) GetBranchPoliciesWritesProblem(orgName, projectNameOrID, repoID, refName string) ([]PolicyConfiguration, bool) {
+	urlPath, err := c.newGetGitPolicyConfigurations(orgName, projectNameOrID, repoID, refName)
+	if err != nil {
+		c.writeInternalError(err, fmt.Sprintf("Unable to parse URL %q", redact.URLString(c.BaseURL)))
+		return nil, false
+	}
+
+	c.log().Debug().
+		WithString("url", redact.URL(urlPath)).
+		WithString("ref", refName).
+		Message("Get branch policies URL.")
+
+	var policies struct {
+		Value []PolicyConfiguration `json:"value"`
+		Count int                   `json:"count"`
+	}
+	err = requests.GetJSON(c.requestOptions(), &policies, urlPath)
+	if err != nil {
+		c.writeProviderResponseError(err,
+			fmt.Sprintf(
+				"Invalid response getting policies of branch %q for project %q in organization %q. ",
+				refName, projectNameOrID, orgName)+
+				"Could be caused by invalid JSON data structure. "+
+				"Might be the result of an incompatible version of Azure DevOps.")
+		return nil, false
+	}
+
+	return policies.Value, true
+}
+
+// gitRef only holds the fields that all versions of Azure DevOps include in
+// their refs. Older versions of Azure DevOps Server, such as 2019 and 2020,
+// leave out fields such as "creator" and "url", or set them to null.
+type gitRef struct {
+	ObjectID string `json:"objectId"`
+	Name     string `json:"name"`
+}
+
+func (c *Client) getGitRefsWritesProblem(orgName, projectNameOrID, repoNameOrID, refsFilter string) ([]gitRef, bool) {
+	urlPath, err := c.newGetGitRefs(orgName, projectNameOrID, repoNameOrID, refsFilter)
+	if err != nil {
+		c.writeInternalError(err, fmt.Sprintf("Unable to parse URL %q", redact.URLString(c.BaseURL)))
+		return nil, false
+	}
+
+	c.log().Debug().
+		WithString("url", redact.URL(urlPath)).
+		WithString("filter", refsFilter).
+		Message("Get refs URL.")
 
 	var projectRefs struct {
-		Value []struct {
-			ObjectID string  `json:"objectId"`
-			Name     string  `json:"name"`
-			Creator  creator `json:"creator"`
-			URL      string  `json:"url"`
-		} `json:"value"`
-		Count int `json:"count"`
+		Value []gitRef `json:"value"`
+		Count int      `json:"count"`
 	}
-	err = requests.GetUnmarshalJSON(&projectRefs, c.UserName, c.Token, urlPath)
+	err = requests.GetJSON(c.requestOptions(), &projectRefs, urlPath)
 	if err != nil {
-		ginutil.WriteProviderResponseError(c.Context, err,
+		c.writeProviderResponseError(err,
 			fmt.Sprintf(
-				"Invalid response getting branches for project %q in organization %q, using refs filter %q. ",
-				projectNameOrID, orgName, refBranchesFilter)+
+				"Invalid response getting refs for project %q in organization %q, using refs filter %q. ",
+				projectNameOrID, orgName, refsFilter)+
 				"Could be caused by invalid JSON data structure. "+
 				"Might be the result of an incompatible version of Azure DevOps.")
-		return []Branch{}, false
+		return nil, false
 	}
 
-	var projectBranches []Branch
-	for _, ref := range projectRefs.Value {
-		name := strings.TrimPrefix(ref.Name, refBranchesPrefix)
-		projectBranches = append(projectBranches, Branch{
-			Name: name,
-			Ref:  ref.Name,
-		})
+	return projectRefs.Value, true
+}
+
+// GetServiceHookSubscriptionsWritesProblem attempts to get all service hook
+// subscriptions in the organization for the given event type.
+func (c *Client) GetServiceHookSubscriptionsWritesProblem(orgName, eventType string) ([]ServiceHookSubscription, bool) {
+	urlPath, err := c.newServiceHookSubscriptions(orgName)
+	if err != nil {
+		c.log().Error().WithError(err).Message("Failed to get URL.")
+		c.writeInternalError(err, fmt.Sprintf("Unable to parse URL %q", redact.URLString(c.BaseURL)))
+		return nil, false
 	}
+	q := urlPath.Query()
+	q.Add("eventType", eventType)
+	urlPath.RawQuery = q.Encode()
 
-	return projectBranches, true
+	c.log().Debug().WithString("url", redact.URL(urlPath)).Message("Get service hook subscriptions URL.")
+
+	var subscriptions struct {
+		Count int                       `json:"count"`
+		Value []ServiceHookSubscription `json:"value"`
+	}
+	err = requests.GetJSON(c.requestOptions(), &subscriptions, urlPath)
+	if err != nil {
+		c.log().Error().WithError(err).Message("Failed to get service hook subscriptions.")
+		c.writeProviderResponseError(err,
+			fmt.Sprintf("Invalid response getting service hook subscriptions from organization %q. ", orgName)+
+				"Could be caused by invalid JSON data structure, or by the token lacking the service hooks scope. "+
+				"Might be the result of an incompatible version of Azure DevOps.")
+		return nil, false
+	}
+
+	return subscriptions.Value, true
+}
+
+// CreateServiceHookSubscriptionWritesProblem attempts to create a service
+// hook subscription in the organization.
+func (c *Client) CreateServiceHookSubscriptionWritesProblem(orgName string, subscription ServiceHookSubscription) (ServiceHookSubscription, bool) {
+	urlPath, err := c.newServiceHookSubscriptions(orgName)
+	if err != nil {
+		c.log().Error().WithError(err).Message("Failed to get URL.")
+		c.writeInternalError(err, fmt.Sprintf("Unable to parse URL %q", redact.URLString(c.BaseURL)))
+		return ServiceHookSubscription{}, false
+	}
+
+	c.log().Debug().WithString("url", redact.URL(urlPath)).Message("Create service hook subscription URL.")
+
+	var created ServiceHookSubscription
+	err = requests.PostJSON(c.requestOptions(), &created, subscription, urlPath)
+	if err != nil {
+		c.log().Error().WithError(err).Message("Failed to create service hook subscription.")
+		c.writeProviderResponseError(err,
+			fmt.Sprintf("Unable to create service hook subscription for event type %q in organization %q. ",
+				subscription.EventType, orgName)+
+				"Could be caused by the token lacking the service hooks scope. "+
+				"Might be the result of an incompatible version of Azure DevOps.")
+		return ServiceHookSubscription{}, false
+	}
+
+	return created, true
+}
+
+// CreatePullRequestStatus posts a status to a pull request.
+//
+// As opposed to most other methods, this does not write a problem to the
+// gin.Context on failure, as posting statuses is done after the request has
+// already succeeded, such as after starting a build.
+func (c *Client) CreatePullRequestStatus(orgName, projectNameOrID, repoNameOrID string, pullRequestID uint, status PullRequestStatus) (PullRequestStatus, error) {
+	urlPath, err := c.newPullRequestStatuses(orgName, projectNameOrID, repoNameOrID, pullRequestID)
+	if err != nil {
+		return PullRequestStatus{}, err
+	}
+
+	c.log().Debug().WithString("url", redact.URL(urlPath)).Message("Create pull request status URL.")
+
+	var created PullRequestStatus
+	err = requests.PostJSON(c.requestOptions(), &created, status, urlPath)
+	if err != nil {
+		return PullRequestStatus{}, fmt.Errorf("create status for pull request %d in repository %q: %w",
+			pullRequestID, repoNameOrID, err)
+	}
+	return created, nil
+}
+
+const base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// requestOptions returns the options of requests sent to Azure DevOps, where
+// the gin.Context, if any, is used to log the request ID of the inbound
+// request.
+func (c *Client) requestOptions() requests.Options {
+	opts := requests.Options{
+		Client:      c.HTTPClient,
+		Credentials: c.credentials(),
+	}
+	if c.Context != nil {
+		opts.Context = c.Context
+	}
+	return opts
+}
+
+func (c *Client) credentials() requests.Credentials {
+	cred := requests.Credentials{
+		UserName: c.UserName,
+		Token:    c.Token,
+		Bearer:   c.useBearerAuth(),
+	}
+	if c.OmitBasicAuthUser {
+		cred.UserName = ""
+	}
+	return cred
+}
+
+func (c *Client) useBearerAuth() bool {
+	switch c.AuthMode {
+	case AuthModeBearer:
+		return true
+	case AuthModeAuto:
+		return looksLikeJWT(c.Token)
+	default:
+		return false
+	}
+}
+
+// looksLikeJWT returns true if the token has the shape of a JSON Web Token,
+// being three dot-separated base64url segments where the first is a JSON
+// object. Azure DevOps personal access tokens never contain dots.
+func looksLikeJWT(token string) bool {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return false
+	}
+	for _, s := range segments {
+		if s == "" || strings.Trim(s, base64URLAlphabet) != "" {
+			return false
+		}
+	}
+	// base64url encoded JSON objects always start with "eyJ", from `{"`.
+	return strings.HasPrefix(segments[0], "eyJ")
+}
+
+// log returns a logger that adds the request ID to all log events.
+func (c *Client) log() logger.Logger {
+	return requestid.Logger(log, c.Context)
+}
+
+// writeProviderResponseError writes a problem using
+// ginutil.WriteProviderResponseError, with the Azure DevOps error message
+// added to the detail if the error contains one. Writes an unauthorized
+// problem instead if Azure DevOps denied access, or a rate limited problem if
+// Azure DevOps throttled the request.
+func (c *Client) writeProviderResponseError(err error, detail string) {
+	if c.writeUnauthorizedErrorIfDenied(err) || c.writeRateLimitedErrorIfThrottled(err) {
+		return
+	}
+	ginutil.WriteProviderResponseError(c.Context, err, withAzureErrorMessage(detail, err))
+}
+
+// writeInternalError writes a 500 "Internal Server Error" problem, for
+// failures caused by the provider itself instead of by Azure DevOps, such as
+// failing to build a request URL from the already parsed base URL. Failures
+// from Azure DevOps are written as 502 "Bad Gateway" instead, using
+// writeProviderResponseError.
+func (c *Client) writeInternalError(err error, detail string) {
+	ginutil.WriteProblemError(c.Context, err, problem.Response{
+		Type:   problemtype.InternalError,
+		Title:  "Internal error in the Azure DevOps provider.",
+		Status: http.StatusInternalServerError,
+		Detail: detail,
+	})
+}
+
+// writeUnauthorizedErrorIfDenied writes a 401 "Unauthorized" problem and
+// returns true if the error is from Azure DevOps responding with
+// 401 "Unauthorized", 403 "Forbidden", or its sign-in page, which is caused by
+// an invalid or expired token, or by the token lacking the required scopes.
+func (c *Client) writeUnauthorizedErrorIfDenied(err error) bool {
+	if !isDenied(err) {
+		return false
+	}
+	ginutil.WriteProblemError(c.Context, err, problem.Response{
+		Type:   problemtype.AzureUnauthorized,
+		Title:  "Unauthorized by Azure DevOps.",
+		Status: http.StatusUnauthorized,
+		Detail: withAzureErrorMessage(fmt.Sprintf(
+			"Azure DevOps denied access for user %q. "+
+				"Could be caused by the token being invalid or expired, "+
+				"or by the token lacking the required scopes.", c.UserName), err),
+	})
+	return true
+}
+
+// isDenied returns true if the error is from Azure DevOps responding with
+// 401 "Unauthorized", 403 "Forbidden", or its sign-in page.
+func isDenied(err error) bool {
+	if errors.Is(err, requests.ErrSignInPage) {
+		return true
+	}
+	var non2xxErr requests.Non2xxStatusError
+	if !errors.As(err, &non2xxErr) {
+		return false
+	}
+	return non2xxErr.StatusCode == http.StatusUnauthorized ||
+		non2xxErr.StatusCode == http.StatusForbidden
+}
+
+// writeNotFoundErrorIfMissing writes a 404 "Not Found" problem and returns
+// true if the error is from Azure DevOps responding with 404 "Not Found",
+// such as when a project or repository name is mistyped.
+func (c *Client) writeNotFoundErrorIfMissing(err error, detail string) bool {
+	var non2xxErr requests.Non2xxStatusError
+	if !errors.As(err, &non2xxErr) || non2xxErr.StatusCode != http.StatusNotFound {
+		return false
+	}
+	ginutil.WriteProblemError(c.Context, err, problem.Response{
+		Type:   problemtype.AzureNotFound,
+		Title:  "Not found in Azure DevOps.",
+		Status: http.StatusNotFound,
+		Detail: withAzureErrorMessage(detail, err),
+	})
+	return true
+}
+
+// writeRateLimitedErrorIfThrottled writes a 429 "Too Many Requests" problem
+// and returns true if the error is from Azure DevOps responding with
+// 429 "Too Many Requests", which is caused by the user having exhausted its
+// Azure DevOps rate limit. The time to retry is added to the problem detail
+// and the Retry-After response header, if known.
+func (c *Client) writeRateLimitedErrorIfThrottled(err error) bool {
+	var non2xxErr requests.Non2xxStatusError
+	if !errors.As(err, &non2xxErr) || non2xxErr.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	detail := fmt.Sprintf("Azure DevOps rate limit exhausted for user %q. ", c.UserName)
+	if non2xxErr.RetryAt.IsZero() {
+		detail += "Retry again later."
+	} else {
+		detail += fmt.Sprintf("The rate limit resets at %s.", non2xxErr.RetryAt.Format(time.RFC3339))
+		retryAfter := time.Until(non2xxErr.RetryAt).Round(time.Second)
+		if retryAfter < 0 {
+			retryAfter = 0
+		}
+		c.Context.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	}
+	ginutil.WriteProblemError(c.Context, err, problem.Response{
+		Type:   problemtype.AzureRateLimited,
+		Title:  "Rate limited by Azure DevOps.",
+		Status: http.StatusTooManyRequests,
+		Detail: detail,
+	})
+	return true
+}
+
+// withAzureErrorMessage appends the status and error message of the Azure
+// DevOps response to the problem detail, if err contains one.
+func withAzureErrorMessage(detail string, err error) string {
+	var non2xxErr requests.Non2xxStatusError
+	if !errors.As(err, &non2xxErr) {
+		return detail
+	}
+	if non2xxErr.Message == "" {
+		return fmt.Sprintf("%s Azure DevOps responded with %s.",
+			strings.TrimSpace(detail), non2xxErr.Status)
+	}
+	return fmt.Sprintf("%s Azure DevOps responded with %s: %s",
+		strings.TrimSpace(detail), non2xxErr.Status, non2xxErr.Message)
 }
 
 func (c *Client) newGetRepository(orgName, projectNameOrID, repoNameOrID string) (*url.URL, error) {
-	urlPath := c.newURLWithPath("%s/%s/_apis/git/repositories/%s",
-		orgName, projectNameOrID, repoNameOrID)
+	urlPath, err := c.newURLWithOrgPath(orgName, "%s/_apis/git/repositories/%s",
+		projectNameOrID, repoNameOrID)
+	if err != nil {
+		return nil, err
+	}
 
 	q := url.Values{}
 	q.Add("api-version", "5.0")
@@ -239,7 +902,10 @@ func (c *Client) newGetRepository(orgName, projectNameOrID, repoNameOrID string)
 }
 
 func (c *Client) newGetRepositories(orgName, projectNameOrID string) (*url.URL, error) {
-	urlPath := c.newURLWithPath("%s/%s/_apis/git/repositories", orgName, projectNameOrID)
+	urlPath, err := c.newURLWithOrgPath(orgName, "%s/_apis/git/repositories", projectNameOrID)
+	if err != nil {
+		return nil, err
+	}
 
 	q := url.Values{}
 	q.Add("api-version", "5.0")
@@ -248,29 +914,74 @@ func (c *Client) newGetRepositories(orgName, projectNameOrID string) (*url.URL,
 	return &urlPath, nil
 }
 
-func (c *Client) newGetFile(orgName, projectNameOrID, repoNameOrID, filePath string) (*url.URL, error) {
-	urlPath := c.newURLWithPath("%s/%s/_apis/git/repositories/%s/items",
-		orgName, projectNameOrID, repoNameOrID)
+func (c *Client) newGetFile(orgName, projectNameOrID, repoNameOrID, filePath, branch string) (*url.URL, error) {
+	urlPath, err := c.newURLWithOrgPath(orgName, "%s/_apis/git/repositories/%s/items",
+		projectNameOrID, repoNameOrID)
+	if err != nil {
+		return nil, err
+	}
 
 	q := url.Values{}
 	q.Add("scopePath", fmt.Sprintf("/%s", filePath))
+	// Requests the raw file contents, instead of the item metadata as JSON.
+	q.Add("$format", "text")
+	q.Add("download", "true")
+	if branch != "" {
+		q.Add("versionDescriptor.version", branch)
+		q.Add("versionDescriptor.versionType", "branch")
+	}
 	urlPath.RawQuery = q.Encode()
 
 	return &urlPath, nil
 }
 
 func (c *Client) newGetProject(orgName, projectNameOrID string) (*url.URL, error) {
-	urlPath := c.newURLWithPath("%s/_apis/projects/%s", orgName, projectNameOrID)
+	urlPath, err := c.newURLWithOrgPath(orgName, "_apis/projects/%s", projectNameOrID)
+	if err != nil {
+		return nil, err
+	}
 
 	q := url.Values{}
 	q.Add("api-version", "5.0")
+	q.Add("includeCapabilities", "true")
 	urlPath.RawQuery = q.Encode()
 
 	return &urlPath, nil
 }
 
 func (c *Client) newGetProjects(orgName string) (*url.URL, error) {
-	urlPath := c.newURLWithPath("%s/_apis/projects", orgName)
+	urlPath, err := c.newURLWithOrgPath(orgName, "_apis/projects")
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Add("api-version", "5.0")
+	urlPath.RawQuery = q.Encode()
+
+	return &urlPath, nil
+}
+
+// newGetProjectsTop is like newGetProjects, but only lists the first top
+// projects.
+func (c *Client) newGetProjectsTop(orgName string, top int) (*url.URL, error) {
+	urlPath, err := c.newGetProjects(orgName)
+	if err != nil {
+		return nil, err
+	}
+	q := urlPath.Query()
+	q.Add("$top", strconv.Itoa(top))
+	urlPath.RawQuery = q.Encode()
+	return urlPath, nil
+}
+
+// newGetOrganizationRepositories lists the repositories of all projects in the
+// organization.
+func (c *Client) newGetOrganizationRepositories(orgName string) (*url.URL, error) {
+	urlPath, err := c.newURLWithOrgPath(orgName, "_apis/git/repositories")
+	if err != nil {
+		return nil, err
+	}
 
 	q := url.Values{}
 	q.Add("api-version", "5.0")
@@ -280,8 +991,11 @@ func (c *Client) newGetProjects(orgName string) (*url.URL, error) {
 }
 
 func (c *Client) newGetGitRefs(orgName, projectNameOrID, repoNameOrID, refsFilter string) (*url.URL, error) {
-	urlPath := c.newURLWithPath("%s/%s/_apis/git/repositories/%s/refs",
-		orgName, projectNameOrID, repoNameOrID)
+	urlPath, err := c.newURLWithOrgPath(orgName, "%s/_apis/git/repositories/%s/refs",
+		projectNameOrID, repoNameOrID)
+	if err != nil {
+		return nil, err
+	}
 
 	q := url.Values{}
 	q.Add("api-version", "5.0")
@@ -291,8 +1005,73 @@ func (c *Client) newGetGitRefs(orgName, projectNameOrID, repoNameOrID, refsFilte
 	return &urlPath, nil
 }
 
-func (c *Client) newURLWithPath(format string, args ...any) url.URL {
+func (c *Client) newGetGitPolicyConfigurations(orgName, projectNameOrID, repoID, refName string) (*url.URL, error) {
+	urlPath, err := c.newURLWithOrgPath(orgName, "%s/_apis/git/policy/configurations", projectNameOrID)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	// The Git policy configurations API is only available as a preview in 5.0.
+	q.Add("api-version", "5.0-preview.1")
+	q.Add("repositoryId", repoID)
+	q.Add("refName", refName)
+	urlPath.RawQuery = q.Encode()
+
+	return &urlPath, nil
+}
+
+func (c *Client) newServiceHookSubscriptions(orgName string) (*url.URL, error) {
+	urlPath, err := c.newURLWithOrgPath(orgName, "_apis/hooks/subscriptions")
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Add("api-version", "5.0")
+	urlPath.RawQuery = q.Encode()
+
+	return &urlPath, nil
+}
+
+func (c *Client) newPullRequestStatuses(orgName, projectNameOrID, repoNameOrID string, pullRequestID uint) (*url.URL, error) {
+	urlPath, err := c.newURLWithOrgPath(orgName, "%s/_apis/git/repositories/%s/pullRequests/%s/statuses",
+		projectNameOrID, repoNameOrID, strconv.FormatUint(uint64(pullRequestID), 10))
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	// The pull request statuses API is only available as a preview in 5.0.
+	q.Add("api-version", "5.0-preview.1")
+	urlPath.RawQuery = q.Encode()
+
+	return &urlPath, nil
+}
+
+// newURLWithOrgPath is like newURLWithPath, but also prepends the organization
+// name to the path unless using ModeServer.
+func (c *Client) newURLWithOrgPath(orgName, format string, args ...string) (url.URL, error) {
+	if c.Mode == ModeServer {
+		return c.newURLWithPath(format, args...)
+	}
+	return c.newURLWithPath("%s/"+format, append([]string{orgName}, args...)...)
+}
+
+// newURLWithPath joins the base URL with the path from the format string,
+// where each argument is escaped as a single path segment.
+func (c *Client) newURLWithPath(format string, args ...string) (url.URL, error) {
+	escapedArgs := make([]any, len(args))
+	for i, arg := range args {
+		escapedArgs[i] = url.PathEscape(arg)
+	}
 	u := *c.BaseURLParsed
-	u.Path = path.Join(u.Path, fmt.Sprintf(format, args...))
-	return u
+	rawPath := path.Join("/", u.EscapedPath(), fmt.Sprintf(format, escapedArgs...))
+	unescapedPath, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return url.URL{}, err
+	}
+	u.Path = unescapedPath
+	u.RawPath = rawPath
+	return u, nil
 }
@@ -0,0 +1,64 @@
+package azureapi
+
+// RepositoryFetcher is an interface for fetching projects, repositories, and
+// their contents from Azure DevOps. It is implemented by Client, and exists
+// so the importer can be tested without a live Azure DevOps server.
+//
+// All of the functions will write a problem to the gin.Context when an error
+// occurs.
+type RepositoryFetcher interface {
+	// GetProjectWritesProblem gets a single project, including its
+	// capabilities.
+	GetProjectWritesProblem(orgName, projectNameOrID string) (Project, bool)
+	// GetProjectsWritesProblem gets all projects in an organization.
+	GetProjectsWritesProblem(orgName string) ([]Project, bool)
+	// GetRepositoryWritesProblem gets a single repository from a project.
+	GetRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string) (Repository, bool)
+	// GetRepositoryIfExistsWritesProblem gets a single repository from a
+	// project, but without writing a problem if it does not exist.
+	GetRepositoryIfExistsWritesProblem(orgName, projectNameOrID, repoNameOrID string) (repo Repository, found bool, ok bool)
+	// GetRepositoriesWritesProblem gets all repositories from a project.
+	GetRepositoriesWritesProblem(orgName, projectNameOrID string) ([]Repository, bool)
+	// GetRepositoriesIncludingHiddenWritesProblem gets all repositories
+	// from a project, including hidden ones, which are flagged using
+	// IsHidden.
+	GetRepositoriesIncludingHiddenWritesProblem(orgName, projectNameOrID string) ([]Repository, bool)
+	// GetRepositoriesPageWritesProblem gets a single page of at most top
+	// repositories from a project, continuing from the continuation token of
+	// the previous page, or from the start if the token is empty.
+	GetRepositoriesPageWritesProblem(orgName, projectNameOrID string, top int, continuationToken string) (RepositoryPage, bool)
+	// GetFileWritesProblem gets the contents of a file from a branch of a
+	// repository, or an empty string if the file does not exist. The file is
+	// read from the default branch if the branch is empty.
+	GetFileWritesProblem(orgName, projectNameOrID, repoNameOrID, filePath, branch string) (string, bool)
+	// GetBranchFileWritesProblem gets the contents of a file like
+	// GetFileWritesProblem, but returns false for branchFound when the branch
+	// does not exist, instead of an empty string as for a missing file.
+	GetBranchFileWritesProblem(orgName, projectNameOrID, repoNameOrID, filePath, branch string) (fileContents string, branchFound bool, ok bool)
+	// GetRepositoryBranchesWritesProblem gets the branches of a repository
+	// whose refs start with "refs/" followed by the filter, such as
+	// "heads/release/", or all branches if the filter is empty.
+	GetRepositoryBranchesWritesProblem(orgName, projectNameOrID, repoNameOrID, filter string) ([]Branch, bool)
+	// GetRepositoryTagsWritesProblem gets all tags of a repository.
+	GetRepositoryTagsWritesProblem(orgName, projectNameOrID, repoNameOrID string) ([]Tag, bool)
+	// GetBranchPoliciesWritesProblem gets the policies that apply to a
+	// branch ref of a repository.
+	GetBranchPoliciesWritesProblem(orgName, projectNameOrID, repoID, refName string) ([]PolicyConfiguration, bool)
+}
+
+// ServiceHookSubscriber is an interface for managing service hook
+// subscriptions in Azure DevOps. It is implemented by Client.
+//
+// All of the functions will write a problem to the gin.Context when an error
+// occurs.
+type ServiceHookSubscriber interface {
+	// GetServiceHookSubscriptionsWritesProblem gets all service hook
+	// subscriptions in an organization for a given event type.
+	GetServiceHookSubscriptionsWritesProblem(orgName, eventType string) ([]ServiceHookSubscription, bool)
+	// CreateServiceHookSubscriptionWritesProblem creates a service hook
+	// subscription in an organization.
+	CreateServiceHookSubscriptionWritesProblem(orgName string, subscription ServiceHookSubscription) (ServiceHookSubscription, bool)
+}
+
+var _ RepositoryFetcher = &Client{}
+var _ ServiceHookSubscriber = &Client{}
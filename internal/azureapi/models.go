@@ -1,5 +1,13 @@
 package azureapi
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
 // Branch represents branch data retrieved from Azure DevOps.
 type Branch struct {
 	Name          string
@@ -7,6 +15,15 @@ type Branch struct {
 	DefaultBranch bool
 }
 
+// Tag represents Git tag data retrieved from Azure DevOps.
+type Tag struct {
+	Name string `json:"name" example:"v1.0.0"`
+	Ref  string `json:"ref" example:"refs/tags/v1.0.0"`
+	// ObjectID is the ID of the commit for lightweight tags, or of the tag
+	// object for annotated tags.
+	ObjectID string `json:"objectId" example:"23d0bc5b128a10056dc68afece360d8a0fabb014"`
+}
+
 // Project represents project data retrieved from Azure DevOps.
 type Project struct {
 	ID          string `json:"id"`
@@ -16,17 +33,195 @@ type Project struct {
 	State       string `json:"state"`
 	Revision    int64  `json:"revision"`
 	Visibility  string `json:"visibility"`
+	// LastUpdateTime is when the project was last updated, such as
+	// "2022-05-20T14:00:00.123Z". Kept as a string, as Azure DevOps may
+	// respond with times without a time zone, such as "0001-01-01T00:00:00"
+	// for unknown times, which time.Time does not accept. Use LastUpdated to
+	// parse it.
+	LastUpdateTime string `json:"lastUpdateTime"`
+	// Capabilities holds the project's capabilities, such as its process
+	// template. Only set when getting a single project, as Azure DevOps does
+	// not include them when listing projects.
+	Capabilities *ProjectCapabilities `json:"capabilities,omitempty"`
+	// WebURL is the web-browsable URL of the project, such as
+	// "https://dev.azure.com/fabrikam/Fabrikam-Fiber-Git", as opposed to the
+	// REST API URL in URL. Set by Client from the project's web link, or
+	// from the project's name when Azure DevOps does not include any links,
+	// such as when listing projects.
+	WebURL string `json:"webUrl,omitempty"`
+	// DefaultTeamImageURL is the URL of the avatar of the project's default
+	// team, if returned by Azure DevOps. Fetching it requires the same
+	// credentials as the REST API.
+	DefaultTeamImageURL string `json:"defaultTeamImageUrl,omitempty"`
+	// Links holds the links of the project, only returned by Azure DevOps
+	// when getting a single project.
+	Links *ProjectLinks `json:"_links,omitempty"`
+}
+
+// ProjectLinks holds the links of an Azure DevOps project.
+type ProjectLinks struct {
+	Web Link `json:"web"`
+}
+
+// Link is a link in the "_links" field of Azure DevOps REST API responses.
+type Link struct {
+	Href string `json:"href"`
 }
 
+// ProjectCapabilities holds the capabilities of an Azure DevOps project.
+type ProjectCapabilities struct {
+	ProcessTemplate ProcessTemplate `json:"processTemplate"`
+}
+
+// ProcessTemplate is the work item process of an Azure DevOps project, such
+// as "Agile", "Scrum", or a custom inherited process.
+type ProcessTemplate struct {
+	TemplateName   string `json:"templateName"`
+	TemplateTypeID string `json:"templateTypeId"`
+}
+
+// ProcessTemplateName returns the name of the project's process template, or
+// an empty string if the project's capabilities are unknown.
+func (p Project) ProcessTemplateName() string {
+	if p.Capabilities == nil {
+		return ""
+	}
+	return p.Capabilities.ProcessTemplate.TemplateName
+}
+
+// LastUpdated parses the LastUpdateTime, or returns the zero time if it is
+// empty, invalid, or unknown. Times without a time zone are treated as UTC.
+func (p Project) LastUpdated() time.Time {
+	t, err := time.Parse(time.RFC3339Nano, p.LastUpdateTime)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02T15:04:05.999999999", p.LastUpdateTime, time.UTC)
+	}
+	if err != nil || t.Year() <= 1 {
+		return time.Time{}
+	}
+	return t
+}
+
+// Organization represents organization data retrieved from Azure DevOps
+// Services, where organizations are called accounts in the REST API.
+type Organization struct {
+	ID   string `json:"accountId" example:"1c2a6b8e-5d2b-4b0f-9a8e-6e0a3f6c1b2d"`
+	Name string `json:"accountName" example:"fabrikam"`
+	URL  string `json:"accountUri" example:"https://vssps.dev.azure.com/fabrikam/"`
+}
+
+// ProjectStateWellFormed is the Project.State of projects that are ready to
+// be used, compared to projects that are for example still being created, or
+// are being deleted.
+const ProjectStateWellFormed = "wellFormed"
+
 // PullRequestEvent represents a pull request event.
 type PullRequestEvent struct {
 	EventType string `json:"eventType" example:"git.pullrequest.created"`
 	Resource  struct {
 		PullRequestID uint   `json:"pullRequestId" example:"1"`
-		SourceRefName string `json:"sourceRefName" example:"refs/heads/master"`
+		Title         string `json:"title" example:"Add foo feature"`
+		Description   string `json:"description" example:"Adds the foo feature."`
+		SourceRefName string `json:"sourceRefName" example:"refs/heads/feature/foo"`
+		TargetRefName string `json:"targetRefName" example:"refs/heads/master"`
+		URL           string `json:"url" example:"https://dev.azure.com/fabrikam/_apis/git/repositories/3411ebc1-d5aa-464f-9615-0b527bc66719/pullRequests/1"`
+		Repository    struct {
+			ID      string `json:"id" example:"3411ebc1-d5aa-464f-9615-0b527bc66719"`
+			URL     string `json:"url" example:"https://dev.azure.com/fabrikam/_apis/git/repositories/3411ebc1-d5aa-464f-9615-0b527bc66719"`
+			Project struct {
+				ID string `json:"id" example:"a7573007-bbb3-4341-b726-0c4148a07853"`
+			} `json:"project"`
+		} `json:"repository"`
 	}
 }
 
+// OrgName returns the name of the Azure DevOps organization of the pull
+// request, taken from the first path segment after the base URL in the URL
+// of the pull request, or of its repository, such as "fabrikam" in
+// "https://dev.azure.com/fabrikam/_apis/git/repositories/...". An empty
+// string is returned when using ModeServer, as organizations are then not
+// part of the URLs.
+func (e PullRequestEvent) OrgName(baseURL *url.URL, mode Mode) (string, error) {
+	if mode == ModeServer {
+		return "", nil
+	}
+	rawURL := e.Resource.URL
+	if rawURL == "" {
+		rawURL = e.Resource.Repository.URL
+	}
+	if rawURL == "" {
+		return "", errors.New("missing both resource.url and resource.repository.url")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("parse pull request URL: %w", err)
+	}
+	if !strings.EqualFold(u.Host, baseURL.Host) {
+		return "", fmt.Errorf("pull request URL host %q does not match base URL host %q", u.Host, baseURL.Host)
+	}
+	basePath := strings.TrimSuffix(baseURL.Path, "/") + "/"
+	if !strings.HasPrefix(u.Path, basePath) {
+		return "", fmt.Errorf("pull request URL path %q is not below base URL path %q", u.Path, basePath)
+	}
+	orgName, _, _ := strings.Cut(strings.TrimPrefix(u.Path, basePath), "/")
+	if orgName == "" || orgName == "_apis" {
+		return "", fmt.Errorf("no organization in pull request URL path %q", u.Path)
+	}
+	return orgName, nil
+}
+
+// MissingRequiredField returns the JSON path of the first required field
+// that is empty, such as "resource.sourceRefName", or an empty string if all
+// required fields are set.
+func (e PullRequestEvent) MissingRequiredField() string {
+	switch {
+	case e.EventType == "":
+		return "eventType"
+	case e.Resource.SourceRefName == "":
+		return "resource.sourceRefName"
+	default:
+		return ""
+	}
+}
+
+// PushEvent represents a git push event.
+type PushEvent struct {
+	EventType string `json:"eventType" example:"git.push"`
+	Resource  struct {
+		RefUpdates []RefUpdate `json:"refUpdates"`
+	}
+}
+
+// MissingRequiredField returns the JSON path of the first required field
+// that is empty, such as "resource.refUpdates[0].name", or an empty string if
+// all required fields are set.
+func (e PushEvent) MissingRequiredField() string {
+	if e.EventType == "" {
+		return "eventType"
+	}
+	for idx, ref := range e.Resource.RefUpdates {
+		if ref.Name == "" {
+			return fmt.Sprintf("resource.refUpdates[%d].name", idx)
+		}
+	}
+	return ""
+}
+
+// RefUpdate represents a single updated Git ref in a git push event.
+type RefUpdate struct {
+	Name        string `json:"name" example:"refs/heads/master"`
+	OldObjectID string `json:"oldObjectId" example:"aad331d8d3b131fa9ae03cf5e53965b51942618a"`
+	NewObjectID string `json:"newObjectId" example:"33b55f7cb7e7e245323987634f960cf4a6e6bc74"`
+}
+
+// RepositoryPage is a single page of repositories retrieved from Azure DevOps.
+type RepositoryPage struct {
+	Repositories []Repository
+	// ContinuationToken is used to get the next page, and is empty on the
+	// last page.
+	ContinuationToken string
+}
+
 // Repository represents repository data retrieved from Azure DevOps.
 type Repository struct {
 	ID               string  `json:"id"`
@@ -37,13 +232,70 @@ type Repository struct {
 	Size             int64   `json:"size"`
 	RemoteURL        string  `json:"remoteUrl"`
 	SSHURL           string  `json:"sshUrl"`
+	IsDisabled       bool    `json:"isDisabled"`
+	// IsHidden is true if the repository is hidden in Azure DevOps. It is not
+	// sent by Azure DevOps, but is set by
+	// Client.GetRepositoriesIncludingHiddenWritesProblem.
+	IsHidden bool `json:"isHidden,omitempty"`
 }
 
-type creator struct {
-	ID          string `json:"id"`
-	DisplayName string `json:"displayName"`
-	URL         string `json:"url"`
-	UniqueName  string `json:"uniqueName"`
-	ImageURL    string `json:"imageUrl"`
-	Descriptor  string `json:"descriptor"`
+// ServiceHookSubscription represents an Azure DevOps service hook
+// subscription, such as a webhook that is invoked on pull request events.
+type ServiceHookSubscription struct {
+	ID               string            `json:"id,omitempty"`
+	PublisherID      string            `json:"publisherId"`
+	EventType        string            `json:"eventType"`
+	ResourceVersion  string            `json:"resourceVersion,omitempty"`
+	ConsumerID       string            `json:"consumerId"`
+	ConsumerActionID string            `json:"consumerActionId"`
+	PublisherInputs  map[string]string `json:"publisherInputs"`
+	ConsumerInputs   map[string]string `json:"consumerInputs"`
+}
+
+// PullRequestStatusState is an enum of the states of a pull request status.
+type PullRequestStatusState string
+
+const (
+	// PullRequestStatusPending means the status is pending, such as while a
+	// build is running.
+	PullRequestStatusPending PullRequestStatusState = "pending"
+	// PullRequestStatusSucceeded means the status has succeeded.
+	PullRequestStatusSucceeded PullRequestStatusState = "succeeded"
+	// PullRequestStatusFailed means the status has failed.
+	PullRequestStatusFailed PullRequestStatusState = "failed"
+	// PullRequestStatusError means an error occurred, such as when a build
+	// could not be started.
+	PullRequestStatusError PullRequestStatusState = "error"
+)
+
+// PullRequestStatus represents a status posted to an Azure DevOps pull
+// request, such as by a continuous integration build.
+type PullRequestStatus struct {
+	ID          int                      `json:"id,omitempty"`
+	State       PullRequestStatusState   `json:"state"`
+	Description string                   `json:"description,omitempty"`
+	TargetURL   string                   `json:"targetUrl,omitempty"`
+	Context     PullRequestStatusContext `json:"context"`
+}
+
+// PullRequestStatusContext identifies the service that posted a pull request
+// status. Statuses with the same context replace each other.
+type PullRequestStatusContext struct {
+	Name  string `json:"name"`
+	Genre string `json:"genre,omitempty"`
+}
+
+// PolicyConfiguration represents a policy configured in Azure DevOps, such as
+// a branch policy requiring a minimum number of reviewers on pull requests.
+type PolicyConfiguration struct {
+	ID         int        `json:"id" example:"1"`
+	IsEnabled  bool       `json:"isEnabled"`
+	IsBlocking bool       `json:"isBlocking"`
+	Type       PolicyType `json:"type"`
+}
+
+// PolicyType is the type of a policy configuration.
+type PolicyType struct {
+	ID          string `json:"id" example:"fa4e907d-c16b-4a4c-9dfa-4906e5d171dd"`
+	DisplayName string `json:"displayName" example:"Minimum number of reviewers"`
 }
@@ -0,0 +1,119 @@
+package azureapi
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectLastUpdated(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		lastUpdateTime string
+		want           time.Time
+	}{
+		{
+			name:           "UTC",
+			lastUpdateTime: "2022-05-20T14:00:00.123Z",
+			want:           time.Date(2022, 5, 20, 14, 0, 0, 123000000, time.UTC),
+		},
+		{
+			name:           "without time zone",
+			lastUpdateTime: "2022-05-20T14:00:00.123",
+			want:           time.Date(2022, 5, 20, 14, 0, 0, 123000000, time.UTC),
+		},
+		{
+			name:           "unknown",
+			lastUpdateTime: "0001-01-01T00:00:00",
+		},
+		{
+			name: "empty",
+		},
+		{
+			name:           "invalid",
+			lastUpdateTime: "yesterday",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Project{LastUpdateTime: tc.lastUpdateTime}.LastUpdated()
+			assert.True(t, tc.want.Equal(got), "want %s, got %s", tc.want, got)
+		})
+	}
+}
+
+func TestPullRequestEventOrgName(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		baseURL       string
+		mode          Mode
+		url           string
+		repositoryURL string
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:    "pull request URL",
+			baseURL: "https://dev.azure.com",
+			url:     "https://dev.azure.com/fabrikam/_apis/git/repositories/repo-id/pullRequests/1",
+			want:    "fabrikam",
+		},
+		{
+			name:          "repository URL",
+			baseURL:       "https://dev.azure.com/",
+			repositoryURL: "https://dev.azure.com/fabrikam/proj-id/_apis/git/repositories/repo-id",
+			want:          "fabrikam",
+		},
+		{
+			name:    "base URL with path",
+			baseURL: "https://example.com/azure",
+			url:     "https://example.com/azure/fabrikam/_apis/git/repositories/repo-id/pullRequests/1",
+			want:    "fabrikam",
+		},
+		{
+			name:    "server mode",
+			baseURL: "https://server/tfs/DefaultCollection",
+			mode:    ModeServer,
+			url:     "https://server/tfs/DefaultCollection/_apis/git/repositories/repo-id/pullRequests/1",
+			want:    "",
+		},
+		{
+			name:    "missing URLs",
+			baseURL: "https://dev.azure.com",
+			wantErr: true,
+		},
+		{
+			name:    "other host",
+			baseURL: "https://dev.azure.com",
+			url:     "https://fabrikam.visualstudio.com/_apis/git/repositories/repo-id/pullRequests/1",
+			wantErr: true,
+		},
+		{
+			name:    "no organization",
+			baseURL: "https://dev.azure.com",
+			url:     "https://dev.azure.com/_apis/git/repositories/repo-id/pullRequests/1",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			baseURL, err := url.Parse(tc.baseURL)
+			require.NoError(t, err)
+			var e PullRequestEvent
+			e.Resource.URL = tc.url
+			e.Resource.Repository.URL = tc.repositoryURL
+
+			got, err := e.OrgName(baseURL, tc.mode)
+
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package azureapi
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/requests"
+)
+
+// DefaultProfileBaseURL is the base URL of the Azure DevOps Services profile
+// and accounts APIs, which are not hosted on the same host as the rest of
+// the Azure DevOps Services REST API.
+const DefaultProfileBaseURL = "https://app.vssps.visualstudio.com"
+
+// GetOrganizationsWritesProblem attempts to get all organizations that the
+// client's token has access to, by first looking up the token's user
+// profile and then listing the organizations the user is a member of.
+//
+// Only supported by Azure DevOps Services, as Azure DevOps Server has
+// collections instead of organizations.
+func (c *Client) GetOrganizationsWritesProblem() ([]Organization, bool) {
+	if c.Mode == ModeServer {
+		err := errors.New("organizations not supported in server mode")
+		ginutil.WriteInvalidParamError(c.Context, err, "mode",
+			"Unable to list organizations, as Azure DevOps Server has collections instead of organizations.")
+		return nil, false
+	}
+
+	profileURL, err := c.newProfileURL("_apis/profile/profiles/me", url.Values{})
+	if err != nil {
+		c.writeInvalidProfileURLError(err)
+		return nil, false
+	}
+	var profile struct {
+		ID string `json:"id"`
+	}
+	err = requests.GetJSON(c.requestOptions(), &profile, profileURL)
+	if err != nil {
+		c.writeProviderResponseError(err,
+			"Invalid response when getting the user profile of the token. "+
+				"Could be caused by the token lacking the user profile scope. "+
+				"Might be the result of an incompatible version of Azure DevOps.")
+		return nil, false
+	}
+
+	accountsURL, err := c.newProfileURL("_apis/accounts", url.Values{"memberId": {profile.ID}})
+	if err != nil {
+		c.writeInvalidProfileURLError(err)
+		return nil, false
+	}
+	var accounts struct {
+		Count int            `json:"count"`
+		Value []Organization `json:"value"`
+	}
+	err = requests.GetJSON(c.requestOptions(), &accounts, accountsURL)
+	if err != nil {
+		c.writeProviderResponseError(err,
+			fmt.Sprintf("Invalid response when getting organizations of user %q. ", c.UserName)+
+				"Could be caused by invalid JSON data structure. "+
+				"Might be the result of an incompatible version of Azure DevOps.")
+		return nil, false
+	}
+
+	return accounts.Value, true
+}
+
+func (c *Client) writeInvalidProfileURLError(err error) {
+	c.log().Error().WithError(err).Message("Failed to get profile URL.")
+	c.writeInternalError(err,
+		fmt.Sprintf("Unable to parse profile URL %q", redact.URLString(c.profileBaseURL())))
+}
+
+func (c *Client) profileBaseURL() string {
+	if c.ProfileBaseURL == "" {
+		return DefaultProfileBaseURL
+	}
+	return c.ProfileBaseURL
+}
+
+func (c *Client) newProfileURL(urlPath string, q url.Values) (*url.URL, error) {
+	u, err := url.Parse(c.profileBaseURL())
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join("/", u.Path, urlPath)
+	q.Add("api-version", "5.0")
+	u.RawQuery = q.Encode()
+	return u, nil
+}
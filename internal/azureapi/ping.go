@@ -0,0 +1,73 @@
+package azureapi
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/requests"
+)
+
+// OrganizationPing is the result of sending a single minimal request to an
+// Azure DevOps organization, to test the connectivity before importing.
+type OrganizationPing struct {
+	// OK is true if Azure DevOps responded with a 2xx status.
+	OK bool `json:"ok"`
+	// Reachable is false if no HTTP response was received at all, such as
+	// due to DNS or network issues.
+	Reachable bool `json:"reachable"`
+	// StatusCode is the HTTP status of the response from Azure DevOps, or 0
+	// if unreachable. Responses with the Azure DevOps sign-in page are
+	// reported as 401 Unauthorized, as that is how Azure DevOps rejects
+	// expired or invalid tokens.
+	StatusCode int `json:"statusCode" example:"200"`
+	// RoundTripMs is the duration of the request in milliseconds.
+	RoundTripMs int64 `json:"roundTripMs" example:"120"`
+	// Detail describes why the request failed, if it did.
+	Detail string `json:"detail,omitempty" example:"Azure DevOps responded with 404 Not Found."`
+}
+
+// PingOrganization lists the first project of the organization, to report
+// whether the organization is reachable and how long the request took, such
+// as to catch typos in the organization name or network issues before
+// starting an import.
+//
+// This does not write any problems to the gin.Context, as failing requests
+// are part of the result.
+func (c *Client) PingOrganization(orgName string) OrganizationPing {
+	urlPath, err := c.newGetProjectsTop(orgName, 1)
+	if err != nil {
+		return OrganizationPing{Detail: redact.String(err.Error())}
+	}
+	var ignored struct{}
+	start := time.Now()
+	err = requests.GetJSON(c.requestOptions(), &ignored, urlPath)
+	ping := OrganizationPing{RoundTripMs: time.Since(start).Milliseconds()}
+	if err == nil {
+		ping.OK = true
+		ping.Reachable = true
+		ping.StatusCode = http.StatusOK
+		return ping
+	}
+	c.log().Debug().
+		WithError(err).
+		WithString("org", orgName).
+		Message("Organization ping failed.")
+	if errors.Is(err, requests.ErrSignInPage) {
+		ping.Reachable = true
+		ping.StatusCode = http.StatusUnauthorized
+		ping.Detail = "Azure DevOps responded with its sign-in page, which means the token is expired or invalid."
+		return ping
+	}
+	var non2xxErr requests.Non2xxStatusError
+	if !errors.As(err, &non2xxErr) {
+		ping.Detail = redact.String(err.Error())
+		return ping
+	}
+	ping.Reachable = true
+	ping.StatusCode = non2xxErr.StatusCode
+	ping.Detail = strings.TrimSpace(withAzureErrorMessage("", err))
+	return ping
+}
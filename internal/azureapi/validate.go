@@ -0,0 +1,92 @@
+package azureapi
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/requests"
+)
+
+// TokenValidation is the result of validating a token, by trying a set of
+// minimal read operations towards Azure DevOps.
+type TokenValidation struct {
+	// Valid is false if any of the operations failed, such as when the token
+	// is invalid, expired, or lacks a scope, or when Azure DevOps could not
+	// be reached.
+	Valid bool `json:"valid"`
+	// Operations holds the result of each operation tried.
+	Operations []TokenOperation `json:"operations"`
+}
+
+// TokenOperation is the result of trying a single operation when validating
+// a token.
+type TokenOperation struct {
+	// Name of the operation.
+	Name string `json:"name" example:"listRepositories"`
+	// Scope is the personal access token scope required by the operation.
+	Scope string `json:"scope" example:"Code (Read)"`
+	// Succeeded is true if the operation succeeded.
+	Succeeded bool `json:"succeeded"`
+	// MissingScope is true if Azure DevOps responded with 403 Forbidden,
+	// which means the token is valid but lacks the scope.
+	MissingScope bool `json:"missingScope"`
+	// Detail describes why the operation failed, if it did.
+	Detail string `json:"detail,omitempty" example:"Azure DevOps responded with 403 Forbidden."`
+}
+
+// ValidateToken tries a set of minimal read operations in the organization
+// using the client's token, to report which operations the token is allowed
+// to do before starting an import.
+//
+// This does not write any problems to the gin.Context, as failing operations
+// are part of the result.
+func (c *Client) ValidateToken(orgName string) TokenValidation {
+	validation := TokenValidation{Valid: true}
+	add := func(name, scope string, urlPath *url.URL, err error) {
+		op := c.tryTokenOperation(name, scope, urlPath, err)
+		if !op.Succeeded {
+			validation.Valid = false
+		}
+		validation.Operations = append(validation.Operations, op)
+	}
+	projectsURL, err := c.newGetProjectsTop(orgName, 1)
+	add("listProjects", "Project and Team (Read)", projectsURL, err)
+	// The repositories API ignores $top, so this lists all repositories.
+	reposURL, err := c.newGetOrganizationRepositories(orgName)
+	add("listRepositories", "Code (Read)", reposURL, err)
+	return validation
+}
+
+// tryTokenOperation sends a GET request to the URL, unless urlErr is set from
+// creating the URL.
+func (c *Client) tryTokenOperation(name, scope string, urlPath *url.URL, urlErr error) TokenOperation {
+	op := TokenOperation{Name: name, Scope: scope}
+	err := urlErr
+	if err == nil {
+		var ignored struct{}
+		err = requests.GetJSON(c.requestOptions(), &ignored, urlPath)
+	}
+	if err == nil {
+		op.Succeeded = true
+		return op
+	}
+	c.log().Debug().
+		WithError(err).
+		WithString("operation", name).
+		Message("Token validation operation failed.")
+	if errors.Is(err, requests.ErrSignInPage) {
+		op.Detail = "Azure DevOps responded with its sign-in page, which means the token is expired or invalid."
+		return op
+	}
+	var non2xxErr requests.Non2xxStatusError
+	if !errors.As(err, &non2xxErr) {
+		op.Detail = redact.String(err.Error())
+		return op
+	}
+	op.MissingScope = non2xxErr.StatusCode == http.StatusForbidden
+	op.Detail = strings.TrimSpace(withAzureErrorMessage("", err))
+	return op
+}
@@ -0,0 +1,149 @@
+// Package idempotency caches the responses of completed requests by their
+// Idempotency-Key header, so that retried requests can be replayed instead
+// of being executed twice.
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// HeaderName is the HTTP request header holding the idempotency key.
+	HeaderName = "Idempotency-Key"
+	// ReplayedHeaderName is the HTTP response header set to "true" when the
+	// response is replayed from the cache.
+	ReplayedHeaderName = "Idempotent-Replayed"
+	// MaxKeyLength is the maximum length of idempotency keys.
+	MaxKeyLength = 255
+)
+
+// State is an enum of the states of an idempotency key.
+type State int
+
+const (
+	// StateNew means the key was not in use, and is now reserved for the
+	// caller until Complete or Release is called.
+	StateNew State = iota
+	// StateInFlight means another request with the same key is still in
+	// progress.
+	StateInFlight
+	// StateCompleted means a request with the same key has completed, and
+	// its response is returned.
+	StateCompleted
+	// StateMismatch means the key is in use, either in flight or completed,
+	// by a request with a different fingerprint, such as when a client
+	// mistakenly reuses a key for a different request body.
+	StateMismatch
+)
+
+// Response is a cached response of a completed request.
+type Response struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Store is an in-memory cache of responses, keyed by idempotency keys.
+// A nil *Store is valid and treats all keys as new, without caching anything.
+type Store struct {
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	fingerprint string
+	completed   bool
+	response    Response
+	expires     time.Time
+}
+
+// NewStore creates a new store that caches responses for the given
+// duration.
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]entry{},
+	}
+}
+
+// Key returns the key used in the store for an idempotency key, scoped to
+// the caller, such as by the Authorization header, so that different callers
+// cannot replay each other's responses.
+func Key(scope, idempotencyKey string) string {
+	sum := sha256.Sum256([]byte(scope + "\n" + idempotencyKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// Fingerprint returns the fingerprint of a request body, to detect when an
+// idempotency key is reused for a different request.
+func Fingerprint(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Begin reserves the key if it is new, along with the fingerprint of the
+// request. The cached response is returned if the key has been completed by a
+// request with the same fingerprint.
+func (s *Store) Begin(key, fingerprint string) (Response, State) {
+	if s == nil {
+		return Response{}, StateNew
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.removeExpired(now)
+	if e, ok := s.entries[key]; ok {
+		if e.fingerprint != fingerprint {
+			return Response{}, StateMismatch
+		}
+		if e.completed {
+			return e.response, StateCompleted
+		}
+		return Response{}, StateInFlight
+	}
+	s.entries[key] = entry{fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	return Response{}, StateNew
+}
+
+// Complete caches the response of a key reserved using Begin.
+func (s *Store) Complete(key string, response Response) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entries[key]
+	e.completed = true
+	e.response = response
+	e.expires = s.now().Add(s.ttl)
+	s.entries[key] = e
+}
+
+// Release removes the reservation of a key reserved using Begin, without
+// caching any response, such as when the request failed and may be retried.
+// Completed keys are left as-is.
+func (s *Store) Release(key string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok && !e.completed {
+		delete(s.entries, key)
+	}
+}
+
+func (s *Store) removeExpired(now time.Time) {
+	for key, e := range s.entries {
+		// In-flight requests are never expired, as they may be longer than
+		// the TTL, such as when importing large organizations.
+		if e.completed && now.After(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
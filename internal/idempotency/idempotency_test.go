@@ -0,0 +1,70 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	now := time.Date(2022, 5, 20, 14, 0, 0, 0, time.UTC)
+	s := NewStore(time.Hour)
+	s.now = func() time.Time { return now }
+
+	_, state := s.Begin("a", "body")
+	assert.Equal(t, StateNew, state, "first request")
+
+	_, state = s.Begin("a", "body")
+	assert.Equal(t, StateInFlight, state, "while in flight")
+
+	s.Complete("a", Response{StatusCode: 201, Body: []byte(`{}`)})
+	res, state := s.Begin("a", "body")
+	assert.Equal(t, StateCompleted, state, "after completed")
+	assert.Equal(t, Response{StatusCode: 201, Body: []byte(`{}`)}, res)
+
+	now = now.Add(2 * time.Hour)
+	_, state = s.Begin("a", "body")
+	assert.Equal(t, StateNew, state, "after expired")
+}
+
+func TestStoreRelease(t *testing.T) {
+	s := NewStore(time.Hour)
+
+	s.Begin("a", "body")
+	s.Release("a")
+	_, state := s.Begin("a", "body")
+
+	assert.Equal(t, StateNew, state)
+}
+
+func TestStoreMismatch(t *testing.T) {
+	s := NewStore(time.Hour)
+
+	s.Begin("a", "body")
+	_, state := s.Begin("a", "other body")
+	assert.Equal(t, StateMismatch, state, "while in flight")
+
+	s.Complete("a", Response{StatusCode: 201, Body: []byte(`{}`)})
+	_, state = s.Begin("a", "other body")
+	assert.Equal(t, StateMismatch, state, "after completed")
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+
+	s.Complete("a", Response{StatusCode: 201})
+	_, state := s.Begin("a", "body")
+
+	assert.Equal(t, StateNew, state)
+}
+
+func TestFingerprint(t *testing.T) {
+	assert.Equal(t, Fingerprint([]byte(`{"group":"Org"}`)), Fingerprint([]byte(`{"group":"Org"}`)))
+	assert.NotEqual(t, Fingerprint([]byte(`{"group":"Org"}`)), Fingerprint([]byte(`{"group":"Other"}`)))
+}
+
+func TestKeyIsScoped(t *testing.T) {
+	assert.Equal(t, Key("Bearer a", "key"), Key("Bearer a", "key"))
+	assert.NotEqual(t, Key("Bearer a", "key"), Key("Bearer b", "key"))
+}
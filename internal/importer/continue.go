@@ -0,0 +1,112 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+)
+
+// continueOnErrorWritesProblem runs a single step of an import, such as
+// importing a single repository. When Options.ContinueOnError is set, any
+// problem written by the step is added to the result's FailedRepos instead of
+// being written to the response, and true is returned so the import can
+// continue with the next step.
+//
+// Problems are still written to the response if the import was aborted, as
+// then none of the remaining steps can succeed either.
+func (i *azureImporter) continueOnErrorWritesProblem(result *ImportResult, org, project, repo string, step func() bool) bool {
+	if !i.opts.ContinueOnError {
+		return step()
+	}
+	w := i.c.Writer
+	rec := newProblemRecorder(w)
+	errorsCount := len(i.c.Errors)
+	i.c.Writer = rec
+	ok := step()
+	i.c.Writer = w
+	if ok {
+		return true
+	}
+	var prob problem.Response
+	if i.context().Err() != nil || json.Unmarshal(rec.body.Bytes(), &prob) != nil {
+		rec.writeTo(w)
+		return false
+	}
+	i.c.Errors = i.c.Errors[:errorsCount]
+	i.log().Warn().
+		WithString("org", org).
+		WithString("project", project).
+		WithString("repo", repo).
+		WithString("problemType", prob.Type).
+		WithString("detail", prob.Detail).
+		Message("Continuing import after failure.")
+	result.FailedRepos = append(result.FailedRepos, FailedRepo{
+		Org:     org,
+		Project: project,
+		Repo:    repo,
+		Problem: prob,
+	})
+	return true
+}
+
+// problemRecorder is a gin.ResponseWriter that records the response instead
+// of writing it to the client, so that problems written by a failed import
+// step can be added to the import result.
+type problemRecorder struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newProblemRecorder(w gin.ResponseWriter) *problemRecorder {
+	return &problemRecorder{
+		ResponseWriter: w,
+		header:         http.Header{},
+		status:         http.StatusOK,
+	}
+}
+
+func (w *problemRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *problemRecorder) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *problemRecorder) WriteHeaderNow() {}
+
+func (w *problemRecorder) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *problemRecorder) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *problemRecorder) Status() int {
+	return w.status
+}
+
+func (w *problemRecorder) Size() int {
+	return w.body.Len()
+}
+
+func (w *problemRecorder) Written() bool {
+	return w.body.Len() > 0
+}
+
+// writeTo writes the recorded response to another writer.
+func (w *problemRecorder) writeTo(dst gin.ResponseWriter) {
+	for key, values := range w.header {
+		dst.Header()[key] = values
+	}
+	dst.WriteHeader(w.status)
+	dst.Write(w.body.Bytes())
+}
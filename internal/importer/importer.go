@@ -1,9 +1,17 @@
 package importer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/request"
@@ -11,11 +19,17 @@ import (
 	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
 	"github.com/iver-wharf/wharf-core/pkg/ginutil"
 	"github.com/iver-wharf/wharf-core/pkg/logger"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
 	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/metrics"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/requestid"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/semaphore"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/wharfapiext"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/problemtype"
 )
 
 const (
-	apiProviderName         = "azuredevops"
 	buildDefinitionFileName = ".wharf-ci.yml"
 )
 
@@ -59,175 +73,1087 @@ type Importer interface {
 	InitWritesProblem(tokenData TokenData, providerData ProviderData, c *gin.Context, client wharfapi.Client) bool
 	// ImportRepositoryWritesProblem imports a given Azure DevOps repository
 	// into Wharf.
-	ImportRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string) bool
+	ImportRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string) (ImportResult, bool)
+	// RefreshRepositoryWritesProblem refreshes a previously imported Azure
+	// DevOps repository in Wharf. The repository is found using the git URL
+	// of the Wharf project, falling back to the given names, and the Wharf
+	// project is kept in its current group. If the repository no longer
+	// exists in Azure DevOps then the Wharf project is reported as stale in
+	// the result instead of failing the refresh.
+	RefreshRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string, wharfProjectID uint) (ImportResult, bool)
 	// ImportProjectWritesProblem imports all Azure DevOps repositories from a
 	// given Azure DevOps project into Wharf.
-	ImportProjectWritesProblem(orgName, projectNameOrID string) bool
+	ImportProjectWritesProblem(orgName, projectNameOrID string) (ImportResult, bool)
 	// ImportOrganizationWritesProblem imports all Azure DevOps repositories
 	// from all projects found in an Azure DevOps organization into Wharf.
-	ImportOrganizationWritesProblem(orgName string) bool
+	ImportOrganizationWritesProblem(orgName string) (ImportResult, bool)
+}
+
+// CloneProtocol is an enum of protocols that Wharf can clone repositories
+// with, deciding which git URL is stored in the Wharf project.
+type CloneProtocol string
+
+const (
+	// CloneProtocolSSH stores the SSH git URL, such as
+	// "git@ssh.dev.azure.com:v3/org/project/repo".
+	CloneProtocolSSH CloneProtocol = "ssh"
+	// CloneProtocolHTTPS stores the HTTPS git URL, such as
+	// "https://dev.azure.com/org/project/_git/repo".
+	CloneProtocolHTTPS CloneProtocol = "https"
+)
+
+// VisibilityFilter is an enum of which Azure DevOps projects to import,
+// based on the projects' visibility.
+type VisibilityFilter string
+
+const (
+	// VisibilityFilterAll imports projects regardless of their visibility.
+	VisibilityFilterAll VisibilityFilter = "all"
+	// VisibilityFilterPrivate only imports private projects.
+	VisibilityFilterPrivate VisibilityFilter = "private"
+	// VisibilityFilterPublic only imports public projects.
+	VisibilityFilterPublic VisibilityFilter = "public"
+)
+
+// matches returns true if a project with the given visibility should be
+// imported. An empty visibility is treated as private, as that is the only
+// visibility supported by older versions of Azure DevOps Server.
+func (f VisibilityFilter) matches(visibility string) bool {
+	if visibility == "" {
+		visibility = string(VisibilityFilterPrivate)
+	}
+	switch f {
+	case "", VisibilityFilterAll:
+		return true
+	default:
+		return strings.EqualFold(string(f), visibility)
+	}
+}
+
+// Options holds settings for how the importer imports repositories.
+type Options struct {
+	// SSHPort is the port used in SSH git URLs constructed by the importer,
+	// for repositories where Azure DevOps does not provide an SSH URL.
+	SSHPort int
+	// CloneProtocol decides which git URL is stored in the Wharf project.
+	// Defaults to CloneProtocolSSH when empty.
+	CloneProtocol CloneProtocol
+	// Mode decides if the provider URL targets Azure DevOps Services or an
+	// Azure DevOps Server collection. Defaults to azureapi.ModeServices when
+	// empty.
+	Mode azureapi.Mode
+	// AuthMode decides how the token is sent to Azure DevOps. Defaults to
+	// azureapi.AuthModeBasic when empty.
+	AuthMode azureapi.AuthMode
+	// OmitBasicAuthUser sends an empty username instead of the token's
+	// username when authenticating towards Azure DevOps using basic auth.
+	OmitBasicAuthUser bool
+	// HTTPClient sends the requests to Azure DevOps. Defaults to
+	// http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// RemoteID decides which Azure DevOps IDs are stored as the remote
+	// project ID of created Wharf projects. Defaults to RemoteIDProject when
+	// empty.
+	RemoteID RemoteID
+	// GroupingStrategy decides the group and project names of imported
+	// Wharf projects. Defaults to GroupingOrgProject when empty.
+	GroupingStrategy GroupingStrategy
+	// GroupNameTemplate overrides the group name of imported Wharf projects
+	// given by the GroupingStrategy when set. Should be parsed using
+	// ParseNameTemplate.
+	GroupNameTemplate *template.Template
+	// TargetGroup is used verbatim as the group name of all imported Wharf
+	// projects when set, overriding both the GroupingStrategy and the
+	// GroupNameTemplate. Wharf projects found using the previous group
+	// names are moved into the target group. Should be validated using
+	// ValidateTargetGroup.
+	TargetGroup string
+	// ProjectNameTemplate overrides the name of imported Wharf projects
+	// given by the GroupingStrategy when set. Should be parsed using
+	// ParseNameTemplate.
+	ProjectNameTemplate *template.Template
+	// Webhooks enables registering Azure DevOps service hooks for each
+	// imported repository when set.
+	Webhooks *WebhookOptions
+	// BuildDefinitionPath is the repository-relative path of the build
+	// definition file, such as "ci/.wharf-ci.yml". Defaults to ".wharf-ci.yml"
+	// when empty. Should be validated using ValidateBuildDefinitionPath.
+	BuildDefinitionPath string
+	// BuildDefinitionPaths are the repository-relative paths of the build
+	// definition files to try in order, where the first file found is
+	// imported, such as for repositories using environment-specific build
+	// definitions. Overrides BuildDefinitionPath when non-empty. Each path
+	// should be validated using ValidateBuildDefinitionPath.
+	BuildDefinitionPaths []string
+	// BuildDefinitionBranch is the name of the branch to read the build
+	// definition file from, such as "feature/foo". Defaults to each
+	// repository's default branch when empty. Should be validated using
+	// ValidateBuildDefinitionBranch.
+	BuildDefinitionBranch string
+	// BranchRefFilter only imports the branches whose refs start with "refs/"
+	// followed by the filter, such as "heads/release/". All branches are
+	// imported when empty. Should be validated using ValidateBranchRefFilter.
+	//
+	// The branches of existing Wharf projects are replaced, not merged, so
+	// their branches not matching the filter are removed, which is warned
+	// about in the import result.
+	BranchRefFilter string
+	// SkipReposWithoutBuildDef skips importing repositories that lack a build
+	// definition file, instead of importing them with an empty build
+	// definition.
+	SkipReposWithoutBuildDef bool
+	// UseReadmeDescription fetches the README.md file in the root of each
+	// imported repository whose Azure DevOps project has no description,
+	// and uses its first paragraph as description instead.
+	UseReadmeDescription bool
+	// ImportTags fetches the Git tags of each imported repository and lists
+	// them in the import result, as the Wharf API has no concept of tags.
+	ImportTags bool
+	// ImportBranchProtection fetches the policies of the default branch of
+	// each imported repository and lists them in the import result, as the
+	// Wharf API has no concept of branch protection.
+	ImportBranchProtection bool
+	// ImportProcessTemplates fetches each imported Azure DevOps project
+	// including its capabilities, and lists the project's process template
+	// in the import result, as the Wharf API has no concept of process
+	// templates.
+	ImportProcessTemplates bool
+	// IncludeDisabledRepos imports repositories that are disabled in Azure
+	// DevOps, instead of skipping them, even though Wharf cannot clone them.
+	IncludeDisabledRepos bool
+	// IncludeHidden also imports the repositories that are hidden in Azure
+	// DevOps when importing an organization or project. The hidden
+	// repositories are listed in the import result's HiddenRepos field.
+	IncludeHidden bool
+	// IncludeAllProjectStates includes projects in all states when importing
+	// an organization, instead of skipping projects that are not in the
+	// azureapi.ProjectStateWellFormed state.
+	IncludeAllProjectStates bool
+	// VisibilityFilter decides which projects to import based on their
+	// visibility when importing an organization or project. Defaults to
+	// VisibilityFilterAll when empty.
+	VisibilityFilter VisibilityFilter
+	// ContinueOnError continues importing the remaining repositories when
+	// importing a single repository fails, collecting the failures in
+	// ImportResult.FailedRepos, instead of aborting the whole import.
+	ContinueOnError bool
+	// ProjectPattern only imports the projects whose names match the regular
+	// expression when importing an organization, when set.
+	ProjectPattern *regexp.Regexp
+	// MaxRepoSizeBytes skips the repositories whose size, as reported by
+	// Azure DevOps, is larger than this number of bytes, when set. The size
+	// excludes Git LFS objects.
+	MaxRepoSizeBytes int64
+	// ProjectMetadataChangedSince skips the projects and repositories whose
+	// Azure DevOps project metadata was last updated before this time, when
+	// set. Changes to the code of the repositories are not taken into account.
+	// Projects whose last update time is unknown are always imported.
+	ProjectMetadataChangedSince time.Time
+	// WharfConflictRetries is how many times to search for and update the
+	// Wharf project again when creating or updating it fails due to a
+	// conflict in the Wharf API, such as caused by concurrent imports.
+	WharfConflictRetries int
+	// WharfWriteSemaphore limits the number of concurrent writes to the
+	// Wharf API, such as creating projects and replacing their branches,
+	// when set. Should be shared by all imports.
+	WharfWriteSemaphore *semaphore.Semaphore
+	// WharfAPIDiagnoser diagnoses the errors from the Wharf API before they
+	// are written as problem responses, such as to report a misconfigured
+	// Wharf API URL. Never diagnoses errors when zero.
+	WharfAPIDiagnoser wharfapiext.Diagnoser
+	// OnRepoImported is called after each imported or skipped repository
+	// when set, such as to report the progress of long imports.
+	OnRepoImported func(RepoProgress)
+	// Metrics is updated with the imported projects and branches when set.
+	Metrics *metrics.ImportMetrics
+}
+
+// ValidateBranchRefFilter returns an error if the branch ref filter does not
+// start with "heads/", as only branches can be imported. A leading "refs/" is
+// removed on success, as Azure DevOps expects the filter without it.
+func ValidateBranchRefFilter(filter string) (string, error) {
+	filter = strings.TrimPrefix(filter, "refs/")
+	if !strings.HasPrefix(filter, "heads/") {
+		return "", fmt.Errorf("branch ref filter must start with \"heads/\": %q", filter)
+	}
+	return filter, nil
+}
+
+// ValidateTargetGroup returns an error if the Wharf group name is empty, or
+// starts or ends with a slash, or contains empty path segments, such as
+// "Org//Proj". Surrounding whitespace is removed on success.
+func ValidateTargetGroup(group string) (string, error) {
+	group = strings.TrimSpace(group)
+	if group == "" {
+		return "", errors.New("empty group name")
+	}
+	for _, segment := range strings.Split(group, "/") {
+		if strings.TrimSpace(segment) == "" {
+			return "", fmt.Errorf("group name must not contain empty segments: %q", group)
+		}
+	}
+	return group, nil
+}
+
+// ValidateBuildDefinitionBranch returns an error if the branch name is empty or
+// refers to a ref that is not a branch, such as "refs/tags/v1.0.0". A leading
+// "refs/heads/" is removed on success, as Azure DevOps expects the branch name
+// without it.
+func ValidateBuildDefinitionBranch(branch string) (string, error) {
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+	if strings.HasPrefix(branch, "refs/") {
+		return "", fmt.Errorf("ref must be a branch: %q", branch)
+	}
+	if strings.TrimSpace(branch) == "" {
+		return "", errors.New("empty branch name")
+	}
+	return branch, nil
+}
+
+// ValidateBuildDefinitionPath returns an error if the build definition path
+// is not relative to the repository root, such as absolute paths and paths
+// containing "..". On success the path is returned cleaned, such as with any
+// leading "./" removed.
+func ValidateBuildDefinitionPath(p string) (string, error) {
+	if p == "" {
+		return "", errors.New("empty path")
+	}
+	if strings.HasPrefix(p, "/") || strings.HasPrefix(p, `\`) {
+		return "", fmt.Errorf("path must be relative to the repository root: %q", p)
+	}
+	for _, segment := range strings.FieldsFunc(p, isPathSeparator) {
+		if segment == ".." {
+			return "", fmt.Errorf("path must not contain \"..\": %q", p)
+		}
+	}
+	cleaned := path.Clean(p)
+	if cleaned == "." {
+		return "", fmt.Errorf("path must point to a file: %q", p)
+	}
+	return cleaned, nil
+}
+
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// WebhookOptions holds settings for registering Azure DevOps service hooks
+// that notify this provider when pull requests are created.
+type WebhookOptions struct {
+	// TriggerURL returns the URL of the pull request trigger endpoint for a
+	// given Wharf project ID.
+	TriggerURL func(wharfProjectID uint) string
+	// BasicAuthUser and BasicAuthPassword are set as HTTP basic
+	// authentication credentials in the service hook when non-empty.
+	BasicAuthUser     string
+	BasicAuthPassword string
 }
 
 type azureImporter struct {
 	c     *gin.Context
-	wharf *wharfapi.Client
-	azure *azureapi.Client
+	wharf WharfClient
+	azure azureapi.RepositoryFetcher
+	hooks azureapi.ServiceHookSubscriber
+	opts  Options
+	// parsed from resProvider.URL
+	providerURL *url.URL
 	// retrieved from database
 	resToken response.Token
 	// retrieved from database
 	resProvider response.Provider
+	// Wharf projects found or written during this import
+	projects projectCache
 }
 
 // NewAzureImporter creates a new azureImporter.
-func NewAzureImporter(c *gin.Context, client *wharfapi.Client) Importer {
-	return &azureImporter{
-		c:     c,
-		wharf: client,
+func NewAzureImporter(c *gin.Context, client WharfClient, opts Options) Importer {
+	i := &azureImporter{
+		c:    c,
+		opts: opts,
 	}
+	i.wharf = i.limitWharfWrites(client)
+	return i
 }
 
 func (i *azureImporter) InitWritesProblem(tokenData TokenData, providerData ProviderData, c *gin.Context, client wharfapi.Client) bool {
-	var ok bool
-	i.resToken, ok = i.getOrPostTokenWritesProblem(tokenData)
+	if providerData.ID == 0 {
+		normalizedURL, err := NormalizeProviderURL(providerData.URL)
+		if err != nil {
+			ginutil.WriteInvalidParamError(i.c, redact.Error(err), "url",
+				fmt.Sprintf("Invalid provider URL %q. Expected an absolute HTTP or HTTPS URL, such as %q.",
+					redact.URLString(providerData.URL), "https://dev.azure.com"))
+			return false
+		}
+		providerData.URL = normalizedURL.String()
+	}
+
+	existingProvider, providerFound, ok := i.findProviderWritesProblem(providerData)
 	if !ok {
-		log.Error().Message("Failed to get or create token.")
 		return false
 	}
-	log.Debug().
+	i.resToken, ok = i.getOrPostTokenWritesProblem(tokenData, existingProvider.TokenID)
+	if !ok {
+		i.log().Error().Message("Failed to get or create token.")
+		return false
+	}
+	i.log().Debug().
 		WithUint("ID", i.resToken.TokenID).
 		Message("Token from DB.")
 
-	var providerWithTokenID = providerData
-	providerWithTokenID.TokenID = i.resToken.TokenID
-	i.resProvider, ok = i.getOrPostProviderWritesProblem(providerWithTokenID)
-	if !ok {
-		return false
+	if providerFound {
+		i.resProvider = existingProvider
+	} else {
+		var providerWithTokenID = providerData
+		providerWithTokenID.TokenID = i.resToken.TokenID
+		i.resProvider, ok = i.postProviderWritesProblem(providerWithTokenID)
+		if !ok {
+			return false
+		}
 	}
-	log.Debug().
+	i.log().Debug().
 		WithUint("ID", i.resProvider.ProviderID).
 		WithString("name", string(i.resProvider.Name)).
-		WithString("url", i.resProvider.URL).
+		WithString("url", redact.URLString(i.resProvider.URL)).
 		Message("Provider from DB.")
 
-	i.wharf = &client
+	i.wharf = i.limitWharfWrites(&client)
 
-	urlParsed, err := url.Parse(i.resProvider.URL)
+	urlParsed, err := NormalizeProviderURL(i.resProvider.URL)
 	if err != nil {
-		ginutil.WriteInvalidParamError(i.c, err, "provider.url",
-			fmt.Sprintf("Unable parse the provider URL %q.", i.resProvider.URL))
+		ginutil.WriteInvalidParamError(i.c, redact.Error(err), "provider.url",
+			fmt.Sprintf("Invalid provider URL %q. Expected an absolute HTTP or HTTPS URL, such as %q.",
+				redact.URLString(i.resProvider.URL), "https://dev.azure.com"))
 		return false
 	}
+	i.providerURL = urlParsed
 
-	i.azure = &azureapi.Client{
+	azureClient := &azureapi.Client{
 		Context:       c,
-		BaseURL:       i.resProvider.URL,
+		BaseURL:       urlParsed.String(),
 		BaseURLParsed: urlParsed,
 		UserName:      i.resToken.UserName,
 		Token:         i.resToken.Token,
+		Mode:          i.opts.Mode,
+		AuthMode:      i.opts.AuthMode,
+
+		OmitBasicAuthUser: i.opts.OmitBasicAuthUser,
+		HTTPClient:        i.opts.HTTPClient,
 	}
+	i.azure = azureClient
+	i.hooks = azureClient
 
 	return true
 }
 
-func (i *azureImporter) ImportRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string) bool {
+// NormalizeProviderURL parses the provider URL and validates that it is an
+// absolute HTTP or HTTPS URL. The returned URL has a lowercase scheme and
+// host, and any trailing slashes removed from its path.
+func NormalizeProviderURL(rawURL string) (*url.URL, error) {
+	if strings.TrimSpace(rawURL) == "" {
+		return nil, errors.New("empty URL")
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("URL must use scheme http or https: %q", rawURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("URL is missing host: %q", rawURL)
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+	return u, nil
+}
+
+// ProviderURLsEqual returns true if both provider URLs are equal after being
+// normalized using NormalizeProviderURL, such as "https://dev.azure.com/org/"
+// and "https://DEV.azure.com/org". URLs that fail to normalize are only equal
+// to identical URLs.
+func ProviderURLsEqual(a, b string) bool {
+	if a == b {
+		return true
+	}
+	normalizedA, err := NormalizeProviderURL(a)
+	if err != nil {
+		return false
+	}
+	normalizedB, err := NormalizeProviderURL(b)
+	if err != nil {
+		return false
+	}
+	return normalizedA.String() == normalizedB.String()
+}
+
+func (i *azureImporter) ImportRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string) (ImportResult, bool) {
 	repo, ok := i.azure.GetRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID)
 	if !ok {
-		return false
+		return ImportResult{}, false
 	}
 
-	return i.importKnownRepositoryWritesProblem(orgName, repo)
+	return i.importRepositoryAndProjectWritesProblem(orgName, repo)
 }
 
-func (i *azureImporter) ImportProjectWritesProblem(orgName, projectNameOrID string) bool {
-	repos, ok := i.azure.GetRepositoriesWritesProblem(orgName, projectNameOrID)
+func (i *azureImporter) RefreshRepositoryWritesProblem(orgName, projectNameOrID, repoNameOrID string, wharfProjectID uint) (ImportResult, bool) {
+	ref, ok := i.resolveRefreshedRepoWritesProblem(repoRef{
+		org:     orgName,
+		project: projectNameOrID,
+		repo:    repoNameOrID,
+	}, wharfProjectID)
 	if !ok {
-		return false
+		return ImportResult{}, false
+	}
+	orgName, projectNameOrID, repoNameOrID = ref.org, ref.project, ref.repo
+
+	repo, found, ok := i.azure.GetRepositoryIfExistsWritesProblem(orgName, projectNameOrID, repoNameOrID)
+	if !ok {
+		return ImportResult{}, false
+	}
+	if !found {
+		i.log().Warn().
+			WithString("org", orgName).
+			WithString("project", projectNameOrID).
+			WithString("repo", repoNameOrID).
+			WithUint("projectId", wharfProjectID).
+			Message("Repository no longer exists in Azure DevOps. Reporting Wharf project as stale.")
+		return ImportResult{
+			StaleProjects: []StaleProject{{
+				ProjectID: wharfProjectID,
+				Org:       orgName,
+				Project:   projectNameOrID,
+				Repo:      repoNameOrID,
+			}},
+		}, true
+	}
+
+	return i.importRepositoryAndProjectWritesProblem(orgName, repo)
+}
+
+// importRepositoryAndProjectWritesProblem imports the repository, including
+// its Azure DevOps project in the result when process templates are
+// imported. The project is fetched first, so that failing to fetch it does
+// not fail the request after the repository was written to Wharf.
+func (i *azureImporter) importRepositoryAndProjectWritesProblem(orgName string, repo azureapi.Repository) (ImportResult, bool) {
+	var result ImportResult
+	if i.opts.ImportProcessTemplates && !i.addProjectWritesProblem(&result, orgName, repo.Project.ID) {
+		return ImportResult{}, false
+	}
+	repoResult, ok := i.importKnownRepositoryWritesProblem(orgName, repo)
+	if !ok {
+		return ImportResult{}, false
+	}
+	result.add(repoResult)
+	return result, true
+}
+
+func (i *azureImporter) ImportProjectWritesProblem(orgName, projectNameOrID string) (ImportResult, bool) {
+	repos, ok := i.getProjectRepositoriesWritesProblem(orgName, projectNameOrID)
+	if !ok {
+		return ImportResult{}, false
+	}
+	SortRepositoriesByName(repos)
+	var result ImportResult
+	// All repositories belong to the same project, so checking the first
+	// one is enough.
+	if len(repos) > 0 && !i.opts.VisibilityFilter.matches(repos[0].Project.Visibility) {
+		i.skipProjectByVisibility(&result, orgName, repos[0].Project)
+		return result, true
+	}
+	if i.opts.ImportProcessTemplates && !i.addProjectWritesProblem(&result, orgName, projectNameOrID) {
+		return ImportResult{}, false
 	}
 	for _, repo := range repos {
-		ok := i.importKnownRepositoryWritesProblem(orgName, repo)
+		var repoResult ImportResult
+		ok := i.continueOnErrorWritesProblem(&result, orgName, repo.Project.Name, repo.Name, func() bool {
+			var ok bool
+			repoResult, ok = i.importKnownRepositoryWritesProblem(orgName, repo)
+			return ok
+		})
 		if !ok {
-			return false
+			return ImportResult{}, false
 		}
+		result.add(repoResult)
 	}
-	return true
+	result.sort()
+	return result, true
+}
+
+// getProjectRepositoriesWritesProblem lists the repositories of a project to
+// import, including hidden repositories when requested.
+func (i *azureImporter) getProjectRepositoriesWritesProblem(orgName, projectNameOrID string) ([]azureapi.Repository, bool) {
+	if i.opts.IncludeHidden {
+		return i.azure.GetRepositoriesIncludingHiddenWritesProblem(orgName, projectNameOrID)
+	}
+	return i.azure.GetRepositoriesWritesProblem(orgName, projectNameOrID)
 }
 
-func (i *azureImporter) ImportOrganizationWritesProblem(groupName string) bool {
+func (i *azureImporter) ImportOrganizationWritesProblem(groupName string) (ImportResult, bool) {
 	projects, ok := i.azure.GetProjectsWritesProblem(groupName)
 	if !ok {
-		return false
+		return ImportResult{}, false
 	}
+	SortProjectsByName(projects)
 
+	var result ImportResult
 	for _, project := range projects {
-		ok := i.ImportProjectWritesProblem(groupName, project.Name)
+		if !i.opts.IncludeAllProjectStates && project.State != azureapi.ProjectStateWellFormed {
+			i.log().Debug().
+				WithString("org", groupName).
+				WithString("project", project.Name).
+				WithString("state", project.State).
+				Message("Skipping project that is not well-formed.")
+			result.addSkippedProject(groupName, project.Name,
+				fmt.Sprintf("Project state is %q, expected %q.", project.State, azureapi.ProjectStateWellFormed))
+			continue
+		}
+		if !i.opts.VisibilityFilter.matches(project.Visibility) {
+			i.skipProjectByVisibility(&result, groupName, project)
+			continue
+		}
+		if i.projectMetadataUnchangedSince(project) {
+			i.log().Debug().
+				WithString("org", groupName).
+				WithString("project", project.Name).
+				WithTime("lastUpdateTime", project.LastUpdated()).
+				WithTime("projectMetadataChangedSince", i.opts.ProjectMetadataChangedSince).
+				Message("Skipping project whose metadata has not changed since the given time.")
+			result.addSkippedProject(groupName, project.Name,
+				fmt.Sprintf("Project metadata last updated at %s, before %s.",
+					project.LastUpdated().Format(time.RFC3339), i.opts.ProjectMetadataChangedSince.Format(time.RFC3339)))
+			continue
+		}
+		if i.opts.ProjectPattern != nil && !i.opts.ProjectPattern.MatchString(project.Name) {
+			i.log().Debug().
+				WithString("org", groupName).
+				WithString("project", project.Name).
+				WithString("projectPattern", i.opts.ProjectPattern.String()).
+				Message("Skipping project not matching project pattern.")
+			result.addSkippedProject(groupName, project.Name,
+				fmt.Sprintf("Project name does not match the project pattern %q.", i.opts.ProjectPattern))
+			continue
+		}
+		i.log().Debug().
+			WithString("org", groupName).
+			WithString("project", project.Name).
+			WithString("projectId", project.ID).
+			Message("Importing all repos from project in org.")
+		// Using the project ID instead of its name, as the name may have
+		// changed since it was listed, or contain characters such as slashes.
+		var projectResult ImportResult
+		ok := i.continueOnErrorWritesProblem(&result, groupName, project.Name, "", func() bool {
+			var ok bool
+			projectResult, ok = i.ImportProjectWritesProblem(groupName, project.ID)
+			return ok
+		})
 		if !ok {
-			return false
+			return ImportResult{}, false
 		}
+		result.add(projectResult)
 	}
-	return true
+	result.sort()
+	return result, true
 }
 
-func (i *azureImporter) importKnownRepositoryWritesProblem(orgName string, repo azureapi.Repository) bool {
-	buildDef, ok := i.azure.GetFileWritesProblem(orgName, repo.Project.Name, repo.Name, buildDefinitionFileName)
+// addProjectWritesProblem gets the Azure DevOps project including its
+// capabilities, and lists it in the import result along with its process
+// template.
+func (i *azureImporter) addProjectWritesProblem(result *ImportResult, orgName, projectNameOrID string) bool {
+	project, ok := i.azure.GetProjectWritesProblem(orgName, projectNameOrID)
 	if !ok {
 		return false
 	}
+	result.addProject(orgName, project)
+	return true
+}
 
-	branches, ok := i.azure.GetRepositoryBranchesWritesProblem(orgName, repo.Project.Name, repo.Name)
-	if !ok {
+// projectMetadataUnchangedSince returns true if the Azure DevOps project
+// metadata is known to have been last updated before
+// Options.ProjectMetadataChangedSince.
+func (i *azureImporter) projectMetadataUnchangedSince(project azureapi.Project) bool {
+	if i.opts.ProjectMetadataChangedSince.IsZero() {
 		return false
 	}
+	lastUpdated := project.LastUpdated()
+	return !lastUpdated.IsZero() && lastUpdated.Before(i.opts.ProjectMetadataChangedSince)
+}
+
+func (i *azureImporter) skipProjectByVisibility(result *ImportResult, orgName string, project azureapi.Project) {
+	i.log().Debug().
+		WithString("org", orgName).
+		WithString("project", project.Name).
+		WithString("visibility", project.Visibility).
+		WithString("visibilityFilter", string(i.opts.VisibilityFilter)).
+		Message("Skipping project due to its visibility.")
+	result.addSkippedProject(orgName, project.Name,
+		fmt.Sprintf("Project visibility is %q, while only importing %q projects.",
+			project.Visibility, i.opts.VisibilityFilter))
+}
+
+func (i *azureImporter) buildDefinitionPath() string {
+	if i.opts.BuildDefinitionPath == "" {
+		return buildDefinitionFileName
+	}
+	return i.opts.BuildDefinitionPath
+}
+
+// buildDefinitionPaths returns the paths of the build definition files to try
+// in order, from Options.BuildDefinitionPaths, or the single path from
+// Options.BuildDefinitionPath.
+func (i *azureImporter) buildDefinitionPaths() []string {
+	if len(i.opts.BuildDefinitionPaths) > 0 {
+		return i.opts.BuildDefinitionPaths
+	}
+	return []string{i.buildDefinitionPath()}
+}
+
+// getBuildDefinitionWritesProblem returns the contents and path of the first
+// build definition file found in the repository, or an empty string for both
+// if none of the files were found. Returns false for branchFound if
+// Options.BuildDefinitionBranch does not exist in the repository.
+func (i *azureImporter) getBuildDefinitionWritesProblem(orgName string, repo azureapi.Repository, paths []string) (buildDef, buildDefPath string, branchFound, ok bool) {
+	for _, p := range paths {
+		// Using the repository ID instead of its name, as the name may have
+		// changed, or contain characters that are troublesome in URLs.
+		buildDef, branchFound, ok := i.azure.GetBranchFileWritesProblem(orgName, repo.Project.Name, repo.ID, p, i.opts.BuildDefinitionBranch)
+		if !ok {
+			return "", "", false, false
+		}
+		if !branchFound {
+			return "", "", false, true
+		}
+		if buildDef != "" {
+			return buildDef, p, true, true
+		}
+	}
+	return "", "", true, true
+}
+
+// describeBuildDefinitionPaths returns the paths for use in warnings, such as
+// `file ".wharf-ci.yml"` or `files "a.yml" or "b.yml"`.
+func describeBuildDefinitionPaths(paths []string) string {
+	if len(paths) == 1 {
+		return fmt.Sprintf("file %q", paths[0])
+	}
+	quoted := make([]string, len(paths))
+	for idx, p := range paths {
+		quoted[idx] = fmt.Sprintf("%q", p)
+	}
+	return "files " + strings.Join(quoted, " or ")
+}
 
-	wharfProject, ok := i.importRepositoryWritesProblem(orgName, repo, buildDef)
+func (i *azureImporter) importKnownRepositoryWritesProblem(orgName string, repo azureapi.Repository) (ImportResult, bool) {
+	var result ImportResult
+	if repo.IsDisabled && !i.opts.IncludeDisabledRepos {
+		i.log().Debug().
+			WithString("org", orgName).
+			WithString("project", repo.Project.Name).
+			WithString("repo", repo.Name).
+			Message("Skipping disabled repository.")
+		result.ReposSkipped++
+		i.opts.Metrics.RepoSkipped()
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			"Skipped as the repository is disabled in Azure DevOps.")
+		i.reportProgress(orgName, repo, RepoStatusSkipped, 0)
+		return result, true
+	}
+	if i.projectMetadataUnchangedSince(repo.Project) {
+		i.log().Debug().
+			WithString("org", orgName).
+			WithString("project", repo.Project.Name).
+			WithString("repo", repo.Name).
+			WithTime("lastUpdateTime", repo.Project.LastUpdated()).
+			WithTime("projectMetadataChangedSince", i.opts.ProjectMetadataChangedSince).
+			Message("Skipping repository whose project metadata has not changed since the given time.")
+		result.ReposSkipped++
+		i.opts.Metrics.RepoSkipped()
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			fmt.Sprintf("Skipped as the project metadata was last updated at %s, before %s.",
+				repo.Project.LastUpdated().Format(time.RFC3339), i.opts.ProjectMetadataChangedSince.Format(time.RFC3339)))
+		i.reportProgress(orgName, repo, RepoStatusSkipped, 0)
+		return result, true
+	}
+	if i.opts.MaxRepoSizeBytes > 0 && repo.Size > i.opts.MaxRepoSizeBytes {
+		i.log().Debug().
+			WithString("org", orgName).
+			WithString("project", repo.Project.Name).
+			WithString("repo", repo.Name).
+			WithInt64("size", repo.Size).
+			WithInt64("maxRepoSizeBytes", i.opts.MaxRepoSizeBytes).
+			Message("Skipping repository larger than the max size.")
+		result.ReposSkipped++
+		i.opts.Metrics.RepoSkipped()
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			fmt.Sprintf("Skipped as the repository size of %d bytes is larger than the max size of %d bytes.",
+				repo.Size, i.opts.MaxRepoSizeBytes))
+		i.reportProgress(orgName, repo, RepoStatusSkipped, 0)
+		return result, true
+	}
+	buildDefPaths := i.buildDefinitionPaths()
+	buildDef, buildDefPath, branchFound, ok := i.getBuildDefinitionWritesProblem(orgName, repo, buildDefPaths)
 	if !ok {
-		return false
+		return ImportResult{}, false
+	}
+	if !branchFound {
+		i.log().Debug().
+			WithString("org", orgName).
+			WithString("project", repo.Project.Name).
+			WithString("repo", repo.Name).
+			WithString("branch", i.opts.BuildDefinitionBranch).
+			Message("Skipping repository without the build definition branch.")
+		result.ReposSkipped++
+		i.opts.Metrics.RepoSkipped()
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			fmt.Sprintf("Skipped as the build definition branch %q was not found.", i.opts.BuildDefinitionBranch))
+		i.reportProgress(orgName, repo, RepoStatusSkipped, 0)
+		return result, true
+	}
+	if buildDef == "" && i.opts.SkipReposWithoutBuildDef {
+		i.log().Debug().
+			WithString("org", orgName).
+			WithString("project", repo.Project.Name).
+			WithString("repo", repo.Name).
+			WithString("files", strings.Join(buildDefPaths, ",")).
+			Message("Skipping repository without build definition.")
+		result.ReposSkipped++
+		i.opts.Metrics.RepoSkipped()
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			fmt.Sprintf("Skipped as no build definition %s found.", describeBuildDefinitionPaths(buildDefPaths)))
+		i.reportProgress(orgName, repo, RepoStatusSkipped, 0)
+		return result, true
+	}
+	if buildDef == "" && i.opts.BuildDefinitionBranch != "" {
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			fmt.Sprintf("No build definition %s found on branch %q.", describeBuildDefinitionPaths(buildDefPaths), i.opts.BuildDefinitionBranch))
+	} else if buildDef == "" {
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			fmt.Sprintf("No build definition %s found.", describeBuildDefinitionPaths(buildDefPaths)))
 	}
 
-	ok = i.importBranchesWritesProblem(repo.DefaultBranchRef, branches, wharfProject.ProjectID)
+	if i.opts.UseReadmeDescription && repo.Project.Description == "" {
+		readme, ok := i.azure.GetFileWritesProblem(orgName, repo.Project.Name, repo.ID, readmeFileName, "")
+		if !ok {
+			return ImportResult{}, false
+		}
+		// Only changes the local copy of the repository, which is used when
+		// creating the Wharf project's description.
+		repo.Project.Description = readmeSummary(readme)
+	}
 
-	return ok
+	branches, ok := i.azure.GetRepositoryBranchesWritesProblem(orgName, repo.Project.Name, repo.ID, i.opts.BranchRefFilter)
+	if !ok {
+		return ImportResult{}, false
+	}
+	branches, collisions := dedupBranchesByName(branches)
+	for _, collision := range collisions {
+		i.log().Warn().
+			WithString("org", orgName).
+			WithString("project", repo.Project.Name).
+			WithString("repo", repo.Name).
+			WithString("branch", collision.kept.Name).
+			WithString("keptRef", collision.kept.Ref).
+			WithString("skippedRef", collision.skipped.Ref).
+			Message("Skipping branch whose name collides with another branch.")
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			fmt.Sprintf("Skipped branch ref %q, as its branch name %q collides with ref %q.",
+				collision.skipped.Ref, collision.kept.Name, collision.kept.Ref))
+	}
+	if i.opts.BranchRefFilter != "" && repo.DefaultBranchRef != "" &&
+		!strings.HasPrefix(normalizeBranchRef(repo.DefaultBranchRef), "refs/"+i.opts.BranchRefFilter) {
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			fmt.Sprintf("No default branch set, as the default branch %q does not match the branch ref filter %q.",
+				strings.TrimPrefix(normalizeBranchRef(repo.DefaultBranchRef), "refs/heads/"), i.opts.BranchRefFilter))
+	}
+
+	var tags []azureapi.Tag
+	if i.opts.ImportTags {
+		tags, ok = i.azure.GetRepositoryTagsWritesProblem(orgName, repo.Project.Name, repo.ID)
+		if !ok {
+			return ImportResult{}, false
+		}
+	}
+
+	defaultBranchRef := normalizeBranchRef(repo.DefaultBranchRef)
+	var policies []azureapi.PolicyConfiguration
+	if i.opts.ImportBranchProtection && defaultBranchRef != "" {
+		policies, ok = i.azure.GetBranchPoliciesWritesProblem(orgName, repo.Project.Name, repo.ID, defaultBranchRef)
+		if !ok {
+			return ImportResult{}, false
+		}
+	}
+
+	if !i.checkNotAbortedWritesProblem() {
+		return ImportResult{}, false
+	}
+
+	wharfProject, created, ok := i.importRepositoryWritesProblem(orgName, repo, buildDef)
+	if !ok {
+		return ImportResult{}, false
+	}
+	if created {
+		result.ProjectsCreated++
+	} else {
+		result.ProjectsUpdated++
+	}
+
+	if !i.importBranchesWritesProblem(repo.DefaultBranchRef, branches, wharfProject.ProjectID) {
+		return ImportResult{}, false
+	}
+	if i.opts.BranchRefFilter != "" && !created {
+		result.addWarning(orgName, repo.Project.Name, repo.Name,
+			fmt.Sprintf("Replaced all branches of the existing Wharf project, so any branches not matching the branch ref filter %q were removed from it.",
+				i.opts.BranchRefFilter))
+	}
+	result.BranchesCreated += len(branches)
+	i.opts.Metrics.ProjectImported(created, len(branches))
+	result.addRepoSize(orgName, repo, wharfProject.ProjectID)
+	if repo.IsHidden {
+		result.addHiddenRepo(orgName, repo, wharfProject.ProjectID)
+	}
+	if buildDef != "" {
+		result.addBuildDefinition(orgName, repo, wharfProject.ProjectID, buildDefPath)
+	}
+	result.addTags(orgName, repo, wharfProject.ProjectID, tags)
+	if i.opts.ImportBranchProtection && defaultBranchRef != "" {
+		result.addBranchProtection(orgName, repo, wharfProject.ProjectID, defaultBranchRef, policies)
+	}
+
+	if i.opts.Webhooks != nil {
+		registered, ok := i.registerWebhookWritesProblem(orgName, repo, wharfProject.ProjectID)
+		if !ok {
+			return ImportResult{}, false
+		}
+		if registered {
+			result.WebhooksRegistered++
+		}
+	}
+
+	status := RepoStatusUpdated
+	if created {
+		status = RepoStatusCreated
+	}
+	i.reportProgress(orgName, repo, status, wharfProject.ProjectID)
+	return result, true
+}
+
+func (i *azureImporter) reportProgress(orgName string, repo azureapi.Repository, status RepoStatus, wharfProjectID uint) {
+	if i.opts.OnRepoImported == nil {
+		return
+	}
+	i.opts.OnRepoImported(RepoProgress{
+		Org:            orgName,
+		Project:        repo.Project.Name,
+		Repo:           repo.Name,
+		Status:         status,
+		WharfProjectID: wharfProjectID,
+	})
+}
+
+// registerWebhookWritesProblem creates an Azure DevOps service hook
+// subscription that notifies the pull request trigger endpoint when pull
+// requests are created in the repository. Returns false for registered if a
+// matching subscription already existed.
+func (i *azureImporter) registerWebhookWritesProblem(orgName string, repo azureapi.Repository, wharfProjectID uint) (registered bool, ok bool) {
+	const eventType = "git.pullrequest.created"
+	triggerURL := i.opts.Webhooks.TriggerURL(wharfProjectID)
+
+	subscriptions, ok := i.hooks.GetServiceHookSubscriptionsWritesProblem(orgName, eventType)
+	if !ok {
+		return false, false
+	}
+	for _, sub := range subscriptions {
+		if sub.ConsumerInputs["url"] == triggerURL &&
+			sub.PublisherInputs["repository"] == repo.ID {
+			i.log().Debug().
+				WithString("org", orgName).
+				WithString("project", repo.Project.Name).
+				WithString("repo", repo.Name).
+				WithString("subscriptionId", sub.ID).
+				Message("Webhook already registered.")
+			return false, true
+		}
+	}
+
+	consumerInputs := map[string]string{
+		"url": triggerURL,
+	}
+	if i.opts.Webhooks.BasicAuthUser != "" || i.opts.Webhooks.BasicAuthPassword != "" {
+		consumerInputs["basicAuthUsername"] = i.opts.Webhooks.BasicAuthUser
+		consumerInputs["basicAuthPassword"] = i.opts.Webhooks.BasicAuthPassword
+	}
+	created, ok := i.hooks.CreateServiceHookSubscriptionWritesProblem(orgName, azureapi.ServiceHookSubscription{
+		PublisherID:      "tfs",
+		EventType:        eventType,
+		ResourceVersion:  "1.0",
+		ConsumerID:       "webHooks",
+		ConsumerActionID: "httpRequest",
+		PublisherInputs: map[string]string{
+			"projectId":  repo.Project.ID,
+			"repository": repo.ID,
+		},
+		ConsumerInputs: consumerInputs,
+	})
+	if !ok {
+		return false, false
+	}
+	i.log().Info().
+		WithString("org", orgName).
+		WithString("project", repo.Project.Name).
+		WithString("repo", repo.Name).
+		WithString("subscriptionId", created.ID).
+		Message("Registered webhook.")
+	return true, true
 }
 
-func (i *azureImporter) importRepositoryWritesProblem(orgName string, repo azureapi.Repository, buildDef string) (response.Project, bool) {
-	projectInDB, err := i.createOrUpdateWharfProject(orgName, repo, buildDef)
+func (i *azureImporter) importRepositoryWritesProblem(orgName string, repo azureapi.Repository, buildDef string) (response.Project, bool, bool) {
+	projectInDB, created, err := i.createOrUpdateWharfProject(orgName, repo, buildDef)
 
 	if err != nil {
-		log.Error().
+		i.log().Error().
 			WithError(err).
 			WithString("org", orgName).
 			WithString("project", repo.Project.Name).
 			WithString("repo", repo.Name).
 			Message("Unable to create project.")
-		ginutil.WriteAPIClientWriteError(i.c, err,
+		i.opts.WharfAPIDiagnoser.WriteWriteError(i.c, err,
 			fmt.Sprintf("Unable to import repository %q from project %q in organization %q.",
 				repo.Name, repo.Project.Name, orgName))
-		return response.Project{}, false
+		return response.Project{}, false, false
 	}
 
-	return projectInDB, true
+	return projectInDB, created, true
 }
 
+// importBranchesWritesProblem replaces all branches of the Wharf project with
+// the branches from Azure DevOps, so that branches deleted in Azure DevOps are
+// also removed from Wharf.
 func (i *azureImporter) importBranchesWritesProblem(defaultBranchRef string, branches []azureapi.Branch, wharfProjectID uint) bool {
+	if !i.checkNotAbortedWritesProblem() {
+		return false
+	}
+
+	defaultBranchRef = normalizeBranchRef(defaultBranchRef)
+	wharfBranches := make([]request.Branch, 0, len(branches))
 	for _, branch := range branches {
-		wharfBranch := request.Branch{
-			Name:    branch.Name,
-			Default: branch.Ref == defaultBranchRef,
+		ref := branch.Ref
+		if ref == "" {
+			ref = branch.Name
 		}
+		wharfBranches = append(wharfBranches, request.Branch{
+			Name:    branch.Name,
+			Default: defaultBranchRef != "" && normalizeBranchRef(ref) == defaultBranchRef,
+		})
+	}
 
-		if _, err := i.wharf.CreateProjectBranch(wharfProjectID, wharfBranch); err != nil {
-			log.Error().
-				WithError(err).
-				WithInt("branchesCount", len(branches)).
-				WithUint("projectId", wharfProjectID).
-				Message("Unable to replace branches for Wharf project.")
-			ginutil.WriteAPIClientWriteError(i.c, err, fmt.Sprintf("Unable to replace branches for Wharf project with ID %d.", wharfProjectID))
-			return false
-		}
+	if _, err := i.wharf.UpdateProjectBranchList(wharfProjectID, wharfBranches); err != nil {
+		i.log().Error().
+			WithError(err).
+			WithInt("branchesCount", len(branches)).
+			WithUint("projectId", wharfProjectID).
+			Message("Unable to replace branches for Wharf project.")
+		i.opts.WharfAPIDiagnoser.WriteWriteError(i.c, err, fmt.Sprintf("Unable to replace branches for Wharf project with ID %d.", wharfProjectID))
+		return false
 	}
 
 	return true
 }
 
+// normalizeBranchRef adds the "refs/heads/" prefix to short branch names,
+// such as "main", as Azure DevOps may return the default branch of a
+// repository either way, to be able to compare it with the branch refs.
+func normalizeBranchRef(ref string) string {
+	const branchRefPrefix = "refs/heads/"
+	if ref == "" || strings.HasPrefix(ref, "refs/") {
+		return ref
+	}
+	return branchRefPrefix + ref
+}
+
+// branchCollision is a branch that was skipped as its name collides with
+// another branch that was kept.
+type branchCollision struct {
+	kept    azureapi.Branch
+	skipped azureapi.Branch
+}
+
+// dedupBranchesByName removes the branches whose names collide with another
+// branch, as the Wharf API requires branch names to be unique per project.
+// Of the colliding branches, the one whose ref is "refs/heads/" followed by
+// its name is kept, or else the first one. The order of the kept branches is
+// preserved.
+//
+// The azureapi.Client names branches by trimming "refs/heads/" from their
+// refs, and only lists refs under "refs/heads/" as the branch ref filter must
+// start with "heads/", so it never lists colliding names. This is a safeguard
+// for other azureapi.RepositoryFetcher implementations, where a collision
+// would otherwise fail the whole import.
+func dedupBranchesByName(branches []azureapi.Branch) ([]azureapi.Branch, []branchCollision) {
+	deduped := make([]azureapi.Branch, 0, len(branches))
+	indexByName := make(map[string]int, len(branches))
+	var collisions []branchCollision
+	for _, branch := range branches {
+		idx, found := indexByName[branch.Name]
+		if !found {
+			indexByName[branch.Name] = len(deduped)
+			deduped = append(deduped, branch)
+			continue
+		}
+		existing := deduped[idx]
+		if isCanonicalBranch(branch) && !isCanonicalBranch(existing) {
+			deduped[idx] = branch
+			collisions = append(collisions, branchCollision{kept: branch, skipped: existing})
+		} else {
+			collisions = append(collisions, branchCollision{kept: existing, skipped: branch})
+		}
+	}
+	return deduped, collisions
+}
+
+// isCanonicalBranch returns true if the branch's ref is "refs/heads/"
+// followed by its name.
+func isCanonicalBranch(branch azureapi.Branch) bool {
+	return branch.Ref == "" || branch.Ref == "refs/heads/"+branch.Name
+}
+
+// checkNotAbortedWritesProblem checks if the import request's context has been
+// canceled or has passed its deadline, such as when the client disconnects.
+//
+// The Wharf API client does not accept a context, so this is meant to be called
+// before writing to the Wharf API to not continue writing after the import has
+// been aborted.
+func (i *azureImporter) checkNotAbortedWritesProblem() bool {
+	err := i.context().Err()
+	if err == nil {
+		return true
+	}
+	i.log().Warn().WithError(err).Message("Import aborted. Skipping remaining writes to Wharf API.")
+	status := http.StatusServiceUnavailable
+	if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	}
+	ginutil.WriteProblemError(i.c, err, problem.Response{
+		Type:   problemtype.ImportAborted,
+		Title:  "Import aborted.",
+		Status: status,
+		Detail: "The import was aborted before all data was written to the Wharf API.",
+	})
+	return false
+}
+
+// log returns a logger that adds the request ID to all log events.
+func (i *azureImporter) log() logger.Logger {
+	return requestid.Logger(log, i.c)
+}
+
+func (i *azureImporter) context() context.Context {
+	if i.c == nil || i.c.Request == nil {
+		return context.Background()
+	}
+	return i.c.Request.Context()
+}
+
 // createOrUpdateWharfProject tries to create a new Wharf project via the
 // Wharf API.
 //
@@ -242,73 +1168,344 @@ func (i *azureImporter) importBranchesWritesProblem(defaultBranchRef string, bra
 //
 // This relies on the "cannot-change-group" being removed, as was done in
 // wharf-api v4.2.0: https://github.com/iver-wharf/wharf-api/pull/55
-func (i *azureImporter) createOrUpdateWharfProject(orgName string, repo azureapi.Repository, buildDef string) (response.Project, error) {
-	groupName := fmt.Sprintf("%s/%s", orgName, repo.Project.Name)
+//
+// The returned bool is true if a new Wharf project was created, and false if
+// an existing one was updated.
+//
+// If writing the project fails due to a conflict in the Wharf API, such as
+// when a concurrent import created the same project, then the existing
+// project is searched for and updated again, up to Options.WharfConflictRetries
+// times.
+func (i *azureImporter) createOrUpdateWharfProject(orgName string, repo azureapi.Repository, buildDef string) (response.Project, bool, error) {
+	for retry := 0; ; retry++ {
+		project, created, err := i.createOrUpdateWharfProjectOnce(orgName, repo, buildDef)
+		if err == nil || !isWharfConflict(err) || retry >= i.opts.WharfConflictRetries {
+			return project, created, err
+		}
+		i.log().Warn().
+			WithError(err).
+			WithString("org", orgName).
+			WithString("project", repo.Project.Name).
+			WithString("repo", repo.Name).
+			WithInt("retry", retry+1).
+			Message("Conflict when writing Wharf project, searching for it again.")
+		// The conflicting project is not in the cached projects of its group.
+		i.projects.reset()
+	}
+}
+
+// isWharfConflict returns true if the error is a HTTP 409 Conflict problem
+// response from the Wharf API.
+func isWharfConflict(err error) bool {
+	var prob problem.Response
+	return errors.As(err, &prob) && prob.Status == http.StatusConflict
+}
+
+func (i *azureImporter) createOrUpdateWharfProjectOnce(orgName string, repo azureapi.Repository, buildDef string) (response.Project, bool, error) {
+	groupName, name, err := i.wharfProjectNames(orgName, repo.Project.Name, repo.Name)
+	if err != nil {
+		return response.Project{}, false, err
+	}
+	gitURL := i.gitURL(orgName, repo)
+	description := newWharfProjectDescription(repo)
 
-	var existingProject response.Project
-	search := wharfapi.ProjectSearch{
-		Name:       &repo.Name,
-		GroupName:  &groupName,
-		ProviderID: &i.resProvider.ProviderID,
+	cacheKey := projectCacheKey{
+		name:       name,
+		groupName:  groupName,
+		providerID: i.resProvider.ProviderID,
 	}
-	searchResults, err := i.wharf.GetProjectList(search)
+	existingProject, found, err := i.findWharfProject(cacheKey)
 	if err != nil {
-		log.Error().
-			WithError(err).
-			WithString("name", *search.Name).
-			WithString("groupName", *search.GroupName).
-			WithUint("providerId", *search.ProviderID).
-			Message("Unable to search for existing project.")
-		return existingProject, err
-	}
-	if len(searchResults.List) > 0 {
-		existingProject = searchResults.List[0]
+		return existingProject, false, err
+	}
+	if !found {
+		existingProject, found, err = i.findOrgProjectNamedWharfProject(orgName, repo, cacheKey)
+		if err != nil {
+			return existingProject, false, err
+		}
+	}
+	if !found {
+		existingProject, found, err = i.findV1NamedWharfProject(orgName, repo, cacheKey)
+		if err != nil {
+			return existingProject, false, err
+		}
+	}
+	if !found {
+		existingProject, found, err = i.findRenamedWharfProject(repo, cacheKey)
+		if err != nil {
+			return existingProject, false, err
+		}
+	}
+	if found {
+		// The Wharf API does not support updating the remote project ID, so
+		// projects keep the remote project ID they were created with.
+		if existingProject.RemoteProjectID != i.remoteProjectID(repo) {
+			i.log().Debug().
+				WithUint("projectId", existingProject.ProjectID).
+				WithString("remoteProjectId", existingProject.RemoteProjectID).
+				WithString("wantRemoteProjectId", i.remoteProjectID(repo)).
+				Message("Unable to update remote project ID of existing Wharf project.")
+		}
 		updatedProject := request.ProjectUpdate{
-			Name:            repo.Name,
+			Name:            name,
 			TokenID:         i.resToken.TokenID,
 			GroupName:       groupName,
 			BuildDefinition: buildDef,
-			Description:     repo.Project.Description,
+			Description:     description,
 			ProviderID:      i.resProvider.ProviderID,
-			GitURL:          repo.SSHURL,
+			GitURL:          gitURL,
+		}
+		updated, err := i.wharf.UpdateProject(existingProject.ProjectID, updatedProject)
+		if err != nil {
+			return updated, false, err
 		}
-		return i.wharf.UpdateProject(existingProject.ProjectID, updatedProject)
+		i.projects.set(updated)
+		return updated, false, nil
 	}
 
 	createdProject, err := i.wharf.CreateProject(request.Project{
-		Name:            repo.Name,
+		Name:            name,
 		TokenID:         i.resToken.TokenID,
 		GroupName:       groupName,
 		BuildDefinition: buildDef,
-		Description:     repo.Project.Description,
+		Description:     description,
 		ProviderID:      i.resProvider.ProviderID,
-		GitURL:          repo.SSHURL,
-		RemoteProjectID: repo.Project.ID,
+		GitURL:          gitURL,
+		RemoteProjectID: i.remoteProjectID(repo),
 	})
 
 	if err != nil {
-		log.Error().
+		i.log().Error().
 			WithError(err).
-			WithString("name", repo.Project.Name).
+			WithString("name", name).
 			WithString("groupName", groupName).
-			WithString("gitURL", repo.SSHURL).
-			WithUint("providerId", *search.ProviderID).
+			WithString("gitURL", gitURL).
+			WithUint("providerId", i.resProvider.ProviderID).
 			Message("Unable to create project.")
-		return response.Project{}, err
+		return response.Project{}, false, err
+	}
+	i.projects.set(createdProject)
+
+	return createdProject, true, nil
+}
+
+// findWharfProject finds an existing project among the projects of its group,
+// which are listed from the Wharf API the first time the group is searched
+// during the same import.
+func (i *azureImporter) findWharfProject(key projectCacheKey) (response.Project, bool, error) {
+	projects, err := i.projects.list(key.group(), i.listWharfGroupProjects)
+	if err != nil {
+		return response.Project{}, false, err
+	}
+	for _, project := range projects {
+		if project.Name == key.name {
+			return project, true, nil
+		}
+	}
+	return response.Project{}, false, nil
+}
+
+// findOrgProjectNamedWharfProject searches for an existing project using the
+// names of the GroupingOrgProject strategy, so that projects imported before
+// changing the grouping strategy or name templates are renamed instead of
+// imported a second time. The key is the project's current names, which
+// are not searched again.
+func (i *azureImporter) findOrgProjectNamedWharfProject(orgName string, repo azureapi.Repository, key projectCacheKey) (response.Project, bool, error) {
+	groupName, name := orgProjectNames(orgName, repo.Project.Name, repo.Name)
+	if groupName == key.groupName && name == key.name {
+		return response.Project{}, false, nil
+	}
+	project, found, err := i.findWharfProject(projectCacheKey{
+		name:       name,
+		groupName:  groupName,
+		providerID: key.providerID,
+	})
+	if found {
+		i.log().Info().
+			WithUint("projectId", project.ProjectID).
+			WithString("oldGroupName", groupName).
+			WithString("oldName", name).
+			WithString("groupName", key.groupName).
+			WithString("name", key.name).
+			Message("Renaming Wharf project imported with other names.")
+	}
+	return project, found, err
+}
+
+// findV1NamedWharfProject searches for a Wharf project of the repository that
+// was imported by wharf-provider-azuredevops v1, which named projects after
+// the Azure DevOps project, as:
+// 	Group:   "{orgName}"
+// 	Project: "{repo.Project.Name}"
+//
+// As v1 did not support multiple repositories per Azure DevOps project, the
+// found project is only used if its git URL belongs to the repository, so that
+// it is not renamed to the name of another repository in the same project.
+func (i *azureImporter) findV1NamedWharfProject(orgName string, repo azureapi.Repository, key projectCacheKey) (response.Project, bool, error) {
+	if orgName == key.groupName && repo.Project.Name == key.name {
+		return response.Project{}, false, nil
+	}
+	project, found, err := i.findWharfProject(projectCacheKey{
+		name:       repo.Project.Name,
+		groupName:  orgName,
+		providerID: key.providerID,
+	})
+	if err != nil || !found {
+		return project, false, err
+	}
+	if !i.isGitURLOfRepo(project.GitURL, orgName, repo) {
+		i.log().Debug().
+			WithUint("projectId", project.ProjectID).
+			WithString("gitURL", project.GitURL).
+			WithString("repo", repo.Name).
+			Message("Not renaming v1 Wharf project, as it belongs to another repository.")
+		return response.Project{}, false, nil
+	}
+	i.log().Info().
+		WithUint("projectId", project.ProjectID).
+		WithString("oldGroupName", orgName).
+		WithString("oldName", repo.Project.Name).
+		WithString("groupName", key.groupName).
+		WithString("name", key.name).
+		Message("Renaming Wharf project imported by v1.")
+	return project, true, nil
+}
+
+// isGitURLOfRepo returns true if the git URL is any of the SSH or HTTPS URLs
+// of the repository.
+func (i *azureImporter) isGitURLOfRepo(gitURL, orgName string, repo azureapi.Repository) bool {
+	if gitURL == "" {
+		return false
+	}
+	return gitURL == repo.SSHURL ||
+		gitURL == repo.RemoteURL ||
+		gitURL == i.gitURL(orgName, repo)
+}
+
+// newWharfProjectDescription returns a description for the Wharf project of
+// an imported repository. Azure DevOps repositories do not have descriptions
+// of their own, so the project's description is combined with the repository
+// details to not give all repositories in a project the same description.
+func newWharfProjectDescription(repo azureapi.Repository) string {
+	description := fmt.Sprintf("Azure DevOps repository %q in project %q", repo.Name, repo.Project.Name)
+	if branch := strings.TrimPrefix(repo.DefaultBranchRef, "refs/heads/"); branch != "" {
+		description += fmt.Sprintf(", with default branch %q", branch)
+	}
+	description += "."
+	if repo.Project.Description != "" {
+		description = repo.Project.Description + "\n\n" + description
+	}
+	return description
+}
+
+// gitURL returns the git URL to store in the Wharf project, depending on the
+// configured clone protocol.
+//
+// For SSH, it returns the SSH URL from Azure DevOps, or constructs one from the
+// provider URL if Azure DevOps did not provide one, which is the case on some
+// Azure DevOps Server configurations.
+//
+// For HTTPS, it returns the remote URL from Azure DevOps without any
+// credentials, or constructs one from the provider URL if Azure DevOps did not
+// provide one.
+func (i *azureImporter) gitURL(orgName string, repo azureapi.Repository) string {
+	if i.opts.CloneProtocol == CloneProtocolHTTPS {
+		return i.httpsGitURL(orgName, repo)
+	}
+	if repo.SSHURL != "" || i.providerURL == nil {
+		return repo.SSHURL
+	}
+	return newSSHGitURL(i.providerURL, i.opts.Mode, i.opts.SSHPort, orgName, repo.Project.Name, repo.Name)
+}
+
+func (i *azureImporter) httpsGitURL(orgName string, repo azureapi.Repository) string {
+	if repo.RemoteURL != "" {
+		remoteURL, err := url.Parse(repo.RemoteURL)
+		if err == nil {
+			// Azure DevOps includes the organization name as the user in the
+			// URL, which is removed to not embed any credentials.
+			remoteURL.User = nil
+			return remoteURL.String()
+		}
+		i.log().Warn().
+			WithError(err).
+			WithString("remoteUrl", repo.RemoteURL).
+			Message("Failed to parse repository remote URL. Constructing one from provider URL instead.")
 	}
+	if i.providerURL == nil {
+		return ""
+	}
+	u := *i.providerURL
+	u.User = nil
+	if i.opts.Mode == azureapi.ModeServer {
+		// The provider URL already points to the collection.
+		u.Path = path.Join("/", u.Path, repo.Project.Name, "_git", repo.Name)
+	} else {
+		u.Path = path.Join("/", u.Path, orgName, repo.Project.Name, "_git", repo.Name)
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
 
-	return createdProject, nil
+// newSSHGitURL constructs an SSH git URL from the provider URL, in the
+// formats used by Azure DevOps Services:
+//
+//	git@ssh.dev.azure.com:v3/{org}/{project}/{repo}
+//	{org}@vs-ssh.visualstudio.com:v3/{org}/{project}/{repo}
+//
+// Or by Azure DevOps Server, where the provider URL's path is the collection:
+//
+//	ssh://{host}:{port}/{collection}/{project}/_git/{repo}
+//
+// The Azure DevOps Services URLs are instead written in the ssh:// format
+// when a non-standard SSH port is used, as the above formats do not support
+// ports.
+func newSSHGitURL(providerURL *url.URL, mode azureapi.Mode, port int, orgName, projectName, repoName string) string {
+	const defaultSSHPort = 22
+	if port == 0 {
+		port = defaultSSHPort
+	}
+	host := strings.ToLower(providerURL.Hostname())
+	if mode == azureapi.ModeServer {
+		segments := []string{strings.Trim(providerURL.EscapedPath(), "/"),
+			url.PathEscape(projectName), "_git", url.PathEscape(repoName)}
+		if segments[0] == "" {
+			segments = segments[1:]
+		}
+		return fmt.Sprintf("ssh://%s:%d/%s", host, port, strings.Join(segments, "/"))
+	}
+	user := "git"
+	switch {
+	case host == "dev.azure.com":
+		host = "ssh.dev.azure.com"
+	case strings.HasSuffix(host, ".visualstudio.com"):
+		user = url.PathEscape(orgName)
+		host = "vs-ssh.visualstudio.com"
+	}
+	repoPath := fmt.Sprintf("v3/%s/%s/%s",
+		url.PathEscape(orgName), url.PathEscape(projectName), url.PathEscape(repoName))
+	if port == defaultSSHPort {
+		return fmt.Sprintf("%s@%s:%s", user, host, repoPath)
+	}
+	return fmt.Sprintf("ssh://%s@%s:%d/%s", user, host, port, repoPath)
 }
 
-func (i *azureImporter) getOrPostTokenWritesProblem(tokenData TokenData) (response.Token, bool) {
+// getOrPostTokenWritesProblem returns the Wharf token given by ID, or else
+// the token of the Wharf provider, given by providerTokenID, if it belongs
+// to the same user. The provider's token is updated if its value differs,
+// such as after the token has been rotated. Otherwise a new token is
+// created, so that tokens shared by other providers are never overwritten.
+func (i *azureImporter) getOrPostTokenWritesProblem(tokenData TokenData, providerTokenID uint) (response.Token, bool) {
 	if tokenData.ID != 0 {
 		dbToken, err := i.wharf.GetToken(tokenData.ID)
 		if err != nil {
-			log.Error().
+			i.log().Error().
 				WithError(err).
 				WithUint("ID", tokenData.ID).
 				Message("Unable to get token by ID.")
-			ginutil.WriteAPIClientReadError(i.c, err,
+			i.opts.WharfAPIDiagnoser.WriteReadError(i.c, err,
 				fmt.Sprintf("Unable to get token by ID %d.", tokenData.ID))
 			return response.Token{}, false
 		}
@@ -322,87 +1519,143 @@ func (i *azureImporter) getOrPostTokenWritesProblem(tokenData TokenData) (respon
 		return response.Token{}, false
 	}
 
-	search := wharfapi.TokenSearch{
-		UserName: &tokenData.UserName,
-	}
-	searchResults, err := i.wharf.GetTokenList(search)
-	if err != nil || len(searchResults.List) == 0 {
-		log.Warn().
-			WithError(err).
-			WithInt("tokensFound", len(searchResults.List)).
-			Message("Unable to get token. Will try to create one instead.")
-		createdToken, err := i.wharf.CreateToken(request.Token{
-			Token:      tokenData.Token,
-			UserName:   tokenData.UserName,
-			ProviderID: i.resProvider.ProviderID,
-		})
+	if providerTokenID != 0 {
+		existingToken, err := i.wharf.GetToken(providerTokenID)
 		if err != nil {
-			log.Error().WithError(err).Message("Unable to create token.")
-			ginutil.WriteAPIClientWriteError(i.c, err, "Unable to create new token.")
+			i.log().Error().
+				WithError(err).
+				WithUint("tokenId", providerTokenID).
+				Message("Unable to get token of provider.")
+			i.opts.WharfAPIDiagnoser.WriteReadError(i.c, err,
+				fmt.Sprintf("Unable to get token of provider by ID %d.", providerTokenID))
 			return response.Token{}, false
 		}
-		return createdToken, true
+		if existingToken.UserName == tokenData.UserName {
+			if tokenData.Token == "" || tokenData.Token == existingToken.Token {
+				return existingToken, true
+			}
+			return i.updateTokenWritesProblem(existingToken.TokenID, tokenData)
+		}
+		i.log().Debug().
+			WithUint("tokenId", existingToken.TokenID).
+			Message("Provider's token belongs to another user. Will create a new token instead.")
 	}
 
-	var foundToken response.Token
-	var found bool
-	for _, t := range searchResults.List {
-		if t.Token == tokenData.Token {
-			foundToken = t
-			found = true
-			break
-		}
+	createdToken, err := i.wharf.CreateToken(request.Token{
+		Token:      tokenData.Token,
+		UserName:   tokenData.UserName,
+		ProviderID: i.resProvider.ProviderID,
+	})
+	if err != nil {
+		i.log().Error().WithError(err).Message("Unable to create token.")
+		i.opts.WharfAPIDiagnoser.WriteWriteError(i.c, err, "Unable to create new token.")
+		return response.Token{}, false
 	}
+	return createdToken, true
+}
 
-	return foundToken, found
+// updateTokenWritesProblem stores the new value of a rotated token.
+func (i *azureImporter) updateTokenWritesProblem(tokenID uint, tokenData TokenData) (response.Token, bool) {
+	updatedToken, err := i.wharf.UpdateToken(tokenID, request.TokenUpdate{
+		Token:    tokenData.Token,
+		UserName: tokenData.UserName,
+	})
+	if err != nil {
+		i.log().Error().
+			WithError(err).
+			WithUint("tokenId", tokenID).
+			Message("Unable to update token.")
+		i.opts.WharfAPIDiagnoser.WriteWriteError(i.c, err,
+			fmt.Sprintf("Unable to update token with ID %d.", tokenID))
+		return response.Token{}, false
+	}
+	return updatedToken, true
 }
 
 func (i *azureImporter) getOrPostProviderWritesProblem(providerData ProviderData) (response.Provider, bool) {
+	provider, found, ok := i.findProviderWritesProblem(providerData)
+	if !ok || found {
+		return provider, ok
+	}
+	return i.postProviderWritesProblem(providerData)
+}
+
+// findProviderWritesProblem returns the Wharf provider given by ID, or else
+// searches for it by name and URL. Failing to search is only logged, so that
+// the provider is created instead.
+func (i *azureImporter) findProviderWritesProblem(providerData ProviderData) (provider response.Provider, found bool, ok bool) {
 	if providerData.ID != 0 {
 		dbProvider, err := i.wharf.GetProvider(providerData.ID)
 		if err != nil {
-			log.Error().
+			i.log().Error().
 				WithError(err).
 				WithUint("providerId", providerData.ID).
 				Message("Unable to get provider by ID.")
-			ginutil.WriteAPIClientReadError(i.c, err,
+			i.opts.WharfAPIDiagnoser.WriteReadError(i.c, err,
 				fmt.Sprintf("Unable to get provider by ID %d", providerData.ID))
-			return response.Provider{}, false
+			return response.Provider{}, false, false
 		}
-		log.Debug().WithUint("providerId", dbProvider.ProviderID).
+		i.log().Debug().WithUint("providerId", dbProvider.ProviderID).
 			Message("Got existing provider from DB.")
-		return dbProvider, true
+		return dbProvider, true, true
 	}
 
+	// Searching by name only, as providers created by older versions may
+	// have URLs that are not normalized, such as with trailing slashes, which
+	// the Wharf API would not match.
 	providerName := string(providerData.Name)
 	search := wharfapi.ProviderSearch{
 		Name: &providerName,
-		URL:  &providerData.URL,
 	}
 	searchResults, err := i.wharf.GetProviderList(search)
 
 	if err == nil {
 		for _, p := range searchResults.List {
-			if p.URL == providerData.URL {
-				return p, true
+			if string(p.Name) == providerName && ProviderURLsEqual(p.URL, providerData.URL) {
+				return p, true, true
 			}
 		}
 	}
 
-	log.Warn().
+	i.log().Warn().
 		WithError(err).
 		WithInt("providersFound", len(searchResults.List)).
 		Message("Unable to get provider. Will try to create one instead.")
+	return response.Provider{}, false, true
+}
+
+func (i *azureImporter) postProviderWritesProblem(providerData ProviderData) (response.Provider, bool) {
 	createdProvider, err := i.wharf.CreateProvider(request.Provider{
 		Name:    request.ProviderName(providerData.Name),
 		URL:     providerData.URL,
 		TokenID: providerData.TokenID,
 	})
 	if err != nil {
-		log.Error().WithError(err).Message("Unable to create provider.")
-		ginutil.WriteAPIClientWriteError(i.c, err,
-			fmt.Sprintf("Unable to get or create provider from %q.", providerData.URL))
+		i.log().Error().WithError(err).Message("Unable to create provider.")
+		i.opts.WharfAPIDiagnoser.WriteWriteError(i.c, err,
+			fmt.Sprintf("Unable to get or create provider from %q.", redact.URLString(providerData.URL)))
 		return response.Provider{}, false
 	}
 	return createdProvider, true
 }
+
+// SortProjectsByName sorts the projects in place, so that projects are
+// listed and imported in the same order regardless of the order Azure DevOps
+// returned them in.
+func SortProjectsByName(projects []azureapi.Project) {
+	sort.SliceStable(projects, func(a, b int) bool {
+		return projects[a].Name < projects[b].Name
+	})
+}
+
+// SortRepositoriesByName sorts the repositories in place, so that
+// repositories are listed and imported in the same order regardless of the
+// order Azure DevOps returned them in.
+func SortRepositoriesByName(repos []azureapi.Repository) {
+	sort.SliceStable(repos, func(a, b int) bool {
+		if repos[a].Project.Name != repos[b].Project.Name {
+			return repos[a].Project.Name < repos[b].Project.Name
+		}
+		return repos[a].Name < repos[b].Name
+	})
+}
@@ -0,0 +1,2361 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/request"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi/azureapitest"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/metrics"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/wharfapitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortProjectsByName(t *testing.T) {
+	var testCases = []struct {
+		name  string
+		input []string
+	}{
+		{
+			name:  "already sorted",
+			input: []string{"Alpha", "Bravo", "Charlie"},
+		},
+		{
+			name:  "reversed",
+			input: []string{"Charlie", "Bravo", "Alpha"},
+		},
+		{
+			name:  "shuffled",
+			input: []string{"Bravo", "Charlie", "Alpha"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var projects []azureapi.Project
+			for _, name := range tc.input {
+				projects = append(projects, azureapi.Project{Name: name})
+			}
+			SortProjectsByName(projects)
+			var got []string
+			for _, p := range projects {
+				got = append(got, p.Name)
+			}
+			assert.Equal(t, []string{"Alpha", "Bravo", "Charlie"}, got)
+		})
+	}
+}
+
+func TestSortRepositoriesByName(t *testing.T) {
+	repos := []azureapi.Repository{
+		{Name: "Repo2", Project: azureapi.Project{Name: "ProjB"}},
+		{Name: "Repo1", Project: azureapi.Project{Name: "ProjB"}},
+		{Name: "Repo3", Project: azureapi.Project{Name: "ProjA"}},
+	}
+	SortRepositoriesByName(repos)
+	var got []string
+	for _, r := range repos {
+		got = append(got, r.Project.Name+"/"+r.Name)
+	}
+	assert.Equal(t, []string{"ProjA/Repo3", "ProjB/Repo1", "ProjB/Repo2"}, got)
+}
+
+func TestImportBranchesStopsAfterContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var requestCount int
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil).WithContext(ctx)
+
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+	}
+	branches := []azureapi.Branch{
+		{Name: "main", Ref: "refs/heads/main"},
+		{Name: "feature", Ref: "refs/heads/feature"},
+	}
+
+	ok := i.importBranchesWritesProblem("refs/heads/main", branches, 1)
+
+	assert.False(t, ok)
+	assert.Equal(t, 0, requestCount, "wharf-api requests")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestImportBranchesReplacesAllBranches(t *testing.T) {
+	var gotBranches []request.Branch
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/api/project/1/branch", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&gotBranches))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+	}
+	branches := []azureapi.Branch{
+		{Name: "main", Ref: "refs/heads/main"},
+		{Name: "feature", Ref: "refs/heads/feature"},
+	}
+
+	ok := i.importBranchesWritesProblem("refs/heads/main", branches, 1)
+
+	assert.True(t, ok)
+	want := []request.Branch{
+		{Name: "main", Default: true},
+		{Name: "feature", Default: false},
+	}
+	assert.Equal(t, want, gotBranches)
+}
+
+func TestImportBranchesFlagsOneDefaultBranch(t *testing.T) {
+	var testCases = []struct {
+		name             string
+		defaultBranchRef string
+		branches         []azureapi.Branch
+	}{
+		{
+			name:             "full refs",
+			defaultBranchRef: "refs/heads/main",
+			branches: []azureapi.Branch{
+				{Name: "feature/main", Ref: "refs/heads/feature/main"},
+				{Name: "main", Ref: "refs/heads/main"},
+			},
+		},
+		{
+			name:             "short default branch",
+			defaultBranchRef: "main",
+			branches: []azureapi.Branch{
+				{Name: "feature/main", Ref: "refs/heads/feature/main"},
+				{Name: "main", Ref: "refs/heads/main"},
+			},
+		},
+		{
+			name:             "branches without refs",
+			defaultBranchRef: "refs/heads/main",
+			branches: []azureapi.Branch{
+				{Name: "feature/main"},
+				{Name: "main"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotBranches []request.Branch
+			wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&gotBranches))
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`[]`))
+			}))
+			defer wharfServer.Close()
+
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+			i := azureImporter{
+				c:     c,
+				wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+			}
+
+			require.True(t, i.importBranchesWritesProblem(tc.defaultBranchRef, tc.branches, 1))
+
+			var defaults []string
+			for _, branch := range gotBranches {
+				if branch.Default {
+					defaults = append(defaults, branch.Name)
+				}
+			}
+			assert.Equal(t, []string{"main"}, defaults)
+		})
+	}
+}
+
+func TestRefreshRepositoryReportsStaleWhenNotFound(t *testing.T) {
+	azureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer azureServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	azureURL, err := url.Parse(azureServer.URL)
+	assert.NoError(t, err)
+	i := azureImporter{
+		c: c,
+		wharf: &wharfapitest.Fake{
+			Projects: []response.Project{{ProjectID: 12, GroupName: "Org/Proj", Name: "Repo",
+				GitURL: "git@ssh.dev.azure.com:v3/Org/Proj/Repo"}},
+		},
+		azure: &azureapi.Client{
+			Context:       c,
+			BaseURL:       azureServer.URL,
+			BaseURLParsed: azureURL,
+		},
+	}
+
+	result, ok := i.RefreshRepositoryWritesProblem("Org", "Proj", "Repo", 12)
+
+	assert.True(t, ok)
+	assert.Equal(t, []StaleProject{{ProjectID: 12, Org: "Org", Project: "Proj", Repo: "Repo"}}, result.StaleProjects)
+	assert.Zero(t, result.ProjectsCreated+result.ProjectsUpdated)
+	assert.False(t, c.Writer.Written(), "response written")
+}
+
+func TestImportRepositoryWithFakeAzure(t *testing.T) {
+	var createdBranches int
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/project":
+			w.Write([]byte(`{"list":[],"totalCount":0}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/project":
+			w.Write([]byte(`{"projectId":5,"name":"Repo"}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/5/branch":
+			var branches []request.Branch
+			json.NewDecoder(r.Body).Decode(&branches)
+			createdBranches += len(branches)
+			w.Write([]byte(`[]`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	importMetrics := metrics.NewImportMetrics(metrics.NewRegistry())
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{
+					Repository: azureapi.Repository{
+						ID:               "repo-id",
+						Name:             "Repo",
+						Project:          azureapi.Project{ID: "proj-id", Name: "Proj"},
+						DefaultBranchRef: "refs/heads/main",
+					},
+					Branches: []azureapi.Branch{
+						{Name: "main", Ref: "refs/heads/main"},
+						{Name: "dev", Ref: "refs/heads/dev"},
+					},
+				},
+			},
+		},
+		opts: Options{Metrics: importMetrics},
+	}
+
+	result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+
+	assert.True(t, ok)
+	assert.Equal(t, 1, result.ProjectsCreated)
+	assert.Equal(t, 2, result.BranchesCreated)
+	assert.Equal(t, 2, createdBranches)
+	assert.Len(t, result.Warnings, 1, "warning about missing build definition")
+	assert.Equal(t, 1.0, importMetrics.ProjectsImported.Value("created"))
+	assert.Equal(t, 2.0, importMetrics.BranchesImported.Value())
+}
+
+func TestImportRepositoryTwiceOnlySearchesWharfOnFirstImport(t *testing.T) {
+	var searches, creates, updates int
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/project":
+			searches++
+			w.Write([]byte(`{"list":[],"totalCount":0}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/project":
+			creates++
+			w.Write([]byte(`{"projectId":5,"name":"Repo","groupName":"Org/Proj"}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/5":
+			updates++
+			w.Write([]byte(`{"projectId":5,"name":"Repo","groupName":"Org/Proj"}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/5/branch":
+			w.Write([]byte(`[]`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-id", Name: "Repo", Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+			},
+		},
+	}
+
+	_, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+	require.True(t, ok)
+	result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+	require.True(t, ok)
+
+	assert.Equal(t, 1, result.ProjectsUpdated)
+	// Only the first import searches, for both the v2 and v1 names.
+	assert.Equal(t, 2, searches, "project searches")
+	assert.Equal(t, 1, creates, "project creates")
+	assert.Equal(t, 1, updates, "project updates")
+}
+
+// countingWharf counts the project searches made through the fake.
+type countingWharf struct {
+	*wharfapitest.Fake
+	searchedGroups []string
+}
+
+func (w *countingWharf) GetProjectList(params wharfapi.ProjectSearch) (response.PaginatedProjects, error) {
+	if params.GroupName != nil {
+		w.searchedGroups = append(w.searchedGroups, *params.GroupName)
+	}
+	return w.Fake.GetProjectList(params)
+}
+
+func TestImportProjectListsEachWharfGroupOnce(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	project := azureapi.Project{ID: "proj-id", Name: "Proj", State: azureapi.ProjectStateWellFormed}
+	wharf := &countingWharf{Fake: &wharfapitest.Fake{
+		Projects: []response.Project{{ProjectID: 1, GroupName: "Org/Proj", Name: "RepoB"}},
+	}}
+	i := azureImporter{
+		c:     c,
+		wharf: wharf,
+		azure: &azureapitest.Fake{
+			Projects: []azureapi.Project{project},
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-a", Name: "RepoA", Project: project}},
+				{Repository: azureapi.Repository{ID: "repo-b", Name: "RepoB", Project: project}},
+				{Repository: azureapi.Repository{ID: "repo-c", Name: "RepoC", Project: project}},
+			},
+		},
+	}
+
+	result, ok := i.ImportProjectWritesProblem("Org", "Proj")
+
+	require.True(t, ok)
+	assert.Equal(t, 2, result.ProjectsCreated)
+	assert.Equal(t, 1, result.ProjectsUpdated)
+	// Listed once for the v2 names, and once for the v1 names.
+	assert.Equal(t, []string{"Org/Proj", "Org"}, wharf.searchedGroups)
+}
+
+func TestImportRepositoryRetriesWharfConflict(t *testing.T) {
+	var created bool
+	var updated request.ProjectUpdate
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/project":
+			if created && r.URL.Query().Get("groupName") == "Org/Proj" {
+				w.Write([]byte(`{"list":[{"projectId":5,"name":"Repo","groupName":"Org/Proj"}],"totalCount":1}`))
+				return
+			}
+			w.Write([]byte(`{"list":[],"totalCount":0}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/project":
+			// Simulates a concurrent import creating the project first.
+			created = true
+			w.Header().Set("Content-Type", problem.HTTPContentType)
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"type":"/prob/api/conflict","title":"Conflict","status":409}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/5":
+			json.NewDecoder(r.Body).Decode(&updated)
+			w.Write([]byte(`{"projectId":5,"name":"Repo","groupName":"Org/Proj"}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/5/branch":
+			w.Write([]byte(`[]`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer wharfServer.Close()
+
+	var testCases = []struct {
+		name    string
+		retries int
+		wantOK  bool
+	}{
+		{name: "retry disabled", retries: 0, wantOK: false},
+		{name: "retry enabled", retries: 1, wantOK: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			created = false
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			i := azureImporter{
+				c:     c,
+				wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{Repository: azureapi.Repository{ID: "repo-id", Name: "Repo", Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+					},
+				},
+				opts: Options{WharfConflictRetries: tc.retries},
+			}
+
+			result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+			require.Equal(t, tc.wantOK, ok)
+			if tc.wantOK {
+				assert.Equal(t, 1, result.ProjectsUpdated)
+				assert.Equal(t, "Org/Proj", updated.GroupName)
+			}
+		})
+	}
+}
+
+func TestImportRepositoryUsesNameTemplates(t *testing.T) {
+	var created request.Project
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/project":
+			w.Write([]byte(`{"list":[],"totalCount":0}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/project":
+			json.NewDecoder(r.Body).Decode(&created)
+			w.Write([]byte(`{"projectId":5}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/5/branch":
+			w.Write([]byte(`[]`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	projectTmpl, err := ParseNameTemplate("projectName", "{{.Project}}-{{.Repo}}")
+	require.NoError(t, err)
+	groupTmpl, err := ParseNameTemplate("groupName", "{{.Org}}")
+	require.NoError(t, err)
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-id", Name: "Repo", Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+			},
+		},
+		opts: Options{ProjectNameTemplate: projectTmpl, GroupNameTemplate: groupTmpl},
+	}
+
+	_, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+	require.True(t, ok)
+	assert.Equal(t, "Proj-Repo", created.Name)
+	assert.Equal(t, "Org", created.GroupName)
+}
+
+func TestImportRepositoryOrgOnlyRenamesOrgProjectNamedProject(t *testing.T) {
+	var updated request.ProjectUpdate
+	var searchedGroups []string
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/project":
+			group := r.URL.Query().Get("groupName")
+			searchedGroups = append(searchedGroups, group)
+			if group == "Org/Proj" {
+				w.Write([]byte(`{"list":[{"projectId":5,"name":"Repo","groupName":"Org/Proj"}],"totalCount":1}`))
+				return
+			}
+			w.Write([]byte(`{"list":[],"totalCount":0}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/5":
+			json.NewDecoder(r.Body).Decode(&updated)
+			w.Write([]byte(`{"projectId":5}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/5/branch":
+			w.Write([]byte(`[]`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-id", Name: "Repo", Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+			},
+		},
+		opts: Options{GroupingStrategy: GroupingOrgOnly},
+	}
+
+	result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+	require.True(t, ok)
+	assert.Equal(t, 1, result.ProjectsUpdated)
+	assert.Equal(t, []string{"Org", "Org/Proj"}, searchedGroups)
+	assert.Equal(t, "Proj-Repo", updated.Name)
+	assert.Equal(t, "Org", updated.GroupName)
+}
+
+func TestParseNameTemplate(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{name: "valid", text: "{{.Org}}-{{.Project}}-{{.Repo}}"},
+		{name: "unknown field", text: "{{.Foo}}", wantErr: true},
+		{name: "syntax error", text: "{{.Repo", wantErr: true},
+		{name: "empty result", text: "{{\"\"}}", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseNameTemplate("name", tc.text)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestImportProjectContinueOnError(t *testing.T) {
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/project":
+			w.Write([]byte(`{"list":[],"totalCount":0}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/project":
+			var project request.Project
+			json.NewDecoder(r.Body).Decode(&project)
+			if project.Name == "Bad" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte(`{"projectId":5}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/5/branch":
+			w.Write([]byte(`[]`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer wharfServer.Close()
+
+	var testCases = []struct {
+		name            string
+		continueOnError bool
+		wantOK          bool
+	}{
+		{name: "abort on error", continueOnError: false, wantOK: false},
+		{name: "continue on error", continueOnError: true, wantOK: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			i := azureImporter{
+				c:     c,
+				wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{Repository: azureapi.Repository{ID: "bad-id", Name: "Bad", Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+						{Repository: azureapi.Repository{ID: "good-id", Name: "Good", Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+					},
+				},
+				opts: Options{ContinueOnError: tc.continueOnError},
+			}
+
+			result, ok := i.ImportProjectWritesProblem("Org", "Proj")
+
+			require.Equal(t, tc.wantOK, ok)
+			if !tc.wantOK {
+				assert.Equal(t, http.StatusBadGateway, rec.Code)
+				return
+			}
+			assert.Empty(t, rec.Body.String(), "response body")
+			assert.Empty(t, c.Errors, "gin errors")
+			assert.Equal(t, 1, result.ProjectsCreated)
+			require.Len(t, result.FailedRepos, 1)
+			assert.Equal(t, "Bad", result.FailedRepos[0].Repo)
+			assert.Equal(t, http.StatusBadGateway, result.FailedRepos[0].Problem.Status)
+		})
+	}
+}
+
+func TestFindWharfProjectRequiresExactMatch(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		list      string
+		wantFound bool
+		wantID    uint
+	}{
+		{
+			name:      "other org",
+			list:      `[{"projectId":1,"name":"lib","groupName":"OtherOrg/Common","providerId":3}]`,
+			wantFound: false,
+		},
+		{
+			name:      "other provider",
+			list:      `[{"projectId":1,"name":"lib","groupName":"Org/Common","providerId":4}]`,
+			wantFound: false,
+		},
+		{
+			name:      "exact match after other",
+			list:      `[{"projectId":1,"name":"lib","groupName":"OtherOrg/Common","providerId":3},{"projectId":2,"name":"lib","groupName":"Org/Common","providerId":3}]`,
+			wantFound: true,
+			wantID:    2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"list":` + tc.list + `,"totalCount":1}`))
+			}))
+			defer wharfServer.Close()
+
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+			i := azureImporter{
+				c:     c,
+				wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+			}
+
+			project, found, err := i.findWharfProject(projectCacheKey{name: "lib", groupName: "Org/Common", providerID: 3})
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantFound, found)
+			assert.Equal(t, tc.wantID, project.ProjectID)
+		})
+	}
+}
+
+func TestImportOrganizationUsesProjectID(t *testing.T) {
+	wharfServer := newTestWharfServer(t)
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+		azure: &azureapitest.Fake{
+			// Project renamed between listing projects and listing repos.
+			Projects: []azureapi.Project{{ID: "proj-id", Name: "Old/Name", State: azureapi.ProjectStateWellFormed}},
+			Repositories: []azureapitest.Repository{
+				{
+					Repository: azureapi.Repository{
+						ID:      "repo-id",
+						Name:    "Repo",
+						Project: azureapi.Project{ID: "proj-id", Name: "New Name"},
+					},
+				},
+			},
+		},
+	}
+
+	result, ok := i.ImportOrganizationWritesProblem("Org")
+
+	assert.True(t, ok)
+	assert.Equal(t, 1, result.ProjectsCreated)
+}
+
+func TestImportOrganizationSkipsProjectsNotWellFormed(t *testing.T) {
+	var testCases = []struct {
+		name             string
+		includeAll       bool
+		wantCreated      int
+		wantSkippedCount int
+	}{
+		{
+			name:             "default",
+			includeAll:       false,
+			wantCreated:      1,
+			wantSkippedCount: 1,
+		},
+		{
+			name:             "include all states",
+			includeAll:       true,
+			wantCreated:      2,
+			wantSkippedCount: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wharfServer := newTestWharfServer(t)
+			defer wharfServer.Close()
+
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			i := azureImporter{
+				c:     c,
+				wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+				azure: &azureapitest.Fake{
+					Projects: []azureapi.Project{
+						{ID: "proj-a", Name: "A", State: azureapi.ProjectStateWellFormed},
+						{ID: "proj-b", Name: "B", State: "deleting"},
+					},
+					Repositories: []azureapitest.Repository{
+						{Repository: azureapi.Repository{ID: "repo-a", Name: "RepoA", Project: azureapi.Project{ID: "proj-a", Name: "A"}}},
+						{Repository: azureapi.Repository{ID: "repo-b", Name: "RepoB", Project: azureapi.Project{ID: "proj-b", Name: "B"}}},
+					},
+				},
+				opts: Options{IncludeAllProjectStates: tc.includeAll},
+			}
+
+			result, ok := i.ImportOrganizationWritesProblem("Org")
+
+			assert.True(t, ok)
+			assert.Equal(t, tc.wantCreated, result.ProjectsCreated)
+			if assert.Len(t, result.SkippedProjects, tc.wantSkippedCount) && tc.wantSkippedCount > 0 {
+				assert.Equal(t, "B", result.SkippedProjects[0].Project)
+			}
+		})
+	}
+}
+
+func TestImportOrganizationFiltersByProjectPattern(t *testing.T) {
+	wharfServer := newTestWharfServer(t)
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+		azure: &azureapitest.Fake{
+			Projects: []azureapi.Project{
+				{ID: "proj-a", Name: "team-a", State: azureapi.ProjectStateWellFormed},
+				{ID: "proj-b", Name: "other", State: azureapi.ProjectStateWellFormed},
+			},
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-a", Name: "RepoA", Project: azureapi.Project{ID: "proj-a", Name: "team-a"}}},
+				{Repository: azureapi.Repository{ID: "repo-b", Name: "RepoB", Project: azureapi.Project{ID: "proj-b", Name: "other"}}},
+			},
+		},
+		opts: Options{ProjectPattern: regexp.MustCompile("^team-")},
+	}
+
+	result, ok := i.ImportOrganizationWritesProblem("Org")
+
+	assert.True(t, ok)
+	assert.Equal(t, 1, result.ProjectsCreated)
+	if assert.Len(t, result.SkippedProjects, 1) {
+		assert.Equal(t, "other", result.SkippedProjects[0].Project)
+	}
+}
+
+func TestImportOrganizationSkipsProjectsWithMetadataUnchangedSince(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	changed := azureapi.Project{ID: "proj-a", Name: "Changed", State: azureapi.ProjectStateWellFormed,
+		LastUpdateTime: "2022-05-21T00:00:00Z"}
+	unchanged := azureapi.Project{ID: "proj-b", Name: "Unchanged", State: azureapi.ProjectStateWellFormed,
+		LastUpdateTime: "2022-05-19T00:00:00Z"}
+	unknown := azureapi.Project{ID: "proj-c", Name: "Unknown", State: azureapi.ProjectStateWellFormed,
+		LastUpdateTime: "0001-01-01T00:00:00"}
+	wharf := &wharfapitest.Fake{}
+	i := azureImporter{
+		c:     c,
+		wharf: wharf,
+		azure: &azureapitest.Fake{
+			Projects: []azureapi.Project{changed, unchanged, unknown},
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-a", Name: "RepoA", Project: changed}},
+				{Repository: azureapi.Repository{ID: "repo-b", Name: "RepoB", Project: unchanged}},
+				{Repository: azureapi.Repository{ID: "repo-c", Name: "RepoC", Project: unknown}},
+			},
+		},
+		opts: Options{ProjectMetadataChangedSince: time.Date(2022, 5, 20, 0, 0, 0, 0, time.UTC)},
+	}
+
+	result, ok := i.ImportOrganizationWritesProblem("Org")
+
+	require.True(t, ok)
+	assert.Equal(t, 2, result.ProjectsCreated, "changed and unknown")
+	if assert.Len(t, result.SkippedProjects, 1) {
+		assert.Equal(t, "Unchanged", result.SkippedProjects[0].Project)
+	}
+
+	result, ok = i.ImportRepositoryWritesProblem("Org", "Unchanged", "RepoB")
+
+	require.True(t, ok)
+	assert.Equal(t, 1, result.ReposSkipped, "single repository of unchanged project")
+	assert.Len(t, wharf.Projects, 2)
+}
+
+// newTestWharfServer creates a fake Wharf API that accepts creating projects
+// and replacing their branches.
+func newTestWharfServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/project":
+			w.Write([]byte(`{"list":[],"totalCount":0}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/project":
+			w.Write([]byte(`{"projectId":1}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/project/1/branch":
+			w.Write([]byte(`[]`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestNewSSHGitURL(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		providerURL string
+		mode        azureapi.Mode
+		port        int
+		project     string
+		want        string
+	}{
+		{
+			name:        "unset port",
+			providerURL: "https://azure.example.com",
+			port:        0,
+			want:        "git@azure.example.com:v3/Org/Proj/Repo",
+		},
+		{
+			name:        "default port",
+			providerURL: "https://azure.example.com",
+			port:        22,
+			want:        "git@azure.example.com:v3/Org/Proj/Repo",
+		},
+		{
+			name:        "custom port",
+			providerURL: "https://azure.example.com",
+			port:        2222,
+			want:        "ssh://git@azure.example.com:2222/v3/Org/Proj/Repo",
+		},
+		{
+			name:        "dev.azure.com",
+			providerURL: "https://dev.azure.com",
+			want:        "git@ssh.dev.azure.com:v3/Org/Proj/Repo",
+		},
+		{
+			name:        "visualstudio.com",
+			providerURL: "https://org.visualstudio.com",
+			want:        "Org@vs-ssh.visualstudio.com:v3/Org/Proj/Repo",
+		},
+		{
+			name:        "escaped names",
+			providerURL: "https://dev.azure.com",
+			project:     "My Proj",
+			want:        "git@ssh.dev.azure.com:v3/Org/My%20Proj/Repo",
+		},
+		{
+			name:        "server",
+			providerURL: "https://azure.example.com/tfs/DefaultCollection",
+			mode:        azureapi.ModeServer,
+			want:        "ssh://azure.example.com:22/tfs/DefaultCollection/Proj/_git/Repo",
+		},
+		{
+			name:        "server with custom port",
+			providerURL: "https://azure.example.com/DefaultCollection/",
+			mode:        azureapi.ModeServer,
+			port:        2222,
+			project:     "My Proj",
+			want:        "ssh://azure.example.com:2222/DefaultCollection/My%20Proj/_git/Repo",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			providerURL, err := url.Parse(tc.providerURL)
+			require.NoError(t, err)
+			project := tc.project
+			if project == "" {
+				project = "Proj"
+			}
+			got := newSSHGitURL(providerURL, tc.mode, tc.port, "Org", project, "Repo")
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestGitURLPrefersSSHURLFromAzure(t *testing.T) {
+	providerURL, err := url.Parse("https://azure.example.com/tfs")
+	assert.NoError(t, err)
+	i := azureImporter{providerURL: providerURL}
+
+	repo := azureapi.Repository{
+		Name:    "Repo",
+		Project: azureapi.Project{Name: "Proj"},
+		SSHURL:  "git@ssh.azure.example.com:v3/Org/Proj/Repo",
+	}
+	assert.Equal(t, repo.SSHURL, i.gitURL("Org", repo))
+
+	repo.SSHURL = ""
+	assert.Equal(t, "git@azure.example.com:v3/Org/Proj/Repo", i.gitURL("Org", repo))
+}
+
+func TestGitURLWithHTTPS(t *testing.T) {
+	providerURL, err := url.Parse("https://azure.example.com/tfs")
+	assert.NoError(t, err)
+	i := azureImporter{
+		providerURL: providerURL,
+		opts:        Options{CloneProtocol: CloneProtocolHTTPS},
+	}
+
+	repo := azureapi.Repository{
+		Name:      "Repo",
+		Project:   azureapi.Project{Name: "Proj"},
+		RemoteURL: "https://Org@dev.azure.com/Org/Proj/_git/Repo",
+	}
+	assert.Equal(t, "https://dev.azure.com/Org/Proj/_git/Repo", i.gitURL("Org", repo))
+
+	repo.RemoteURL = ""
+	assert.Equal(t, "https://azure.example.com/tfs/Org/Proj/_git/Repo", i.gitURL("Org", repo))
+}
+
+func TestGetOrPostTokenUpdatesExistingToken(t *testing.T) {
+	var gotUpdate request.TokenUpdate
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/token/3":
+			w.Write([]byte(`{"tokenId":3,"userName":"user","token":"old"}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/api/token/3":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&gotUpdate))
+			w.Write([]byte(`{"tokenId":3,"userName":"user","token":"rotated"}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+	}
+
+	token, ok := i.getOrPostTokenWritesProblem(TokenData{
+		ReqToken: ReqToken{UserName: "user", Token: "rotated"},
+	}, 3)
+
+	assert.True(t, ok)
+	assert.Equal(t, uint(3), token.TokenID)
+	assert.Equal(t, request.TokenUpdate{UserName: "user", Token: "rotated"}, gotUpdate)
+}
+
+func TestGetOrPostProviderFindsExistingProvider(t *testing.T) {
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/provider" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"list":[
+			{"providerId":1,"name":"gitlab","url":"https://azure.example.com"},
+			{"providerId":2,"name":"azuredevops","url":"https://azure.example.com"}
+		],"totalCount":2}`))
+	}))
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+	}
+
+	provider, ok := i.getOrPostProviderWritesProblem(ProviderData{
+		ReqProvider: ReqProvider{Name: "azuredevops", URL: "https://azure.example.com"},
+	})
+
+	assert.True(t, ok)
+	assert.Equal(t, uint(2), provider.ProviderID)
+}
+
+func TestNewWharfProjectDescriptionDiffersPerRepo(t *testing.T) {
+	project := azureapi.Project{Name: "Proj", Description: "Our project."}
+	repoA := azureapi.Repository{Name: "RepoA", Project: project, DefaultBranchRef: "refs/heads/main"}
+	repoB := azureapi.Repository{Name: "RepoB", Project: project, DefaultBranchRef: "refs/heads/main"}
+
+	descA := newWharfProjectDescription(repoA)
+	descB := newWharfProjectDescription(repoB)
+
+	assert.NotEqual(t, descA, descB)
+	assert.Equal(t, "Our project.\n\nAzure DevOps repository \"RepoA\" in project \"Proj\", with default branch \"main\".", descA)
+}
+
+func TestNewWharfProjectDescriptionWithoutProjectDescription(t *testing.T) {
+	repo := azureapi.Repository{Name: "Repo", Project: azureapi.Project{Name: "Proj"}}
+	assert.Equal(t, "Azure DevOps repository \"Repo\" in project \"Proj\".", newWharfProjectDescription(repo))
+}
+
+func TestRegisterWebhookIsIdempotent(t *testing.T) {
+	fake := &azureapitest.Fake{}
+	i := azureImporter{
+		hooks: fake,
+		opts: Options{
+			Webhooks: &WebhookOptions{
+				TriggerURL: func(wharfProjectID uint) string {
+					return "https://wharf.example.com/trigger"
+				},
+			},
+		},
+	}
+	repo := azureapi.Repository{
+		ID:      "repo-id",
+		Name:    "Repo",
+		Project: azureapi.Project{ID: "proj-id", Name: "Proj"},
+	}
+
+	registered, ok := i.registerWebhookWritesProblem("Org", repo, 1)
+	assert.True(t, ok)
+	assert.True(t, registered)
+
+	registered, ok = i.registerWebhookWritesProblem("Org", repo, 1)
+	assert.True(t, ok)
+	assert.False(t, registered, "registered again")
+
+	if assert.Len(t, fake.Subscriptions, 1) {
+		sub := fake.Subscriptions[0]
+		assert.Equal(t, "git.pullrequest.created", sub.EventType)
+		assert.Equal(t, "repo-id", sub.PublisherInputs["repository"])
+		assert.Equal(t, "https://wharf.example.com/trigger", sub.ConsumerInputs["url"])
+	}
+}
+
+func TestValidateBranchRefFilter(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		filter  string
+		want    string
+		wantErr bool
+	}{
+		{name: "heads prefix", filter: "heads/release/", want: "heads/release/"},
+		{name: "refs prefix", filter: "refs/heads/main", want: "heads/main"},
+		{name: "tags", filter: "tags/", wantErr: true},
+		{name: "missing heads", filter: "release/", wantErr: true},
+		{name: "empty", filter: "", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ValidateBranchRefFilter(tc.filter)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestImportRepositoryWithBranchRefFilter(t *testing.T) {
+	var testCases = []struct {
+		name             string
+		defaultBranchRef string
+		existing         bool
+		wantBranches     []request.Branch
+		wantWarnings     []string
+	}{
+		{
+			name:             "default branch matches filter",
+			defaultBranchRef: "refs/heads/release/1.0",
+			wantBranches: []request.Branch{
+				{Name: "release/1.0", Default: true},
+				{Name: "release/2.0", Default: false},
+			},
+		},
+		{
+			name:             "default branch outside filter",
+			defaultBranchRef: "refs/heads/main",
+			wantBranches: []request.Branch{
+				{Name: "release/1.0", Default: false},
+				{Name: "release/2.0", Default: false},
+			},
+			wantWarnings: []string{"branch ref filter"},
+		},
+		{
+			name:             "existing project",
+			defaultBranchRef: "refs/heads/release/1.0",
+			existing:         true,
+			wantBranches: []request.Branch{
+				{Name: "release/1.0", Default: true},
+				{Name: "release/2.0", Default: false},
+			},
+			wantWarnings: []string{"were removed"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			wharf := &wharfapitest.Fake{}
+			if tc.existing {
+				wharf.Projects = []response.Project{{ProjectID: 1, GroupName: "Org/Proj", Name: "Repo"}}
+				wharf.Branches = map[uint][]request.Branch{1: {{Name: "main", Default: true}}}
+			}
+			i := azureImporter{
+				c:     c,
+				wharf: wharf,
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{
+							Repository: azureapi.Repository{
+								ID:               "repo-id",
+								Name:             "Repo",
+								Project:          azureapi.Project{ID: "proj-id", Name: "Proj"},
+								DefaultBranchRef: tc.defaultBranchRef,
+							},
+							Branches: []azureapi.Branch{
+								{Name: "main", Ref: "refs/heads/main"},
+								{Name: "feature/x", Ref: "refs/heads/feature/x"},
+								{Name: "release/1.0", Ref: "refs/heads/release/1.0"},
+								{Name: "release/2.0", Ref: "refs/heads/release/2.0"},
+							},
+							Files: map[string]string{".wharf-ci.yml": "build: {}"},
+						},
+					},
+				},
+				opts: Options{BranchRefFilter: "heads/release/"},
+			}
+
+			result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+
+			require.True(t, ok)
+			assert.Equal(t, tc.wantBranches, wharf.Branches[1])
+			require.Len(t, result.Warnings, len(tc.wantWarnings))
+			for idx, want := range tc.wantWarnings {
+				assert.Contains(t, result.Warnings[idx].Message, want)
+			}
+		})
+	}
+}
+
+func TestValidateBuildDefinitionPath(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "file in root", path: ".wharf-ci.yml", want: ".wharf-ci.yml"},
+		{name: "file in dir", path: "ci/.wharf-ci.yml", want: "ci/.wharf-ci.yml"},
+		{name: "leading dot slash", path: "./ci/.wharf-ci.yml", want: "ci/.wharf-ci.yml"},
+		{name: "absolute", path: "/ci/.wharf-ci.yml", wantErr: true},
+		{name: "absolute backslash", path: `\ci\.wharf-ci.yml`, wantErr: true},
+		{name: "parent dir", path: "../.wharf-ci.yml", wantErr: true},
+		{name: "parent dir in middle", path: "ci/../../.wharf-ci.yml", wantErr: true},
+		{name: "parent dir backslash", path: `ci\..\.wharf-ci.yml`, wantErr: true},
+		{name: "dot", path: ".", wantErr: true},
+		{name: "empty", path: "", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ValidateBuildDefinitionPath(tc.path)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestBuildDefinitionPathDefault(t *testing.T) {
+	i := azureImporter{}
+	assert.Equal(t, ".wharf-ci.yml", i.buildDefinitionPath())
+
+	i.opts.BuildDefinitionPath = "ci/.wharf-ci.yml"
+	assert.Equal(t, "ci/.wharf-ci.yml", i.buildDefinitionPath())
+}
+
+func TestValidateBuildDefinitionBranch(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		branch  string
+		want    string
+		wantErr bool
+	}{
+		{name: "branch name", branch: "feature/foo", want: "feature/foo"},
+		{name: "branch ref", branch: "refs/heads/feature/foo", want: "feature/foo"},
+		{name: "tag ref", branch: "refs/tags/v1.0.0", wantErr: true},
+		{name: "only prefix", branch: "refs/heads/", wantErr: true},
+		{name: "blank", branch: " ", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ValidateBuildDefinitionBranch(tc.branch)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestImportRepositoryWithBuildDefinitionBranch(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		branch       string
+		wantBuildDef string
+		wantSkipped  bool
+		wantWarning  string
+	}{
+		{
+			name:         "default branch",
+			wantBuildDef: "build: default\n",
+		},
+		{
+			name:         "other branch",
+			branch:       "feature/foo",
+			wantBuildDef: "build: feature\n",
+		},
+		{
+			name:        "missing on branch",
+			branch:      "feature/bar",
+			wantWarning: `No build definition file ".wharf-ci.yml" found on branch "feature/bar".`,
+		},
+		{
+			name:        "missing branch",
+			branch:      "feature/baz",
+			wantSkipped: true,
+			wantWarning: `Skipped as the build definition branch "feature/baz" was not found.`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			wharf := &wharfapitest.Fake{}
+			i := azureImporter{
+				c:     c,
+				wharf: wharf,
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{
+							Repository: azureapi.Repository{
+								ID:      "repo-id",
+								Name:    "Repo",
+								Project: azureapi.Project{ID: "proj-id", Name: "Proj"},
+							},
+							Files: map[string]string{
+								".wharf-ci.yml": "build: default\n",
+							},
+							BranchFiles: map[string]map[string]string{
+								"feature/foo": {".wharf-ci.yml": "build: feature\n"},
+								"feature/bar": {},
+							},
+						},
+					},
+				},
+				opts: Options{BuildDefinitionBranch: tc.branch},
+			}
+
+			result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+			require.True(t, ok)
+			if tc.wantSkipped {
+				assert.Empty(t, wharf.Projects)
+				assert.Equal(t, 1, result.ReposSkipped)
+			} else {
+				require.Len(t, wharf.Projects, 1)
+				assert.Equal(t, tc.wantBuildDef, wharf.Projects[0].BuildDefinition)
+			}
+			if tc.wantWarning != "" {
+				require.Len(t, result.Warnings, 1)
+				assert.Equal(t, tc.wantWarning, result.Warnings[0].Message)
+			}
+		})
+	}
+}
+
+func TestImportRepositoryWithBuildDefinitionPaths(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		paths        []string
+		wantBuildDef string
+		wantPath     string
+		wantWarning  string
+	}{
+		{
+			name:         "first found",
+			paths:        []string{".wharf-ci.yml", ".wharf-ci.dev.yml"},
+			wantBuildDef: "build: default\n",
+			wantPath:     ".wharf-ci.yml",
+		},
+		{
+			name:         "first missing",
+			paths:        []string{".wharf-ci.prod.yml", ".wharf-ci.dev.yml", ".wharf-ci.yml"},
+			wantBuildDef: "build: dev\n",
+			wantPath:     ".wharf-ci.dev.yml",
+		},
+		{
+			name:        "all missing",
+			paths:       []string{".wharf-ci.prod.yml", ".wharf-ci.test.yml"},
+			wantWarning: `No build definition files ".wharf-ci.prod.yml" or ".wharf-ci.test.yml" found.`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			wharf := &wharfapitest.Fake{}
+			i := azureImporter{
+				c:     c,
+				wharf: wharf,
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{
+							Repository: azureapi.Repository{
+								ID:      "repo-id",
+								Name:    "Repo",
+								Project: azureapi.Project{ID: "proj-id", Name: "Proj"},
+							},
+							Files: map[string]string{
+								".wharf-ci.yml":     "build: default\n",
+								".wharf-ci.dev.yml": "build: dev\n",
+							},
+						},
+					},
+				},
+				opts: Options{BuildDefinitionPaths: tc.paths},
+			}
+
+			result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+			require.True(t, ok)
+			require.Len(t, wharf.Projects, 1)
+			assert.Equal(t, tc.wantBuildDef, wharf.Projects[0].BuildDefinition)
+			if tc.wantPath != "" {
+				assert.Equal(t, []RepoBuildDefinition{
+					{Org: "Org", Project: "Proj", Repo: "Repo", WharfProjectID: wharf.Projects[0].ProjectID, Path: tc.wantPath},
+				}, result.BuildDefinitions)
+			} else {
+				assert.Empty(t, result.BuildDefinitions)
+			}
+			if tc.wantWarning != "" {
+				require.Len(t, result.Warnings, 1)
+				assert.Equal(t, tc.wantWarning, result.Warnings[0].Message)
+			}
+		})
+	}
+}
+
+func TestImportRepositorySkipsWithoutBuildDef(t *testing.T) {
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{
+					Repository: azureapi.Repository{
+						ID:      "repo-id",
+						Name:    "Repo",
+						Project: azureapi.Project{ID: "proj-id", Name: "Proj"},
+					},
+				},
+			},
+		},
+		opts: Options{SkipReposWithoutBuildDef: true},
+	}
+
+	result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+
+	assert.True(t, ok)
+	assert.Equal(t, 0, result.ProjectsCreated)
+	assert.Equal(t, 1, result.ReposSkipped)
+	assert.Len(t, result.Warnings, 1, "warning about skipped repository")
+}
+
+func TestNormalizeProviderURL(t *testing.T) {
+	var testCases = []struct {
+		name    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{name: "host only", url: "https://dev.azure.com", want: "https://dev.azure.com"},
+		{name: "trailing slash", url: "https://dev.azure.com/", want: "https://dev.azure.com"},
+		{name: "multiple trailing slashes", url: "https://server/tfs/DefaultCollection//", want: "https://server/tfs/DefaultCollection"},
+		{name: "uppercase scheme", url: "HTTP://server/tfs", want: "http://server/tfs"},
+		{name: "uppercase host", url: "https://DEV.Azure.com/MyOrg", want: "https://dev.azure.com/MyOrg"},
+		{name: "surrounding whitespace", url: " https://dev.azure.com ", want: "https://dev.azure.com"},
+		{name: "missing scheme", url: "dev.azure.com/myorg", wantErr: true},
+		{name: "unsupported scheme", url: "ssh://dev.azure.com", wantErr: true},
+		{name: "missing host", url: "https:///myorg", wantErr: true},
+		{name: "empty", url: "", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NormalizeProviderURL(tc.url)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got.String())
+		})
+	}
+}
+
+func TestProviderURLsEqual(t *testing.T) {
+	assert.True(t, ProviderURLsEqual("https://dev.azure.com/org", "https://dev.azure.com/org/"), "trailing slash")
+	assert.True(t, ProviderURLsEqual("https://DEV.azure.com/org", "https://dev.azure.com/org"), "host case")
+	assert.False(t, ProviderURLsEqual("https://dev.azure.com/org", "https://dev.azure.com/other"), "other path")
+	assert.False(t, ProviderURLsEqual("https://dev.azure.com/org", "https://dev.azure.com/ORG"), "path case")
+	assert.True(t, ProviderURLsEqual("not a url", "not a url"), "identical invalid")
+	assert.False(t, ProviderURLsEqual("not a url", "https://dev.azure.com"), "invalid")
+}
+
+func TestGetOrPostProviderWithFakeWharfMatchesNormalizedURL(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	wharf := &wharfapitest.Fake{
+		Providers: []response.Provider{{ProviderID: 1, Name: "azuredevops", URL: "https://Dev.Azure.com/org/"}},
+	}
+	i := azureImporter{c: c, wharf: wharf}
+
+	provider, ok := i.getOrPostProviderWritesProblem(ProviderData{
+		ReqProvider: ReqProvider{Name: "azuredevops", URL: "https://dev.azure.com/org"},
+	})
+	require.True(t, ok)
+	assert.Equal(t, uint(1), provider.ProviderID, "found provider with unnormalized URL")
+	assert.Len(t, wharf.Providers, 1)
+}
+
+func TestVisibilityFilterMatches(t *testing.T) {
+	var testCases = []struct {
+		filter     VisibilityFilter
+		visibility string
+		want       bool
+	}{
+		{filter: "", visibility: "public", want: true},
+		{filter: VisibilityFilterAll, visibility: "public", want: true},
+		{filter: VisibilityFilterAll, visibility: "private", want: true},
+		{filter: VisibilityFilterPrivate, visibility: "private", want: true},
+		{filter: VisibilityFilterPrivate, visibility: "public", want: false},
+		{filter: VisibilityFilterPrivate, visibility: "", want: true},
+		{filter: VisibilityFilterPublic, visibility: "public", want: true},
+		{filter: VisibilityFilterPublic, visibility: "private", want: false},
+		{filter: VisibilityFilterPublic, visibility: "", want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%s", tc.filter, tc.visibility), func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.filter.matches(tc.visibility))
+		})
+	}
+}
+
+func TestImportProjectSkipsByVisibility(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c: c,
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{
+					Repository: azureapi.Repository{
+						ID:      "repo-id",
+						Name:    "Repo",
+						Project: azureapi.Project{ID: "proj-id", Name: "Proj", Visibility: "public"},
+					},
+				},
+			},
+		},
+		opts: Options{VisibilityFilter: VisibilityFilterPrivate},
+	}
+
+	result, ok := i.ImportProjectWritesProblem("Org", "Proj")
+
+	assert.True(t, ok)
+	assert.Equal(t, 0, result.ProjectsCreated)
+	if assert.Len(t, result.SkippedProjects, 1) {
+		assert.Equal(t, "Proj", result.SkippedProjects[0].Project)
+	}
+}
+
+func TestImportRepositoryListsTags(t *testing.T) {
+	wharfServer := newTestWharfServer(t)
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{
+					Repository: azureapi.Repository{
+						ID:      "repo-id",
+						Name:    "Repo",
+						Project: azureapi.Project{ID: "proj-id", Name: "Proj"},
+					},
+					Tags: []azureapi.Tag{
+						{Name: "v1.0.0", Ref: "refs/tags/v1.0.0", ObjectID: "abc123"},
+					},
+				},
+			},
+		},
+		opts: Options{ImportTags: true},
+	}
+
+	result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+
+	assert.True(t, ok)
+	assert.Equal(t, []RepoTag{
+		{
+			Org:            "Org",
+			Project:        "Proj",
+			Repo:           "Repo",
+			WharfProjectID: 1,
+			Name:           "v1.0.0",
+			Ref:            "refs/tags/v1.0.0",
+			ObjectID:       "abc123",
+		},
+	}, result.Tags)
+}
+
+func TestImportRepositoryListsBranchProtection(t *testing.T) {
+	wharfServer := newTestWharfServer(t)
+	defer wharfServer.Close()
+
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c:     c,
+		wharf: &wharfapi.Client{APIURL: wharfServer.URL},
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{
+					Repository: azureapi.Repository{
+						ID:               "repo-id",
+						Name:             "Repo",
+						Project:          azureapi.Project{ID: "proj-id", Name: "Proj"},
+						DefaultBranchRef: "refs/heads/main",
+					},
+					BranchPolicies: map[string][]azureapi.PolicyConfiguration{
+						"refs/heads/main": {
+							{ID: 1, IsEnabled: true, IsBlocking: true, Type: azureapi.PolicyType{DisplayName: "Minimum number of reviewers"}},
+							{ID: 2, IsEnabled: false, IsBlocking: true, Type: azureapi.PolicyType{DisplayName: "Build"}},
+						},
+					},
+				},
+			},
+		},
+		opts: Options{ImportBranchProtection: true},
+	}
+
+	result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+
+	assert.True(t, ok)
+	assert.Equal(t, []BranchProtection{
+		{
+			Org:                 "Org",
+			Project:             "Proj",
+			Repo:                "Repo",
+			WharfProjectID:      1,
+			Branch:              "main",
+			RequiresPullRequest: true,
+			Policies:            []string{"Minimum number of reviewers"},
+		},
+	}, result.BranchProtections)
+}
+
+var _ WharfClient = &wharfapitest.Fake{}
+
+func TestImportRepositoryWithFakeWharf(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	wharf := &wharfapitest.Fake{}
+	i := azureImporter{
+		c:     c,
+		wharf: wharf,
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{
+					Repository: azureapi.Repository{
+						ID:               "repo-id",
+						Name:             "Repo",
+						Project:          azureapi.Project{ID: "proj-id", Name: "Proj"},
+						DefaultBranchRef: "refs/heads/main",
+					},
+					Branches: []azureapi.Branch{
+						{Name: "main", Ref: "refs/heads/main"},
+						{Name: "dev", Ref: "refs/heads/dev"},
+					},
+				},
+			},
+		},
+	}
+
+	result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+	require.True(t, ok)
+	assert.Equal(t, 1, result.ProjectsCreated)
+
+	// Clearing the project cache to make the importer look up the project again.
+	i.projects = projectCache{}
+	result, ok = i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+	require.True(t, ok)
+	assert.Equal(t, 1, result.ProjectsUpdated)
+
+	require.Len(t, wharf.Projects, 1)
+	assert.Equal(t, "Repo", wharf.Projects[0].Name)
+	assert.Equal(t, "Org/Proj", wharf.Projects[0].GroupName)
+	assert.Equal(t, []request.Branch{
+		{Name: "main", Default: true},
+		{Name: "dev", Default: false},
+	}, wharf.Branches[wharf.Projects[0].ProjectID])
+}
+
+func TestGetOrPostTokenWithFakeWharfOnlyUpdatesProviderToken(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		providerTokenID uint
+		wantTokenID     uint
+		wantTokens      []response.Token
+	}{
+		{
+			name:            "provider token of same user",
+			providerTokenID: 1,
+			wantTokenID:     1,
+			wantTokens: []response.Token{
+				{TokenID: 1, UserName: "user", Token: "new"},
+				{TokenID: 2, UserName: "other", Token: "other"},
+			},
+		},
+		{
+			name:        "no provider token",
+			wantTokenID: 3,
+			wantTokens: []response.Token{
+				{TokenID: 1, UserName: "user", Token: "old"},
+				{TokenID: 2, UserName: "other", Token: "other"},
+				{TokenID: 3, UserName: "user", Token: "new"},
+			},
+		},
+		{
+			name:            "provider token of other user",
+			providerTokenID: 2,
+			wantTokenID:     3,
+			wantTokens: []response.Token{
+				{TokenID: 1, UserName: "user", Token: "old"},
+				{TokenID: 2, UserName: "other", Token: "other"},
+				{TokenID: 3, UserName: "user", Token: "new"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			wharf := &wharfapitest.Fake{
+				Tokens: []response.Token{
+					{TokenID: 1, UserName: "user", Token: "old"},
+					{TokenID: 2, UserName: "other", Token: "other"},
+				},
+			}
+			i := azureImporter{c: c, wharf: wharf}
+
+			token, ok := i.getOrPostTokenWritesProblem(TokenData{ReqToken: ReqToken{UserName: "user", Token: "new"}}, tc.providerTokenID)
+
+			require.True(t, ok)
+			assert.Equal(t, tc.wantTokenID, token.TokenID)
+			assert.Equal(t, tc.wantTokens, wharf.Tokens)
+		})
+	}
+}
+
+func TestCreateOrUpdateWharfProjectMigration(t *testing.T) {
+	repo := azureapi.Repository{
+		ID:        "repo-id",
+		Name:      "Repo",
+		Project:   azureapi.Project{ID: "proj-id", Name: "Proj"},
+		SSHURL:    "git@ssh.dev.azure.com:v3/Org/Proj/Repo",
+		RemoteURL: "https://dev.azure.com/Org/Proj/_git/Repo",
+	}
+	var testCases = []struct {
+		name         string
+		existing     []response.Project
+		wantCreated  bool
+		wantProjects []response.Project
+	}{
+		{
+			name:        "no existing project",
+			wantCreated: true,
+			wantProjects: []response.Project{
+				{ProjectID: 1, Name: "Repo", GroupName: "Org/Proj", GitURL: repo.SSHURL},
+			},
+		},
+		{
+			name: "existing v2 project",
+			existing: []response.Project{
+				{ProjectID: 1, Name: "Repo", GroupName: "Org/Proj", GitURL: repo.SSHURL},
+			},
+			wantProjects: []response.Project{
+				{ProjectID: 1, Name: "Repo", GroupName: "Org/Proj", GitURL: repo.SSHURL},
+			},
+		},
+		{
+			name: "existing v1 project",
+			existing: []response.Project{
+				{ProjectID: 1, Name: "Proj", GroupName: "Org", GitURL: repo.SSHURL},
+			},
+			wantProjects: []response.Project{
+				{ProjectID: 1, Name: "Repo", GroupName: "Org/Proj", GitURL: repo.SSHURL},
+			},
+		},
+		{
+			name: "existing v1 project with HTTPS git URL",
+			existing: []response.Project{
+				{ProjectID: 1, Name: "Proj", GroupName: "Org", GitURL: repo.RemoteURL},
+			},
+			wantProjects: []response.Project{
+				{ProjectID: 1, Name: "Repo", GroupName: "Org/Proj", GitURL: repo.SSHURL},
+			},
+		},
+		{
+			name: "existing v1 project of other repository",
+			existing: []response.Project{
+				{ProjectID: 1, Name: "Proj", GroupName: "Org", GitURL: "git@ssh.dev.azure.com:v3/Org/Proj/Other"},
+			},
+			wantCreated: true,
+			wantProjects: []response.Project{
+				{ProjectID: 1, Name: "Proj", GroupName: "Org", GitURL: "git@ssh.dev.azure.com:v3/Org/Proj/Other"},
+				{ProjectID: 2, Name: "Repo", GroupName: "Org/Proj", GitURL: repo.SSHURL},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wharf := &wharfapitest.Fake{Projects: tc.existing}
+			i := azureImporter{wharf: wharf}
+
+			project, created, err := i.createOrUpdateWharfProject("Org", repo, "")
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCreated, created)
+			assert.Equal(t, tc.wantProjects[len(tc.wantProjects)-1].ProjectID, project.ProjectID)
+			require.Len(t, wharf.Projects, len(tc.wantProjects))
+			for idx, want := range tc.wantProjects {
+				got := wharf.Projects[idx]
+				assert.Equal(t, want.ProjectID, got.ProjectID)
+				assert.Equal(t, want.Name, got.Name)
+				assert.Equal(t, want.GroupName, got.GroupName)
+				assert.Equal(t, want.GitURL, got.GitURL)
+			}
+		})
+	}
+}
+
+func TestImportRepositoryUsesReadmeDescription(t *testing.T) {
+	var testCases = []struct {
+		name               string
+		projectDescription string
+		wantDescription    string
+	}{
+		{
+			name:            "blank project description",
+			wantDescription: "Does things.\n\nAzure DevOps repository \"Repo\" in project \"Proj\".",
+		},
+		{
+			name:               "project description",
+			projectDescription: "Project things.",
+			wantDescription:    "Project things.\n\nAzure DevOps repository \"Repo\" in project \"Proj\".",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			wharf := &wharfapitest.Fake{}
+			i := azureImporter{
+				c:     c,
+				wharf: wharf,
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{
+							Repository: azureapi.Repository{
+								ID:      "repo-id",
+								Name:    "Repo",
+								Project: azureapi.Project{ID: "proj-id", Name: "Proj", Description: tc.projectDescription},
+							},
+							Files: map[string]string{
+								"README.md": "# Repo\n\nDoes things.\n",
+							},
+						},
+					},
+				},
+				opts: Options{UseReadmeDescription: true},
+			}
+
+			_, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+			require.True(t, ok)
+			require.Len(t, wharf.Projects, 1)
+			assert.Equal(t, tc.wantDescription, wharf.Projects[0].Description)
+		})
+	}
+}
+
+func TestImportProjectWithMultipleRepositories(t *testing.T) {
+	newFakeAzure := func() *azureapitest.Fake {
+		project := azureapi.Project{ID: "proj-id", Name: "Proj", State: azureapi.ProjectStateWellFormed}
+		return &azureapitest.Fake{
+			Projects: []azureapi.Project{project},
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-a", Name: "RepoA", Project: project, SSHURL: "ssh://a"}},
+				{Repository: azureapi.Repository{ID: "repo-b", Name: "RepoB", Project: project, SSHURL: "ssh://b"}},
+				{Repository: azureapi.Repository{ID: "repo-c", Name: "Proj", Project: project, SSHURL: "ssh://c"}},
+			},
+		}
+	}
+	var testCases = []struct {
+		name     string
+		importFn func(i *azureImporter) (ImportResult, bool)
+	}{
+		{
+			name: "project import",
+			importFn: func(i *azureImporter) (ImportResult, bool) {
+				return i.ImportProjectWritesProblem("Org", "Proj")
+			},
+		},
+		{
+			name: "organization import",
+			importFn: func(i *azureImporter) (ImportResult, bool) {
+				return i.ImportOrganizationWritesProblem("Org")
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			wharf := &wharfapitest.Fake{}
+			i := &azureImporter{c: c, wharf: wharf, azure: newFakeAzure()}
+
+			result, ok := tc.importFn(i)
+			require.True(t, ok)
+			assert.Equal(t, 3, result.ProjectsCreated)
+
+			// Importing again must update, and not duplicate, the projects.
+			i.projects = projectCache{}
+			result, ok = tc.importFn(i)
+			require.True(t, ok)
+			assert.Equal(t, 3, result.ProjectsUpdated)
+
+			var names []string
+			for _, p := range wharf.Projects {
+				assert.Equal(t, "Org/Proj", p.GroupName)
+				names = append(names, p.Name)
+			}
+			assert.ElementsMatch(t, []string{"RepoA", "RepoB", "Proj"}, names)
+		})
+	}
+}
+
+func TestGetOrPostProviderWithFakeWharfMatchesProviderName(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	wharf := &wharfapitest.Fake{
+		Providers: []response.Provider{{ProviderID: 1, Name: "azuredevops", URL: "https://server"}},
+	}
+	i := azureImporter{c: c, wharf: wharf}
+
+	provider, ok := i.getOrPostProviderWritesProblem(ProviderData{
+		ReqProvider: ReqProvider{Name: "azuredevops-onprem", URL: "https://server"},
+	})
+	require.True(t, ok)
+	assert.Equal(t, uint(2), provider.ProviderID, "created new provider")
+
+	provider, ok = i.getOrPostProviderWritesProblem(ProviderData{
+		ReqProvider: ReqProvider{Name: "azuredevops-onprem", URL: "https://server"},
+	})
+	require.True(t, ok)
+	assert.Equal(t, uint(2), provider.ProviderID, "found created provider")
+	assert.Len(t, wharf.Providers, 2)
+}
+
+func TestImportProjectSkipsDisabledRepos(t *testing.T) {
+	var testCases = []struct {
+		name             string
+		includeDisabled  bool
+		wantCreated      int
+		wantReposSkipped int
+	}{
+		{name: "skipped by default", wantCreated: 1, wantReposSkipped: 1},
+		{name: "included", includeDisabled: true, wantCreated: 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			project := azureapi.Project{ID: "proj-id", Name: "Proj"}
+			i := azureImporter{
+				c:     c,
+				wharf: &wharfapitest.Fake{},
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{Repository: azureapi.Repository{ID: "repo-a", Name: "RepoA", Project: project}},
+						{Repository: azureapi.Repository{ID: "repo-b", Name: "RepoB", Project: project, IsDisabled: true}},
+					},
+				},
+				opts: Options{IncludeDisabledRepos: tc.includeDisabled},
+			}
+
+			result, ok := i.ImportProjectWritesProblem("Org", "Proj")
+
+			require.True(t, ok)
+			assert.Equal(t, tc.wantCreated, result.ProjectsCreated)
+			assert.Equal(t, tc.wantReposSkipped, result.ReposSkipped)
+		})
+	}
+}
+
+func TestImportProjectIncludeHidden(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		includeHidden   bool
+		wantCreated     int
+		wantHiddenRepos []HiddenRepo
+	}{
+		{name: "excluded by default", wantCreated: 1},
+		{
+			name:            "included",
+			includeHidden:   true,
+			wantCreated:     2,
+			wantHiddenRepos: []HiddenRepo{{Org: "Org", Project: "Proj", Repo: "RepoB", WharfProjectID: 2}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			project := azureapi.Project{ID: "proj-id", Name: "Proj"}
+			i := azureImporter{
+				c:     c,
+				wharf: &wharfapitest.Fake{},
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{Repository: azureapi.Repository{ID: "repo-a", Name: "RepoA", Project: project}},
+						{Repository: azureapi.Repository{ID: "repo-b", Name: "RepoB", Project: project}, Hidden: true},
+					},
+				},
+				opts: Options{IncludeHidden: tc.includeHidden},
+			}
+
+			result, ok := i.ImportProjectWritesProblem("Org", "Proj")
+
+			require.True(t, ok)
+			assert.Equal(t, tc.wantCreated, result.ProjectsCreated)
+			assert.Equal(t, tc.wantHiddenRepos, result.HiddenRepos)
+		})
+	}
+}
+
+func TestImportProjectWithMaxRepoSize(t *testing.T) {
+	var testCases = []struct {
+		name             string
+		maxRepoSizeBytes int64
+		wantCreated      int
+		wantReposSkipped int
+		wantTotalSize    int64
+	}{
+		{name: "no limit", wantCreated: 2, wantTotalSize: 3072},
+		{name: "limit", maxRepoSizeBytes: 1024, wantCreated: 1, wantReposSkipped: 1, wantTotalSize: 1024},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			project := azureapi.Project{ID: "proj-id", Name: "Proj"}
+			i := azureImporter{
+				c:     c,
+				wharf: &wharfapitest.Fake{},
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{Repository: azureapi.Repository{ID: "repo-a", Name: "RepoA", Project: project, Size: 1024}},
+						{Repository: azureapi.Repository{ID: "repo-b", Name: "RepoB", Project: project, Size: 2048}},
+					},
+				},
+				opts: Options{MaxRepoSizeBytes: tc.maxRepoSizeBytes},
+			}
+
+			result, ok := i.ImportProjectWritesProblem("Org", "Proj")
+
+			require.True(t, ok)
+			assert.Equal(t, tc.wantCreated, result.ProjectsCreated)
+			assert.Equal(t, tc.wantReposSkipped, result.ReposSkipped)
+			assert.Equal(t, tc.wantTotalSize, result.TotalRepoSizeBytes)
+			require.Len(t, result.RepoSizes, tc.wantCreated)
+			assert.Equal(t, RepoSize{Org: "Org", Project: "Proj", Repo: "RepoA", WharfProjectID: 1, SizeBytes: 1024}, result.RepoSizes[0])
+		})
+	}
+}
+
+func TestImportProjectWithProcessTemplates(t *testing.T) {
+	var testCases = []struct {
+		name                   string
+		importProcessTemplates bool
+		want                   []ImportedProject
+	}{
+		{name: "not requested"},
+		{
+			name:                   "requested",
+			importProcessTemplates: true,
+			want: []ImportedProject{
+				{Org: "Org", Project: "Proj", ProjectID: "proj-id", ProcessTemplate: "Scrum"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			// Repositories only reference their project, without its
+			// capabilities.
+			project := azureapi.Project{ID: "proj-id", Name: "Proj"}
+			projectWithCapabilities := project
+			projectWithCapabilities.Capabilities = &azureapi.ProjectCapabilities{
+				ProcessTemplate: azureapi.ProcessTemplate{TemplateName: "Scrum"},
+			}
+			i := azureImporter{
+				c:     c,
+				wharf: &wharfapitest.Fake{},
+				azure: &azureapitest.Fake{
+					Projects: []azureapi.Project{projectWithCapabilities},
+					Repositories: []azureapitest.Repository{
+						{Repository: azureapi.Repository{ID: "repo-a", Name: "RepoA", Project: project}},
+						{Repository: azureapi.Repository{ID: "repo-b", Name: "RepoB", Project: project}},
+					},
+				},
+				opts: Options{ImportProcessTemplates: tc.importProcessTemplates},
+			}
+
+			result, ok := i.ImportProjectWritesProblem("Org", "Proj")
+
+			require.True(t, ok)
+			assert.Equal(t, 2, result.ProjectsCreated)
+			assert.Equal(t, tc.want, result.Projects)
+		})
+	}
+}
+
+func TestRefreshRepositoryWithProcessTemplates(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	project := azureapi.Project{ID: "proj-id", Name: "Proj"}
+	projectWithCapabilities := project
+	projectWithCapabilities.Capabilities = &azureapi.ProjectCapabilities{
+		ProcessTemplate: azureapi.ProcessTemplate{TemplateName: "Agile"},
+	}
+	i := azureImporter{
+		c: c,
+		wharf: &wharfapitest.Fake{
+			Projects: []response.Project{{ProjectID: 1, GroupName: "Org/Proj", Name: "Repo",
+				GitURL: "git@ssh.dev.azure.com:v3/Org/Proj/Repo"}},
+		},
+		azure: &azureapitest.Fake{
+			Projects: []azureapi.Project{projectWithCapabilities},
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-id", Name: "Repo", Project: project}},
+			},
+		},
+		opts: Options{ImportProcessTemplates: true},
+	}
+
+	result, ok := i.RefreshRepositoryWritesProblem("Org", "Proj", "Repo", 1)
+
+	require.True(t, ok)
+	assert.Equal(t, []ImportedProject{
+		{Org: "Org", Project: "Proj", ProjectID: "proj-id", ProcessTemplate: "Agile"},
+	}, result.Projects)
+}
+
+// projectlessAzure fails to get any project through the fake.
+type projectlessAzure struct {
+	*azureapitest.Fake
+}
+
+func (a projectlessAzure) GetProjectWritesProblem(orgName, projectNameOrID string) (azureapi.Project, bool) {
+	return azureapi.Project{}, false
+}
+
+func TestImportRepositoryWithProcessTemplatesFailsBeforeWriting(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	wharf := &wharfapitest.Fake{}
+	i := azureImporter{
+		c:     c,
+		wharf: wharf,
+		azure: projectlessAzure{&azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-id", Name: "Repo",
+					Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+			},
+		}},
+		opts: Options{ImportProcessTemplates: true},
+	}
+
+	_, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+
+	assert.False(t, ok)
+	assert.Empty(t, wharf.Projects)
+}
+
+func TestDedupBranchesByName(t *testing.T) {
+	// Branches whose names are not their refs trimmed of "refs/heads/", which
+	// azureapi.Client never lists, but other fetchers might.
+	branches := []azureapi.Branch{
+		{Name: "main", Ref: "refs/heads/mirror/main"},
+		{Name: "feature", Ref: "refs/heads/feature"},
+		{Name: "main", Ref: "refs/heads/main"},
+		{Name: "feature", Ref: "refs/heads/mirror/feature"},
+	}
+
+	got, collisions := dedupBranchesByName(branches)
+
+	assert.Equal(t, []azureapi.Branch{
+		{Name: "main", Ref: "refs/heads/main"},
+		{Name: "feature", Ref: "refs/heads/feature"},
+	}, got)
+	assert.Equal(t, []branchCollision{
+		{kept: branches[2], skipped: branches[0]},
+		{kept: branches[1], skipped: branches[3]},
+	}, collisions)
+}
+
+func TestImportRepositoryDedupsBranchNames(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	wharf := &wharfapitest.Fake{}
+	i := azureImporter{
+		c:     c,
+		wharf: wharf,
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{
+					Repository: azureapi.Repository{
+						ID:               "repo-id",
+						Name:             "Repo",
+						DefaultBranchRef: "refs/heads/main",
+						Project:          azureapi.Project{ID: "proj-id", Name: "Proj"},
+					},
+					// Colliding names, which azureapi.Client never lists.
+					Branches: []azureapi.Branch{
+						{Name: "main", Ref: "refs/heads/mirror/main"},
+						{Name: "main", Ref: "refs/heads/main"},
+					},
+					Files: map[string]string{".wharf-ci.yml": "build: {}\n"},
+				},
+			},
+		},
+	}
+
+	result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+
+	require.True(t, ok)
+	assert.Equal(t, 1, result.BranchesCreated)
+	require.Len(t, wharf.Projects, 1)
+	assert.Equal(t, []request.Branch{{Name: "main", Default: true}}, wharf.Branches[wharf.Projects[0].ProjectID])
+	require.Len(t, result.Warnings, 1)
+	assert.Equal(t, `Skipped branch ref "refs/heads/mirror/main", as its branch name "main" collides with ref "refs/heads/main".`,
+		result.Warnings[0].Message)
+}
+
+func TestValidateTargetGroup(t *testing.T) {
+	testCases := []struct {
+		name    string
+		group   string
+		want    string
+		wantErr bool
+	}{
+		{name: "single segment", group: "platform", want: "platform"},
+		{name: "nested", group: "platform/backend", want: "platform/backend"},
+		{name: "trims whitespace", group: "  platform/backend ", want: "platform/backend"},
+		{name: "empty", group: "  ", wantErr: true},
+		{name: "leading slash", group: "/platform", wantErr: true},
+		{name: "trailing slash", group: "platform/", wantErr: true},
+		{name: "empty segment", group: "platform//backend", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ValidateTargetGroup(tc.group)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestImportRepositoryWithTargetGroup(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	wharf := &wharfapitest.Fake{
+		Projects: []response.Project{
+			{ProjectID: 1, Name: "Repo", GroupName: "Org/Proj"},
+		},
+	}
+	i := azureImporter{
+		c:     c,
+		wharf: wharf,
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{
+					Repository: azureapi.Repository{
+						ID:      "repo-id",
+						Name:    "Repo",
+						Project: azureapi.Project{ID: "proj-id", Name: "Proj"},
+					},
+					Files: map[string]string{".wharf-ci.yml": "build: {}\n"},
+				},
+				{
+					Repository: azureapi.Repository{
+						ID:      "other-repo-id",
+						Name:    "Other",
+						Project: azureapi.Project{ID: "proj-id", Name: "Proj"},
+					},
+					Files: map[string]string{".wharf-ci.yml": "build: {}\n"},
+				},
+			},
+		},
+		opts: Options{TargetGroup: "platform/backend"},
+	}
+
+	_, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+	require.True(t, ok)
+	result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Other")
+	require.True(t, ok)
+
+	assert.Equal(t, 1, result.ProjectsCreated)
+	require.Len(t, wharf.Projects, 2)
+	assert.Equal(t, uint(1), wharf.Projects[0].ProjectID)
+	assert.Equal(t, "Repo", wharf.Projects[0].Name)
+	assert.Equal(t, "platform/backend", wharf.Projects[0].GroupName)
+	assert.Equal(t, "Other", wharf.Projects[1].Name)
+	assert.Equal(t, "platform/backend", wharf.Projects[1].GroupName)
+}
@@ -0,0 +1,96 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// GroupingStrategy is an enum of how imported repositories are grouped into
+// Wharf groups.
+type GroupingStrategy string
+
+const (
+	// GroupingOrgProject uses the group "{org}/{project}" and the repository
+	// name as project name.
+	GroupingOrgProject GroupingStrategy = "org-project"
+	// GroupingOrgOnly uses the group "{org}" and folds the Azure DevOps
+	// project into the project name, as "{project}-{repo}".
+	GroupingOrgOnly GroupingStrategy = "org-only"
+)
+
+// NameTemplateData is the data given to the templates of Wharf project and
+// group names.
+type NameTemplateData struct {
+	// Org is the name of the Azure DevOps organization.
+	Org string
+	// Project is the name of the Azure DevOps project.
+	Project string
+	// Repo is the name of the Azure DevOps repository.
+	Repo string
+}
+
+// ParseNameTemplate parses a template of Wharf project or group names, such
+// as "{{.Org}}-{{.Project}}-{{.Repo}}". The template is also executed with
+// example data to catch references to unknown fields.
+func ParseNameTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := executeNameTemplate(tmpl, NameTemplateData{Org: "Org", Project: "Project", Repo: "Repo"}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func executeNameTemplate(tmpl *template.Template, data NameTemplateData) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(sb.String())
+	if name == "" {
+		return "", errors.New("template resulted in an empty name")
+	}
+	return name, nil
+}
+
+// wharfProjectNames returns the group and project names of the Wharf project
+// of an imported repository, based on the grouping strategy, name templates,
+// and target group in the Options.
+func (i *azureImporter) wharfProjectNames(orgName, projectName, repoName string) (groupName, name string, err error) {
+	data := NameTemplateData{Org: orgName, Project: projectName, Repo: repoName}
+	switch i.opts.GroupingStrategy {
+	case GroupingOrgOnly:
+		groupName = orgName
+		name = fmt.Sprintf("%s-%s", projectName, repoName)
+	default:
+		groupName, name = orgProjectNames(orgName, projectName, repoName)
+	}
+	if i.opts.GroupNameTemplate != nil {
+		groupName, err = executeNameTemplate(i.opts.GroupNameTemplate, data)
+		if err != nil {
+			return "", "", fmt.Errorf("execute group name template: %w", err)
+		}
+	}
+	if i.opts.TargetGroup != "" {
+		groupName = i.opts.TargetGroup
+	}
+	if i.opts.ProjectNameTemplate != nil {
+		name, err = executeNameTemplate(i.opts.ProjectNameTemplate, data)
+		if err != nil {
+			return "", "", fmt.Errorf("execute project name template: %w", err)
+		}
+	}
+	return groupName, name, nil
+}
+
+// orgProjectNames returns the group and project names of the
+// GroupingOrgProject strategy, which are also the names used by
+// wharf-provider-azuredevops v2.0.0 and later before the names could be
+// configured.
+func orgProjectNames(orgName, projectName, repoName string) (groupName, name string) {
+	return fmt.Sprintf("%s/%s", orgName, projectName), repoName
+}
@@ -0,0 +1,148 @@
+package importer
+
+import (
+	"sync"
+
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
+)
+
+// wharfProjectsPageSize is the number of Wharf projects fetched per request
+// when listing the projects of a group.
+const wharfProjectsPageSize = 100
+
+// projectCacheKey holds the same fields as used when searching for existing
+// Wharf projects.
+type projectCacheKey struct {
+	name       string
+	groupName  string
+	providerID uint
+}
+
+func (key projectCacheKey) group() projectGroupKey {
+	return projectGroupKey{groupName: key.groupName, providerID: key.providerID}
+}
+
+// projectGroupKey identifies the Wharf projects of a single group and
+// provider.
+type projectGroupKey struct {
+	groupName  string
+	providerID uint
+}
+
+// projectCache remembers the Wharf projects of each group and provider that
+// projects are imported into during a single import, so that the projects of
+// a group are listed from the Wharf API once, instead of searching once per
+// imported repository. Projects written during the import are added to the
+// cache.
+//
+// The zero value is ready to use.
+type projectCache struct {
+	mu     sync.Mutex
+	groups map[projectGroupKey]*projectCacheGroup
+}
+
+type projectCacheGroup struct {
+	mu       sync.Mutex
+	loaded   bool
+	projects []response.Project
+}
+
+func (pc *projectCache) group(key projectGroupKey) *projectCacheGroup {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.groups == nil {
+		pc.groups = map[projectGroupKey]*projectCacheGroup{}
+	}
+	g, ok := pc.groups[key]
+	if !ok {
+		g = &projectCacheGroup{}
+		pc.groups[key] = g
+	}
+	return g
+}
+
+// list returns the Wharf projects of the group and provider, using the
+// listGroup function to list them the first time the group is listed.
+func (pc *projectCache) list(key projectGroupKey, listGroup func(projectGroupKey) ([]response.Project, error)) ([]response.Project, error) {
+	g := pc.group(key)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.loaded {
+		projects, err := listGroup(key)
+		if err != nil {
+			return nil, err
+		}
+		g.projects = projects
+		g.loaded = true
+	}
+	return append([]response.Project{}, g.projects...), nil
+}
+
+// set adds or replaces the written Wharf project in its group, and removes it
+// from any other group it was moved from.
+func (pc *projectCache) set(project response.Project) {
+	pc.mu.Lock()
+	groups := make(map[projectGroupKey]*projectCacheGroup, len(pc.groups))
+	for key, g := range pc.groups {
+		groups[key] = g
+	}
+	pc.mu.Unlock()
+
+	key := projectGroupKey{groupName: project.GroupName, providerID: project.ProviderID}
+	for groupKey, g := range groups {
+		g.mu.Lock()
+		kept := g.projects[:0]
+		for _, p := range g.projects {
+			if p.ProjectID != project.ProjectID {
+				kept = append(kept, p)
+			}
+		}
+		g.projects = kept
+		if groupKey == key && g.loaded {
+			g.projects = append(g.projects, project)
+		}
+		g.mu.Unlock()
+	}
+}
+
+// reset forgets all cached projects, so that each group is listed again the
+// next time it is searched.
+func (pc *projectCache) reset() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.groups = nil
+}
+
+// listWharfGroupProjects lists all Wharf projects in the group of the
+// provider, one page at a time. The search may match on substrings, such as
+// a search for group "Org/Common" also matching "OtherOrg/Common", so only
+// exact matches are returned.
+func (i *azureImporter) listWharfGroupProjects(key projectGroupKey) ([]response.Project, error) {
+	var projects []response.Project
+	limit := wharfProjectsPageSize
+	for offset := 0; ; offset += limit {
+		page, err := i.wharf.GetProjectList(wharfapi.ProjectSearch{
+			GroupName:  &key.groupName,
+			ProviderID: &key.providerID,
+			Limit:      &limit,
+			Offset:     &offset,
+		})
+		if err != nil {
+			i.log().Error().
+				WithError(err).
+				WithString("groupName", key.groupName).
+				WithUint("providerId", key.providerID).
+				Message("Unable to list projects of group.")
+			return nil, err
+		}
+		for _, project := range page.List {
+			if project.GroupName == key.groupName && project.ProviderID == key.providerID {
+				projects = append(projects, project)
+			}
+		}
+		if len(page.List) < limit || int64(offset+len(page.List)) >= page.TotalCount {
+			return projects, nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package importer
+
+import (
+	"strings"
+)
+
+const readmeFileName = "README.md"
+
+// readmeSummary returns the first paragraph of a Markdown document, such as a
+// repository's README.md, to be used as a project description. Headings,
+// images, badges, HTML, and code blocks are skipped. The first heading is
+// returned if the document has no paragraphs.
+func readmeSummary(markdown string) string {
+	var (
+		firstHeading string
+		paragraph    []string
+		inCodeBlock  bool
+	)
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~") {
+			inCodeBlock = !inCodeBlock
+			continue
+		}
+		if inCodeBlock {
+			continue
+		}
+		switch {
+		case line == "":
+			if len(paragraph) > 0 {
+				return strings.Join(paragraph, " ")
+			}
+		case strings.HasPrefix(line, "#"):
+			if len(paragraph) > 0 {
+				return strings.Join(paragraph, " ")
+			}
+			if firstHeading == "" {
+				firstHeading = strings.TrimSpace(strings.Trim(line, "#"))
+			}
+		case isSetextUnderline(line):
+			// The paragraph so far was a heading, such as "Title\n=====".
+			if firstHeading == "" {
+				firstHeading = strings.Join(paragraph, " ")
+			}
+			paragraph = nil
+		case strings.HasPrefix(line, "!["),
+			strings.HasPrefix(line, "[!["),
+			strings.HasPrefix(line, "<"):
+			// Images, badges, and HTML are not meaningful as descriptions.
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	if len(paragraph) > 0 {
+		return strings.Join(paragraph, " ")
+	}
+	return firstHeading
+}
+
+func isSetextUnderline(line string) bool {
+	return strings.Trim(line, "=") == "" || strings.Trim(line, "-") == ""
+}
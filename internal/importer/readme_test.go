@@ -0,0 +1,56 @@
+package importer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadmeSummary(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "empty",
+			markdown: "",
+			want:     "",
+		},
+		{
+			name:     "heading and paragraph",
+			markdown: "# My repo\n\nDoes things\nwell.\n\nMore text.",
+			want:     "Does things well.",
+		},
+		{
+			name:     "only heading",
+			markdown: "# My repo\n",
+			want:     "My repo",
+		},
+		{
+			name:     "setext heading",
+			markdown: "My repo\r\n=======\r\n\r\nDoes things.\r\n",
+			want:     "Does things.",
+		},
+		{
+			name:     "skips badges and html",
+			markdown: "# My repo\n[![Build](badge.svg)](link)\n<img src=\"logo.png\">\n\nDoes things.",
+			want:     "Does things.",
+		},
+		{
+			name:     "skips code blocks",
+			markdown: "# My repo\n\n```sh\nmake\n```\n\nDoes things.",
+			want:     "Does things.",
+		},
+		{
+			name:     "paragraph ended by heading",
+			markdown: "Does things.\n## Usage\nRun it.",
+			want:     "Does things.",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, readmeSummary(tc.markdown))
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package importer
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+)
+
+// repoRef references an Azure DevOps repository by the names of its
+// organization, project, and repository.
+type repoRef struct {
+	org     string
+	project string
+	repo    string
+}
+
+// resolveRefreshedRepoWritesProblem returns the Azure DevOps repository of
+// the refreshed Wharf project, parsed from the project's git URL. The Wharf
+// group and project names do not always map back to the Azure DevOps names,
+// such as when imported using the "org-only" grouping strategy, a group name
+// template, or a target group. The given names are only used if the git URL
+// cannot be parsed, such as for projects not imported by this provider.
+//
+// The Wharf project is also kept in its current group, by using the group as
+// Options.TargetGroup unless already set.
+func (i *azureImporter) resolveRefreshedRepoWritesProblem(given repoRef, wharfProjectID uint) (repoRef, bool) {
+	project, err := i.wharf.GetProject(wharfProjectID)
+	if err != nil {
+		i.log().Error().
+			WithError(err).
+			WithUint("projectId", wharfProjectID).
+			Message("Unable to get refreshed project.")
+		i.opts.WharfAPIDiagnoser.WriteReadError(i.c, err,
+			fmt.Sprintf("Unable to get project by ID %d.", wharfProjectID))
+		return repoRef{}, false
+	}
+	if i.opts.TargetGroup == "" {
+		i.opts.TargetGroup = project.GroupName
+	}
+
+	ref, err := i.parseGitURL(project.GitURL)
+	if err == nil {
+		if ref.org == "" {
+			// Server mode, where the organization is only used in names.
+			ref.org = given.org
+		}
+		return ref, true
+	}
+	if given.repo == "" {
+		ginutil.WriteInvalidParamError(i.c, redact.Error(err), "projectId",
+			fmt.Sprintf("Unable to refresh project %d, as its Azure DevOps repository could not be found from its git URL %q.",
+				wharfProjectID, redact.URLString(project.GitURL)))
+		return repoRef{}, false
+	}
+	i.log().Warn().
+		WithError(redact.Error(err)).
+		WithUint("projectId", wharfProjectID).
+		Message("Unable to parse git URL of refreshed project. Using the given group and project names instead.")
+	return given, true
+}
+
+// parseGitURL returns the Azure DevOps repository of a git URL, in any of the
+// formats stored in Wharf projects by this provider, such as:
+//
+//	git@ssh.dev.azure.com:v3/{org}/{project}/{repo}
+//	ssh://git@{host}:{port}/v3/{org}/{project}/{repo}
+//	https://dev.azure.com/{org}/{project}/_git/{repo}
+//	https://{org}.visualstudio.com/{project}/_git/{repo}
+//	https://{host}/{collection}/{project}/_git/{repo}
+//
+// The organization is empty in server mode, where the collection of the
+// provider URL takes the organization's place.
+func (i *azureImporter) parseGitURL(gitURL string) (repoRef, error) {
+	var host, rawPath string
+	if u, err := url.Parse(gitURL); err == nil && u.Scheme != "" && u.Host != "" {
+		host = strings.ToLower(u.Hostname())
+		rawPath = i.trimProviderPath(host, u.EscapedPath())
+	} else if userHost, after, ok := strings.Cut(gitURL, ":"); ok && strings.Contains(userHost, "@") {
+		// SCP-like syntax, as used by Azure DevOps SSH URLs.
+		rawPath = after
+	} else {
+		return repoRef{}, fmt.Errorf("unsupported git URL: %q", gitURL)
+	}
+
+	var segments []string
+	for _, s := range strings.Split(strings.Trim(rawPath, "/"), "/") {
+		unescaped, err := url.PathUnescape(s)
+		if err != nil {
+			return repoRef{}, fmt.Errorf("unescape git URL path: %w", err)
+		}
+		segments = append(segments, unescaped)
+	}
+
+	if len(segments) == 4 && segments[0] == "v3" {
+		return repoRef{org: segments[1], project: segments[2], repo: segments[3]}, nil
+	}
+	if len(segments) < 2 || segments[len(segments)-2] != "_git" || segments[len(segments)-1] == "" {
+		return repoRef{}, fmt.Errorf("git URL path is missing the repository: %q", gitURL)
+	}
+	ref := repoRef{repo: segments[len(segments)-1]}
+	before := segments[:len(segments)-2]
+	if i.opts.Mode == azureapi.ModeServer {
+		// The project is omitted from the path when it has the same name as
+		// the repository.
+		ref.project = ref.repo
+		if len(before) > 0 {
+			ref.project = before[len(before)-1]
+		}
+		return ref, nil
+	}
+	if strings.HasSuffix(host, ".visualstudio.com") {
+		before = append([]string{strings.TrimSuffix(host, ".visualstudio.com")}, before...)
+	}
+	switch len(before) {
+	case 1:
+		ref.org, ref.project = before[0], ref.repo
+	case 2:
+		ref.org, ref.project = before[0], before[1]
+	default:
+		return repoRef{}, fmt.Errorf("git URL path is missing the organization and project: %q", gitURL)
+	}
+	return ref, nil
+}
+
+// trimProviderPath removes the path of the provider URL from the escaped path
+// of a git URL on the same host, such as the collection in server mode.
+func (i *azureImporter) trimProviderPath(host, escapedPath string) string {
+	if i.providerURL == nil || host != strings.ToLower(i.providerURL.Hostname()) {
+		return escapedPath
+	}
+	basePath := strings.TrimRight(i.providerURL.EscapedPath(), "/")
+	if basePath == "" || !strings.HasPrefix(escapedPath, basePath+"/") {
+		return escapedPath
+	}
+	return strings.TrimPrefix(escapedPath, basePath)
+}
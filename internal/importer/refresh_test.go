@@ -0,0 +1,166 @@
+package importer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi/azureapitest"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/wharfapitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGitURL(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		mode        azureapi.Mode
+		providerURL string
+		gitURL      string
+		want        repoRef
+		wantErr     bool
+	}{
+		{
+			name:   "ssh",
+			gitURL: "git@ssh.dev.azure.com:v3/Org/Proj/Repo",
+			want:   repoRef{org: "Org", project: "Proj", repo: "Repo"},
+		},
+		{
+			name:   "ssh with port",
+			gitURL: "ssh://git@ssh.example.com:2222/v3/Org/Proj/Repo",
+			want:   repoRef{org: "Org", project: "Proj", repo: "Repo"},
+		},
+		{
+			name:   "ssh with escaped names",
+			gitURL: "git@ssh.dev.azure.com:v3/Org/My%20Proj/My%20Repo",
+			want:   repoRef{org: "Org", project: "My Proj", repo: "My Repo"},
+		},
+		{
+			name:   "ssh visualstudio.com",
+			gitURL: "Org@vs-ssh.visualstudio.com:v3/Org/Proj/Repo",
+			want:   repoRef{org: "Org", project: "Proj", repo: "Repo"},
+		},
+		{
+			name:   "https",
+			gitURL: "https://dev.azure.com/Org/Proj/_git/Repo",
+			want:   repoRef{org: "Org", project: "Proj", repo: "Repo"},
+		},
+		{
+			name:   "https without project",
+			gitURL: "https://dev.azure.com/Org/_git/Repo",
+			want:   repoRef{org: "Org", project: "Repo", repo: "Repo"},
+		},
+		{
+			name:   "https visualstudio.com",
+			gitURL: "https://org.visualstudio.com/Proj/_git/Repo",
+			want:   repoRef{org: "org", project: "Proj", repo: "Repo"},
+		},
+		{
+			name:        "server",
+			mode:        azureapi.ModeServer,
+			providerURL: "https://server/tfs/DefaultCollection",
+			gitURL:      "https://server/tfs/DefaultCollection/Proj/_git/Repo",
+			want:        repoRef{project: "Proj", repo: "Repo"},
+		},
+		{
+			name:        "server ssh",
+			mode:        azureapi.ModeServer,
+			providerURL: "https://server/tfs/DefaultCollection",
+			gitURL:      "ssh://server:22/tfs/DefaultCollection/My%20Proj/_git/Repo",
+			want:        repoRef{project: "My Proj", repo: "Repo"},
+		},
+		{
+			name:        "server without project",
+			mode:        azureapi.ModeServer,
+			providerURL: "https://server/tfs/DefaultCollection",
+			gitURL:      "https://server/tfs/DefaultCollection/_git/Repo",
+			want:        repoRef{project: "Repo", repo: "Repo"},
+		},
+		{
+			name:    "missing repository",
+			gitURL:  "https://dev.azure.com/Org/Proj",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			i := azureImporter{opts: Options{Mode: tc.mode}}
+			if tc.providerURL != "" {
+				u, err := url.Parse(tc.providerURL)
+				require.NoError(t, err)
+				i.providerURL = u
+			}
+			got, err := i.parseGitURL(tc.gitURL)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestRefreshRepositoryWithOrgOnlyGrouping(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	wharf := &wharfapitest.Fake{
+		Projects: []response.Project{{
+			ProjectID: 1,
+			GroupName: "Org",
+			Name:      "Proj-Repo",
+			GitURL:    "git@ssh.dev.azure.com:v3/Org/Proj/Repo",
+		}},
+	}
+	i := azureImporter{
+		c:     c,
+		wharf: wharf,
+		azure: &azureapitest.Fake{
+			Repositories: []azureapitest.Repository{
+				{Repository: azureapi.Repository{ID: "repo-id", Name: "Repo",
+					Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+			},
+		},
+		opts: Options{GroupingStrategy: GroupingOrgOnly},
+	}
+
+	// As sent when refreshing, from the Wharf group and project names.
+	result, ok := i.RefreshRepositoryWritesProblem("Org", "Proj-Repo", "", 1)
+
+	require.True(t, ok, rec.Body.String())
+	assert.Equal(t, 1, result.ProjectsUpdated)
+	assert.Zero(t, result.ProjectsCreated)
+	require.Len(t, wharf.Projects, 1)
+	assert.Equal(t, "Org", wharf.Projects[0].GroupName)
+	assert.Equal(t, "Proj-Repo", wharf.Projects[0].Name)
+}
+
+func TestRefreshRepositoryWithUnparsableGitURL(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	i := azureImporter{
+		c: c,
+		wharf: &wharfapitest.Fake{
+			Projects: []response.Project{{ProjectID: 1, GroupName: "Org", Name: "Proj-Repo"}},
+		},
+		azure: &azureapitest.Fake{},
+		opts:  Options{GroupingStrategy: GroupingOrgOnly},
+	}
+
+	_, ok := i.RefreshRepositoryWritesProblem("Org", "Proj-Repo", "", 1)
+
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,62 @@
+package importer
+
+import (
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+)
+
+// RemoteID is an enum of which Azure DevOps IDs are stored as the remote
+// project ID of imported Wharf projects.
+type RemoteID string
+
+const (
+	// RemoteIDProject stores the ID of the Azure DevOps project, which is
+	// shared by all repositories in the project.
+	RemoteIDProject RemoteID = "project"
+	// RemoteIDRepository stores the ID of the Azure DevOps repository.
+	RemoteIDRepository RemoteID = "repository"
+	// RemoteIDProjectRepository stores both the ID of the Azure DevOps
+	// project and repository, as "{projectId}/{repoId}".
+	RemoteIDProjectRepository RemoteID = "project-repository"
+)
+
+// remoteProjectID returns the remote project ID of the repository's Wharf
+// project, based on Options.RemoteID.
+func (i *azureImporter) remoteProjectID(repo azureapi.Repository) string {
+	switch i.opts.RemoteID {
+	case RemoteIDRepository:
+		return repo.ID
+	case RemoteIDProjectRepository:
+		return repo.Project.ID + "/" + repo.ID
+	default:
+		return repo.Project.ID
+	}
+}
+
+// findRenamedWharfProject searches the group for a Wharf project whose remote
+// project ID is the repository's, such as a project imported before the
+// repository was renamed in Azure DevOps. Only searched when the remote
+// project ID includes the repository ID, as the Azure DevOps project ID is
+// shared by all repositories in the project.
+func (i *azureImporter) findRenamedWharfProject(repo azureapi.Repository, key projectCacheKey) (response.Project, bool, error) {
+	if i.opts.RemoteID != RemoteIDRepository && i.opts.RemoteID != RemoteIDProjectRepository {
+		return response.Project{}, false, nil
+	}
+	remoteProjectID := i.remoteProjectID(repo)
+	projects, err := i.projects.list(key.group(), i.listWharfGroupProjects)
+	if err != nil {
+		return response.Project{}, false, err
+	}
+	for _, project := range projects {
+		if project.RemoteProjectID == remoteProjectID {
+			i.log().Info().
+				WithUint("projectId", project.ProjectID).
+				WithString("remoteProjectId", remoteProjectID).
+				WithString("oldName", project.Name).
+				WithString("name", key.name).
+				Message("Renaming Wharf project of renamed repository.")
+			return project, true, nil
+		}
+	}
+	return response.Project{}, false, nil
+}
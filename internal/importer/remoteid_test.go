@@ -0,0 +1,102 @@
+package importer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi/azureapitest"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/wharfapitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportRepositoryRemoteProjectID(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		remoteID RemoteID
+		want     string
+	}{
+		{name: "default", want: "proj-id"},
+		{name: "project", remoteID: RemoteIDProject, want: "proj-id"},
+		{name: "repository", remoteID: RemoteIDRepository, want: "repo-id"},
+		{name: "project and repository", remoteID: RemoteIDProjectRepository, want: "proj-id/repo-id"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			wharf := &wharfapitest.Fake{}
+			i := azureImporter{
+				c:     c,
+				wharf: wharf,
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{Repository: azureapi.Repository{ID: "repo-id", Name: "Repo",
+							Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+					},
+				},
+				opts: Options{RemoteID: tc.remoteID},
+			}
+
+			_, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "Repo")
+
+			require.True(t, ok)
+			require.Len(t, wharf.Projects, 1)
+			assert.Equal(t, tc.want, wharf.Projects[0].RemoteProjectID)
+		})
+	}
+}
+
+func TestImportRepositoryRenamesProjectOfRenamedRepo(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		remoteID    RemoteID
+		wantRenamed bool
+	}{
+		{name: "project", remoteID: RemoteIDProject},
+		{name: "repository", remoteID: RemoteIDRepository, wantRenamed: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+			wharf := &wharfapitest.Fake{
+				Projects: []response.Project{
+					{ProjectID: 1, Name: "Other", GroupName: "Org/Proj", RemoteProjectID: "other-repo-id"},
+					{ProjectID: 2, Name: "OldName", GroupName: "Org/Proj", RemoteProjectID: "repo-id"},
+				},
+			}
+			i := azureImporter{
+				c:     c,
+				wharf: wharf,
+				azure: &azureapitest.Fake{
+					Repositories: []azureapitest.Repository{
+						{Repository: azureapi.Repository{ID: "repo-id", Name: "NewName",
+							Project: azureapi.Project{ID: "proj-id", Name: "Proj"}}},
+					},
+				},
+				opts: Options{RemoteID: tc.remoteID},
+			}
+
+			result, ok := i.ImportRepositoryWritesProblem("Org", "Proj", "NewName")
+
+			require.True(t, ok)
+			if tc.wantRenamed {
+				assert.Equal(t, 1, result.ProjectsUpdated)
+				require.Len(t, wharf.Projects, 2)
+				assert.Equal(t, "NewName", wharf.Projects[1].Name)
+			} else {
+				assert.Equal(t, 1, result.ProjectsCreated)
+				assert.Len(t, wharf.Projects, 3)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package importer
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+)
+
+// ImportResult is a summary of what was imported into Wharf in a single
+// import request.
+type ImportResult struct {
+	// ProjectsCreated is the number of Wharf projects that did not exist
+	// beforehand and were created.
+	ProjectsCreated int `json:"projectsCreated"`
+	// ProjectsUpdated is the number of already existing Wharf projects that
+	// were updated.
+	ProjectsUpdated int `json:"projectsUpdated"`
+	// BranchesCreated is the number of branches sent to the Wharf API,
+	// summed up from all imported repositories.
+	BranchesCreated int `json:"branchesCreated"`
+	// WebhooksRegistered is the number of Azure DevOps service hooks that
+	// were registered for the imported repositories.
+	WebhooksRegistered int `json:"webhooksRegistered"`
+	// ReposSkipped is the number of Azure DevOps repositories that were not
+	// imported.
+	ReposSkipped int `json:"reposSkipped"`
+	// TotalRepoSizeBytes is the size of all imported repositories, as
+	// reported by Azure DevOps, summed up. Excludes Git LFS objects.
+	TotalRepoSizeBytes int64 `json:"totalRepoSizeBytes" example:"1048576"`
+	// CloneProtocol is the protocol of the git URLs stored in the imported
+	// Wharf projects, being either "ssh" or "https". HTTPS git URLs never
+	// contain any credentials, so Wharf must be configured separately to
+	// authenticate when cloning.
+	CloneProtocol CloneProtocol `json:"cloneProtocol" enums:"ssh,https" example:"ssh"`
+	// Warnings contains non-fatal issues found when importing the
+	// repositories.
+	Warnings []ImportWarning `json:"warnings"`
+	// StaleProjects contains previously imported Wharf projects whose Azure
+	// DevOps repository no longer exists, and that may need to be cleaned up
+	// manually.
+	StaleProjects []StaleProject `json:"staleProjects"`
+	// SkippedProjects contains the Azure DevOps projects that were not
+	// imported when importing an organization or project.
+	SkippedProjects []SkippedProject `json:"skippedProjects"`
+	// FailedRepos contains the Azure DevOps repositories, or projects, that
+	// failed to import when continuing on errors was requested. The import
+	// is only partial if this is non-empty.
+	FailedRepos []FailedRepo `json:"failedRepos,omitempty"`
+	// Tags contains the Git tags found in the imported repositories, when
+	// importing tags was requested. Tags are not stored in Wharf.
+	Tags []RepoTag `json:"tags,omitempty"`
+	// BranchProtections contains the protection of the default branch of
+	// each imported repository, when importing branch protection was
+	// requested. Branch protection is not stored in Wharf.
+	BranchProtections []BranchProtection `json:"branchProtections,omitempty"`
+	// RepoSizes contains the size of each imported repository, such as for
+	// capacity planning of the build runners that clone them.
+	RepoSizes []RepoSize `json:"repoSizes"`
+	// BuildDefinitions contains the path of the build definition file that
+	// was imported for each imported repository that had one, such as when
+	// multiple build definition paths were given.
+	BuildDefinitions []RepoBuildDefinition `json:"buildDefinitions"`
+	// Projects contains the imported Azure DevOps projects along with their
+	// process templates, when importing process templates was requested.
+	// Process templates are not stored in Wharf.
+	Projects []ImportedProject `json:"projects,omitempty"`
+	// HiddenRepos contains the imported Azure DevOps repositories that are
+	// hidden in Azure DevOps, when including hidden repositories was
+	// requested.
+	HiddenRepos []HiddenRepo `json:"hiddenRepos,omitempty"`
+}
+
+// HiddenRepo is an imported Azure DevOps repository that is hidden in Azure
+// DevOps.
+type HiddenRepo struct {
+	Org     string `json:"org" example:"my-org"`
+	Project string `json:"project" example:"my-project"`
+	Repo    string `json:"repo" example:"my-repo"`
+	// WharfProjectID is the ID of the Wharf project that the repository was
+	// imported as.
+	WharfProjectID uint `json:"wharfProjectId" example:"1"`
+}
+
+// ImportedProject is an Azure DevOps project whose repositories were
+// imported.
+type ImportedProject struct {
+	Org     string `json:"org" example:"my-org"`
+	Project string `json:"project" example:"my-project"`
+	// ProjectID is the ID of the Azure DevOps project.
+	ProjectID string `json:"projectId" example:"eb6e4656-77fc-42a1-9181-4c6d8e9da5d1"`
+	// ProcessTemplate is the name of the project's work item process, such
+	// as "Agile", "Scrum", or a custom inherited process, or empty if
+	// unknown.
+	ProcessTemplate string `json:"processTemplate" example:"Agile"`
+}
+
+// RepoSize is the size of an imported Azure DevOps repository.
+type RepoSize struct {
+	Org     string `json:"org" example:"my-org"`
+	Project string `json:"project" example:"my-project"`
+	Repo    string `json:"repo" example:"my-repo"`
+	// WharfProjectID is the ID of the Wharf project that the repository was
+	// imported as.
+	WharfProjectID uint `json:"wharfProjectId" example:"1"`
+	// SizeBytes is the size of the repository's Git objects, as reported by
+	// Azure DevOps. Files stored using Git LFS are not included, as Azure
+	// DevOps does not report their size, so the size of cloned repositories
+	// using Git LFS may be much larger.
+	SizeBytes int64 `json:"sizeBytes" example:"1048576"`
+}
+
+// RepoBuildDefinition is the build definition file imported from an Azure
+// DevOps repository.
+type RepoBuildDefinition struct {
+	Org     string `json:"org" example:"my-org"`
+	Project string `json:"project" example:"my-project"`
+	Repo    string `json:"repo" example:"my-repo"`
+	// WharfProjectID is the ID of the Wharf project that the repository was
+	// imported as.
+	WharfProjectID uint `json:"wharfProjectId" example:"1"`
+	// Path is the repository-relative path of the imported build definition
+	// file.
+	Path string `json:"path" example:".wharf-ci.yml"`
+}
+
+// BranchProtection is the protection of the default branch of an imported
+// Azure DevOps repository, based on its branch policies.
+type BranchProtection struct {
+	Org     string `json:"org" example:"my-org"`
+	Project string `json:"project" example:"my-project"`
+	Repo    string `json:"repo" example:"my-repo"`
+	// WharfProjectID is the ID of the Wharf project that the repository was
+	// imported as.
+	WharfProjectID uint   `json:"wharfProjectId" example:"1"`
+	Branch         string `json:"branch" example:"main"`
+	// RequiresPullRequest is true if any enabled and blocking policy applies
+	// to the branch, as Azure DevOps then rejects pushes directly to the
+	// branch, requiring changes to go through pull requests.
+	RequiresPullRequest bool `json:"requiresPullRequest"`
+	// Policies contains the display names of the enabled policies that
+	// apply to the branch.
+	Policies []string `json:"policies" example:"Minimum number of reviewers"`
+}
+
+// RepoTag is a Git tag found in an imported Azure DevOps repository.
+type RepoTag struct {
+	Org     string `json:"org" example:"my-org"`
+	Project string `json:"project" example:"my-project"`
+	Repo    string `json:"repo" example:"my-repo"`
+	// WharfProjectID is the ID of the Wharf project that the repository was
+	// imported as.
+	WharfProjectID uint   `json:"wharfProjectId" example:"1"`
+	Name           string `json:"name" example:"v1.0.0"`
+	Ref            string `json:"ref" example:"refs/tags/v1.0.0"`
+	ObjectID       string `json:"objectId" example:"23d0bc5b128a10056dc68afece360d8a0fabb014"`
+}
+
+// FailedRepo is an Azure DevOps repository that failed to import, or a
+// project whose repositories could not be listed, in which case Repo is
+// empty.
+type FailedRepo struct {
+	Org     string `json:"org" example:"my-org"`
+	Project string `json:"project" example:"my-project"`
+	Repo    string `json:"repo,omitempty" example:"my-repo"`
+	// Problem is the problem that would have been the response if the import
+	// had not continued after the failure.
+	Problem problem.Response `json:"problem"`
+}
+
+// SkippedProject is an Azure DevOps project that was not imported.
+type SkippedProject struct {
+	Org     string `json:"org" example:"my-org"`
+	Project string `json:"project" example:"my-project"`
+	Reason  string `json:"reason" example:"Project state is \"deleting\", expected \"wellFormed\"."`
+}
+
+// RepoStatus is an enum of the outcomes of importing a single Azure DevOps
+// repository.
+type RepoStatus string
+
+const (
+	// RepoStatusCreated means a new Wharf project was created.
+	RepoStatusCreated RepoStatus = "created"
+	// RepoStatusUpdated means an existing Wharf project was updated.
+	RepoStatusUpdated RepoStatus = "updated"
+	// RepoStatusSkipped means the repository was not imported.
+	RepoStatusSkipped RepoStatus = "skipped"
+)
+
+// RepoProgress is the outcome of importing a single Azure DevOps repository,
+// reported while the import is still in progress.
+type RepoProgress struct {
+	Org     string     `json:"org" example:"my-org"`
+	Project string     `json:"project" example:"my-project"`
+	Repo    string     `json:"repo" example:"my-repo"`
+	Status  RepoStatus `json:"status" enums:"created,updated,skipped" example:"created"`
+	// WharfProjectID is the ID of the created or updated Wharf project, or 0
+	// if the repository was skipped.
+	WharfProjectID uint `json:"wharfProjectId" example:"1"`
+}
+
+// StaleProject is a previously imported Wharf project whose Azure DevOps
+// repository could not be found when refreshing it.
+type StaleProject struct {
+	ProjectID uint   `json:"projectId" example:"1"`
+	Org       string `json:"org" example:"my-org"`
+	Project   string `json:"project" example:"my-project"`
+	Repo      string `json:"repo" example:"my-repo"`
+}
+
+// ImportWarning is a non-fatal issue found when importing a single Azure
+// DevOps repository.
+type ImportWarning struct {
+	Org     string `json:"org" example:"my-org"`
+	Project string `json:"project" example:"my-project"`
+	Repo    string `json:"repo" example:"my-repo"`
+	Message string `json:"message" example:"No build definition found."`
+}
+
+func (r *ImportResult) add(other ImportResult) {
+	r.ProjectsCreated += other.ProjectsCreated
+	r.ProjectsUpdated += other.ProjectsUpdated
+	r.BranchesCreated += other.BranchesCreated
+	r.WebhooksRegistered += other.WebhooksRegistered
+	r.ReposSkipped += other.ReposSkipped
+	r.TotalRepoSizeBytes += other.TotalRepoSizeBytes
+	r.Warnings = append(r.Warnings, other.Warnings...)
+	r.StaleProjects = append(r.StaleProjects, other.StaleProjects...)
+	r.SkippedProjects = append(r.SkippedProjects, other.SkippedProjects...)
+	r.FailedRepos = append(r.FailedRepos, other.FailedRepos...)
+	r.Tags = append(r.Tags, other.Tags...)
+	r.BranchProtections = append(r.BranchProtections, other.BranchProtections...)
+	r.RepoSizes = append(r.RepoSizes, other.RepoSizes...)
+	r.BuildDefinitions = append(r.BuildDefinitions, other.BuildDefinitions...)
+	r.Projects = append(r.Projects, other.Projects...)
+	r.HiddenRepos = append(r.HiddenRepos, other.HiddenRepos...)
+}
+
+func (r *ImportResult) addWarning(org, project, repo, message string) {
+	r.Warnings = append(r.Warnings, ImportWarning{
+		Org:     org,
+		Project: project,
+		Repo:    repo,
+		Message: message,
+	})
+}
+
+func (r *ImportResult) addTags(org string, repo azureapi.Repository, wharfProjectID uint, tags []azureapi.Tag) {
+	for _, tag := range tags {
+		r.Tags = append(r.Tags, RepoTag{
+			Org:            org,
+			Project:        repo.Project.Name,
+			Repo:           repo.Name,
+			WharfProjectID: wharfProjectID,
+			Name:           tag.Name,
+			Ref:            tag.Ref,
+			ObjectID:       tag.ObjectID,
+		})
+	}
+}
+
+func (r *ImportResult) addBranchProtection(org string, repo azureapi.Repository, wharfProjectID uint, branchRef string, policies []azureapi.PolicyConfiguration) {
+	protection := BranchProtection{
+		Org:            org,
+		Project:        repo.Project.Name,
+		Repo:           repo.Name,
+		WharfProjectID: wharfProjectID,
+		Branch:         strings.TrimPrefix(branchRef, "refs/heads/"),
+		Policies:       []string{},
+	}
+	for _, policy := range policies {
+		if !policy.IsEnabled {
+			continue
+		}
+		protection.Policies = append(protection.Policies, policy.Type.DisplayName)
+		if policy.IsBlocking {
+			protection.RequiresPullRequest = true
+		}
+	}
+	r.BranchProtections = append(r.BranchProtections, protection)
+}
+
+func (r *ImportResult) addRepoSize(org string, repo azureapi.Repository, wharfProjectID uint) {
+	r.TotalRepoSizeBytes += repo.Size
+	r.RepoSizes = append(r.RepoSizes, RepoSize{
+		Org:            org,
+		Project:        repo.Project.Name,
+		Repo:           repo.Name,
+		WharfProjectID: wharfProjectID,
+		SizeBytes:      repo.Size,
+	})
+}
+
+func (r *ImportResult) addBuildDefinition(org string, repo azureapi.Repository, wharfProjectID uint, path string) {
+	r.BuildDefinitions = append(r.BuildDefinitions, RepoBuildDefinition{
+		Org:            org,
+		Project:        repo.Project.Name,
+		Repo:           repo.Name,
+		WharfProjectID: wharfProjectID,
+		Path:           path,
+	})
+}
+
+func (r *ImportResult) addHiddenRepo(org string, repo azureapi.Repository, wharfProjectID uint) {
+	r.HiddenRepos = append(r.HiddenRepos, HiddenRepo{
+		Org:            org,
+		Project:        repo.Project.Name,
+		Repo:           repo.Name,
+		WharfProjectID: wharfProjectID,
+	})
+}
+
+func (r *ImportResult) addProject(org string, project azureapi.Project) {
+	r.Projects = append(r.Projects, ImportedProject{
+		Org:             org,
+		Project:         project.Name,
+		ProjectID:       project.ID,
+		ProcessTemplate: project.ProcessTemplateName(),
+	})
+}
+
+func (r *ImportResult) addSkippedProject(org, project, reason string) {
+	r.SkippedProjects = append(r.SkippedProjects, SkippedProject{
+		Org:     org,
+		Project: project,
+		Reason:  reason,
+	})
+}
+
+// sort orders the warnings by organization, project, and repository name, so
+// the summary is stable between imports regardless of in which order the
+// repositories were imported.
+func (r *ImportResult) sort() {
+	sort.SliceStable(r.Warnings, func(a, b int) bool {
+		wa, wb := r.Warnings[a], r.Warnings[b]
+		if wa.Org != wb.Org {
+			return wa.Org < wb.Org
+		}
+		if wa.Project != wb.Project {
+			return wa.Project < wb.Project
+		}
+		return wa.Repo < wb.Repo
+	})
+}
@@ -0,0 +1,26 @@
+package importer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportResultSort(t *testing.T) {
+	var a, b ImportResult
+	a.addWarning("Org", "ProjB", "Repo1", "")
+	a.addWarning("Org", "ProjA", "Repo2", "")
+	b.addWarning("Org", "ProjA", "Repo1", "")
+
+	var result ImportResult
+	result.add(a)
+	result.add(b)
+	result.sort()
+
+	var got []string
+	for _, w := range result.Warnings {
+		got = append(got, w.Org+"/"+w.Project+"/"+w.Repo)
+	}
+	want := []string{"Org/ProjA/Repo1", "Org/ProjA/Repo2", "Org/ProjB/Repo1"}
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,40 @@
+package importer
+
+import (
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/request"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
+)
+
+// WharfClient is an interface for the Wharf API endpoints used by the
+// importer. It is implemented by *wharfapi.Client, and exists so the importer
+// can be tested without a live Wharf API, such as by using
+// wharfapitest.Fake.
+type WharfClient interface {
+	// GetProjectList searches for projects.
+	GetProjectList(params wharfapi.ProjectSearch) (response.PaginatedProjects, error)
+	// GetProject gets a project by its ID.
+	GetProject(projectID uint) (response.Project, error)
+	// CreateProject creates a new project.
+	CreateProject(project request.Project) (response.Project, error)
+	// UpdateProject updates an existing project.
+	UpdateProject(projectID uint, project request.ProjectUpdate) (response.Project, error)
+	// UpdateProjectBranchList replaces all branches of a project.
+	UpdateProjectBranchList(projectID uint, branches []request.Branch) ([]response.Branch, error)
+	// GetToken gets a token by its ID.
+	GetToken(tokenID uint) (response.Token, error)
+	// GetTokenList searches for tokens.
+	GetTokenList(params wharfapi.TokenSearch) (response.PaginatedTokens, error)
+	// CreateToken creates a new token.
+	CreateToken(token request.Token) (response.Token, error)
+	// UpdateToken updates an existing token.
+	UpdateToken(tokenID uint, token request.TokenUpdate) (response.Token, error)
+	// GetProvider gets a provider by its ID.
+	GetProvider(providerID uint) (response.Provider, error)
+	// GetProviderList searches for providers.
+	GetProviderList(params wharfapi.ProviderSearch) (response.PaginatedProviders, error)
+	// CreateProvider creates a new provider.
+	CreateProvider(provider request.Provider) (response.Provider, error)
+}
+
+var _ WharfClient = &wharfapi.Client{}
@@ -0,0 +1,77 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/request"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/semaphore"
+)
+
+// writeLimitedWharfClient is a WharfClient that holds a slot of the semaphore
+// during each write to the Wharf API, to limit the number of concurrent
+// writes across all imports. Reads are not limited.
+type writeLimitedWharfClient struct {
+	WharfClient
+	ctx       context.Context
+	semaphore *semaphore.Semaphore
+}
+
+// limitWharfWrites returns the client wrapped to limit its writes using the
+// Options.WharfWriteSemaphore, or the client as-is if there is no limit.
+func (i *azureImporter) limitWharfWrites(client WharfClient) WharfClient {
+	if i.opts.WharfWriteSemaphore == nil {
+		return client
+	}
+	return writeLimitedWharfClient{
+		WharfClient: client,
+		ctx:         i.context(),
+		semaphore:   i.opts.WharfWriteSemaphore,
+	}
+}
+
+func limitWrite[T any](c writeLimitedWharfClient, write func() (T, error)) (T, error) {
+	if err := c.semaphore.Acquire(c.ctx); err != nil {
+		var zero T
+		return zero, fmt.Errorf("wait for concurrent Wharf API writes: %w", err)
+	}
+	defer c.semaphore.Release()
+	return write()
+}
+
+func (c writeLimitedWharfClient) CreateProject(project request.Project) (response.Project, error) {
+	return limitWrite(c, func() (response.Project, error) {
+		return c.WharfClient.CreateProject(project)
+	})
+}
+
+func (c writeLimitedWharfClient) UpdateProject(projectID uint, project request.ProjectUpdate) (response.Project, error) {
+	return limitWrite(c, func() (response.Project, error) {
+		return c.WharfClient.UpdateProject(projectID, project)
+	})
+}
+
+func (c writeLimitedWharfClient) UpdateProjectBranchList(projectID uint, branches []request.Branch) ([]response.Branch, error) {
+	return limitWrite(c, func() ([]response.Branch, error) {
+		return c.WharfClient.UpdateProjectBranchList(projectID, branches)
+	})
+}
+
+func (c writeLimitedWharfClient) CreateToken(token request.Token) (response.Token, error) {
+	return limitWrite(c, func() (response.Token, error) {
+		return c.WharfClient.CreateToken(token)
+	})
+}
+
+func (c writeLimitedWharfClient) UpdateToken(tokenID uint, token request.TokenUpdate) (response.Token, error) {
+	return limitWrite(c, func() (response.Token, error) {
+		return c.WharfClient.UpdateToken(tokenID, token)
+	})
+}
+
+func (c writeLimitedWharfClient) CreateProvider(provider request.Provider) (response.Provider, error) {
+	return limitWrite(c, func() (response.Provider, error) {
+		return c.WharfClient.CreateProvider(provider)
+	})
+}
@@ -0,0 +1,44 @@
+package importer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/request"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/semaphore"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/wharfapitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitWharfWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil).WithContext(ctx)
+
+	sem := semaphore.New(1)
+	wharf := &wharfapitest.Fake{}
+	i := azureImporter{c: c, opts: Options{WharfWriteSemaphore: sem}}
+	client := i.limitWharfWrites(wharf)
+
+	_, err := client.CreateProject(request.Project{Name: "first", GroupName: "Org/Proj"})
+	require.NoError(t, err, "slot free")
+
+	require.NoError(t, sem.Acquire(context.Background()))
+	cancel()
+	_, err = client.CreateProject(request.Project{Name: "second", GroupName: "Org/Proj"})
+	assert.ErrorIs(t, err, context.Canceled, "waiting for slot")
+	_, err = client.GetProjectList(wharfapi.ProjectSearch{})
+	assert.NoError(t, err, "reads are not limited")
+	assert.Len(t, wharf.Projects, 1)
+}
+
+func TestLimitWharfWritesWithoutLimit(t *testing.T) {
+	wharf := &wharfapitest.Fake{}
+	i := azureImporter{}
+	assert.Same(t, wharf, i.limitWharfWrites(wharf))
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import "time"
+
+const namespace = "wharf_provider_azuredevops_"
+
+// ImportMetrics holds the metrics of the Azure DevOps imports.
+//
+// All methods are no-ops on a nil *ImportMetrics.
+type ImportMetrics struct {
+	// ProjectsImported counts imported Wharf projects, partitioned by the
+	// label "result", being either "created" or "updated".
+	ProjectsImported *CounterVec
+	// ReposSkipped counts Azure DevOps repositories that were not imported.
+	ReposSkipped *CounterVec
+	// BranchesImported counts the branches sent to the Wharf API.
+	BranchesImported *CounterVec
+	// ImportsFailed counts failed import requests, partitioned by the label
+	// "reason".
+	ImportsFailed *CounterVec
+	// ImportDuration samples the duration of import requests in seconds.
+	ImportDuration *Histogram
+	// AzureRequestDuration samples the duration of HTTP requests to Azure
+	// DevOps in seconds.
+	AzureRequestDuration *Histogram
+}
+
+// NewImportMetrics creates and registers the import metrics in the registry.
+func NewImportMetrics(r *Registry) *ImportMetrics {
+	return &ImportMetrics{
+		ProjectsImported: r.NewCounterVec(namespace+"projects_imported_total",
+			"Number of imported Wharf projects, by whether they were created or updated.",
+			"result"),
+		ReposSkipped: r.NewCounterVec(namespace+"repos_skipped_total",
+			"Number of Azure DevOps repositories that were skipped during import."),
+		BranchesImported: r.NewCounterVec(namespace+"branches_imported_total",
+			"Number of branches sent to the Wharf API during import."),
+		ImportsFailed: r.NewCounterVec(namespace+"imports_failed_total",
+			"Number of failed import requests, by reason.",
+			"reason"),
+		ImportDuration: r.NewHistogram(namespace+"import_duration_seconds",
+			"Duration of import requests in seconds.",
+			DefaultDurationBuckets),
+		AzureRequestDuration: r.NewHistogram(namespace+"azure_request_duration_seconds",
+			"Duration of HTTP requests to Azure DevOps in seconds.",
+			DefaultDurationBuckets),
+	}
+}
+
+// ProjectImported counts an imported Wharf project and its branches.
+func (m *ImportMetrics) ProjectImported(created bool, branches int) {
+	if m == nil {
+		return
+	}
+	if created {
+		m.ProjectsImported.Inc("created")
+	} else {
+		m.ProjectsImported.Inc("updated")
+	}
+	m.BranchesImported.Add(float64(branches))
+}
+
+// RepoSkipped counts a skipped Azure DevOps repository.
+func (m *ImportMetrics) RepoSkipped() {
+	if m == nil {
+		return
+	}
+	m.ReposSkipped.Inc()
+}
+
+// ImportFinished samples the duration of an import request, and counts it
+// as failed if reason is non-empty.
+func (m *ImportMetrics) ImportFinished(d time.Duration, failedReason string) {
+	if m == nil {
+		return
+	}
+	m.ImportDuration.ObserveDuration(d)
+	if failedReason != "" {
+		m.ImportsFailed.Inc(failedReason)
+	}
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	counter := r.NewCounterVec("test_total", "Test counter.", "result")
+	histogram := r.NewHistogram("test_seconds", "Test histogram.", []float64{1, 5})
+
+	counter.Inc("created")
+	counter.Add(2, "updated")
+	counter.Inc(`quote"d`)
+	histogram.Observe(0.5)
+	histogram.Observe(3)
+	histogram.Observe(10)
+
+	var buf bytes.Buffer
+	_, err := r.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	want := `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{result="created"} 1
+test_total{result="quote\"d"} 1
+test_total{result="updated"} 2
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="1"} 1
+test_seconds_bucket{le="5"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 13.5
+test_seconds_count 3
+`
+	assert.Equal(t, want, buf.String())
+}
+
+func TestImportMetrics(t *testing.T) {
+	m := NewImportMetrics(NewRegistry())
+
+	m.ProjectImported(true, 3)
+	m.ProjectImported(false, 2)
+	m.RepoSkipped()
+	m.ImportFinished(2*time.Second, "")
+	m.ImportFinished(time.Second, "unauthorized")
+
+	assert.Equal(t, 1.0, m.ProjectsImported.Value("created"))
+	assert.Equal(t, 1.0, m.ProjectsImported.Value("updated"))
+	assert.Equal(t, 5.0, m.BranchesImported.Value())
+	assert.Equal(t, 1.0, m.ReposSkipped.Value())
+	assert.Equal(t, 1.0, m.ImportsFailed.Value("unauthorized"))
+	assert.Equal(t, uint64(2), m.ImportDuration.Count())
+	assert.Equal(t, 3.0, m.ImportDuration.Sum())
+}
+
+func TestImportMetricsNil(t *testing.T) {
+	var m *ImportMetrics
+	assert.NotPanics(t, func() {
+		m.ProjectImported(true, 1)
+		m.RepoSkipped()
+		m.ImportFinished(time.Second, "other")
+	})
+}
@@ -0,0 +1,97 @@
+// Package redact removes credentials, such as access tokens and passwords,
+// from URLs and error messages before they are logged or sent in problem
+// responses.
+package redact
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Placeholder replaces the redacted credentials. It is the same placeholder
+// as used by url.URL.Redacted.
+const Placeholder = "xxxxx"
+
+// urlPattern matches absolute URLs inside free text.
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// URL returns the URL as a string with the password of the userinfo, and the
+// values of any token query parameters, replaced by Placeholder.
+func URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	if pass, ok := redacted.User.Password(); ok && pass != "" {
+		redacted.User = url.UserPassword(redacted.User.Username(), Placeholder)
+	}
+	if redacted.RawQuery != "" {
+		redacted.RawQuery = redactQuery(redacted.RawQuery)
+	}
+	return redacted.String()
+}
+
+// URLString is the same as URL, but takes an unparsed URL. Values that cannot
+// be parsed as URLs are redacted using String instead.
+func URLString(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return String(rawURL)
+	}
+	return URL(u)
+}
+
+// String redacts all absolute URLs found in the text using URL.
+func String(s string) string {
+	return urlPattern.ReplaceAllStringFunc(s, func(match string) string {
+		u, err := url.Parse(match)
+		if err != nil {
+			return match
+		}
+		return URL(u)
+	})
+}
+
+// Error wraps the error so that its message is redacted using String. The
+// original error can still be accessed using errors.Is and errors.As. Returns
+// nil if err is nil.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	return redactedError{err}
+}
+
+type redactedError struct {
+	err error
+}
+
+func (e redactedError) Error() string {
+	return String(e.err.Error())
+}
+
+func (e redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactQuery replaces the values of query parameters named "token", case
+// insensitive, such as "token" and "Token". The order and encoding of all
+// other parameters are kept as-is.
+func redactQuery(rawQuery string) string {
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		key, _, hasValue := strings.Cut(param, "=")
+		if !hasValue {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(key); err == nil && isTokenParam(unescaped) {
+			params[i] = key + "=" + Placeholder
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func isTokenParam(key string) bool {
+	return strings.EqualFold(key, "token")
+}
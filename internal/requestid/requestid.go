@@ -0,0 +1,107 @@
+// Package requestid handles the request IDs used to correlate all log lines
+// written while handling a single inbound HTTP request.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-core/pkg/logger"
+)
+
+const (
+	// HeaderName is the HTTP header used to propagate the request ID from
+	// the caller, and to return the request ID in the response.
+	HeaderName = "X-Request-Id"
+	// maxLength is the maximum length of propagated request IDs, where longer
+	// IDs are replaced with a newly generated one.
+	maxLength = 128
+	// contextKey is the key used to store the request ID in the gin.Context.
+	contextKey = "requestId"
+	// fieldName is the name of the field added to log lines.
+	fieldName = "requestId"
+)
+
+// Middleware is a gin middleware that stores the request ID in the
+// gin.Context, and sets it in the response header. The request ID is taken
+// from the X-Request-Id request header, or is generated if missing.
+func Middleware(c *gin.Context) {
+	id := c.GetHeader(HeaderName)
+	if id == "" || len(id) > maxLength {
+		id = newID()
+	}
+	c.Set(contextKey, id)
+	c.Header(HeaderName, id)
+	c.Next()
+}
+
+// FromContext returns the request ID stored in the gin.Context by the
+// Middleware, or an empty string if none is stored.
+func FromContext(c *gin.Context) string {
+	if c == nil {
+		return ""
+	}
+	return c.GetString(contextKey)
+}
+
+// Logger returns a logger that adds the request ID from the gin.Context as
+// a field to all log events. The logger is returned as-is if the gin.Context
+// has no request ID.
+func Logger(log logger.Logger, c *gin.Context) logger.Logger {
+	id := FromContext(c)
+	if id == "" {
+		return log
+	}
+	return requestLogger{log, id}
+}
+
+// ContextLogger returns a logger that adds the request ID from the context
+// as a field to all log events, such as when given a *gin.Context as a
+// context.Context by packages that do not depend on gin. The logger is
+// returned as-is if the context has no request ID.
+func ContextLogger(log logger.Logger, ctx context.Context) logger.Logger {
+	if ctx == nil {
+		return log
+	}
+	id, _ := ctx.Value(contextKey).(string)
+	if id == "" {
+		return log
+	}
+	return requestLogger{log, id}
+}
+
+type requestLogger struct {
+	log       logger.Logger
+	requestID string
+}
+
+func (l requestLogger) Debug() logger.Event { return l.with(l.log.Debug()) }
+func (l requestLogger) Info() logger.Event  { return l.with(l.log.Info()) }
+func (l requestLogger) Warn() logger.Event  { return l.with(l.log.Warn()) }
+func (l requestLogger) Error() logger.Event { return l.with(l.log.Error()) }
+func (l requestLogger) Panic() logger.Event { return l.with(l.log.Panic()) }
+
+func (l requestLogger) with(ev logger.Event) logger.Event {
+	ev = ev.WithString(fieldName, l.requestID)
+	// The caller would otherwise be this file, so it's overridden with the
+	// caller of the Debug, Info, etc. methods above.
+	if _, file, line, ok := runtime.Caller(2); ok {
+		ev = ev.WithCaller(filepath.Join(filepath.Base(filepath.Dir(file)), filepath.Base(file)), line)
+	}
+	return ev
+}
+
+// newID generates a random UUID (version 4).
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("read random bytes for request ID: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,85 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-core/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		header    string
+		wantReuse bool
+	}{
+		{
+			name:      "propagated",
+			header:    "my-request-id",
+			wantReuse: true,
+		},
+		{
+			name:      "missing",
+			header:    "",
+			wantReuse: false,
+		},
+		{
+			name:      "too long",
+			header:    strings.Repeat("a", maxLength+1),
+			wantReuse: false,
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotID string
+			r := gin.New()
+			r.Use(Middleware)
+			r.GET("/", func(c *gin.Context) {
+				gotID = FromContext(c)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set(HeaderName, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if tc.wantReuse {
+				assert.Equal(t, tc.header, gotID)
+			} else {
+				assert.Regexp(t, uuidRegex, gotID)
+			}
+			assert.Equal(t, gotID, rec.Header().Get(HeaderName))
+		})
+	}
+}
+
+func TestFromContextWithoutMiddleware(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Equal(t, "", FromContext(c))
+	assert.Equal(t, "", FromContext(nil))
+}
+
+func TestContextLogger(t *testing.T) {
+	log := logger.NewScoped("TEST")
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	assert.IsType(t, log, ContextLogger(log, c), "without request ID")
+	assert.IsType(t, log, ContextLogger(log, nil), "without context")
+
+	c.Set(contextKey, "my-request-id")
+	reqLog, ok := ContextLogger(log, c).(requestLogger)
+	if assert.True(t, ok, "with request ID") {
+		assert.Equal(t, "my-request-id", reqLog.requestID)
+	}
+}
+
+var uuidRegex = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
@@ -0,0 +1,52 @@
+// Package semaphore limits how many operations run concurrently, such as how
+// many requests are sent to the same API at once across parallel imports.
+package semaphore
+
+import "context"
+
+// Semaphore limits the number of concurrent holders. A nil *Semaphore is
+// valid and never blocks, meaning no limit.
+type Semaphore struct {
+	slots chan struct{}
+}
+
+// New creates a semaphore that allows at most n concurrent holders, or
+// returns nil, meaning no limit, if n is zero or less.
+func New(n int) *Semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return &Semaphore{slots: make(chan struct{}, n)}
+}
+
+// Acquire blocks until a slot is free, or returns the context's error if the
+// context is done first. Each successful call must be followed by a call to
+// Release.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot acquired using Acquire.
+func (s *Semaphore) Release() {
+	if s == nil {
+		return
+	}
+	<-s.slots
+}
+
+// Limit returns the maximum number of concurrent holders, or 0 if there is no
+// limit.
+func (s *Semaphore) Limit() int {
+	if s == nil {
+		return 0
+	}
+	return cap(s.slots)
+}
@@ -0,0 +1,37 @@
+package semaphore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := New(2)
+	assert.Equal(t, 2, s.Limit())
+
+	require.NoError(t, s.Acquire(context.Background()))
+	require.NoError(t, s.Acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, s.Acquire(ctx), context.Canceled, "when full")
+
+	s.Release()
+	assert.NoError(t, s.Acquire(ctx), "after release")
+}
+
+func TestSemaphoreUnlimited(t *testing.T) {
+	s := New(0)
+	assert.Nil(t, s)
+	assert.Equal(t, 0, s.Limit())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for n := 0; n < 3; n++ {
+		assert.NoError(t, s.Acquire(ctx))
+	}
+	s.Release()
+}
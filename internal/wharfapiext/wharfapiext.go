@@ -0,0 +1,198 @@
+// Package wharfapiext extends the Wharf API client with diagnostics of failed
+// requests, such as detecting when the Wharf API URL points at something
+// other than the Wharf API.
+package wharfapiext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/problemtype"
+)
+
+const diagnoseTimeout = 5 * time.Second
+
+// MisconfiguredURLError is returned when the Wharf API responded with a
+// content type other than JSON, such as an HTML error page from an ingress,
+// which suggests that the Wharf API URL is misconfigured.
+type MisconfiguredURLError struct {
+	// URL is the requested URL that responded with ContentType.
+	URL string
+	// ContentType is the Content-Type header of the response.
+	ContentType string
+	// Err is the error from the Wharf API client, if any.
+	Err error
+}
+
+func (e *MisconfiguredURLError) Error() string {
+	msg := fmt.Sprintf("Wharf API URL appears misconfigured: received Content-Type %q from %s",
+		e.ContentType, redact.URLString(e.URL))
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *MisconfiguredURLError) Unwrap() error {
+	return e.Err
+}
+
+// HealthURL returns the URL of the health endpoint of the Wharf API, based on
+// the Wharf API base URL, such as "http://wharf-api/api/health" for
+// "http://wharf-api/api".
+func HealthURL(apiURL string) (string, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return "", fmt.Errorf("parse Wharf API URL: %w", err)
+	}
+	u.Path = path.Join("/", u.Path, "health")
+	return u.String(), nil
+}
+
+// CheckContentType returns a *MisconfiguredURLError if the Wharf API response
+// has a Content-Type header that is not JSON. Responses without a
+// Content-Type header are not considered misconfigured.
+func CheckContentType(resp *http.Response) error {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" || isJSONContentType(contentType) {
+		return nil
+	}
+	return &MisconfiguredURLError{
+		URL:         resp.Request.URL.String(),
+		ContentType: contentType,
+	}
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// isNonJSONResponseError returns true if the error from the Wharf API client
+// could have been caused by a response that is not JSON, such as failing to
+// decode a HTML page, or a non-2xx response that is not a problem response.
+func isNonJSONResponseError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "unexpected status code returned")
+}
+
+// Diagnoser diagnoses errors from the Wharf API client, and writes them as
+// problem responses.
+//
+// The zero value is valid, and never diagnoses any errors.
+type Diagnoser struct {
+	// APIURL is the base URL of the Wharf API. Errors are not diagnosed when
+	// empty.
+	APIURL string
+	// HTTPClient sends the requests used to diagnose errors. Uses
+	// http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// Diagnose returns a *MisconfiguredURLError wrapping the error if the error
+// could have been caused by a response that is not JSON and the health
+// endpoint of the Wharf API responds with a content type other than JSON.
+// Otherwise the error is returned as-is.
+func (d Diagnoser) Diagnose(ctx context.Context, err error) error {
+	if err == nil || d.APIURL == "" || !isNonJSONResponseError(err) {
+		return err
+	}
+	healthURL, urlErr := HealthURL(d.APIURL)
+	if urlErr != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, diagnoseTimeout)
+	defer cancel()
+	req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if reqErr != nil {
+		return err
+	}
+	client := d.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, respErr := client.Do(req)
+	if respErr != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var misconfiguredErr *MisconfiguredURLError
+	if errors.As(CheckContentType(resp), &misconfiguredErr) {
+		misconfiguredErr.Err = err
+		return misconfiguredErr
+	}
+	return err
+}
+
+// WriteReadError writes a problem response for an error when reading from the
+// Wharf API, using ginutil.WriteAPIClientReadError unless the Wharf API URL
+// appears misconfigured.
+func (d Diagnoser) WriteReadError(c *gin.Context, err error, detail string) {
+	if !d.writeMisconfiguredURLProblem(c, err) {
+		ginutil.WriteAPIClientReadError(c, err, detail)
+	}
+}
+
+// WriteWriteError writes a problem response for an error when writing to the
+// Wharf API, using ginutil.WriteAPIClientWriteError unless the Wharf API URL
+// appears misconfigured.
+func (d Diagnoser) WriteWriteError(c *gin.Context, err error, detail string) {
+	if !d.writeMisconfiguredURLProblem(c, err) {
+		ginutil.WriteAPIClientWriteError(c, err, detail)
+	}
+}
+
+// WriteTriggerError writes a problem response for an error when starting a
+// build in the Wharf API, using ginutil.WriteTriggerError unless the Wharf
+// API URL appears misconfigured.
+func (d Diagnoser) WriteTriggerError(c *gin.Context, err error, detail string) {
+	if !d.writeMisconfiguredURLProblem(c, err) {
+		ginutil.WriteTriggerError(c, err, detail)
+	}
+}
+
+func (d Diagnoser) writeMisconfiguredURLProblem(c *gin.Context, err error) bool {
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	var misconfiguredErr *MisconfiguredURLError
+	if !errors.As(d.Diagnose(ctx, err), &misconfiguredErr) {
+		return false
+	}
+	WriteMisconfiguredURLProblem(c, misconfiguredErr)
+	return true
+}
+
+// WriteMisconfiguredURLProblem writes a 502 "Bad Gateway" problem response
+// describing the misconfigured Wharf API URL.
+func WriteMisconfiguredURLProblem(c *gin.Context, err *MisconfiguredURLError) {
+	ginutil.WriteProblemError(c, err, problem.Response{
+		Type:   problemtype.WharfAPIMisconfigured,
+		Title:  "Wharf API URL appears misconfigured.",
+		Status: http.StatusBadGateway,
+		Detail: fmt.Sprintf("Expected a JSON response from the Wharf API at %s, "+
+			"but received Content-Type %q, such as from an HTML error page of an ingress. "+
+			"Make sure the config api.url points at the Wharf API, "+
+			"including the trailing \"/api\" in the URL path.",
+			redact.URLString(err.URL), err.ContentType),
+	})
+}
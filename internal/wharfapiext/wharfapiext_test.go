@@ -0,0 +1,105 @@
+package wharfapiext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/problemtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newContentTypeServer(t *testing.T, contentType string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/health", r.URL.Path)
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<html><body>404 Not Found</body></html>"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDiagnose(t *testing.T) {
+	statusErr := errors.New("unexpected status code returned: 404 Not Found")
+	var testCases = []struct {
+		name             string
+		contentType      string
+		err              error
+		wantMisconfigure bool
+	}{
+		{
+			name:             "HTML error page",
+			contentType:      "text/html; charset=utf-8",
+			err:              statusErr,
+			wantMisconfigure: true,
+		},
+		{
+			name:             "HTML decoded as JSON",
+			contentType:      "text/html",
+			err:              json.Unmarshal([]byte("<html>"), &struct{}{}),
+			wantMisconfigure: true,
+		},
+		{
+			name:        "JSON",
+			contentType: "application/json",
+			err:         statusErr,
+		},
+		{
+			name:        "problem JSON",
+			contentType: "application/problem+json",
+			err:         statusErr,
+		},
+		{
+			name:        "other error",
+			contentType: "text/html",
+			err:         errors.New("connection refused"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newContentTypeServer(t, tc.contentType)
+			d := Diagnoser{APIURL: server.URL + "/api"}
+
+			got := d.Diagnose(context.Background(), tc.err)
+
+			assert.ErrorIs(t, got, tc.err)
+			var misconfiguredErr *MisconfiguredURLError
+			assert.Equal(t, tc.wantMisconfigure, errors.As(got, &misconfiguredErr))
+			if tc.wantMisconfigure {
+				assert.Equal(t, tc.contentType, misconfiguredErr.ContentType)
+			}
+		})
+	}
+}
+
+func TestDiagnoseWithoutAPIURL(t *testing.T) {
+	err := errors.New("unexpected status code returned: 404 Not Found")
+	assert.Same(t, err, Diagnoser{}.Diagnose(context.Background(), err))
+}
+
+func TestWriteReadErrorMisconfigured(t *testing.T) {
+	server := newContentTypeServer(t, "text/html")
+	d := Diagnoser{APIURL: server.URL + "/api"}
+
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	d.WriteReadError(c, errors.New("unexpected status code returned: 404 Not Found"), "Unable to get project.")
+
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	var got struct {
+		Type   string `json:"type"`
+		Detail string `json:"detail"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, problemtype.AbsoluteURL(problemtype.WharfAPIMisconfigured), got.Type)
+	assert.Contains(t, got.Detail, `"text/html"`)
+}
@@ -0,0 +1,236 @@
+// Package wharfapitest provides a fake of the Wharf API client, implementing
+// the importer.WharfClient interface, meant to be used in tests.
+package wharfapitest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/request"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
+)
+
+// ErrFake is returned by all functions of the Fake when Err is set.
+var ErrFake = errors.New("fake Wharf API error")
+
+// Fake is an in-memory implementation of the Wharf API endpoints used by the
+// importer.
+//
+// Contrary to the Wharf API, searches only match on exact values, and only
+// on the Name, GroupName, and ProviderID fields of projects, the UserName of
+// tokens, and the Name and URL of providers. Created objects are given
+// incrementing IDs, starting at 1.
+type Fake struct {
+	Projects  []response.Project
+	Tokens    []response.Token
+	Providers []response.Provider
+	// Branches is a map of project IDs to the branches of each project, as
+	// last replaced using UpdateProjectBranchList.
+	Branches map[uint][]request.Branch
+	// Err makes all functions fail with ErrFake when set to true.
+	Err bool
+}
+
+// GetProjectList returns the projects matching the search.
+func (f *Fake) GetProjectList(params wharfapi.ProjectSearch) (response.PaginatedProjects, error) {
+	if f.Err {
+		return response.PaginatedProjects{}, ErrFake
+	}
+	result := response.PaginatedProjects{List: []response.Project{}}
+	for _, p := range f.Projects {
+		if matches(params.Name, p.Name) &&
+			matches(params.GroupName, p.GroupName) &&
+			matches(params.ProviderID, p.ProviderID) {
+			result.List = append(result.List, p)
+		}
+	}
+	result.TotalCount = int64(len(result.List))
+	return result, nil
+}
+
+// GetProject returns the project with the matching ID.
+func (f *Fake) GetProject(projectID uint) (response.Project, error) {
+	if f.Err {
+		return response.Project{}, ErrFake
+	}
+	for _, p := range f.Projects {
+		if p.ProjectID == projectID {
+			return p, nil
+		}
+	}
+	return response.Project{}, fmt.Errorf("project with ID %d not found", projectID)
+}
+
+// CreateProject adds a new project.
+func (f *Fake) CreateProject(project request.Project) (response.Project, error) {
+	if f.Err {
+		return response.Project{}, ErrFake
+	}
+	created := response.Project{
+		ProjectID:       uint(len(f.Projects) + 1),
+		RemoteProjectID: project.RemoteProjectID,
+		Name:            project.Name,
+		GroupName:       project.GroupName,
+		Description:     project.Description,
+		AvatarURL:       project.AvatarURL,
+		TokenID:         project.TokenID,
+		ProviderID:      project.ProviderID,
+		BuildDefinition: project.BuildDefinition,
+		GitURL:          project.GitURL,
+	}
+	f.Projects = append(f.Projects, created)
+	return created, nil
+}
+
+// UpdateProject updates the project with the matching ID.
+func (f *Fake) UpdateProject(projectID uint, project request.ProjectUpdate) (response.Project, error) {
+	if f.Err {
+		return response.Project{}, ErrFake
+	}
+	for idx, p := range f.Projects {
+		if p.ProjectID != projectID {
+			continue
+		}
+		p.Name = project.Name
+		p.GroupName = project.GroupName
+		p.Description = project.Description
+		p.AvatarURL = project.AvatarURL
+		p.TokenID = project.TokenID
+		p.ProviderID = project.ProviderID
+		p.BuildDefinition = project.BuildDefinition
+		p.GitURL = project.GitURL
+		f.Projects[idx] = p
+		return p, nil
+	}
+	return response.Project{}, fmt.Errorf("project with ID %d not found", projectID)
+}
+
+// UpdateProjectBranchList replaces the branches of the project with the
+// matching ID.
+func (f *Fake) UpdateProjectBranchList(projectID uint, branches []request.Branch) ([]response.Branch, error) {
+	if f.Err {
+		return nil, ErrFake
+	}
+	if f.Branches == nil {
+		f.Branches = map[uint][]request.Branch{}
+	}
+	f.Branches[projectID] = append([]request.Branch{}, branches...)
+	result := make([]response.Branch, 0, len(branches))
+	for idx, b := range branches {
+		result = append(result, response.Branch{
+			BranchID:  uint(idx + 1),
+			ProjectID: projectID,
+			Name:      b.Name,
+			Default:   b.Default,
+		})
+	}
+	return result, nil
+}
+
+// GetToken returns the token with the matching ID.
+func (f *Fake) GetToken(tokenID uint) (response.Token, error) {
+	if f.Err {
+		return response.Token{}, ErrFake
+	}
+	for _, t := range f.Tokens {
+		if t.TokenID == tokenID {
+			return t, nil
+		}
+	}
+	return response.Token{}, fmt.Errorf("token with ID %d not found", tokenID)
+}
+
+// GetTokenList returns the tokens matching the search.
+func (f *Fake) GetTokenList(params wharfapi.TokenSearch) (response.PaginatedTokens, error) {
+	if f.Err {
+		return response.PaginatedTokens{}, ErrFake
+	}
+	result := response.PaginatedTokens{List: []response.Token{}}
+	for _, t := range f.Tokens {
+		if matches(params.UserName, t.UserName) {
+			result.List = append(result.List, t)
+		}
+	}
+	result.TotalCount = int64(len(result.List))
+	return result, nil
+}
+
+// CreateToken adds a new token.
+func (f *Fake) CreateToken(token request.Token) (response.Token, error) {
+	if f.Err {
+		return response.Token{}, ErrFake
+	}
+	created := response.Token{
+		TokenID:  uint(len(f.Tokens) + 1),
+		Token:    token.Token,
+		UserName: token.UserName,
+	}
+	f.Tokens = append(f.Tokens, created)
+	return created, nil
+}
+
+// UpdateToken updates the token with the matching ID.
+func (f *Fake) UpdateToken(tokenID uint, token request.TokenUpdate) (response.Token, error) {
+	if f.Err {
+		return response.Token{}, ErrFake
+	}
+	for idx, t := range f.Tokens {
+		if t.TokenID != tokenID {
+			continue
+		}
+		t.Token = token.Token
+		t.UserName = token.UserName
+		f.Tokens[idx] = t
+		return t, nil
+	}
+	return response.Token{}, fmt.Errorf("token with ID %d not found", tokenID)
+}
+
+// GetProvider returns the provider with the matching ID.
+func (f *Fake) GetProvider(providerID uint) (response.Provider, error) {
+	if f.Err {
+		return response.Provider{}, ErrFake
+	}
+	for _, p := range f.Providers {
+		if p.ProviderID == providerID {
+			return p, nil
+		}
+	}
+	return response.Provider{}, fmt.Errorf("provider with ID %d not found", providerID)
+}
+
+// GetProviderList returns the providers matching the search.
+func (f *Fake) GetProviderList(params wharfapi.ProviderSearch) (response.PaginatedProviders, error) {
+	if f.Err {
+		return response.PaginatedProviders{}, ErrFake
+	}
+	result := response.PaginatedProviders{List: []response.Provider{}}
+	for _, p := range f.Providers {
+		if matches(params.Name, string(p.Name)) && matches(params.URL, p.URL) {
+			result.List = append(result.List, p)
+		}
+	}
+	result.TotalCount = int64(len(result.List))
+	return result, nil
+}
+
+// CreateProvider adds a new provider.
+func (f *Fake) CreateProvider(provider request.Provider) (response.Provider, error) {
+	if f.Err {
+		return response.Provider{}, ErrFake
+	}
+	created := response.Provider{
+		ProviderID: uint(len(f.Providers) + 1),
+		Name:       response.ProviderName(provider.Name),
+		URL:        provider.URL,
+		TokenID:    provider.TokenID,
+	}
+	f.Providers = append(f.Providers, created)
+	return created, nil
+}
+
+// matches returns true if the search value is unset, or equal to the value.
+func matches[T comparable](search *T, value T) bool {
+	return search == nil || *search == value
+}
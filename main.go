@@ -1,17 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/gin-contrib/cors"
-	"github.com/iver-wharf/wharf-core/pkg/cacertutil"
 	"github.com/iver-wharf/wharf-core/pkg/ginutil"
 	"github.com/iver-wharf/wharf-core/pkg/logger"
 	"github.com/iver-wharf/wharf-core/pkg/logger/consolepretty"
 	"github.com/iver-wharf/wharf-provider-azuredevops/docs"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/idempotency"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/metrics"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/requestid"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/semaphore"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/requests"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
@@ -44,24 +56,37 @@ func main() {
 	}
 
 	docs.SwaggerInfo.Version = AppVersion.Version
+	docs.SwaggerInfo.BasePath = config.HTTP.BasePath + "/import"
 
-	if config.CA.CertsFile != "" {
-		client, err := cacertutil.NewHTTPClientWithCerts(config.CA.CertsFile)
-		if err != nil {
-			log.Error().WithError(err).Message("Failed to get net/http.Client with certs.")
-			os.Exit(1)
-		}
-		http.DefaultClient = client
+	azureHTTPClient, err := newAzureHTTPClient(config)
+	if err != nil {
+		log.Error().WithError(err).Message("Failed to create net/http.Client for Azure DevOps.")
+		os.Exit(1)
+	}
+	if config.Azure.Proxy != "" {
+		log.Info().
+			WithString("proxy", redact.URLString(config.Azure.Proxy)).
+			Message("Using HTTP proxy for Azure DevOps requests.")
+	}
+	healthTransport, err := newHTTPTransport(config.CA)
+	if err != nil {
+		log.Error().WithError(err).Message("Failed to create net/http.Client for health checks.")
+		os.Exit(1)
 	}
 
 	if config.CA.InsecureSkipVerify {
+		// The Wharf API client always uses http.DefaultTransport, so it has
+		// to be changed globally for the Wharf API requests.
 		transport, ok := http.DefaultTransport.(*http.Transport)
 		if !ok {
 			log.Error().
-				WithStringf("httpTransportType", "%T", http.DefaultClient).
+				WithStringf("httpTransportType", "%T", http.DefaultTransport).
 				Messagef("Failed to cast the HTTP transport to %T when insecurely configuring TLS to skip cert verify.", &http.Transport{})
 			os.Exit(1)
 		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
 		transport.TLSClientConfig.InsecureSkipVerify = true
 		log.Warn().Message("Insecurely configured TLS to skip certificate verification.")
 	}
@@ -71,30 +96,139 @@ func main() {
 
 	r := gin.New()
 	r.Use(
+		requestid.Middleware,
 		ginutil.DefaultLoggerHandler,
 		ginutil.RecoverProblem,
 	)
 
 	if config.HTTP.CORS.AllowAllOrigins {
 		log.Info().Message("Allowing all origins in CORS.")
-		r.Use(cors.Default())
+		corsConfig := cors.DefaultConfig()
+		corsConfig.AllowAllOrigins = true
+		corsConfig.AddAllowHeaders(idempotency.HeaderName)
+		corsConfig.AddExposeHeaders(continuationTokenHeader, idempotency.ReplayedHeaderName)
+		r.Use(cors.New(corsConfig))
+	} else if len(config.HTTP.CORS.AllowedOrigins) > 0 {
+		log.Info().
+			WithStringf("origins", "%v", config.HTTP.CORS.AllowedOrigins).
+			Message("Allowing origins in CORS.")
+		corsConfig := cors.DefaultConfig()
+		corsConfig.AllowOrigins = config.HTTP.CORS.AllowedOrigins
+		corsConfig.AddAllowHeaders("Authorization", idempotency.HeaderName)
+		corsConfig.AddExposeHeaders(continuationTokenHeader, idempotency.ReplayedHeaderName)
+		r.Use(cors.New(corsConfig))
 	}
 
-	r.GET("/", pingHandler)
-	r.GET("/import/azuredevops/version", getVersionHandler)
-	r.GET("/import/azuredevops/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	metricsRegistry := metrics.NewRegistry()
+	importMetrics := metrics.NewImportMetrics(metricsRegistry)
+	requests.RequestDurations = importMetrics.AzureRequestDuration
+	requests.MaxResponseBytes = config.Azure.MaxResponseBytes
+	requests.UserAgent = config.Azure.UserAgent
+	if requests.UserAgent == "" {
+		requests.UserAgent = userAgent(AppVersion)
+	}
+
+	if config.HTTP.BasePath != "" {
+		log.Info().
+			WithString("basePath", config.HTTP.BasePath).
+			Message("Serving all endpoints from base path.")
+	}
+	base := r.Group(config.HTTP.BasePath)
 
-	importModule{&config}.register(r)
+	base.GET("/", pingHandler)
+	base.GET("/import/azuredevops/version", getVersionHandler)
+	base.GET("/import/azuredevops/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	if err := r.Run(config.HTTP.BindAddress); err != nil {
+	var idempotencyStore *idempotency.Store
+	if config.Import.IdempotencyTTL > 0 {
+		idempotencyStore = idempotency.NewStore(config.Import.IdempotencyTTL)
+	}
+
+	wharfHTTPClient := &http.Client{Transport: healthTransport}
+	importModule{
+		config:          &config,
+		metrics:         importMetrics,
+		idempotency:     idempotencyStore,
+		azureHTTPClient: azureHTTPClient,
+
+		wharfWriteSemaphore: semaphore.New(config.API.MaxConcurrentWrites),
+		wharfHTTPClient:     wharfHTTPClient,
+	}.register(base)
+	healthModule{
+		config:     &config,
+		httpClient: wharfHTTPClient,
+	}.register(base)
+	capabilitiesModule{config: &config}.register(base)
+
+	servers := []*http.Server{{Addr: config.HTTP.BindAddress, Handler: r}}
+	if config.HTTP.MetricsBindAddress != "" {
+		metricsRouter := gin.New()
+		metricsRouter.Use(ginutil.RecoverProblem)
+		metricsRouter.GET("/metrics", metrics.Handler(metricsRegistry))
+		servers = append(servers, &http.Server{Addr: config.HTTP.MetricsBindAddress, Handler: metricsRouter})
+	}
+
+	if err := serveGracefully(servers, config.HTTP.ShutdownTimeout); err != nil {
 		log.Error().
 			WithError(err).
-			WithString("address", config.HTTP.BindAddress).
 			Message("Failed to start web server.")
 		os.Exit(2)
 	}
 }
 
+// serveGracefully serves the servers until an interrupt or SIGTERM signal is
+// received, or until any of the servers fail, and then waits for in-flight
+// requests to finish before returning.
+func serveGracefully(servers []*http.Server, shutdownTimeout time.Duration) error {
+	serveErr := make(chan error, len(servers))
+	for _, srv := range servers {
+		srv := srv
+		go func() {
+			log.Info().WithString("address", srv.Addr).Message("Listening and serving HTTP.")
+			err := srv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				err = fmt.Errorf("serve %s: %w", srv.Addr, err)
+			}
+			serveErr <- err
+		}()
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	var firstErr error
+	remaining := len(servers)
+	select {
+	case firstErr = <-serveErr:
+		remaining--
+	case sig := <-quit:
+		log.Info().
+			WithStringer("signal", sig).
+			WithDuration("timeout", shutdownTimeout).
+			Message("Shutting down web server, waiting for in-flight requests to finish.")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Warn().WithError(err).WithString("address", srv.Addr).Message("Failed to shut down web server gracefully, closing remaining connections.")
+			srv.Close()
+		}
+	}
+	for ; remaining > 0; remaining-- {
+		if err := <-serveErr; firstErr == nil && !errors.Is(err, http.ErrServerClosed) {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	log.Info().Message("Web server shut down.")
+	return nil
+}
+
 func pingHandler(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "pong"})
 }
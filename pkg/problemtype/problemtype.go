@@ -0,0 +1,93 @@
+// Package problemtype contains the type URIs of all problem responses that
+// wharf-provider-azuredevops may respond with, meant to be compared against
+// the problem.Response.Type field by API consumers.
+//
+// The constants are relative URIs, which are converted to absolute URIs
+// pointing to the Wharf documentation when the problem is written, such as
+// "https://iver-wharf.github.io/#/prob/api/invalid-param". Use AbsoluteURL
+// to compare them against the type of a received problem response.
+//
+// The type URIs are stable, and are never changed within the same major
+// version of wharf-provider-azuredevops.
+package problemtype
+
+import (
+	"net/url"
+
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+)
+
+// AbsoluteURL returns the problem type as it is sent in problem responses,
+// such as "https://iver-wharf.github.io/#/prob/api/invalid-param" for
+// InvalidParam.
+func AbsoluteURL(problemType string) string {
+	return problem.ConvertURLToAbsDocsURL(url.URL{Path: problemType}).String()
+}
+
+// Problem types specific to wharf-provider-azuredevops.
+const (
+	// AzureUnauthorized means Azure DevOps denied access, such as due to an
+	// invalid or expired token, or due to the token lacking the required
+	// scopes.
+	AzureUnauthorized = "/prob/provider/azuredevops/unauthorized"
+	// AzureNotFound means the organization, project, or repository could not
+	// be found in Azure DevOps.
+	AzureNotFound = "/prob/provider/azuredevops/not-found"
+	// AzureRateLimited means Azure DevOps throttled the request due to the
+	// user having exhausted its rate limit.
+	AzureRateLimited = "/prob/provider/azuredevops/rate-limited"
+	// ImportAborted means the import was aborted before it completed, such
+	// as due to the client disconnecting or the server shutting down.
+	ImportAborted = "/prob/provider/azuredevops/import-aborted"
+	// ImportInProgress means another import with the same idempotency key
+	// is still in progress.
+	ImportInProgress = "/prob/provider/azuredevops/import-in-progress"
+	// IdempotencyKeyReused means the idempotency key was already used by an
+	// import with a different request body.
+	IdempotencyKeyReused = "/prob/provider/azuredevops/idempotency-key-reused"
+	// InternalError means the request failed due to a fault in
+	// wharf-provider-azuredevops itself, such as failing to build a request
+	// URL, as opposed to failures from Azure DevOps, which are reported with
+	// the status 502 "Bad Gateway".
+	InternalError = "/prob/provider/azuredevops/internal-error"
+	// RequestBodyTooLarge means the request body was larger than the
+	// endpoint accepts.
+	RequestBodyTooLarge = "/prob/provider/azuredevops/request-body-too-large"
+	// UnsupportedEventType means a trigger endpoint received an Azure DevOps
+	// service hook event of an unexpected type.
+	UnsupportedEventType = "/prob/provider/azuredevops/unsupported-event-type"
+	// WharfAPIMisconfigured means the Wharf API URL appears to point at
+	// something other than the Wharf API, as it responded with a content type
+	// other than JSON, such as an HTML error page from an ingress.
+	WharfAPIMisconfigured = "/prob/provider/azuredevops/wharf-api-misconfigured"
+	// WharfAPITimeout means the Wharf API did not respond in time, such as
+	// when a trigger waited too long for the Wharf API to start a build.
+	WharfAPITimeout = "/prob/provider/azuredevops/wharf-api-timeout"
+	// WharfAPIUnreachable means the health check failed to reach the Wharf
+	// API.
+	WharfAPIUnreachable = "/prob/provider/azuredevops/wharf-api-unreachable"
+)
+
+// Problem types shared by all Wharf providers, written by the helpers in the
+// wharf-core ginutil package.
+const (
+	// InvalidParam means a request parameter, such as from the path, query,
+	// or body, was missing or invalid.
+	InvalidParam = "/prob/api/invalid-param"
+	// Unauthorized means the request lacks the Authorization header required
+	// to talk to the Wharf API, or the trigger endpoint credentials are
+	// invalid.
+	Unauthorized = "/prob/api/unauthorized"
+	// WharfAPIReadError means reading from the Wharf API failed.
+	WharfAPIReadError = "/prob/api-client/unexpected-read-error"
+	// WharfAPIWriteError means writing to the Wharf API failed.
+	WharfAPIWriteError = "/prob/api-client/unexpected-write-error"
+	// WharfAPITriggerError means starting a build in the Wharf API failed.
+	WharfAPITriggerError = "/prob/api-client/unexpected-trigger-error"
+	// ProviderResponseError means Azure DevOps responded with an unexpected
+	// status or response body.
+	ProviderResponseError = "/prob/provider/unexpected-response-format"
+	// FetchBuildDefinitionError means fetching the build definition file
+	// from Azure DevOps failed.
+	FetchBuildDefinitionError = "/prob/provider/fetch-build-definition"
+)
@@ -0,0 +1,48 @@
+package problemtype
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedTypesMatchGinutil(t *testing.T) {
+	err := errors.New("test error")
+	var testCases = []struct {
+		name  string
+		write func(c *gin.Context)
+		want  string
+	}{
+		{"InvalidParam", func(c *gin.Context) { ginutil.WriteInvalidParamError(c, err, "param", "") }, InvalidParam},
+		{"Unauthorized", func(c *gin.Context) { ginutil.WriteUnauthorized(c, "") }, Unauthorized},
+		{"WharfAPIReadError", func(c *gin.Context) { ginutil.WriteAPIClientReadError(c, err, "") }, WharfAPIReadError},
+		{"WharfAPIWriteError", func(c *gin.Context) { ginutil.WriteAPIClientWriteError(c, err, "") }, WharfAPIWriteError},
+		{"WharfAPITriggerError", func(c *gin.Context) { ginutil.WriteTriggerError(c, err, "") }, WharfAPITriggerError},
+		{"ProviderResponseError", func(c *gin.Context) { ginutil.WriteProviderResponseError(c, err, "") }, ProviderResponseError},
+		{"FetchBuildDefinitionError", func(c *gin.Context) { ginutil.WriteFetchBuildDefinitionError(c, err, "") }, FetchBuildDefinitionError},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+			tc.write(c)
+
+			var got struct {
+				Type string `json:"type"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			assert.Equal(t, AbsoluteURL(tc.want), got.Type)
+		})
+	}
+}
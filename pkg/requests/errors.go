@@ -1,25 +1,68 @@
 package requests
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
 )
 
+// maxErrorBodySize is the maximum number of bytes read from a non-2xx
+// response body when looking for an error message.
+const maxErrorBodySize = 4096
+
 // Non2xxStatusError represents a failed request response where the HTTP status
 // code was non-2xx, meaning not 200 (OK), not 201 (Created), etc.
 type Non2xxStatusError struct {
 	Status     string
 	StatusCode int
+	// Message is the error message from the response body, taken from the
+	// "message" field if the body is JSON, or else the raw body. May be empty.
+	Message string
+	// TypeKey is the "typeKey" field from the response body if the body is
+	// JSON, such as "GitItemNotFoundException" in Azure DevOps error
+	// responses. May be empty.
+	TypeKey string
+	// RetryAt is when the request may be retried, for responses with the
+	// status 429 (Too Many Requests). Zero if unknown.
+	RetryAt time.Time
 }
 
 // Error adds compliance to the error interface.
 func (err Non2xxStatusError) Error() string {
+	if err.Message != "" {
+		return fmt.Sprintf("non-2xx HTTP status: %s: %s", err.Status, err.Message)
+	}
 	return fmt.Sprintf("non-2xx HTTP status: %s", err.Status)
 }
 
 func newNon2xxStatusError(resp *http.Response) error {
-	return Non2xxStatusError{
+	err := Non2xxStatusError{
 		Status:     resp.Status,
 		StatusCode: resp.StatusCode,
 	}
+	err.Message, err.TypeKey = readErrorMessage(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		err.RetryAt = parseRetryAt(resp.Header, time.Now())
+	}
+	return err
+}
+
+func readErrorMessage(body io.Reader) (message, typeKey string) {
+	bodyBytes, err := io.ReadAll(io.LimitReader(body, maxErrorBodySize))
+	if err != nil {
+		return "", ""
+	}
+	var errorBody struct {
+		Message string `json:"message"`
+		TypeKey string `json:"typeKey"`
+	}
+	if err := json.Unmarshal(bodyBytes, &errorBody); err == nil && errorBody.Message != "" {
+		return redact.String(errorBody.Message), errorBody.TypeKey
+	}
+	return redact.String(strings.TrimSpace(string(bodyBytes))), ""
 }
@@ -0,0 +1,58 @@
+package requests
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNon2xxStatusError(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		body        string
+		wantMessage string
+		wantTypeKey string
+	}{
+		{
+			name:        "json message",
+			body:        `{"$id":"1","message":"TF200016: The following project does not exist: foo.","typeKey":"ProjectDoesNotExistException"}`,
+			wantMessage: "TF200016: The following project does not exist: foo.",
+			wantTypeKey: "ProjectDoesNotExistException",
+		},
+		{
+			name:        "plain text",
+			body:        "  Access denied\n",
+			wantMessage: "Access denied",
+		},
+		{
+			name:        "empty",
+			body:        "",
+			wantMessage: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{
+				Status:     "404 Not Found",
+				StatusCode: http.StatusNotFound,
+				Body:       http.NoBody,
+			}
+			if tc.body != "" {
+				resp.Body = io.NopCloser(strings.NewReader(tc.body))
+			}
+			err := newNon2xxStatusError(resp).(Non2xxStatusError)
+			assert.Equal(t, http.StatusNotFound, err.StatusCode)
+			assert.Equal(t, tc.wantMessage, err.Message)
+			assert.Equal(t, tc.wantTypeKey, err.TypeKey)
+		})
+	}
+}
+
+func TestNon2xxStatusErrorString(t *testing.T) {
+	err := Non2xxStatusError{Status: "404 Not Found", Message: "Project not found."}
+	assert.Equal(t, "non-2xx HTTP status: 404 Not Found: Project not found.", err.Error())
+}
@@ -0,0 +1,89 @@
+package requests
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/iver-wharf/wharf-core/pkg/logger"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+)
+
+var log = logger.NewScoped("REQUESTS")
+
+// RateLimitWarningRatio is the ratio of the remaining rate limit, compared
+// to the total rate limit, below which a warning is logged. Meant to be set
+// once on startup.
+var RateLimitWarningRatio = 0.1
+
+// RateLimit holds the rate limit state reported by Azure DevOps in the
+// X-RateLimit-* response headers, which are only sent when the user is
+// getting close to, or has exceeded, the rate limit.
+//
+// The limit and remaining values are measured in Azure DevOps throughput
+// units (TSTUs).
+type RateLimit struct {
+	Limit     float64
+	Remaining float64
+	// Reset is when the usage has fully recovered. Zero if unknown.
+	Reset time.Time
+}
+
+// parseRateLimit returns the rate limit from the response headers, or false
+// if the headers are missing or invalid.
+func parseRateLimit(header http.Header) (RateLimit, bool) {
+	limit, err := strconv.ParseFloat(header.Get("X-RateLimit-Limit"), 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+	remaining, err := strconv.ParseFloat(header.Get("X-RateLimit-Remaining"), 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+	return RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     parseRateLimitReset(header),
+	}, true
+}
+
+// parseRateLimitReset returns the time of the X-RateLimit-Reset header,
+// given as Unix epoch seconds, or zero if missing or invalid.
+func parseRateLimitReset(header http.Header) time.Time {
+	sec, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// parseRetryAt returns the time to retry a throttled request, taken from the
+// Retry-After header, given as seconds or as an HTTP date, or else from the
+// X-RateLimit-Reset header. Returns zero if unknown.
+func parseRetryAt(header http.Header, now time.Time) time.Time {
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		if sec, err := strconv.Atoi(retryAfter); err == nil {
+			return now.Add(time.Duration(sec) * time.Second).UTC().Truncate(time.Second)
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return date.UTC()
+		}
+	}
+	return parseRateLimitReset(header)
+}
+
+func warnIfRateLimitLow(log logger.Logger, header http.Header, urlPath *url.URL) {
+	rateLimit, ok := parseRateLimit(header)
+	if !ok || rateLimit.Remaining >= rateLimit.Limit*RateLimitWarningRatio {
+		return
+	}
+	ev := log.Warn().
+		WithString("url", redact.URL(urlPath)).
+		WithFloat64("limit", rateLimit.Limit).
+		WithFloat64("remaining", rateLimit.Remaining)
+	if !rateLimit.Reset.IsZero() {
+		ev = ev.WithTime("reset", rateLimit.Reset)
+	}
+	ev.Message("Close to exhausting the Azure DevOps rate limit.")
+}
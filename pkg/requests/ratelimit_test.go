@@ -0,0 +1,68 @@
+package requests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "200")
+	header.Set("X-RateLimit-Remaining", "12.5")
+	header.Set("X-RateLimit-Reset", "1653056831")
+
+	got, ok := parseRateLimit(header)
+
+	assert.True(t, ok)
+	assert.Equal(t, RateLimit{
+		Limit:     200,
+		Remaining: 12.5,
+		Reset:     time.Date(2022, 5, 20, 14, 27, 11, 0, time.UTC),
+	}, got)
+
+	_, ok = parseRateLimit(http.Header{})
+	assert.False(t, ok, "missing headers")
+}
+
+func TestParseRetryAt(t *testing.T) {
+	now := time.Date(2022, 5, 20, 14, 0, 0, 0, time.UTC)
+	var testCases = []struct {
+		name   string
+		header map[string]string
+		want   time.Time
+	}{
+		{
+			name:   "retry-after seconds",
+			header: map[string]string{"Retry-After": "30", "X-RateLimit-Reset": "1653056831"},
+			want:   now.Add(30 * time.Second),
+		},
+		{
+			name:   "retry-after date",
+			header: map[string]string{"Retry-After": "Fri, 20 May 2022 14:05:00 GMT"},
+			want:   time.Date(2022, 5, 20, 14, 5, 0, 0, time.UTC),
+		},
+		{
+			name:   "rate limit reset",
+			header: map[string]string{"X-RateLimit-Reset": "1653056831"},
+			want:   time.Date(2022, 5, 20, 14, 27, 11, 0, time.UTC),
+		},
+		{
+			name:   "unknown",
+			header: map[string]string{},
+			want:   time.Time{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tc.header {
+				header.Set(k, v)
+			}
+			assert.Equal(t, tc.want, parseRetryAt(header, now))
+		})
+	}
+}
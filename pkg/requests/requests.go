@@ -1,65 +1,228 @@
 package requests
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
+	"time"
 
-	"github.com/iver-wharf/wharf-core/pkg/logger"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/requestid"
 )
 
-var log = logger.NewScoped("REQUESTS")
+// DurationObserver is an interface for observing the duration of HTTP
+// requests.
+type DurationObserver interface {
+	ObserveDuration(d time.Duration)
+}
 
-// GetUnmarshalJSON invokes a HTTP request with basic auth.
-// On success the response body will be unmarshalled as JSON.
-func GetUnmarshalJSON(result any, user, token string, urlPath *url.URL) error {
-	body, err := getBodyFromRequest(user, token, urlPath)
+// RequestDurations observes the duration of all HTTP requests sent by this
+// package when set. Meant to be set once on startup.
+var RequestDurations DurationObserver
+
+// UserAgent is sent as the User-Agent header in all HTTP requests when
+// non-empty. Meant to be set once on startup.
+var UserAgent string
+
+// DefaultMaxResponseBytes is the default value of MaxResponseBytes, 32 MiB.
+const DefaultMaxResponseBytes int64 = 32 << 20
+
+// MaxResponseBytes is the maximum size of a response body that this package
+// will read. Requests with larger response bodies fail with an error wrapping
+// ErrResponseTooLarge. Meant to be set once on startup. Values of zero or
+// less disables the limit.
+var MaxResponseBytes = DefaultMaxResponseBytes
+
+// ErrResponseTooLarge is returned when a response body is larger than
+// MaxResponseBytes.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// ErrSignInPage is returned when a request responds with an HTML sign-in
+// page instead of the API response. Azure DevOps does this with the status
+// 200 (OK) or 203 (Non-Authoritative Information) for expired or invalid
+// personal access tokens, which it treats as anonymous users.
+var ErrSignInPage = errors.New("responded with sign-in page, token expired or invalid")
+
+// Credentials holds the authentication sent with each HTTP request.
+type Credentials struct {
+	UserName string
+	Token    string
+	// Bearer sends the token as an OAuth bearer token in the Authorization
+	// header, instead of using basic auth. The UserName is then ignored.
+	Bearer bool
+}
+
+func (cred Credentials) setAuth(req *http.Request) {
+	if cred.Bearer {
+		req.Header.Set("Authorization", "Bearer "+cred.Token)
+		return
+	}
+	req.SetBasicAuth(cred.UserName, cred.Token)
+}
+
+// Options holds the options of a single HTTP request.
+type Options struct {
+	// Context of the request, used to cancel it and to log the request ID of
+	// the inbound request that it is sent for, such as a *gin.Context.
+	// Defaults to context.Background() when nil.
+	Context context.Context
+	// Client sends the request, defaulting to http.DefaultClient when nil.
+	Client *http.Client
+	// Credentials is the authentication sent with the request.
+	Credentials Credentials
+}
+
+// GetJSON invokes a HTTP GET request using the given options. On success the
+// response body will be unmarshalled as JSON.
+func GetJSON(opts Options, result any, urlPath *url.URL) error {
+	body, err := getBodyFromRequest(opts, urlPath)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(body, &result)
+	return json.Unmarshal(body, &result)
+}
 
-	return err
+// GetString invokes a HTTP GET request using the given options. Returns the
+// response as a string.
+func GetString(opts Options, urlPath *url.URL) (string, error) {
+	body, err := getBodyFromRequest(opts, urlPath)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// PostJSON invokes a HTTP POST request using the given options, with the
+// body marshalled as JSON. On success the response body will be unmarshalled
+// as JSON.
+func PostJSON(opts Options, result any, body any, urlPath *url.URL) error {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("unable to post: %w", err)
+	}
+	respBody, err := doRequest(opts, http.MethodPost, bytes.NewReader(bodyBytes), urlPath)
+	if err != nil {
+		return fmt.Errorf("unable to post: %w", err)
+	}
+	return json.Unmarshal(respBody, &result)
+}
+
+// GetUnmarshalJSON invokes a HTTP request with basic auth.
+// On success the response body will be unmarshalled as JSON.
+//
+// Deprecated: Use GetJSON instead.
+func GetUnmarshalJSON(result any, user, token string, urlPath *url.URL) error {
+	return GetJSON(Options{Credentials: Credentials{UserName: user, Token: token}}, result, urlPath)
 }
 
 // GetAsString invokes a HTTP request with basic auth.
 // Returns the response as a string.
+//
+// Deprecated: Use GetString instead.
 func GetAsString(user, token string, urlPath *url.URL) (string, error) {
-	body, err := getBodyFromRequest(user, token, urlPath)
+	return GetString(Options{Credentials: Credentials{UserName: user, Token: token}}, urlPath)
+}
+
+func getBodyFromRequest(opts Options, urlPath *url.URL) ([]byte, error) {
+	body, err := doRequest(opts, http.MethodGet, nil, urlPath)
 	if err != nil {
-		return "", err
+		return []byte{}, fmt.Errorf("unable to get: %w", err)
 	}
-
-	return string(body), nil
+	return body, nil
 }
 
-func getBodyFromRequest(user string, token string, urlPath *url.URL) ([]byte, error) {
-	url := urlPath.String()
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// doRequest sends the request using the client of the options, or using
+// http.DefaultClient if the client is nil.
+func doRequest(opts Options, method string, body io.Reader, urlPath *url.URL) ([]byte, error) {
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	reqLog := requestid.ContextLogger(log, ctx)
+	req, err := http.NewRequestWithContext(ctx, method, urlPath.String(), body)
 	if err != nil {
-		return []byte{}, fmt.Errorf("unable to get: %w", err)
+		return []byte{}, redact.Error(err)
 	}
 
-	req.SetBasicAuth(user, token)
+	opts.Credentials.setAuth(req)
+	if UserAgent != "" {
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	start := time.Now()
+	resp, err := client.Do(req)
+	if RequestDurations != nil {
+		RequestDurations.ObserveDuration(time.Since(start))
+	}
 	if err != nil {
-		return []byte{}, fmt.Errorf("unable to get: %w", err)
+		return []byte{}, redact.Error(err)
 	}
 
 	defer resp.Body.Close()
 
+	warnIfRateLimitLow(reqLog, resp.Header, urlPath)
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return []byte{}, fmt.Errorf("unable to get: %w", newNon2xxStatusError(resp))
+		reqLog.Debug().
+			WithString("url", redact.URL(urlPath)).
+			WithString("status", resp.Status).
+			Message("Received non-2xx HTTP response.")
+		return []byte{}, newNon2xxStatusError(resp)
+	}
+	if isSignInPage(resp) {
+		return []byte{}, fmt.Errorf("%s: %w", redact.URL(urlPath), ErrSignInPage)
 	}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	bodyBytes, err := readLimited(resp.Body, MaxResponseBytes)
 	if err != nil {
-		log.Error().WithError(err).WithStringer("url", urlPath).Message("Failed to read HTTP response body.")
-		return []byte{}, fmt.Errorf("unable to get: %w", err)
+		reqLog.Error().
+			WithError(redact.Error(err)).
+			WithString("url", redact.URL(urlPath)).
+			Message("Failed to read HTTP response body.")
+		return []byte{}, fmt.Errorf("read response body from %s: %w", redact.URL(urlPath), redact.Error(err))
+	}
+
+	return bodyBytes, nil
+}
+
+// isSignInPage returns true if the response is an HTML page sent with the
+// status 200 or 203, as the Azure DevOps REST API only responds with HTML
+// when redirecting unauthenticated users to its sign-in page.
+func isSignInPage(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusNonAuthoritativeInfo {
+		return false
 	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/html"
+}
 
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	// Reading one byte past the limit tells apart bodies that are exactly at
+	// the limit from those that would otherwise be silently truncated.
+	bodyBytes, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(bodyBytes)) > limit {
+		return nil, fmt.Errorf("%w: exceeds limit of %d bytes", ErrResponseTooLarge, limit)
+	}
 	return bodyBytes, nil
 }
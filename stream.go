@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const eventStreamContentType = "text/event-stream"
+
+func acceptsEventStream(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), eventStreamContentType)
+}
+
+// eventStream writes server-sent events to the client. While streaming, the
+// gin.Context's writer is replaced with a buffer, so that problems written
+// by the import can be sent as an "error" event instead of being mixed into
+// the stream.
+type eventStream struct {
+	c       *gin.Context
+	w       gin.ResponseWriter
+	buf     *bufferedResponseWriter
+	started bool
+}
+
+func newEventStream(c *gin.Context) *eventStream {
+	s := &eventStream{
+		c:   c,
+		w:   c.Writer,
+		buf: newBufferedResponseWriter(c.Writer),
+	}
+	c.Writer = s.buf
+	return s
+}
+
+// writeEvent writes a single event with the value marshaled as JSON.
+func (s *eventStream) writeEvent(event string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Error().WithError(err).WithString("event", event).Message("Failed to marshal event.")
+		return
+	}
+	s.start()
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	s.w.Flush()
+}
+
+// finish writes the result as a "result" event if ok, or else the buffered
+// problem as an "error" event. If no events have been streamed yet when not
+// ok, then the problem is instead written as a regular response, including
+// its HTTP status.
+func (s *eventStream) finish(result any, ok bool) {
+	if ok {
+		s.writeEvent("result", result)
+		return
+	}
+	if s.started {
+		fmt.Fprintf(s.w, "event: error\ndata: %s\n\n", bytes.TrimSpace(s.buf.body.Bytes()))
+		s.w.Flush()
+		return
+	}
+	for key, values := range s.buf.header {
+		s.w.Header()[key] = values
+	}
+	s.w.WriteHeader(s.buf.Status())
+	s.w.Write(s.buf.body.Bytes())
+}
+
+func (s *eventStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	header := s.w.Header()
+	header.Set("Content-Type", eventStreamContentType)
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	s.w.WriteHeader(http.StatusOK)
+	s.w.Flush()
+}
+
+// bufferedResponseWriter is a gin.ResponseWriter that buffers the response
+// instead of writing it to the client.
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponseWriter(w gin.ResponseWriter) *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		ResponseWriter: w,
+		header:         http.Header{},
+		status:         http.StatusOK,
+	}
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedResponseWriter) WriteHeaderNow() {}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedResponseWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedResponseWriter) Written() bool {
+	return w.body.Len() > 0
+}
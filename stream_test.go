@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventStreamWritesEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+
+	s := newEventStream(c)
+	s.writeEvent("repo", map[string]string{"repo": "Repo"})
+	s.finish(map[string]int{"projectsCreated": 1}, true)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "event: repo\ndata: {\"repo\":\"Repo\"}\n\n"+
+		"event: result\ndata: {\"projectsCreated\":1}\n\n", rec.Body.String())
+}
+
+func TestEventStreamWritesProblemAsEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	s := newEventStream(c)
+	s.writeEvent("repo", map[string]string{"repo": "Repo"})
+	ginutil.WriteProviderResponseError(c, errors.New("boom"), "Failed.")
+	s.finish(nil, false)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "event: error\ndata: {")
+	assert.Contains(t, rec.Body.String(), "unexpected-response-format")
+	assert.Equal(t, http.StatusBadGateway, c.Writer.Status(), "buffered status")
+}
+
+func TestEventStreamWritesProblemAsResponseBeforeStarted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/azuredevops", nil)
+
+	s := newEventStream(c)
+	ginutil.WriteProviderResponseError(c, errors.New("boom"), "Failed.")
+	s.finish(nil, false)
+
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "unexpected-response-format")
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/request"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/model/response"
+	"github.com/iver-wharf/wharf-api-client-go/v2/pkg/wharfapi"
+	"github.com/iver-wharf/wharf-core/pkg/ginutil"
+	"github.com/iver-wharf/wharf-core/pkg/problem"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/importer"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/redact"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/requestid"
+	"github.com/iver-wharf/wharf-provider-azuredevops/pkg/problemtype"
+)
+
+const refBranchesPrefix = "refs/heads/"
+
+// Names of the build inputs sent by the pull request triggers, which can be
+// used in the .wharf-ci.yml file.
+const (
+	prTargetBranchInput = "targetBranch"
+	prIDInput           = "pullRequestId"
+	prTitleInput        = "pullRequestTitle"
+	prDescriptionInput  = "pullRequestDescription"
+	prRepositoryIDInput = "repositoryId"
+)
+
+// prTrigger maps an Azure DevOps pull request service hook event type to the
+// Wharf build stage it triggers.
+type prTrigger struct {
+	eventType string
+	stage     string
+}
+
+var (
+	prCreatedTrigger = prTrigger{eventType: "git.pullrequest.created", stage: "prcreated"}
+	prUpdatedTrigger = prTrigger{eventType: "git.pullrequest.updated", stage: "prupdated"}
+	prMergedTrigger  = prTrigger{eventType: "git.pullrequest.merged", stage: "prmerged"}
+)
+
+// eventTypePush is the Azure DevOps service hook event type handled by the
+// push trigger.
+const eventTypePush = "git.push"
+
+// triggerBasicAuthHandler is a middleware that verifies the HTTP basic
+// authentication credentials sent by the Azure DevOps service hooks, if
+// configured.
+func (m importModule) triggerBasicAuthHandler(c *gin.Context) {
+	cfg := m.config.Triggers
+	if !cfg.BasicAuthEnabled() {
+		return
+	}
+	user, password, ok := c.Request.BasicAuth()
+	if !ok {
+		ginutil.WriteUnauthorized(c,
+			"Missing HTTP basic authentication credentials for trigger.")
+		c.Abort()
+		return
+	}
+	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(cfg.BasicAuthUser))
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(cfg.BasicAuthPassword))
+	if userMatch&passwordMatch != 1 {
+		requestid.Logger(log, c).Warn().
+			WithString("user", user).
+			WithString("path", c.FullPath()).
+			Message("Invalid HTTP basic authentication credentials for trigger.")
+		ginutil.WriteUnauthorized(c,
+			"Invalid HTTP basic authentication credentials for trigger.")
+		c.Abort()
+		return
+	}
+}
+
+// prCreatedTriggerHandler godoc
+// @Summary Triggers prcreated action on wharf-client
+// @Accept json
+// @Produce json
+// @Param projectid path int true "wharf project ID"
+// @Param azureDevOpsPR body azureapi.PullRequestEvent _ "AzureDevOps PR"
+// @Param environment query string true "wharf build environment, unless overridden by config triggers.prEnvironments"
+// @Success 200 {object} response.BuildReferenceWrapper "OK"
+// @Failure 400 {object} problem.Response "Bad request"
+// @Failure 401 {object} problem.Response "Unauthorized or missing jwt token"
+// @Failure 413 {object} problem.Response "Request body too large"
+// @Failure 502 {object} problem.Response "Bad gateway"
+// @Failure 504 {object} problem.Response "Wharf API did not start the build in time"
+// @Router /azuredevops/triggers/{projectid}/pr/created [post]
+func (m importModule) prCreatedTriggerHandler(c *gin.Context) {
+	m.handlePRTrigger(c, prCreatedTrigger)
+}
+
+// prUpdatedTriggerHandler godoc
+// @Summary Triggers prupdated action on wharf-client
+// @Accept json
+// @Produce json
+// @Param projectid path int true "wharf project ID"
+// @Param azureDevOpsPR body azureapi.PullRequestEvent _ "AzureDevOps PR"
+// @Param environment query string true "wharf build environment, unless overridden by config triggers.prEnvironments"
+// @Success 200 {object} response.BuildReferenceWrapper "OK"
+// @Failure 400 {object} problem.Response "Bad request"
+// @Failure 401 {object} problem.Response "Unauthorized or missing jwt token"
+// @Failure 413 {object} problem.Response "Request body too large"
+// @Failure 502 {object} problem.Response "Bad gateway"
+// @Failure 504 {object} problem.Response "Wharf API did not start the build in time"
+// @Router /azuredevops/triggers/{projectid}/pr/updated [post]
+func (m importModule) prUpdatedTriggerHandler(c *gin.Context) {
+	m.handlePRTrigger(c, prUpdatedTrigger)
+}
+
+// prMergedTriggerHandler godoc
+// @Summary Triggers prmerged action on wharf-client
+// @Accept json
+// @Produce json
+// @Param projectid path int true "wharf project ID"
+// @Param azureDevOpsPR body azureapi.PullRequestEvent _ "AzureDevOps PR"
+// @Param environment query string true "wharf build environment, unless overridden by config triggers.prEnvironments"
+// @Success 200 {object} response.BuildReferenceWrapper "OK"
+// @Failure 400 {object} problem.Response "Bad request"
+// @Failure 401 {object} problem.Response "Unauthorized or missing jwt token"
+// @Failure 413 {object} problem.Response "Request body too large"
+// @Failure 502 {object} problem.Response "Bad gateway"
+// @Failure 504 {object} problem.Response "Wharf API did not start the build in time"
+// @Router /azuredevops/triggers/{projectid}/pr/merged [post]
+func (m importModule) prMergedTriggerHandler(c *gin.Context) {
+	m.handlePRTrigger(c, prMergedTrigger)
+}
+
+func (m importModule) handlePRTrigger(c *gin.Context, trigger prTrigger) {
+	t := azureapi.PullRequestEvent{}
+	if !bindJSONWritesProblem(c, &t, m.config.Triggers.MaxBodyBytes,
+		"One or more parameters failed to parse when reading the request body for pull request.") {
+		return
+	}
+
+	if t.EventType != "" && t.EventType != trigger.eventType {
+		err := fmt.Errorf("expected event type %q for trigger, got: %q", trigger.eventType, t.EventType)
+		ginutil.WriteProblemError(c, err, problem.Response{
+			Type:   problemtype.UnsupportedEventType,
+			Title:  "Invalid event type.",
+			Status: http.StatusBadRequest,
+			Detail: fmt.Sprintf("Received event type %q, while only %q is supported.",
+				t.EventType, trigger.eventType),
+		})
+		return
+	}
+	if !requireTriggerFieldWritesProblem(c, t.MissingRequiredField()) {
+		return
+	}
+
+	projectID, ok := ginutil.ParseParamUint(c, "projectid")
+	if !ok {
+		return
+	}
+
+	environment, ok := ginutil.RequireQueryString(c, "environment")
+	if !ok {
+		return
+	}
+	targetBranch := strings.TrimPrefix(t.Resource.TargetRefName, refBranchesPrefix)
+	environment = prEnvironment(m.config.Triggers.PREnvironments, targetBranch, environment)
+
+	params := wharfapi.ProjectStartBuild{
+		Stage:       trigger.stage,
+		Branch:      strings.TrimPrefix(t.Resource.SourceRefName, refBranchesPrefix),
+		Environment: environment,
+	}
+	resp, ok := m.startBuildWritesProblem(c, projectID, params, prBuildInputs(t))
+	if !ok {
+		return
+	}
+
+	if m.config.Triggers.PostPRStatus {
+		if err := m.postPRStatus(c, projectID, t, resp); err != nil {
+			requestid.Logger(log, c).Warn().
+				WithError(err).
+				WithUint("projectId", projectID).
+				WithUint("pullRequestId", t.Resource.PullRequestID).
+				Message("Failed to post status to pull request.")
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// postPRStatus posts a pending status to the pull request in Azure DevOps,
+// using the token and provider of the Wharf project.
+func (m importModule) postPRStatus(c *gin.Context, projectID uint, t azureapi.PullRequestEvent, build response.BuildReferenceWrapper) error {
+	client := m.triggerWharfClient(c)
+	project, err := client.GetProject(projectID)
+	if err != nil {
+		return fmt.Errorf("get Wharf project: %w", err)
+	}
+	token, err := client.GetToken(project.TokenID)
+	if err != nil {
+		return fmt.Errorf("get Wharf token: %w", err)
+	}
+	provider, err := client.GetProvider(project.ProviderID)
+	if err != nil {
+		return fmt.Errorf("get Wharf provider: %w", err)
+	}
+	providerURL, err := importer.NormalizeProviderURL(provider.URL)
+	if err != nil {
+		return fmt.Errorf("parse Wharf provider URL: %w", redact.Error(err))
+	}
+	// The Wharf group name cannot be used to find the Azure DevOps
+	// organization and project, as it depends on the grouping strategy and
+	// name templates, so they are taken from the event instead.
+	orgName, err := t.OrgName(providerURL, m.config.Import.Mode)
+	if err != nil {
+		return fmt.Errorf("get Azure DevOps organization from event: %w", err)
+	}
+	azureProjectID := t.Resource.Repository.Project.ID
+	if azureProjectID == "" {
+		return errors.New("missing resource.repository.project.id in event")
+	}
+
+	azureClient := azureapi.Client{
+		Context:           c,
+		BaseURL:           providerURL.String(),
+		BaseURLParsed:     providerURL,
+		UserName:          token.UserName,
+		Token:             token.Token,
+		Mode:              m.config.Import.Mode,
+		AuthMode:          m.config.Azure.AuthMode,
+		OmitBasicAuthUser: m.config.Azure.OmitBasicAuthUser,
+		HTTPClient:        m.azureHTTPClient,
+	}
+	_, err = azureClient.CreatePullRequestStatus(orgName, azureProjectID,
+		t.Resource.Repository.ID, t.Resource.PullRequestID, azureapi.PullRequestStatus{
+			State:       azureapi.PullRequestStatusPending,
+			Description: fmt.Sprintf("Wharf build %s started.", build.BuildReference),
+			Context: azureapi.PullRequestStatusContext{
+				Name:  "wharf",
+				Genre: "continuous-integration",
+			},
+		})
+	return err
+}
+
+// prBuildInputs returns the build inputs describing the pull request, such
+// as for posting statuses back to the pull request from the build. The title
+// and description are left out when not included in the event.
+func prBuildInputs(t azureapi.PullRequestEvent) request.BuildInputs {
+	inputs := request.BuildInputs{
+		prTargetBranchInput: strings.TrimPrefix(t.Resource.TargetRefName, refBranchesPrefix),
+		prIDInput:           t.Resource.PullRequestID,
+		prRepositoryIDInput: t.Resource.Repository.ID,
+	}
+	if t.Resource.Title != "" {
+		inputs[prTitleInput] = t.Resource.Title
+	}
+	if t.Resource.Description != "" {
+		inputs[prDescriptionInput] = t.Resource.Description
+	}
+	return inputs
+}
+
+// prEnvironment returns the environment of the first rule matching the pull
+// request's target branch, or the default environment if none match.
+func prEnvironment(rules []PREnvironmentRule, targetBranch, defaultEnvironment string) string {
+	for _, rule := range rules {
+		if rule.Matches(targetBranch) {
+			return rule.Environment
+		}
+	}
+	return defaultEnvironment
+}
+
+// pushTriggerResult is the response of the push trigger, listing the pushed
+// branches whose builds were started, and those whose builds failed to start.
+type pushTriggerResult struct {
+	Started []pushTriggerBuild   `json:"started"`
+	Failed  []pushTriggerFailure `json:"failed"`
+}
+
+type pushTriggerBuild struct {
+	Branch   string `json:"branch" example:"main"`
+	BuildRef string `json:"buildRef" example:"123"`
+}
+
+type pushTriggerFailure struct {
+	Branch string `json:"branch" example:"feature/foo"`
+	Error  string `json:"error" example:"Wharf API timed out."`
+}
+
+// pushTriggerHandler godoc
+// @Summary Triggers push action on wharf-client
+// @Description Starts one build per pushed branch. Pushed tags and deleted
+// @Description branches are ignored. Responds with 207 Multi-Status listing
+// @Description the started and failed builds if only some builds failed to
+// @Description start, and with the problem of the first failure if all failed.
+// @Accept json
+// @Produce json
+// @Param projectid path int true "wharf project ID"
+// @Param azureDevOpsPush body azureapi.PushEvent _ "AzureDevOps push"
+// @Param environment query string true "wharf build environment"
+// @Success 200 {object} pushTriggerResult "OK"
+// @Success 207 {object} pushTriggerResult "Some builds failed to start"
+// @Failure 400 {object} problem.Response "Bad request"
+// @Failure 401 {object} problem.Response "Unauthorized or missing jwt token"
+// @Failure 413 {object} problem.Response "Request body too large"
+// @Failure 502 {object} problem.Response "Bad gateway"
+// @Failure 504 {object} problem.Response "Wharf API did not start the build in time"
+// @Router /azuredevops/triggers/{projectid}/push [post]
+func (m importModule) pushTriggerHandler(c *gin.Context) {
+	const pushStage = "push"
+
+	t := azureapi.PushEvent{}
+	if !bindJSONWritesProblem(c, &t, m.config.Triggers.MaxBodyBytes,
+		"One or more parameters failed to parse when reading the request body for push.") {
+		return
+	}
+
+	if t.EventType != "" && t.EventType != eventTypePush {
+		err := fmt.Errorf("expected event type %q for trigger, got: %q", eventTypePush, t.EventType)
+		ginutil.WriteProblemError(c, err, problem.Response{
+			Type:   problemtype.UnsupportedEventType,
+			Title:  "Invalid event type.",
+			Status: http.StatusBadRequest,
+			Detail: fmt.Sprintf("Received event type %q, while only %q is supported.",
+				t.EventType, eventTypePush),
+		})
+		return
+	}
+	if !requireTriggerFieldWritesProblem(c, t.MissingRequiredField()) {
+		return
+	}
+
+	projectID, ok := ginutil.ParseParamUint(c, "projectid")
+	if !ok {
+		return
+	}
+
+	environment, ok := ginutil.RequireQueryString(c, "environment")
+	if !ok {
+		return
+	}
+
+	result := pushTriggerResult{
+		Started: []pushTriggerBuild{},
+		Failed:  []pushTriggerFailure{},
+	}
+	var firstFailure *buildStartFailure
+	for _, branch := range pushedBranches(t.Resource.RefUpdates) {
+		params := wharfapi.ProjectStartBuild{
+			Stage:       pushStage,
+			Branch:      branch,
+			Environment: environment,
+		}
+		resp, failure := m.startBuild(c, projectID, params, nil)
+		if failure != nil {
+			if firstFailure == nil {
+				firstFailure = failure
+			}
+			result.Failed = append(result.Failed, pushTriggerFailure{
+				Branch: branch,
+				Error:  failure.title,
+			})
+			continue
+		}
+		result.Started = append(result.Started, pushTriggerBuild{
+			Branch:   branch,
+			BuildRef: resp.BuildReference,
+		})
+	}
+
+	switch {
+	case firstFailure != nil && len(result.Started) == 0:
+		firstFailure.writeProblem(c)
+	case firstFailure != nil:
+		c.JSON(http.StatusMultiStatus, result)
+	default:
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// requireTriggerFieldWritesProblem writes an invalid param problem if the
+// JSON path of a missing required field in the trigger's request body is not
+// empty, so that malformed service hook events do not start builds on empty
+// branch names.
+func requireTriggerFieldWritesProblem(c *gin.Context, missingField string) bool {
+	if missingField == "" {
+		return true
+	}
+	err := fmt.Errorf("missing required field: %s", missingField)
+	ginutil.WriteInvalidParamError(c, err, missingField,
+		fmt.Sprintf("Missing required field %q in the Azure DevOps service hook event.", missingField))
+	return false
+}
+
+// pushedBranches returns the names of the branches that were created or
+// updated by a push, ignoring tags and deleted branches.
+func pushedBranches(refUpdates []azureapi.RefUpdate) []string {
+	const deletedObjectID = "0000000000000000000000000000000000000000"
+	var branches []string
+	for _, ref := range refUpdates {
+		if !strings.HasPrefix(ref.Name, refBranchesPrefix) {
+			continue
+		}
+		if ref.NewObjectID == deletedObjectID {
+			continue
+		}
+		branches = append(branches, strings.TrimPrefix(ref.Name, refBranchesPrefix))
+	}
+	return branches
+}
+
+func (m importModule) startBuildWritesProblem(c *gin.Context, projectID uint, params wharfapi.ProjectStartBuild, inputs request.BuildInputs) (response.BuildReferenceWrapper, bool) {
+	resp, failure := m.startBuild(c, projectID, params, inputs)
+	if failure != nil {
+		failure.writeProblem(c)
+		return response.BuildReferenceWrapper{}, false
+	}
+	return resp, true
+}
+
+// buildStartFailure is a build that failed to start, along with how to write
+// the problem response for it.
+type buildStartFailure struct {
+	title        string
+	writeProblem func(c *gin.Context)
+}
+
+// startBuild starts a build, returning a failure instead of writing its
+// problem response, so that triggers starting multiple builds can continue
+// with the other builds.
+func (m importModule) startBuild(c *gin.Context, projectID uint, params wharfapi.ProjectStartBuild, inputs request.BuildInputs) (response.BuildReferenceWrapper, *buildStartFailure) {
+	client := m.triggerWharfClient(c)
+	resp, err := m.startProjectBuildWithTimeout(c.Request.Context(), client, projectID, params, inputs)
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		requestid.Logger(log, c).Warn().
+			WithError(err).
+			WithUint("projectId", projectID).
+			WithString("branch", params.Branch).
+			WithDuration("timeout", m.config.Triggers.StartBuildTimeout).
+			Message("Timed out waiting for wharf-api to start build.")
+		return response.BuildReferenceWrapper{}, &buildStartFailure{
+			title: "Wharf API timed out.",
+			writeProblem: func(c *gin.Context) {
+				ginutil.WriteProblemError(c, err, problem.Response{
+					Type:   problemtype.WharfAPITimeout,
+					Title:  "Wharf API timed out.",
+					Status: http.StatusGatewayTimeout,
+					Detail: fmt.Sprintf("The Wharf API did not start the build within %s. "+
+						"The build may still have been started by the Wharf API.",
+						m.config.Triggers.StartBuildTimeout),
+				})
+			},
+		}
+	}
+
+	if authErr, ok := err.(*wharfapi.AuthError); ok {
+		detail := "Failed to authenticate to the Wharf API. The Authorization header was " +
+			"missing or is invalid."
+		if m.config.Triggers.BasicAuthEnabled() {
+			detail = "Failed to authenticate to the Wharf API. The config api.authHeader " +
+				"is missing or is invalid."
+		}
+		return response.BuildReferenceWrapper{}, &buildStartFailure{
+			title: "Unauthorized by the Wharf API.",
+			writeProblem: func(c *gin.Context) {
+				ginutil.WriteUnauthorizedError(c, authErr, detail)
+			},
+		}
+	}
+
+	if err != nil {
+		requestid.Logger(log, c).Error().
+			WithError(err).
+			WithUint("projectId", projectID).
+			WithString("branch", params.Branch).
+			Message("Failed to send trigger to wharf-api.")
+		err = fmt.Errorf("unable to send trigger to wharf-api: %w", err)
+		return response.BuildReferenceWrapper{}, &buildStartFailure{
+			title: "Unable to send trigger to Wharf API.",
+			writeProblem: func(c *gin.Context) {
+				m.wharfAPIDiagnoser().WriteTriggerError(c, err, "Unable to send trigger to Wharf API.")
+			},
+		}
+	}
+
+	return resp, nil
+}
+
+// startProjectBuildWithTimeout starts a build, but cancels the request
+// towards the Wharf API after the config triggers.startBuildTimeout. The
+// Wharf API may still have started the build if it received the request
+// before it was canceled.
+func (m importModule) startProjectBuildWithTimeout(ctx context.Context, client wharfapi.Client, projectID uint, params wharfapi.ProjectStartBuild, inputs request.BuildInputs) (response.BuildReferenceWrapper, error) {
+	if timeout := m.config.Triggers.StartBuildTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	resp, err := startWharfProjectBuild(ctx, m.wharfHTTPClient, client, projectID, params, inputs)
+	if err != nil && ctx.Err() != nil {
+		return response.BuildReferenceWrapper{}, fmt.Errorf("wait for Wharf API to start build: %w", ctx.Err())
+	}
+	return resp, err
+}
+
+// startWharfProjectBuild starts a build by invoking the HTTP request:
+//
+//	POST /api/project/{projectId}/build
+//
+// The Wharf API client does not accept a context, so the request is sent
+// using the HTTP client, or http.DefaultClient when nil, so that it is
+// canceled together with the context. Errors are returned in the same way as
+// by the Wharf API client.
+func startWharfProjectBuild(ctx context.Context, httpClient *http.Client, client wharfapi.Client, projectID uint, params wharfapi.ProjectStartBuild, inputs request.BuildInputs) (response.BuildReferenceWrapper, error) {
+	startURL, err := url.Parse(client.APIURL)
+	if err != nil {
+		return response.BuildReferenceWrapper{}, fmt.Errorf("parse Wharf API URL: %w", err)
+	}
+	startURL.Path = fmt.Sprintf("/api/project/%d/build", projectID)
+	q := url.Values{}
+	q.Set("stage", params.Stage)
+	if params.Branch != "" {
+		q.Set("branch", params.Branch)
+	}
+	if params.Environment != "" {
+		q.Set("environment", params.Environment)
+	}
+	startURL.RawQuery = q.Encode()
+
+	body, err := json.Marshal(inputs)
+	if err != nil {
+		return response.BuildReferenceWrapper{}, fmt.Errorf("marshal build inputs: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, startURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return response.BuildReferenceWrapper{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if client.AuthHeader != "" {
+		req.Header.Set("Authorization", client.AuthHeader)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return response.BuildReferenceWrapper{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return response.BuildReferenceWrapper{}, &wharfapi.AuthError{Realm: resp.Header.Get("WWW-Authenticate")}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if problem.IsHTTPResponse(resp) {
+			prob, err := problem.ParseHTTPResponse(resp)
+			if err != nil {
+				return response.BuildReferenceWrapper{}, fmt.Errorf("unexpected status code returned: %s: %w", resp.Status, err)
+			}
+			return response.BuildReferenceWrapper{}, prob
+		}
+		return response.BuildReferenceWrapper{}, fmt.Errorf("unexpected status code returned: %s", resp.Status)
+	}
+	var buildRef response.BuildReferenceWrapper
+	if err := json.NewDecoder(resp.Body).Decode(&buildRef); err != nil {
+		return response.BuildReferenceWrapper{}, fmt.Errorf("decode started build: %w", err)
+	}
+	return buildRef, nil
+}
+
+// triggerWharfClient returns a Wharf API client that forwards the
+// Authorization header of the trigger request, unless the trigger endpoints
+// use HTTP basic authentication, where the config api.authHeader is used
+// instead.
+func (m importModule) triggerWharfClient(c *gin.Context) wharfapi.Client {
+	authHeader := c.GetHeader("Authorization")
+	if m.config.Triggers.BasicAuthEnabled() {
+		// The Authorization header then contains the service hook's
+		// credentials, which are not meant for the Wharf API.
+		authHeader = m.config.API.AuthHeader
+	}
+	return wharfapi.Client{
+		APIURL:     m.config.API.URL,
+		AuthHeader: authHeader,
+	}
+}
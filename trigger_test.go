@@ -0,0 +1,475 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iver-wharf/wharf-provider-azuredevops/internal/azureapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPRTriggerHandlerRejectsOtherEventTypes(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		path      string
+		eventType string
+	}{
+		{
+			name:      "created route with updated event",
+			path:      "/import/azuredevops/triggers/1/pr/created",
+			eventType: "git.pullrequest.updated",
+		},
+		{
+			name:      "updated route with merged event",
+			path:      "/import/azuredevops/triggers/1/pr/updated",
+			eventType: "git.pullrequest.merged",
+		},
+		{
+			name:      "merged route with created event",
+			path:      "/import/azuredevops/triggers/1/pr/merged",
+			eventType: "git.pullrequest.created",
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	importModule{config: &Config{}}.register(r)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := strings.NewReader(`{"eventType":"` + tc.eventType + `"}`)
+			req := httptest.NewRequest(http.MethodPost, tc.path+"?environment=dev", body)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), "/prob/provider/azuredevops/unsupported-event-type")
+		})
+	}
+}
+
+func TestTriggerHandlersRejectMissingFields(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		path      string
+		body      string
+		wantField string
+	}{
+		{
+			name:      "pr without event type",
+			path:      "/import/azuredevops/triggers/1/pr/created",
+			body:      `{"resource":{"sourceRefName":"refs/heads/feature"}}`,
+			wantField: "eventType",
+		},
+		{
+			name:      "pr without source ref",
+			path:      "/import/azuredevops/triggers/1/pr/created",
+			body:      `{"eventType":"git.pullrequest.created","resource":{"targetRefName":"refs/heads/master"}}`,
+			wantField: "resource.sourceRefName",
+		},
+		{
+			name:      "push without event type",
+			path:      "/import/azuredevops/triggers/1/push",
+			body:      `{}`,
+			wantField: "eventType",
+		},
+		{
+			name:      "push without ref name",
+			path:      "/import/azuredevops/triggers/1/push",
+			body:      `{"eventType":"git.push","resource":{"refUpdates":[{"newObjectId":"33b55f7c"}]}}`,
+			wantField: "resource.refUpdates[0].name",
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	importModule{config: &Config{}}.register(r)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path+"?environment=dev", strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), "/prob/api/invalid-param")
+			assert.Contains(t, rec.Body.String(), tc.wantField)
+		})
+	}
+}
+
+func TestPREnvironment(t *testing.T) {
+	rules := []PREnvironmentRule{
+		{TargetBranch: "release/*", Environment: "staging"},
+		{TargetBranch: "main", Environment: "prod"},
+		{TargetBranch: "*", Environment: "dev"},
+	}
+	var testCases = []struct {
+		name         string
+		rules        []PREnvironmentRule
+		targetBranch string
+		want         string
+	}{
+		{name: "no rules", rules: nil, targetBranch: "main", want: "default"},
+		{name: "glob", rules: rules, targetBranch: "release/v1", want: "staging"},
+		{name: "exact", rules: rules, targetBranch: "main", want: "prod"},
+		{name: "first match", rules: rules, targetBranch: "develop", want: "dev"},
+		{name: "no match", rules: rules, targetBranch: "feature/foo", want: "default"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, prEnvironment(tc.rules, tc.targetBranch, "default"))
+		})
+	}
+}
+
+func TestPushedBranches(t *testing.T) {
+	refUpdates := []azureapi.RefUpdate{
+		{Name: "refs/heads/main", NewObjectID: "33b55f7cb7e7e245323987634f960cf4a6e6bc74"},
+		{Name: "refs/tags/v1.0.0", NewObjectID: "33b55f7cb7e7e245323987634f960cf4a6e6bc74"},
+		{Name: "refs/heads/feature/foo", NewObjectID: "aad331d8d3b131fa9ae03cf5e53965b51942618a"},
+		{Name: "refs/heads/old", NewObjectID: "0000000000000000000000000000000000000000"},
+	}
+	assert.Equal(t, []string{"main", "feature/foo"}, pushedBranches(refUpdates))
+}
+
+func TestTriggerBasicAuthHandler(t *testing.T) {
+	var testCases = []struct {
+		name       string
+		user       string
+		password   string
+		noAuth     bool
+		wantStatus int
+	}{
+		{
+			name:       "missing credentials",
+			noAuth:     true,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong password",
+			user:       "azure",
+			password:   "wrong",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong user",
+			user:       "someone",
+			password:   "secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "valid credentials",
+			user:       "azure",
+			password:   "secret",
+			wantStatus: http.StatusBadRequest, // fails later on the event type
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{
+		Triggers: TriggersConfig{
+			BasicAuthUser:     "azure",
+			BasicAuthPassword: "secret",
+		},
+	}
+	importModule{config: &cfg}.register(r)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := strings.NewReader(`{"eventType":"git.push"}`)
+			req := httptest.NewRequest(http.MethodPost, "/import/azuredevops/triggers/1/pr/created?environment=dev", body)
+			if !tc.noAuth {
+				req.SetBasicAuth(tc.user, tc.password)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tc.wantStatus, rec.Code)
+		})
+	}
+}
+
+func TestTriggerWharfAuthHeader(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		triggers       TriggersConfig
+		wharfAuth      string
+		setAuth        func(req *http.Request)
+		wantAuthHeader string
+	}{
+		{
+			name:           "forwards header without basic auth",
+			wharfAuth:      "Bearer from-config",
+			setAuth:        func(req *http.Request) { req.Header.Set("Authorization", "Bearer from-request") },
+			wantAuthHeader: "Bearer from-request",
+		},
+		{
+			name:           "config header with basic auth",
+			triggers:       TriggersConfig{BasicAuthUser: "azure", BasicAuthPassword: "secret"},
+			wharfAuth:      "Bearer from-config",
+			setAuth:        func(req *http.Request) { req.SetBasicAuth("azure", "secret") },
+			wantAuthHeader: "Bearer from-config",
+		},
+		{
+			name:           "no header with basic auth and no config",
+			triggers:       TriggersConfig{BasicAuthUser: "azure", BasicAuthPassword: "secret"},
+			setAuth:        func(req *http.Request) { req.SetBasicAuth("azure", "secret") },
+			wantAuthHeader: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotAuthHeader string
+			wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAuthHeader = r.Header.Get("Authorization")
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"buildRef":"123"}`))
+			}))
+			defer wharfServer.Close()
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			cfg := Config{
+				API:      WharfAPIConfig{URL: wharfServer.URL, AuthHeader: tc.wharfAuth},
+				Triggers: tc.triggers,
+			}
+			importModule{config: &cfg}.register(r)
+
+			body := strings.NewReader(`{"eventType":"git.pullrequest.created","resource":{"pullRequestId":7,"sourceRefName":"refs/heads/feature/foo","targetRefName":"refs/heads/main","repository":{"id":"repo-id"}}}`)
+			req := httptest.NewRequest(http.MethodPost, "/import/azuredevops/triggers/1/pr/created?environment=dev", body)
+			tc.setAuth(req)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+			assert.Equal(t, tc.wantAuthHeader, gotAuthHeader)
+		})
+	}
+}
+
+func TestPRTriggerHandlerSendsPullRequestInputs(t *testing.T) {
+	var gotQuery url.Values
+	var gotInputs map[string]any
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/project/1/build", r.URL.Path)
+		gotQuery = r.URL.Query()
+		json.NewDecoder(r.Body).Decode(&gotInputs)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"buildRef":"123"}`))
+	}))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{
+		API: WharfAPIConfig{URL: wharfServer.URL},
+		Triggers: TriggersConfig{
+			PREnvironments: []PREnvironmentRule{{TargetBranch: "release/*", Environment: "staging"}},
+		},
+	}
+	importModule{config: &cfg}.register(r)
+
+	body := strings.NewReader(`{"eventType":"git.pullrequest.created","resource":{"pullRequestId":7,"title":"Add foo","sourceRefName":"refs/heads/feature/foo","targetRefName":"refs/heads/release/v1","repository":{"id":"repo-id"}}}`)
+	req := httptest.NewRequest(http.MethodPost, "/import/azuredevops/triggers/1/pr/created?environment=dev", body)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "feature/foo", gotQuery.Get("branch"))
+	assert.Equal(t, "staging", gotQuery.Get("environment"))
+	assert.Equal(t, map[string]any{
+		"targetBranch":     "release/v1",
+		"pullRequestId":    7.0,
+		"pullRequestTitle": "Add foo",
+		"repositoryId":     "repo-id",
+	}, gotInputs)
+}
+
+func TestPRTriggerHandlerPostsPRStatus(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		groupName string
+	}{
+		{name: "org-project grouping", groupName: "Org/Proj"},
+		{name: "org-only grouping", groupName: "Org"},
+		{name: "group without organization", groupName: "platform/backend/x"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotStatus := firePRCreatedTriggerWithPRStatus(t, tc.groupName)
+			assert.Equal(t, azureapi.PullRequestStatusPending, gotStatus.State)
+			assert.Equal(t, "wharf", gotStatus.Context.Name)
+			assert.Contains(t, gotStatus.Description, "123")
+		})
+	}
+}
+
+// firePRCreatedTriggerWithPRStatus sends a pr/created trigger for the Wharf
+// project with ID 1 in the group, and returns the status posted to the pull
+// request in Azure DevOps, which is expected to be found using the Azure
+// DevOps organization and project from the event.
+func firePRCreatedTriggerWithPRStatus(t *testing.T, groupName string) azureapi.PullRequestStatus {
+	var gotStatus azureapi.PullRequestStatus
+	azureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/Org/proj-id/_apis/git/repositories/repo-id/pullRequests/7/statuses", r.URL.Path)
+		_, pass, _ := r.BasicAuth()
+		assert.Equal(t, "azure-token", pass)
+		json.NewDecoder(r.Body).Decode(&gotStatus)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer azureServer.Close()
+
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/project/1/build":
+			w.Write([]byte(`{"buildRef":"123"}`))
+		case "/api/project/1":
+			json.NewEncoder(w).Encode(map[string]any{
+				"projectId":  1,
+				"groupName":  groupName,
+				"tokenId":    2,
+				"providerId": 3,
+			})
+		case "/api/token/2":
+			w.Write([]byte(`{"tokenId":2,"token":"azure-token","userName":"user"}`))
+		case "/api/provider/3":
+			w.Write([]byte(`{"providerId":3,"name":"azuredevops","url":"` + azureServer.URL + `"}`))
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{
+		API:      WharfAPIConfig{URL: wharfServer.URL},
+		Triggers: TriggersConfig{PostPRStatus: true},
+	}
+	importModule{config: &cfg}.register(r)
+
+	body := strings.NewReader(`{"eventType":"git.pullrequest.created","resource":{"pullRequestId":7,` +
+		`"sourceRefName":"refs/heads/feature/foo","targetRefName":"refs/heads/main",` +
+		`"url":"` + azureServer.URL + `/Org/_apis/git/repositories/repo-id/pullRequests/7",` +
+		`"repository":{"id":"repo-id","project":{"id":"proj-id"}}}}`)
+	req := httptest.NewRequest(http.MethodPost, "/import/azuredevops/triggers/1/pr/created?environment=dev", body)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	return gotStatus
+}
+
+func TestPushTriggerHandlerStartBuildTimeout(t *testing.T) {
+	canceled := make(chan struct{})
+	wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the closed connection after the body is read.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"buildRef":"123"}`))
+		}
+	}))
+	defer wharfServer.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{
+		API:      WharfAPIConfig{URL: wharfServer.URL},
+		Triggers: TriggersConfig{StartBuildTimeout: 10 * time.Millisecond},
+	}
+	importModule{config: &cfg}.register(r)
+
+	body := strings.NewReader(`{"eventType":"git.push","resource":{"refUpdates":[{"name":"refs/heads/master","newObjectId":"abc"}]}}`)
+	req := httptest.NewRequest(http.MethodPost, "/import/azuredevops/triggers/1/push?environment=dev", body)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/prob/provider/azuredevops/wharf-api-timeout")
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("request towards the Wharf API was not canceled")
+	}
+}
+
+func TestPushTriggerHandlerPartialFailure(t *testing.T) {
+	var testCases = []struct {
+		name       string
+		refs       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "all started",
+			refs:       `[{"name":"refs/heads/main","newObjectId":"abc"}]`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"started":[{"branch":"main","buildRef":"123"}],"failed":[]}`,
+		},
+		{
+			name:       "some failed",
+			refs:       `[{"name":"refs/heads/main","newObjectId":"abc"},{"name":"refs/heads/broken","newObjectId":"def"}]`,
+			wantStatus: http.StatusMultiStatus,
+			wantBody: `{"started":[{"branch":"main","buildRef":"123"}],` +
+				`"failed":[{"branch":"broken","error":"Unable to send trigger to Wharf API."}]}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			wharfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if r.URL.Query().Get("branch") == "broken" {
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write([]byte(`{}`))
+					return
+				}
+				w.Write([]byte(`{"buildRef":"123"}`))
+			}))
+			defer wharfServer.Close()
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			cfg := Config{API: WharfAPIConfig{URL: wharfServer.URL}}
+			importModule{config: &cfg}.register(r)
+
+			body := strings.NewReader(`{"eventType":"git.push","resource":{"refUpdates":` + tc.refs + `}}`)
+			req := httptest.NewRequest(http.MethodPost, "/import/azuredevops/triggers/1/push?environment=dev", body)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.wantStatus, rec.Code)
+			assert.JSONEq(t, tc.wantBody, rec.Body.String())
+		})
+	}
+}
+
+func TestTriggerHandlerRejectsLargeBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	cfg := Config{Triggers: TriggersConfig{MaxBodyBytes: 32}}
+	importModule{config: &cfg}.register(r)
+
+	body := strings.NewReader(`{"eventType":"git.pullrequest.created","resource":{}}`)
+	req := httptest.NewRequest(http.MethodPost, "/import/azuredevops/triggers/1/pr/created?environment=dev", body)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
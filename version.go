@@ -2,6 +2,9 @@ package main
 
 import (
 	"net/http"
+	"runtime"
+	"strings"
+	"time"
 
 	_ "embed"
 
@@ -14,18 +17,93 @@ import (
 //	GET /import/azuredevops/version
 var AppVersion app.Version
 
+// Build metadata that may be set at build time, used as fallback for the
+// values missing in the embedded version.yaml. Example:
+//	go build -ldflags "-X main.buildGitCommit=$(git rev-parse HEAD)"
+var (
+	buildVersion   string
+	buildGitCommit string
+	// buildDate is expected to be formatted as RFC3339.
+	buildDate string
+)
+
 //go:embed version.yaml
 var versionFile []byte
 
 func loadEmbeddedVersionFile() error {
-	return app.UnmarshalVersionYAML(versionFile, &AppVersion)
+	if err := app.UnmarshalVersionYAML(versionFile, &AppVersion); err != nil {
+		return err
+	}
+	applyBuildFlags(&AppVersion)
+	return nil
+}
+
+func applyBuildFlags(v *app.Version) {
+	if v.Version == "" && buildVersion != "" {
+		v.Version = buildVersion
+	}
+	// "HEAD" is the placeholder used in the version.yaml file for local
+	// development builds.
+	if (v.BuildGitCommit == "" || v.BuildGitCommit == "HEAD") && buildGitCommit != "" {
+		v.BuildGitCommit = buildGitCommit
+	}
+	if v.BuildDate.IsZero() && buildDate != "" {
+		date, err := time.Parse(time.RFC3339, buildDate)
+		if err != nil {
+			log.Warn().
+				WithError(err).
+				WithString("buildDate", buildDate).
+				Message("Failed to parse build date from build flags.")
+		} else {
+			v.BuildDate = date
+		}
+	}
+}
+
+// userAgent returns the User-Agent HTTP header value identifying this
+// provider and its version, such as "wharf-provider-azuredevops/v3.1.0".
+func userAgent(v app.Version) string {
+	const product = "wharf-provider-azuredevops"
+	version := strings.Join(strings.Fields(v.Version), "-")
+	if version == "" {
+		return product
+	}
+	return product + "/" + version
+}
+
+type versionResponse struct {
+	// Version is the version of this API build.
+	Version string `json:"version" example:"v3.1.0"`
+	// BuildDate is the date on which this version of the API was built.
+	BuildDate time.Time `json:"buildDate" format:"date-time"`
+	// BuildGitCommit is the Git commit that this version of the API was built
+	// from. Kept under the same key as in earlier versions of this endpoint.
+	BuildGitCommit string `json:"buildGitCommit" example:"10aaf36a71ffe4f021b3d85341f684931f333040"`
+	// GitCommit holds the same value as BuildGitCommit.
+	GitCommit string `json:"gitCommit" example:"10aaf36a71ffe4f021b3d85341f684931f333040"`
+	// GoVersion is the version of Go that this API was built with.
+	GoVersion string `json:"goVersion" example:"go1.18"`
+	// BuildRef is the Wharf build ID/reference from which this version of
+	// the API was built in.
+	BuildRef uint `json:"buildRef"`
+}
+
+func newVersionResponse(v app.Version) versionResponse {
+	return versionResponse{
+		Version:        v.Version,
+		BuildDate:      v.BuildDate,
+		BuildGitCommit: v.BuildGitCommit,
+		GitCommit:      v.BuildGitCommit,
+		GoVersion:      runtime.Version(),
+		BuildRef:       v.BuildRef,
+	}
 }
 
 // getVersionHandler godoc
 // @summary Returns the version of this API
 // @tags meta
-// @success 200 {object} app.Version
+// @success 200 {object} versionResponse
 // @router /azuredevops/version [get]
 func getVersionHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, AppVersion)
+	c.JSON(http.StatusOK, newVersionResponse(AppVersion))
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/iver-wharf/wharf-core/pkg/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyBuildFlags(t *testing.T) {
+	buildVersion, buildGitCommit, buildDate = "v1.2.3", "abc123", "2022-05-20T14:27:11Z"
+	defer func() {
+		buildVersion, buildGitCommit, buildDate = "", "", ""
+	}()
+
+	v := app.Version{Version: "local dev", BuildGitCommit: "HEAD"}
+	applyBuildFlags(&v)
+
+	assert.Equal(t, "local dev", v.Version, "version from file")
+	assert.Equal(t, "abc123", v.BuildGitCommit)
+	assert.Equal(t, time.Date(2022, 5, 20, 14, 27, 11, 0, time.UTC), v.BuildDate)
+}
+
+func TestNewVersionResponse(t *testing.T) {
+	got := newVersionResponse(app.Version{
+		Version:        "v1.2.3",
+		BuildGitCommit: "abc123",
+		BuildRef:       5,
+	})
+	assert.Equal(t, "v1.2.3", got.Version)
+	assert.Equal(t, "abc123", got.BuildGitCommit)
+	assert.Equal(t, "abc123", got.GitCommit)
+	assert.Equal(t, runtime.Version(), got.GoVersion)
+	assert.Equal(t, uint(5), got.BuildRef)
+}
+
+func TestUserAgent(t *testing.T) {
+	assert.Equal(t, "wharf-provider-azuredevops/v1.2.3", userAgent(app.Version{Version: "v1.2.3"}))
+	assert.Equal(t, "wharf-provider-azuredevops/local-dev", userAgent(app.Version{Version: "local dev"}))
+	assert.Equal(t, "wharf-provider-azuredevops", userAgent(app.Version{}))
+}